@@ -0,0 +1,250 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"tbapi/chains"
+	"tbapi/fetch"
+	"tbapi/modals"
+	"tbapi/money"
+)
+
+type CreateAccountRequest struct {
+	ID       string `json:"id"`
+	Referrer string `json:"referrer,omitempty"`
+}
+
+type CreateAccountResponse struct {
+	DepositAddress string `json:"depositAddress"`
+}
+
+func createAccount(r *http.Request) (interface{}, *Error) {
+	var req CreateAccountRequest
+	if apiErr := decode(r, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if req.ID == "" {
+		return nil, badRequest("id is required")
+	}
+	referrer := req.Referrer
+	if referrer == "" {
+		referrer = "NIL"
+	}
+	isCreated, message := modals.NewAccount(req.ID, referrer)
+	if isCreated != "true" {
+		return nil, rejected(message)
+	}
+	return CreateAccountResponse{DepositAddress: message}, nil
+}
+
+type RecoverAccountRequest struct {
+	Auth
+}
+
+type RecoverAccountResponse struct {
+	DepositAddress string `json:"depositAddress"`
+	TronAddress    string `json:"tronAddress"`
+}
+
+func recoverAccount(r *http.Request) (interface{}, *Error) {
+	var req RecoverAccountRequest
+	if apiErr := decode(r, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := authenticate(req.Auth); apiErr != nil {
+		return nil, apiErr
+	}
+	isRecovered, message, wallet := modals.RecoverWallet(req.Address)
+	if !isRecovered {
+		return nil, rejected(message)
+	}
+	return RecoverAccountResponse{DepositAddress: wallet.EADD, TronAddress: wallet.TADD}, nil
+}
+
+type AccountOverviewRequest struct {
+	Auth
+}
+
+type Referral struct {
+	ID          string `json:"id"`
+	ActiveStake bool   `json:"activeStake"`
+}
+
+type AccountOverviewResponse struct {
+	TBYT           string     `json:"tbyt"`
+	USDTPOS        string     `json:"usdtPos"`
+	USDTERC        string     `json:"usdtErc"`
+	NetProfit      string     `json:"netProfit"`
+	NetProfitPct   string     `json:"netProfitPercent"`
+	DepositAddress string     `json:"depositAddress"`
+	Referrals      []Referral `json:"referrals"`
+	// Balances holds TBYT and every chain asset by exchange code
+	Balances map[string]string `json:"balances"`
+}
+
+func accountOverview(r *http.Request) (interface{}, *Error) {
+	var req AccountOverviewRequest
+	if apiErr := decode(r, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := authenticate(req.Auth); apiErr != nil {
+		return nil, apiErr
+	}
+	isFound, message, user, REFStatus := modals.GetAccountOverview(req.Address)
+	if !isFound {
+		return nil, rejected(message)
+	}
+	referrals := make([]Referral, 0, len(user.REFS))
+	for i, refID := range user.REFS {
+		referrals = append(referrals, Referral{ID: refID, ActiveStake: REFStatus[i] == 1})
+	}
+	return AccountOverviewResponse{
+		TBYT:           modals.Balance(user.TBT).String(),
+		USDTPOS:        modals.Balance(user.POS).String(),
+		USDTERC:        modals.Balance(user.ERC).String(),
+		NetProfit:      modals.Balance(user.NPT).String(),
+		NetProfitPct:   modals.Balance(user.NPTP).String(),
+		DepositAddress: user.EADD,
+		Referrals:      referrals,
+		Balances:       assetBalances(user),
+	}, nil
+}
+
+type RefreshDepositsRequest struct {
+	Auth
+}
+
+type RefreshDepositsResponse struct {
+	USDTPOSCredited string `json:"usdtPosCredited"`
+	USDTERCCredited string `json:"usdtErcCredited"`
+	CreditedAt      int64  `json:"creditedAt,omitempty"`
+	DepositAddress  string `json:"depositAddress"`
+	USDTPOS         string `json:"usdtPos"`
+	USDTERC         string `json:"usdtErc"`
+	// Credited and Balances hold every chain asset by exchange code
+	Credited map[string]string `json:"credited"`
+	Balances map[string]string `json:"balances"`
+}
+
+func refreshDeposits(r *http.Request) (interface{}, *Error) {
+	var req RefreshDepositsRequest
+	if apiErr := decode(r, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := authenticate(req.Auth); apiErr != nil {
+		return nil, apiErr
+	}
+	isRefreshed, message, refresh := fetch.RefreshChainBalance(req.Address)
+	if !isRefreshed {
+		return nil, rejected(message)
+	}
+	credited := map[string]string{}
+	balances := map[string]string{}
+	for _, chain := range chains.All() {
+		credited[chain.Asset] = refresh.Credited[chain.Name].String()
+		balances[chain.Asset] = refresh.Balances[chain.Name].String()
+	}
+	return RefreshDepositsResponse{
+		USDTPOSCredited: refresh.Credited["POS"].String(),
+		USDTERCCredited: refresh.Credited["ERC"].String(),
+		CreditedAt:      refresh.TMP,
+		DepositAddress:  refresh.EADD,
+		USDTPOS:         refresh.Balances["POS"].String(),
+		USDTERC:         refresh.Balances["ERC"].String(),
+		Credited:        credited,
+		Balances:        balances,
+	}, nil
+}
+
+type PlatformInfoResponse struct {
+	PolygonTransferFeeUSD string            `json:"polygonTransferFeeUsd"`
+	EthTransferFeeUSD     string            `json:"ethTransferFeeUsd"`
+	TransferFeesUSD       map[string]string `json:"transferFeesUsd"` // by asset code
+	TotalSupply           string            `json:"totalSupply"`
+	MaxSupply             string            `json:"maxSupply"`
+	Mined                 string            `json:"mined"`
+	Holders               string            `json:"holders"`
+}
+
+func platformInfo(r *http.Request) (interface{}, *Error) {
+	isGot, message, summary := modals.GetPlatformSummary()
+	if !isGot {
+		return nil, rejected(message)
+	}
+	transferFees := map[string]string{}
+	for asset, cost := range summary.Transfer {
+		transferFees[asset] = formatFloat(cost)
+	}
+	return PlatformInfoResponse{
+		PolygonTransferFeeUSD: formatFloat(summary.PolygonFee),
+		EthTransferFeeUSD:     formatFloat(summary.EthFee),
+		TransferFeesUSD:       transferFees,
+		TotalSupply:           summary.Currency.TotalSupply,
+		MaxSupply:             summary.Currency.MaxSupply,
+		Mined:                 string(summary.Currency.Mined),
+		Holders:               summary.Currency.Holder,
+	}, nil
+}
+
+type ChainInfo struct {
+	Name          string `json:"name"`
+	Asset         string `json:"asset"`
+	Display       string `json:"display"`
+	ChainID       uint64 `json:"chainId"`
+	Token         string `json:"token"`
+	Decimals      int    `json:"decimals"`
+	Confirmations uint64 `json:"confirmations"`
+}
+
+// listChains lists the chains deposits are taken on, the same deposit address
+// serves all of them
+func listChains(r *http.Request) (interface{}, *Error) {
+	registry, err := chains.Load()
+	if err != nil {
+		return nil, internal("Chain registry unavailable")
+	}
+	infos := make([]ChainInfo, 0, len(registry))
+	for _, chain := range registry {
+		infos = append(infos, ChainInfo{
+			Name:          chain.Name,
+			Asset:         chain.Asset,
+			Display:       chain.Display,
+			ChainID:       chain.ChainID,
+			Token:         chain.Token,
+			Decimals:      money.ChainAsset(chain).Decimals,
+			Confirmations: chain.Confirmations,
+		})
+	}
+	return infos, nil
+}
+
+// rpcHealth shows the latency, error rate and breaker state of every RPC
+// endpoint as seen by this server
+func rpcHealth(r *http.Request) (interface{}, *Error) {
+	return chains.Health(), nil
+}
+
+type VersionResponse struct {
+	Version string `json:"version"`
+}
+
+func appVersion(r *http.Request) (interface{}, *Error) {
+	isGot, version := modals.GetVersion()
+	if isGot != "true" {
+		return nil, internal(version)
+	}
+	return VersionResponse{Version: version}, nil
+}
+
+// assetBalances maps TBYT and the asset of every chain to the account balance
+func assetBalances(user modals.User) map[string]string {
+	balances := map[string]string{money.TBYT().Code: user.BalanceOf("TBT").String()}
+	for _, chain := range chains.All() {
+		balances[chain.Asset] = user.BalanceOf(chain.Name).String()
+	}
+	return balances
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,180 @@
+// Package api serves the versioned JSON interface (/v2). Every endpoint has its
+// own request and response struct and failures carry an explicit error code.
+// The comma packed v1 interface stays with the handlers of each package.
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"tbapi/modals"
+)
+
+// Error codes returned in the "error.code" field
+const (
+	CodeBadRequest   = "BAD_REQUEST"
+	CodeUnauthorized = "UNAUTHORIZED"
+	CodeNotFound     = "NOT_FOUND"
+	CodeConflict     = "CONFLICT"
+	CodeRejected     = "REJECTED"
+	CodeInternal     = "INTERNAL"
+)
+
+var statusByCode = map[string]int{
+	CodeBadRequest:   http.StatusBadRequest,
+	CodeUnauthorized: http.StatusUnauthorized,
+	CodeNotFound:     http.StatusNotFound,
+	CodeConflict:     http.StatusConflict,
+	CodeRejected:     http.StatusUnprocessableEntity,
+	CodeInternal:     http.StatusInternalServerError,
+}
+
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type envelope struct {
+	OK    bool        `json:"ok"`
+	Data  interface{} `json:"data,omitempty"`
+	Error *Error      `json:"error,omitempty"`
+}
+
+// Auth identifies the caller, it is embedded in every authenticated request.
+// Token is the session token from /auth/login, WalletKey is only accepted from
+// older clients while LEGACY_KEY_AUTH is enabled.
+type Auth struct {
+	Address   string `json:"address"`
+	Token     string `json:"token,omitempty"`
+	WalletKey string `json:"walletKey,omitempty"`
+}
+
+type handlerFunc func(r *http.Request) (interface{}, *Error)
+
+type route struct {
+	Method  string
+	Path    string
+	Handler handlerFunc
+}
+
+var routes = []route{
+	// auth
+	{http.MethodPost, "/auth/challenge", authChallenge},
+	{http.MethodPost, "/auth/login", authLogin},
+	{http.MethodPost, "/auth/logout", authLogout},
+
+	// accounts
+	{http.MethodPost, "/accounts", createAccount},
+	{http.MethodPost, "/accounts/recover", recoverAccount},
+	{http.MethodPost, "/accounts/overview", accountOverview},
+	{http.MethodPost, "/accounts/deposits/refresh", refreshDeposits},
+
+	// platform
+	{http.MethodGet, "/platform", platformInfo},
+	{http.MethodGet, "/platform/chains", listChains},
+	{http.MethodGet, "/platform/rpc", rpcHealth},
+	{http.MethodGet, "/version", appVersion},
+
+	// transfers
+	{http.MethodPost, "/transfers", sendTransfer},
+	{http.MethodPost, "/transfers/list", listTransfers},
+
+	// withdrawals
+	{http.MethodPost, "/withdrawals/quote", quoteWithdrawal},
+	{http.MethodPost, "/withdrawals", requestWithdrawal},
+	{http.MethodPost, "/withdrawals/list", listWithdrawals},
+
+	// exchange
+	{http.MethodPost, "/exchange/quote", quoteExchangeOrder},
+	{http.MethodPost, "/exchange/orders", placeExchangeOrder},
+	{http.MethodPost, "/exchange/orders/cancel", cancelExchangeOrder},
+	{http.MethodPost, "/exchange/orders/list", listExchangeOrders},
+	{http.MethodPost, "/exchange/fills", listExchangeFills},
+	{http.MethodGet, "/exchange/depth", orderBookDepth},
+	{http.MethodGet, "/exchange/candles", pairCandles},
+	{http.MethodGet, "/exchange/ticker", pairTickers},
+	{http.MethodGet, "/exchange/fees", feeSchedule},
+
+	// staking
+	{http.MethodPost, "/stakes", placeStake},
+	{http.MethodPost, "/stakes/unstake", unstake},
+	{http.MethodPost, "/stakes/list", listStakes},
+}
+
+// Register mounts every v2 endpoint under prefix (for example "/v2")
+func Register(mux *http.ServeMux, prefix string) {
+	for _, rt := range routes {
+		mux.Handle(rt.Method+" "+prefix+rt.Path, serve(rt.Handler))
+	}
+	mux.HandleFunc(http.MethodGet+" "+prefix+"/stream", serveStream)
+}
+
+func serve(handler handlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				log.Printf("Panic serving %s: %v", r.URL.Path, rec)
+				writeJSON(w, http.StatusInternalServerError, envelope{Error: internal("Problem at backend")})
+			}
+		}()
+		data, apiErr := handler(r)
+		if apiErr != nil {
+			writeJSON(w, statusByCode[apiErr.Code], envelope{Error: apiErr})
+			return
+		}
+		writeJSON(w, http.StatusOK, envelope{OK: true, Data: data})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body envelope) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// decode reads the JSON body into req, unknown fields are rejected so that
+// typos in field names do not silently fall back to zero values
+func decode(r *http.Request, req interface{}) *Error {
+	defer r.Body.Close()
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(req); err != nil {
+		return badRequest("Request Malformed")
+	}
+	return nil
+}
+
+// authenticate checks that the caller owns the address
+func authenticate(auth Auth) *Error {
+	credential := auth.Token
+	if credential == "" {
+		credential = auth.WalletKey
+	}
+	if auth.Address == "" || credential == "" {
+		return &Error{Code: CodeUnauthorized, Message: "Missing credentials"}
+	}
+	if !modals.CheckAuth(credential, auth.Address) {
+		return &Error{Code: CodeUnauthorized, Message: "Invalid or expired session"}
+	}
+	return nil
+}
+
+func badRequest(message string) *Error {
+	return &Error{Code: CodeBadRequest, Message: message}
+}
+
+func internal(message string) *Error {
+	return &Error{Code: CodeInternal, Message: message}
+}
+
+// rejected maps a failure message from the domain packages to an error code
+func rejected(message string) *Error {
+	switch {
+	case strings.Contains(message, "Database Error"), strings.Contains(message, "Backend"), strings.Contains(message, "backend"):
+		return internal(message)
+	case strings.Contains(message, "No Account Found"), strings.Contains(message, "not found"):
+		return &Error{Code: CodeNotFound, Message: message}
+	}
+	return &Error{Code: CodeRejected, Message: message}
+}
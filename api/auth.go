@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/http"
+	"tbapi/modals"
+)
+
+type ChallengeRequest struct {
+	Address string `json:"address"`
+}
+
+type ChallengeResponse struct {
+	Nonce     string `json:"nonce"`
+	Message   string `json:"message"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+func authChallenge(r *http.Request) (interface{}, *Error) {
+	var req ChallengeRequest
+	if apiErr := decode(r, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if req.Address == "" {
+		return nil, badRequest("address is required")
+	}
+	isIssued, message, challenge := modals.IssueChallenge(req.Address)
+	if !isIssued {
+		return nil, rejected(message)
+	}
+	return ChallengeResponse{Nonce: challenge.Nonce, Message: challenge.Message, ExpiresAt: challenge.EXP}, nil
+}
+
+type LoginRequest struct {
+	Address   string `json:"address"`
+	Nonce     string `json:"nonce"`
+	Signature string `json:"signature"` // hex [R || S || V] personal_sign signature of the challenge message
+}
+
+type LoginResponse struct {
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+func authLogin(r *http.Request) (interface{}, *Error) {
+	var req LoginRequest
+	if apiErr := decode(r, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if req.Address == "" || req.Nonce == "" || req.Signature == "" {
+		return nil, badRequest("address, nonce and signature are required")
+	}
+	isLoggedIn, message, session := modals.Login(req.Address, req.Nonce, req.Signature)
+	if !isLoggedIn {
+		return nil, &Error{Code: CodeUnauthorized, Message: message}
+	}
+	return LoginResponse{Token: session.Token, ExpiresAt: session.EXP}, nil
+}
+
+type LogoutRequest struct {
+	Auth
+}
+
+func authLogout(r *http.Request) (interface{}, *Error) {
+	var req LogoutRequest
+	if apiErr := decode(r, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := authenticate(req.Auth); apiErr != nil {
+		return nil, apiErr
+	}
+	isLoggedOut, message := modals.Logout(req.Address, req.Token)
+	if !isLoggedOut {
+		return nil, rejected(message)
+	}
+	return nil, nil
+}
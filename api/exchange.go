@@ -0,0 +1,492 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"tbapi/exchange"
+	"tbapi/money"
+)
+
+type PlaceOrderRequest struct {
+	Auth
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Amount      string `json:"amount"`                // of from
+	Price       string `json:"price,omitempty"`       // limit in quote per base of the pair
+	Type        string `json:"type,omitempty"`        // limit (default) or market
+	TimeInForce string `json:"timeInForce,omitempty"` // GTC (default for limit), GTT, DAY, IOC or FOK (default for market)
+	MaxSlippage string `json:"maxSlippage,omitempty"` // market orders, percent away from the best price
+	ExpiresAt   int64  `json:"expiresAt,omitempty"`   // GTT orders, unix time
+}
+
+type PlaceOrderResponse struct {
+	OrderID string `json:"orderId"`
+	Status  string `json:"status"`
+	Settled string `json:"settled"` // part of amount exchanged
+}
+
+func placeExchangeOrder(r *http.Request) (interface{}, *Error) {
+	var req PlaceOrderRequest
+	if apiErr := decode(r, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := authenticate(req.Auth); apiErr != nil {
+		return nil, apiErr
+	}
+	if req.From == "" || req.To == "" || req.Amount == "" {
+		return nil, badRequest("from, to and amount are required")
+	}
+	if req.Type == exchange.TypeMarket && req.MaxSlippage == "" {
+		return nil, badRequest("maxSlippage is required for market orders")
+	}
+	if req.Type != exchange.TypeMarket && req.Price == "" {
+		return nil, badRequest("price is required for limit orders")
+	}
+	isCreated, message, _, EID := exchange.PlaceOrder(req.Address, exchange.OrderSpec{
+		From:        req.From,
+		To:          req.To,
+		Amount:      req.Amount,
+		Price:       req.Price,
+		Type:        req.Type,
+		TimeInForce: req.TimeInForce,
+		MaxSlippage: req.MaxSlippage,
+		ExpiresAt:   req.ExpiresAt,
+	})
+	if isCreated != "true" {
+		return nil, rejected(message)
+	}
+	exStatus, amountSettled := exchange.MatchOrder(EID)
+	return PlaceOrderResponse{
+		OrderID: EID.Hex(),
+		Status:  exStatus,
+		Settled: amountSettled.String(),
+	}, nil
+}
+
+type QuoteOrderRequest struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount string `json:"amount"` // of from
+}
+
+type QuoteOrderResponse struct {
+	Pair         string `json:"pair"`
+	Side         string `json:"side"`
+	Amount       string `json:"amount"`
+	Filled       string `json:"filled"` // part of amount the book takes
+	Received     string `json:"received"`
+	BestPrice    string `json:"bestPrice,omitempty"`
+	AveragePrice string `json:"averagePrice,omitempty"`
+	WorstPrice   string `json:"worstPrice,omitempty"`
+	Complete     bool   `json:"complete"`
+}
+
+func quoteExchangeOrder(r *http.Request) (interface{}, *Error) {
+	var req QuoteOrderRequest
+	if apiErr := decode(r, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if req.From == "" || req.To == "" || req.Amount == "" {
+		return nil, badRequest("from, to and amount are required")
+	}
+	isQuoted, message, quote := exchange.QuoteOrder(req.From, req.To, req.Amount)
+	if !isQuoted {
+		return nil, rejected(message)
+	}
+	res := QuoteOrderResponse{
+		Pair:     quote.Pair.Symbol(),
+		Side:     quote.Side,
+		Amount:   quote.Amount.String(),
+		Filled:   quote.Paid.String(),
+		Received: quote.Received.String(),
+		Complete: quote.Complete,
+	}
+	if quote.Paid.Sign() > 0 {
+		res.BestPrice = quote.Best.String()
+		res.AveragePrice = quote.Average.String()
+		res.WorstPrice = quote.Worst.String()
+	}
+	return res, nil
+}
+
+type CancelOrderRequest struct {
+	Auth
+	OrderID string `json:"orderId"`
+}
+
+type CancelOrderResponse struct {
+	Result   string `json:"result"` // deleted when nothing had filled, else settled
+	Status   string `json:"status"`
+	Refunded string `json:"refunded"` // unfilled part of amount, of from
+	Paid     string `json:"paid"`     // proceeds of fills from before the order book, of to
+}
+
+func cancelExchangeOrder(r *http.Request) (interface{}, *Error) {
+	var req CancelOrderRequest
+	if apiErr := decode(r, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := authenticate(req.Auth); apiErr != nil {
+		return nil, apiErr
+	}
+	if req.OrderID == "" {
+		return nil, badRequest("orderId is required")
+	}
+	cancelled, err := exchange.Cancel(req.Address, req.OrderID)
+	switch {
+	case errors.Is(err, exchange.ErrOrderNotFound):
+		return nil, &Error{Code: CodeNotFound, Message: err.Error()}
+	case errors.Is(err, exchange.ErrOrderDone), errors.Is(err, exchange.ErrOrderCancelled), errors.Is(err, exchange.ErrOrderExpired):
+		return nil, &Error{Code: CodeConflict, Message: err.Error()}
+	case err != nil:
+		return nil, rejected(exchange.CancelMessage(err))
+	}
+	res := CancelOrderResponse{
+		Result:   "settled",
+		Status:   cancelled.Order.STAT,
+		Refunded: cancelled.Refunded.String(),
+		Paid:     cancelled.Paid.String(),
+	}
+	if settled, _ := money.ParseDecimal(cancelled.Order.SAMT); settled.IsZero() {
+		res.Result = "deleted"
+	}
+	return res, nil
+}
+
+type ExchangeOrder struct {
+	OrderID  string `json:"orderId"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Pair     string `json:"pair,omitempty"`
+	Side     string `json:"side,omitempty"`
+	Price    string `json:"price"`
+	Amount   string `json:"amount"`
+	Settled  string `json:"settled"`
+	Received string `json:"received"`
+	// AveragePrice is in quote per base over every fill, empty before the first
+	AveragePrice string `json:"averagePrice,omitempty"`
+	LastFill     string `json:"lastFill,omitempty"`
+	// Fee is what the fills kept from received, of to, at the rates of FeeTier
+	Fee       string `json:"fee"`
+	FeeTier   string `json:"feeTier,omitempty"`
+	MakerRate string `json:"makerRate,omitempty"` // percent
+	TakerRate string `json:"takerRate,omitempty"` // percent
+	ExpiresAt int64  `json:"expiresAt,omitempty"`
+	Timestamp string `json:"timestamp"`
+	Status    string `json:"status"` // pending, partial, done, cancelled or expired
+}
+
+type ExchangeOrderListResponse struct {
+	Orders []ExchangeOrder `json:"orders"`
+}
+
+func listExchangeOrders(r *http.Request) (interface{}, *Error) {
+	var req ListRequest
+	if apiErr := decode(r, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := authenticate(req.Auth); apiErr != nil {
+		return nil, apiErr
+	}
+	if req.Page <= 0 {
+		return nil, badRequest("page must be 1 or more")
+	}
+	isFound, message, orders := exchange.ListExOrders(req.Address, strconv.Itoa(req.Page))
+	if !isFound {
+		return nil, rejected(message)
+	}
+	res := ExchangeOrderListResponse{Orders: make([]ExchangeOrder, 0, len(orders))}
+	for _, order := range orders {
+		res.Orders = append(res.Orders, exchangeOrder(order))
+	}
+	return res, nil
+}
+
+func exchangeOrder(order exchange.ExOrder) ExchangeOrder {
+	price, received := order.PRC, order.RCV
+	if price == "" {
+		price = "1"
+	}
+	if received == "" {
+		received = "0"
+	}
+	item := ExchangeOrder{
+		OrderID:   order.EID.Hex(),
+		From:      order.FROM,
+		To:        order.TO,
+		Pair:      order.PAIR,
+		Side:      order.SIDE,
+		Price:     price,
+		Amount:    order.AMT,
+		Settled:   order.SAMT,
+		Received:  received,
+		LastFill:  order.LastFill(),
+		Fee:       order.Fees().String(),
+		FeeTier:   order.TIER,
+		MakerRate: order.MFR,
+		TakerRate: order.TFR,
+		ExpiresAt: order.EXP,
+		Timestamp: order.TMP,
+		Status:    order.STAT,
+	}
+	if average := order.AveragePrice(); average.Sign() > 0 {
+		item.AveragePrice = average.String()
+	}
+	return item
+}
+
+type FillListRequest struct {
+	Auth
+	OrderID string `json:"orderId,omitempty"` // every order of the account when empty
+	Page    int    `json:"page"`
+}
+
+type ExchangeFill struct {
+	FillID         string `json:"fillId"`
+	OrderID        string `json:"orderId"`
+	CounterOrderID string `json:"counterOrderId"`
+	Pair           string `json:"pair"`
+	Role           string `json:"role"` // maker or taker
+	Side           string `json:"side"`
+	Price          string `json:"price"`
+	Amount         string `json:"amount"` // of the base asset
+	Total          string `json:"total"`  // of the quote asset
+	Fee            string `json:"fee"`    // kept from what the account received
+	FeeAsset       string `json:"feeAsset"`
+	Timestamp      int64  `json:"timestamp"`
+}
+
+type FillListResponse struct {
+	Fills []ExchangeFill `json:"fills"`
+}
+
+func listExchangeFills(r *http.Request) (interface{}, *Error) {
+	var req FillListRequest
+	if apiErr := decode(r, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := authenticate(req.Auth); apiErr != nil {
+		return nil, apiErr
+	}
+	if req.Page <= 0 {
+		return nil, badRequest("page must be 1 or more")
+	}
+	isFound, message, fills := exchange.ListFills(req.Address, req.OrderID, req.Page)
+	if !isFound {
+		return nil, rejected(message)
+	}
+	res := FillListResponse{Fills: make([]ExchangeFill, 0, len(fills))}
+	for _, fill := range fills {
+		role, own, side, counter := fill.Role(req.OrderID, req.Address)
+		fee, feeAsset := fill.Fee(role, side)
+		res.Fills = append(res.Fills, ExchangeFill{
+			FillID:         fill.FID.Hex(),
+			OrderID:        own.Hex(),
+			CounterOrderID: counter.Hex(),
+			Pair:           fill.PAIR,
+			Role:           role,
+			Side:           side,
+			Price:          fill.PRC,
+			Amount:         fill.AMT,
+			Total:          fill.QAMT,
+			Fee:            fee,
+			FeeAsset:       feeAsset,
+			Timestamp:      fill.TMP,
+		})
+	}
+	return res, nil
+}
+
+type DepthLevel struct {
+	Price  string `json:"price"`
+	Amount string `json:"amount"` // of the base asset
+	Orders int    `json:"orders"`
+}
+
+type PairDepth struct {
+	Pair  string       `json:"pair"`
+	Base  string       `json:"base"`
+	Quote string       `json:"quote"`
+	Bids  []DepthLevel `json:"bids"` // best (highest) first
+	Asks  []DepthLevel `json:"asks"` // best (lowest) first
+}
+
+type DepthResponse struct {
+	Pairs []PairDepth `json:"pairs"`
+}
+
+// orderBookDepth takes ?pair=TBYT/USDT-POS (every pair when absent) and
+// ?levels=n (default 20)
+func orderBookDepth(r *http.Request) (interface{}, *Error) {
+	levels := 20
+	if value := r.URL.Query().Get("levels"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return nil, badRequest("levels must be 1 or more")
+		}
+		levels = parsed
+	}
+	isGot, message, depths := exchange.GetDepth(r.URL.Query().Get("pair"), levels)
+	if !isGot {
+		return nil, rejected(message)
+	}
+	res := DepthResponse{Pairs: make([]PairDepth, 0, len(depths))}
+	for _, depth := range depths {
+		res.Pairs = append(res.Pairs, pairDepth(depth))
+	}
+	return res, nil
+}
+
+func pairDepth(depth exchange.Depth) PairDepth {
+	return PairDepth{
+		Pair:  depth.Pair.Symbol(),
+		Base:  depth.Pair.Base.Code,
+		Quote: depth.Pair.Quote.Code,
+		Bids:  depthLevels(depth.Bids),
+		Asks:  depthLevels(depth.Asks),
+	}
+}
+
+func depthLevels(levels []exchange.Level) []DepthLevel {
+	out := make([]DepthLevel, 0, len(levels))
+	for _, level := range levels {
+		out = append(out, DepthLevel{Price: level.Price.String(), Amount: level.Amount.String(), Orders: level.Orders})
+	}
+	return out
+}
+
+type Candle struct {
+	OpenTime    int64  `json:"openTime"`
+	Open        string `json:"open"`
+	High        string `json:"high"`
+	Low         string `json:"low"`
+	Close       string `json:"close"`
+	Volume      string `json:"volume"`      // of the base asset
+	QuoteVolume string `json:"quoteVolume"` // of the quote asset
+	Trades      int64  `json:"trades"`
+}
+
+type CandlesResponse struct {
+	Pair     string   `json:"pair"`
+	Interval string   `json:"interval"`
+	Page     int      `json:"page"`
+	Candles  []Candle `json:"candles"` // newest first
+}
+
+// pairCandles takes ?pair=TBYT/USDT-POS, ?interval=1m, 5m, 1h or 1d (default
+// 1h) and ?page=n (default 1)
+func pairCandles(r *http.Request) (interface{}, *Error) {
+	query := r.URL.Query()
+	if query.Get("pair") == "" {
+		return nil, badRequest("pair is required")
+	}
+	interval := query.Get("interval")
+	if interval == "" {
+		interval = "1h"
+	}
+	page := 1
+	if value := query.Get("page"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return nil, badRequest("page must be 1 or more")
+		}
+		page = parsed
+	}
+	isGot, message, candles := exchange.ListCandles(query.Get("pair"), interval, page)
+	if !isGot {
+		return nil, rejected(message)
+	}
+	res := CandlesResponse{Pair: query.Get("pair"), Interval: interval, Page: page, Candles: make([]Candle, 0, len(candles))}
+	for _, candle := range candles {
+		res.Candles = append(res.Candles, Candle{
+			OpenTime:    candle.TMP,
+			Open:        candle.O,
+			High:        candle.H,
+			Low:         candle.L,
+			Close:       candle.C,
+			Volume:      candle.VOL,
+			QuoteVolume: candle.QVOL,
+			Trades:      candle.N,
+		})
+	}
+	return res, nil
+}
+
+type Ticker struct {
+	Pair          string `json:"pair"`
+	Last          string `json:"last"`
+	Open          string `json:"open"`
+	High          string `json:"high"`
+	Low           string `json:"low"`
+	Volume        string `json:"volume"`      // of the base asset
+	QuoteVolume   string `json:"quoteVolume"` // of the quote asset
+	Trades        int64  `json:"trades"`
+	Change        string `json:"change"`
+	ChangePercent string `json:"changePercent"`
+	Timestamp     int64  `json:"timestamp"` // minute of the last trade
+}
+
+type TickerResponse struct {
+	Pairs []Ticker `json:"pairs"`
+}
+
+// pairTickers takes ?pair=TBYT/USDT-POS (every pair when absent)
+func pairTickers(r *http.Request) (interface{}, *Error) {
+	isGot, message, tickers := exchange.GetTickers(r.URL.Query().Get("pair"))
+	if !isGot {
+		return nil, rejected(message)
+	}
+	res := TickerResponse{Pairs: make([]Ticker, 0, len(tickers))}
+	for _, t := range tickers {
+		res.Pairs = append(res.Pairs, Ticker{
+			Pair:          t.Pair.Symbol(),
+			Last:          t.Last.String(),
+			Open:          t.Open.String(),
+			High:          t.High.String(),
+			Low:           t.Low.String(),
+			Volume:        t.Volume.String(),
+			QuoteVolume:   t.QuoteVolume.String(),
+			Trades:        t.Trades,
+			Change:        t.Change.String(),
+			ChangePercent: t.ChangePercent.Fixed(2),
+			Timestamp:     t.TMP,
+		})
+	}
+	return res, nil
+}
+
+type FeeRates struct {
+	Maker string `json:"maker"` // percent
+	Taker string `json:"taker"` // percent
+}
+
+type FeeTier struct {
+	Name   string `json:"name"`
+	Volume string `json:"volume"` // 30 day quote volume the tier applies from
+	FeeRates
+	Pairs map[string]FeeRates `json:"pairs,omitempty"` // by pair symbol
+}
+
+type FeeScheduleResponse struct {
+	Tiers []FeeTier `json:"tiers"` // trading is free without any
+}
+
+func feeSchedule(r *http.Request) (interface{}, *Error) {
+	isGot, message, schedule := exchange.GetFeeSchedule()
+	if !isGot {
+		return nil, rejected(message)
+	}
+	res := FeeScheduleResponse{Tiers: make([]FeeTier, 0, len(schedule.Tiers))}
+	for _, tier := range schedule.Tiers {
+		item := FeeTier{Name: tier.Name, Volume: tier.Volume, FeeRates: FeeRates{Maker: tier.Maker, Taker: tier.Taker}}
+		if len(tier.Pairs) > 0 {
+			item.Pairs = map[string]FeeRates{}
+			for symbol, rates := range tier.Pairs {
+				item.Pairs[symbol] = FeeRates{Maker: rates.Maker, Taker: rates.Taker}
+			}
+		}
+		res.Tiers = append(res.Tiers, item)
+	}
+	return res, nil
+}
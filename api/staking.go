@@ -0,0 +1,120 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"tbapi/staking"
+)
+
+type PlaceStakeRequest struct {
+	Auth
+	Amount string `json:"amount"`
+	Days   int    `json:"days"` // 7, 14, 21 or 29
+}
+
+type PlaceStakeResponse struct {
+	StakeID         string `json:"stakeId"`
+	Amount          string `json:"amount"`
+	MaturityAmount  string `json:"maturityAmount"`
+	MaturesAt       string `json:"maturesAt"`
+	ReferralPercent string `json:"referralPercent"`
+}
+
+func placeStake(r *http.Request) (interface{}, *Error) {
+	var req PlaceStakeRequest
+	if apiErr := decode(r, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := authenticate(req.Auth); apiErr != nil {
+		return nil, apiErr
+	}
+	if req.Amount == "" || req.Days == 0 {
+		return nil, badRequest("amount and days are required")
+	}
+	isStaked, message, receipt := staking.CreateStake(req.Address, req.Amount, strconv.Itoa(req.Days))
+	if !isStaked || receipt.EID.IsZero() {
+		return nil, rejected(message)
+	}
+	return PlaceStakeResponse{
+		StakeID:         receipt.EID.Hex(),
+		Amount:          receipt.AMT,
+		MaturityAmount:  receipt.MaturityAmount.String(),
+		MaturesAt:       receipt.MTMP,
+		ReferralPercent: receipt.ReferralPercent.String(),
+	}, nil
+}
+
+type UnstakeRequest struct {
+	Auth
+	StakeID string `json:"stakeId"`
+}
+
+type UnstakeResponse struct {
+	Message string `json:"message"`
+}
+
+func unstake(r *http.Request) (interface{}, *Error) {
+	var req UnstakeRequest
+	if apiErr := decode(r, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := authenticate(req.Auth); apiErr != nil {
+		return nil, apiErr
+	}
+	if req.StakeID == "" {
+		return nil, badRequest("stakeId is required")
+	}
+	isUnstaked, message := staking.UnstakeByID(req.Address, req.StakeID)
+	if !isUnstaked {
+		return nil, rejected(message)
+	}
+	return UnstakeResponse{Message: message}, nil
+}
+
+type StakeOrder struct {
+	StakeID   string `json:"stakeId"`
+	Amount    string `json:"amount"`
+	Profit    string `json:"profit"`
+	Days      string `json:"days"`
+	StakedAt  string `json:"stakedAt"`
+	MaturesAt string `json:"maturesAt"`
+	Status    string `json:"status"`
+}
+
+type StakeListResponse struct {
+	Stakes []StakeOrder `json:"stakes"`
+}
+
+func listStakes(r *http.Request) (interface{}, *Error) {
+	var req ListRequest
+	if apiErr := decode(r, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := authenticate(req.Auth); apiErr != nil {
+		return nil, apiErr
+	}
+	if req.Page <= 0 {
+		return nil, badRequest("page must be 1 or more")
+	}
+	isFound, message, stakes := staking.ListStakes(req.Address, strconv.Itoa(req.Page))
+	if !isFound {
+		return nil, rejected(message)
+	}
+	res := StakeListResponse{Stakes: make([]StakeOrder, 0, len(stakes))}
+	for _, stake := range stakes {
+		res.Stakes = append(res.Stakes, stakeOrder(stake))
+	}
+	return res, nil
+}
+
+func stakeOrder(stake staking.Stake) StakeOrder {
+	return StakeOrder{
+		StakeID:   stake.EID.Hex(),
+		Amount:    stake.AMT,
+		Profit:    stake.STKP,
+		Days:      stake.OPT,
+		StakedAt:  stake.STMP,
+		MaturesAt: stake.MTMP,
+		Status:    stake.STAT,
+	}
+}
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"tbapi/exchange"
+	"tbapi/stream"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	streamBuffer    = 256 // events queued for a connection before it is dropped
+	streamPing      = 30 * time.Second
+	streamPongWait  = 70 * time.Second
+	streamWriteWait = 10 * time.Second
+	streamMaxFrame  = 4096
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	// credentials travel inside the socket, not in cookies, so any origin may
+	// connect
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// StreamRequest is a message from the client. Auth unlocks the private
+// channels (orders, balances, stakes) with the same credentials as the other
+// endpoints; depth:<pair> and trades:<pair> need none.
+type StreamRequest struct {
+	Op       string   `json:"op"` // auth, subscribe or unsubscribe
+	Channels []string `json:"channels,omitempty"`
+	Auth
+}
+
+// StreamMessage is a message to the client, either the reply to an op or an
+// event of a channel
+type StreamMessage struct {
+	Op       string      `json:"op,omitempty"`
+	Channels []string    `json:"channels,omitempty"`
+	Channel  string      `json:"channel,omitempty"`
+	Data     interface{} `json:"data,omitempty"`
+	Error    *Error      `json:"error,omitempty"`
+}
+
+type Trade struct {
+	TradeID   string `json:"tradeId"`
+	Pair      string `json:"pair"`
+	Price     string `json:"price"`
+	Amount    string `json:"amount"` // of the base asset
+	Total     string `json:"total"`  // of the quote asset
+	TakerSide string `json:"takerSide"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type BalanceUpdate struct {
+	Asset     string `json:"asset"` // balance field
+	Change    string `json:"change"`
+	Balance   string `json:"balance"`
+	Kind      string `json:"kind"`
+	Ref       string `json:"ref"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type StakeUpdate struct {
+	StakeOrder
+	Matured bool `json:"matured"`
+}
+
+// serveStream upgrades GET /v2/stream to a WebSocket
+func serveStream(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	hub := stream.Shared()
+	sub := hub.Subscribe(streamBuffer)
+	replies := make(chan StreamMessage, 16)
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		readStream(conn, sub, replies, stop)
+	}()
+	writeStream(conn, sub, replies, done)
+	close(stop)
+	hub.Unsubscribe(sub)
+	conn.Close()
+	<-done
+}
+
+// readStream handles the requests of the client until it goes away or the
+// writer stops
+func readStream(conn *websocket.Conn, sub *stream.Subscriber, replies chan<- StreamMessage, stop <-chan struct{}) {
+	conn.SetReadLimit(streamMaxFrame)
+	conn.SetReadDeadline(time.Now().Add(streamPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(streamPongWait))
+	})
+	for {
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req StreamRequest
+		answer := []StreamMessage{{Op: "error", Error: badRequest("Request Malformed")}}
+		if err := json.Unmarshal(frame, &req); err == nil {
+			answer = handleStream(sub, req)
+		}
+		for _, reply := range answer {
+			select {
+			case replies <- reply:
+			case <-stop:
+				return
+			}
+		}
+	}
+}
+
+func handleStream(sub *stream.Subscriber, req StreamRequest) []StreamMessage {
+	switch req.Op {
+	case "auth":
+		if apiErr := authenticate(req.Auth); apiErr != nil {
+			return []StreamMessage{{Op: "error", Error: apiErr}}
+		}
+		sub.Authorize(req.Address)
+		return []StreamMessage{{Op: "auth"}}
+	case "subscribe":
+		replies := []StreamMessage{}
+		for _, channel := range req.Channels {
+			if apiErr := checkChannel(sub, channel); apiErr != nil {
+				return []StreamMessage{{Op: "error", Error: apiErr}}
+			}
+		}
+		for _, channel := range req.Channels {
+			sub.Join(channel)
+			// depth starts from a snapshot, the other channels only carry changes
+			if kind, symbol := stream.SplitChannel(channel); kind == stream.ChannelDepth {
+				if isGot, _, depths := exchange.GetDepth(symbol, stream.DepthLevels); isGot && len(depths) == 1 {
+					replies = append(replies, StreamMessage{Channel: channel, Data: pairDepth(depths[0])})
+				}
+			}
+		}
+		return append([]StreamMessage{{Op: "subscribed", Channels: req.Channels}}, replies...)
+	case "unsubscribe":
+		for _, channel := range req.Channels {
+			sub.Leave(channel)
+		}
+		return []StreamMessage{{Op: "unsubscribed", Channels: req.Channels}}
+	}
+	return []StreamMessage{{Op: "error", Error: badRequest("op must be auth, subscribe or unsubscribe")}}
+}
+
+func checkChannel(sub *stream.Subscriber, channel string) *Error {
+	if stream.IsPrivate(channel) {
+		if sub.Account() == "" {
+			return &Error{Code: CodeUnauthorized, Message: "Authenticate before subscribing to " + channel}
+		}
+		return nil
+	}
+	kind, symbol := stream.SplitChannel(channel)
+	if kind != stream.ChannelDepth && kind != stream.ChannelTrades {
+		return badRequest("Unknown channel " + channel)
+	}
+	if _, isPair := exchange.PairBySymbol(symbol); !isPair {
+		return &Error{Code: CodeNotFound, Message: "Pair not found: " + symbol}
+	}
+	return nil
+}
+
+// writeStream sends replies and events until the client or the hub drops the
+// connection, pinging it so dead connections are noticed
+func writeStream(conn *websocket.Conn, sub *stream.Subscriber, replies <-chan StreamMessage, done <-chan struct{}) {
+	ping := time.NewTicker(streamPing)
+	defer ping.Stop()
+	for {
+		var msg StreamMessage
+		select {
+		case <-done:
+			return
+		case reply := <-replies:
+			msg = reply
+		case ev, isOpen := <-sub.C:
+			if !isOpen {
+				// too slow to keep up
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too slow"), time.Now().Add(streamWriteWait))
+				return
+			}
+			msg = StreamMessage{Channel: ev.Channel, Data: streamData(ev.Data)}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteWait)); err != nil {
+				return
+			}
+			continue
+		}
+		conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+		if err := conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+}
+
+// streamData is the JSON view of an event of the stream package
+func streamData(data interface{}) interface{} {
+	switch v := data.(type) {
+	case exchange.Depth:
+		return pairDepth(v)
+	case exchange.FillRecord:
+		return Trade{
+			TradeID:   v.FID.Hex(),
+			Pair:      v.PAIR,
+			Price:     v.PRC,
+			Amount:    v.AMT,
+			Total:     v.QAMT,
+			TakerSide: v.SIDE,
+			Timestamp: v.TMP,
+		}
+	case exchange.ExOrder:
+		return exchangeOrder(v)
+	case stream.BalanceChange:
+		return BalanceUpdate{
+			Asset:     v.Field,
+			Change:    v.Amount.String(),
+			Balance:   v.Balance.String(),
+			Kind:      v.Kind,
+			Ref:       v.Ref,
+			Timestamp: v.TMP,
+		}
+	case stream.StakeUpdate:
+		return StakeUpdate{StakeOrder: stakeOrder(v.Stake), Matured: v.Matured}
+	}
+	return data
+}
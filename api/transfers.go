@@ -0,0 +1,93 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"tbapi/transfer"
+)
+
+type TransferRequest struct {
+	Auth
+	Recipient string `json:"recipient"`
+	Amount    string `json:"amount"`
+	Asset     string `json:"asset"` // USDT-PoS, USDT-ERC or TBYT-PoS
+}
+
+type TransferOrder struct {
+	Sender           string `json:"sender"`
+	Receiver         string `json:"receiver"`
+	RecipientAddress string `json:"recipientAddress"`
+	Amount           string `json:"amount"`
+	Asset            string `json:"asset"`
+	Type             string `json:"type"`
+	Timestamp        string `json:"timestamp"`
+	Status           string `json:"status"`
+	Fee              string `json:"fee"`
+	Reference        string `json:"reference,omitempty"`
+	TxHash           string `json:"txHash,omitempty"`
+}
+
+func sendTransfer(r *http.Request) (interface{}, *Error) {
+	var req TransferRequest
+	if apiErr := decode(r, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := authenticate(req.Auth); apiErr != nil {
+		return nil, apiErr
+	}
+	if req.Recipient == "" || req.Amount == "" || req.Asset == "" {
+		return nil, badRequest("recipient, amount and asset are required")
+	}
+	isTransfered, message, order := transfer.SendAsset(req.Address, req.Recipient, req.Amount, req.Asset)
+	if !isTransfered {
+		return nil, rejected(message)
+	}
+	return toTransferOrder(order), nil
+}
+
+type ListRequest struct {
+	Auth
+	Page int `json:"page"`
+}
+
+type TransferListResponse struct {
+	Orders []TransferOrder `json:"orders"`
+}
+
+func listTransfers(r *http.Request) (interface{}, *Error) {
+	var req ListRequest
+	if apiErr := decode(r, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := authenticate(req.Auth); apiErr != nil {
+		return nil, apiErr
+	}
+	if req.Page <= 0 {
+		return nil, badRequest("page must be 1 or more")
+	}
+	isFound, message, orders := transfer.ListOrders(req.Address, strconv.Itoa(req.Page))
+	if !isFound {
+		return nil, rejected(message)
+	}
+	res := TransferListResponse{Orders: make([]TransferOrder, 0, len(orders))}
+	for _, order := range orders {
+		res.Orders = append(res.Orders, toTransferOrder(order))
+	}
+	return res, nil
+}
+
+func toTransferOrder(order transfer.Order) TransferOrder {
+	return TransferOrder{
+		Sender:           order.SADD,
+		Receiver:         order.RADD,
+		RecipientAddress: order.CADD,
+		Amount:           order.AMT,
+		Asset:            order.CTP,
+		Type:             order.TYP,
+		Timestamp:        order.TMP,
+		Status:           order.STAT,
+		Fee:              order.FEE,
+		Reference:        order.REF,
+		TxHash:           order.TXH,
+	}
+}
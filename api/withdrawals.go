@@ -0,0 +1,119 @@
+package api
+
+import (
+	"net/http"
+	"tbapi/money"
+	"tbapi/withdraw"
+)
+
+type WithdrawalQuoteRequest struct {
+	Amount string `json:"amount"`
+	Asset  string `json:"asset"` // USDT-PoS, USDT-ERC or another chain asset
+}
+
+type WithdrawalQuote struct {
+	Asset  string `json:"asset"`
+	Amount string `json:"amount"`
+	Fee    string `json:"fee"`
+	Total  string `json:"total"`  // debited from the balance
+	Review bool   `json:"review"` // waits for an admin before it is sent
+}
+
+type WithdrawalRequest struct {
+	Auth
+	Recipient string `json:"recipient"` // external address
+	Amount    string `json:"amount"`
+	Asset     string `json:"asset"`
+}
+
+type Withdrawal struct {
+	ID        string `json:"id"`
+	Asset     string `json:"asset"`
+	Recipient string `json:"recipient"`
+	Amount    string `json:"amount"`
+	Fee       string `json:"fee"`
+	Status    string `json:"status"` // review, pending, broadcast, confirmed or failed
+	TxHash    string `json:"txHash,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type WithdrawalListResponse struct {
+	Withdrawals []Withdrawal `json:"withdrawals"`
+}
+
+func quoteWithdrawal(r *http.Request) (interface{}, *Error) {
+	var req WithdrawalQuoteRequest
+	if apiErr := decode(r, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if req.Amount == "" || req.Asset == "" {
+		return nil, badRequest("amount and asset are required")
+	}
+	isQuoted, message, quote := withdraw.QuoteWithdrawal(req.Asset, req.Amount)
+	if !isQuoted {
+		return nil, rejected(message)
+	}
+	return WithdrawalQuote{
+		Asset:  quote.Asset.Code,
+		Amount: quote.Amount.Format(quote.Asset),
+		Fee:    quote.Fee.Format(quote.Asset),
+		Total:  quote.Total.Format(quote.Asset),
+		Review: quote.Review,
+	}, nil
+}
+
+func requestWithdrawal(r *http.Request) (interface{}, *Error) {
+	var req WithdrawalRequest
+	if apiErr := decode(r, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := authenticate(req.Auth); apiErr != nil {
+		return nil, apiErr
+	}
+	if req.Recipient == "" || req.Amount == "" || req.Asset == "" {
+		return nil, badRequest("recipient, amount and asset are required")
+	}
+	isQueued, message, w := withdraw.Request(req.Address, req.Asset, req.Recipient, req.Amount)
+	if !isQueued {
+		return nil, rejected(message)
+	}
+	return toWithdrawal(w), nil
+}
+
+func listWithdrawals(r *http.Request) (interface{}, *Error) {
+	var req ListRequest
+	if apiErr := decode(r, &req); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := authenticate(req.Auth); apiErr != nil {
+		return nil, apiErr
+	}
+	if req.Page <= 0 {
+		return nil, badRequest("page must be 1 or more")
+	}
+	isFound, message, withdrawals := withdraw.ListWithdrawals(req.Address, req.Page)
+	if !isFound {
+		return nil, rejected(message)
+	}
+	res := WithdrawalListResponse{Withdrawals: make([]Withdrawal, 0, len(withdrawals))}
+	for _, w := range withdrawals {
+		res.Withdrawals = append(res.Withdrawals, toWithdrawal(w))
+	}
+	return res, nil
+}
+
+func toWithdrawal(w withdraw.Withdrawal) Withdrawal {
+	asset, _ := money.Lookup(w.CHAIN)
+	return Withdrawal{
+		ID:        w.EID.Hex(),
+		Asset:     asset.Code,
+		Recipient: w.TO,
+		Amount:    w.Amount().Format(asset),
+		Fee:       w.Fee().Format(asset),
+		Status:    w.STATUS,
+		TxHash:    w.TXH,
+		Error:     w.ERR,
+		Timestamp: w.TMP,
+	}
+}
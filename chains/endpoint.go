@@ -0,0 +1,215 @@
+package chains
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// breakerFailures consecutive faults open the breaker of an endpoint
+	breakerFailures = 3
+	// breakerCooldown is how long an open endpoint is skipped before it gets
+	// one trial call
+	breakerCooldown = 30 * time.Second
+	// ewmaWeight is the share of the newest call in the latency and error rate
+	ewmaWeight = 0.1
+)
+
+// endpoint is one RPC provider of a chain with its breaker and statistics
+type endpoint struct {
+	url     string
+	label   string // host and position, the URL may carry an access token
+	chainID uint64
+
+	mu        sync.Mutex
+	client    *ethclient.Client
+	verified  bool // the chain id was checked
+	failures  int  // consecutive faults
+	openUntil time.Time
+	trial     bool // a half open trial call is running
+	calls     int64
+	faults    int64
+	latency   float64 // ms, moving average
+	errorRate float64 // moving average
+	lastError string
+	lastAt    time.Time
+	head      uint64 // block number of the last probe
+}
+
+func newEndpoint(position int, rawURL string, chainID uint64) *endpoint {
+	host := "endpoint"
+	if parsed, err := url.Parse(rawURL); err == nil && parsed.Host != "" {
+		host = parsed.Host
+	}
+	return &endpoint{url: rawURL, label: fmt.Sprintf("%d %s", position, host), chainID: chainID}
+}
+
+// acquire reports whether the endpoint takes a call now. An open breaker lets
+// a single trial call through once the cooldown passed.
+func (e *endpoint) acquire(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.failures < breakerFailures {
+		return true
+	}
+	if now.Before(e.openUntil) || e.trial {
+		return false
+	}
+	e.trial = true
+	return true
+}
+
+// connect dials on first use and checks the endpoint serves the chain
+func (e *endpoint) connect(ctx context.Context) (*ethclient.Client, error) {
+	e.mu.Lock()
+	client, verified := e.client, e.verified
+	e.mu.Unlock()
+	if client == nil {
+		dialed, err := ethclient.DialContext(ctx, e.url)
+		if err != nil {
+			return nil, err
+		}
+		e.mu.Lock()
+		if e.client == nil {
+			e.client = dialed
+		} else {
+			dialed.Close()
+		}
+		client = e.client
+		e.mu.Unlock()
+	}
+	if verified || e.chainID == 0 {
+		return client, nil
+	}
+	remoteID, err := client.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if remoteID.Uint64() != e.chainID {
+		return nil, errWrongChain{remoteID.Uint64()}
+	}
+	e.mu.Lock()
+	e.verified = true
+	e.mu.Unlock()
+	return client, nil
+}
+
+type errWrongChain struct {
+	chainID uint64
+}
+
+func (err errWrongChain) Error() string {
+	return fmt.Sprintf("wrong chain id %d", err.chainID)
+}
+
+// book records the outcome of a call made for parent, or only ends the trial
+// when parent gave up on it
+func (e *endpoint) book(parent context.Context, took time.Duration, err error, fault bool) {
+	if err != nil && parent.Err() != nil {
+		e.mu.Lock()
+		e.trial = false
+		e.mu.Unlock()
+		return
+	}
+	e.record(took, err, fault)
+}
+
+// record books the outcome of a call, fault tells whether the endpoint itself
+// failed rather than the node rejecting the request
+func (e *endpoint) record(took time.Duration, err error, fault bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.trial = false
+	e.calls++
+	e.lastAt = time.Now()
+	ms := float64(took.Microseconds()) / 1000
+	if e.calls == 1 {
+		e.latency = ms
+	} else {
+		e.latency += ewmaWeight * (ms - e.latency)
+	}
+	outcome := 0.0
+	if fault {
+		outcome = 1
+	}
+	e.errorRate += ewmaWeight * (outcome - e.errorRate)
+	if !fault {
+		e.failures = 0
+		return
+	}
+	e.faults++
+	e.failures++
+	e.lastError = strings.ReplaceAll(err.Error(), e.url, e.label)
+	if e.failures >= breakerFailures {
+		e.openUntil = time.Now().Add(breakerCooldown)
+	}
+}
+
+func (e *endpoint) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.client != nil {
+		e.client.Close()
+		e.client = nil
+		e.verified = false
+	}
+}
+
+// isFault tells whether err is the endpoint's fault, as opposed to an answer
+// of the node (a revert, a missing receipt, a rejected transaction) that any
+// other endpoint would give as well
+func isFault(err error) bool {
+	if errors.Is(err, ethereum.NotFound) {
+		return false
+	}
+	var rpcErr rpc.Error
+	return !errors.As(err, &rpcErr)
+}
+
+// EndpointHealth is the state of one provider
+type EndpointHealth struct {
+	Endpoint  string  `json:"endpoint"`
+	State     string  `json:"state"` // closed, open or half-open
+	Calls     int64   `json:"calls"`
+	Faults    int64   `json:"faults"`
+	LatencyMS float64 `json:"latencyMs"`
+	ErrorRate float64 `json:"errorRate"`
+	Head      uint64  `json:"head,omitempty"`
+	LastError string  `json:"lastError,omitempty"`
+	LastCall  int64   `json:"lastCall,omitempty"`
+}
+
+func (e *endpoint) health(now time.Time) EndpointHealth {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	state := "closed"
+	if e.failures >= breakerFailures {
+		state = "open"
+		if !now.Before(e.openUntil) {
+			state = "half-open"
+		}
+	}
+	health := EndpointHealth{
+		Endpoint:  e.label,
+		State:     state,
+		Calls:     e.calls,
+		Faults:    e.faults,
+		LatencyMS: float64(int64(e.latency*10)) / 10,
+		ErrorRate: float64(int64(e.errorRate*1000)) / 1000,
+		Head:      e.head,
+		LastError: e.lastError,
+	}
+	if !e.lastAt.IsZero() {
+		health.LastCall = e.lastAt.Unix()
+	}
+	return health
+}
@@ -0,0 +1,82 @@
+package chains
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// ChainHealth is the state of the endpoints of one chain
+type ChainHealth struct {
+	Chain     string           `json:"chain"`
+	Endpoints []EndpointHealth `json:"endpoints"`
+	Error     string           `json:"error,omitempty"`
+}
+
+func (p *Pool) Health() ChainHealth {
+	now := time.Now()
+	health := ChainHealth{Chain: p.chain.Name}
+	for _, e := range p.endpoints {
+		health.Endpoints = append(health.Endpoints, e.health(now))
+	}
+	return health
+}
+
+// Probe asks every endpoint for its head block. It keeps the statistics of
+// fallbacks that get no traffic current and gives open endpoints their trial
+// call.
+func (p *Pool) Probe(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, e := range p.endpoints {
+		if !e.acquire(time.Now()) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			head, err := attempt(ctx, e, callTimeout, func(ctx context.Context, client *ethclient.Client) (uint64, error) {
+				return client.BlockNumber(ctx)
+			})
+			if err == nil {
+				e.mu.Lock()
+				e.head = head
+				e.mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Health reports every chain of the registry
+func Health() []ChainHealth {
+	var report []ChainHealth
+	for _, chain := range All() {
+		pool, err := Shared(chain.Name)
+		if err != nil {
+			report = append(report, ChainHealth{Chain: chain.Name, Error: err.Error()})
+			continue
+		}
+		report = append(report, pool.Health())
+	}
+	return report
+}
+
+// Monitor probes the pools of every chain of the registry each interval until
+// ctx is done
+func Monitor(ctx context.Context, interval time.Duration) {
+	for {
+		for _, chain := range All() {
+			// a chain without endpoints shows its error in Health
+			if pool, err := Shared(chain.Name); err == nil {
+				pool.Probe(ctx)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package chains
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const (
+	// callTimeout bounds one call to one endpoint
+	callTimeout = 10 * time.Second
+	// logsTimeout bounds eth_getLogs, which scans block ranges
+	logsTimeout = 30 * time.Second
+	// attempts is how many endpoints a call is tried on
+	attempts = 3
+	// backoff is the pause before the second attempt, doubled for each next one
+	backoff = 200 * time.Millisecond
+)
+
+// ErrUnavailable is returned when every endpoint of the chain is open
+var ErrUnavailable = errors.New("no rpc endpoint available")
+
+// Pool spreads the calls of one chain over its endpoints in order of
+// preference. A call that times out or fails in transport moves on to the
+// next endpoint after a backoff, and an endpoint failing repeatedly is skipped
+// for a while. It satisfies the Backend of the watcher, the sweeper, the
+// withdrawal signer and the fee oracle.
+type Pool struct {
+	chain     Chain
+	endpoints []*endpoint
+
+	mu       sync.Mutex
+	decimals map[common.Address]int
+}
+
+func NewPool(chain Chain) (*Pool, error) {
+	urls := chain.Endpoints()
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("%s: no rpc endpoint configured", chain.Name)
+	}
+	pool := &Pool{chain: chain, decimals: map[common.Address]int{}}
+	for i, url := range urls {
+		pool.endpoints = append(pool.endpoints, newEndpoint(i+1, url, chain.ChainID))
+	}
+	return pool, nil
+}
+
+var (
+	poolsMu sync.Mutex
+	pools   = map[string]*Pool{}
+)
+
+// Shared returns the pool of the named chain, created on first use and kept
+// for the life of the process
+func Shared(name string) (*Pool, error) {
+	if _, err := Load(); err != nil {
+		return nil, err
+	}
+	chain, found := Get(name)
+	if !found {
+		return nil, fmt.Errorf("unknown chain %q", name)
+	}
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	if pool, found := pools[name]; found {
+		return pool, nil
+	}
+	pool, err := NewPool(chain)
+	if err != nil {
+		return nil, err
+	}
+	pools[name] = pool
+	return pool, nil
+}
+
+func (p *Pool) Chain() Chain {
+	return p.chain
+}
+
+func (p *Pool) Close() {
+	for _, e := range p.endpoints {
+		e.close()
+	}
+}
+
+// call runs fn on the preferred available endpoint, failing over on faults
+func call[T any](ctx context.Context, p *Pool, timeout time.Duration, fn func(ctx context.Context, client *ethclient.Client) (T, error)) (T, error) {
+	var zero T
+	var lastErr error
+	tried := map[*endpoint]bool{}
+	for n := 0; n < attempts; n++ {
+		if n > 0 {
+			select {
+			case <-ctx.Done():
+				return zero, ctx.Err()
+			case <-time.After(backoff << (n - 1)):
+			}
+		}
+		e := p.pick(tried)
+		if e == nil {
+			break
+		}
+		tried[e] = true
+		result, err := attempt(ctx, e, timeout, fn)
+		if err == nil || !isFault(err) || ctx.Err() != nil {
+			return result, err
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		return zero, fmt.Errorf("%s: %w", p.chain.Name, ErrUnavailable)
+	}
+	return zero, fmt.Errorf("%s: %w", p.chain.Name, lastErr)
+}
+
+// pick prefers an endpoint not tried yet for this call, in order of
+// preference, and otherwise retries one that was
+func (p *Pool) pick(tried map[*endpoint]bool) *endpoint {
+	now := time.Now()
+	for _, e := range p.endpoints {
+		if !tried[e] && e.acquire(now) {
+			return e
+		}
+	}
+	for _, e := range p.endpoints {
+		if tried[e] && e.acquire(now) {
+			return e
+		}
+	}
+	return nil
+}
+
+// attempt runs fn on e once. A call given up by the caller, cancelled or past
+// its deadline, says nothing about the endpoint and is not booked.
+func attempt[T any](parent context.Context, e *endpoint, timeout time.Duration, fn func(ctx context.Context, client *ethclient.Client) (T, error)) (T, error) {
+	var zero T
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	start := time.Now()
+	client, err := e.connect(ctx)
+	if err != nil {
+		e.book(parent, time.Since(start), err, true)
+		return zero, err
+	}
+	result, err := fn(ctx, client)
+	e.book(parent, time.Since(start), err, err != nil && isFault(err))
+	return result, err
+}
+
+func (p *Pool) ChainID(ctx context.Context) (*big.Int, error) {
+	if p.chain.ChainID != 0 {
+		return new(big.Int).SetUint64(p.chain.ChainID), nil
+	}
+	return call(ctx, p, callTimeout, func(ctx context.Context, client *ethclient.Client) (*big.Int, error) {
+		return client.ChainID(ctx)
+	})
+}
+
+func (p *Pool) BlockNumber(ctx context.Context) (uint64, error) {
+	return call(ctx, p, callTimeout, func(ctx context.Context, client *ethclient.Client) (uint64, error) {
+		return client.BlockNumber(ctx)
+	})
+}
+
+func (p *Pool) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return call(ctx, p, callTimeout, func(ctx context.Context, client *ethclient.Client) (*types.Header, error) {
+		return client.HeaderByNumber(ctx, number)
+	})
+}
+
+func (p *Pool) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	return call(ctx, p, callTimeout, func(ctx context.Context, client *ethclient.Client) (*big.Int, error) {
+		return client.BalanceAt(ctx, account, blockNumber)
+	})
+}
+
+func (p *Pool) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return call(ctx, p, callTimeout, func(ctx context.Context, client *ethclient.Client) ([]byte, error) {
+		return client.CallContract(ctx, msg, blockNumber)
+	})
+}
+
+func (p *Pool) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	return call(ctx, p, logsTimeout, func(ctx context.Context, client *ethclient.Client) ([]types.Log, error) {
+		return client.FilterLogs(ctx, query)
+	})
+}
+
+func (p *Pool) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return call(ctx, p, callTimeout, func(ctx context.Context, client *ethclient.Client) (uint64, error) {
+		return client.PendingNonceAt(ctx, account)
+	})
+}
+
+func (p *Pool) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	return call(ctx, p, callTimeout, func(ctx context.Context, client *ethclient.Client) (uint64, error) {
+		return client.NonceAt(ctx, account, blockNumber)
+	})
+}
+
+func (p *Pool) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	return call(ctx, p, callTimeout, func(ctx context.Context, client *ethclient.Client) (*big.Int, error) {
+		return client.SuggestGasTipCap(ctx)
+	})
+}
+
+func (p *Pool) FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error) {
+	return call(ctx, p, callTimeout, func(ctx context.Context, client *ethclient.Client) (*ethereum.FeeHistory, error) {
+		return client.FeeHistory(ctx, blockCount, lastBlock, rewardPercentiles)
+	})
+}
+
+func (p *Pool) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error) {
+	return call(ctx, p, callTimeout, func(ctx context.Context, client *ethclient.Client) (uint64, error) {
+		return client.EstimateGas(ctx, msg)
+	})
+}
+
+// SendTransaction may reach more than one endpoint, which is harmless for a
+// signed transaction: the nodes know it by its hash
+func (p *Pool) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	_, err := call(ctx, p, callTimeout, func(ctx context.Context, client *ethclient.Client) (struct{}, error) {
+		return struct{}{}, client.SendTransaction(ctx, tx)
+	})
+	return err
+}
+
+func (p *Pool) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	return call(ctx, p, callTimeout, func(ctx context.Context, client *ethclient.Client) (*types.Receipt, error) {
+		return client.TransactionReceipt(ctx, txHash)
+	})
+}
+
+var decimalsABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(`[{"constant":true,"inputs":[],"name":"decimals","outputs":[{"name":"","type":"uint8"}],"type":"function"}]`))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// TokenDecimals reads decimals() of a token once per pool
+func (p *Pool) TokenDecimals(ctx context.Context, token common.Address) (int, error) {
+	p.mu.Lock()
+	decimals, found := p.decimals[token]
+	p.mu.Unlock()
+	if found {
+		return decimals, nil
+	}
+	data, err := decimalsABI.Pack("decimals")
+	if err != nil {
+		return 0, err
+	}
+	result, err := p.CallContract(ctx, ethereum.CallMsg{To: &token, Data: data}, nil)
+	if err != nil {
+		return 0, err
+	}
+	var read uint8
+	if err := decimalsABI.UnpackIntoInterface(&read, "decimals", result); err != nil {
+		return 0, err
+	}
+	p.mu.Lock()
+	p.decimals[token] = int(read)
+	p.mu.Unlock()
+	return int(read), nil
+}
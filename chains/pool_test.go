@@ -0,0 +1,155 @@
+package chains_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"tbapi/chains"
+	"tbapi/chaintest"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// TestPool puts a pool in front of a node that is down, one serving another
+// chain and a healthy one, then lets the healthy one revert a call
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+	var down, wrongChain, healthy atomic.Int64
+	downServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		down.Add(1)
+		http.Error(w, "maintenance", http.StatusServiceUnavailable)
+	}))
+	defer downServer.Close()
+	wrongServer := httptest.NewServer(jsonRPC(&wrongChain, "0x1"))
+	defer wrongServer.Close()
+	healthyServer := httptest.NewServer(jsonRPC(&healthy, "0x539"))
+	defer healthyServer.Close()
+
+	pool, err := chains.NewPool(chains.Chain{
+		Name:    "SIM",
+		ChainID: 1337,
+		RPC:     []string{downServer.URL, wrongServer.URL, healthyServer.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	for i := 0; i < 5; i++ {
+		head, err := pool.BlockNumber(ctx)
+		if err != nil || head != 42 {
+			t.Errorf("call %d: head %d, %v", i, head, err)
+		}
+	}
+	if down.Load() != 3 {
+		t.Errorf("the endpoint that is down got %d calls, want 3 before its breaker opened", down.Load())
+	}
+	if healthy.Load() < 5 {
+		t.Errorf("the healthy endpoint got %d calls", healthy.Load())
+	}
+
+	// a revert is the node's answer, no other endpoint is asked
+	before := healthy.Load()
+	_, err = pool.CallContract(ctx, ethereum.CallMsg{To: &chaintest.TokenAddress}, nil)
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		t.Errorf("revert came back as %v", err)
+	}
+	if healthy.Load() != before+1 {
+		t.Errorf("the revert took %d calls", healthy.Load()-before)
+	}
+
+	health := pool.Health()
+	if len(health.Endpoints) != 3 {
+		t.Errorf("%d endpoints in health", len(health.Endpoints))
+	}
+	if len(health.Endpoints) == 3 {
+		if health.Endpoints[0].State != "open" {
+			t.Errorf("endpoint that is down is %s", health.Endpoints[0].State)
+		}
+		if health.Endpoints[1].State != "open" {
+			t.Errorf("endpoint of another chain is %s", health.Endpoints[1].State)
+		}
+		if health.Endpoints[2].State != "closed" || health.Endpoints[2].Faults != 0 {
+			t.Errorf("healthy endpoint is %s with %d faults", health.Endpoints[2].State, health.Endpoints[2].Faults)
+		}
+	}
+	t.Logf("rpc health %+v", health.Endpoints)
+}
+
+// TestPoolCallerGivesUp lets callers cancel and time out calls to a slow
+// endpoint, which must not open its breaker
+func TestPoolCallerGivesUp(t *testing.T) {
+	var calls atomic.Int64
+	var isSlow atomic.Bool
+	release := make(chan struct{})
+	answer := jsonRPC(&calls, "0x539")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isSlow.Load() {
+			<-release
+			return
+		}
+		answer(w, r)
+	}))
+	defer server.Close()
+
+	pool, err := chains.NewPool(chains.Chain{Name: "SIM", RPC: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	isSlow.Store(true)
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		if i%2 == 1 {
+			cancel()
+		}
+		if _, err := pool.BlockNumber(ctx); err == nil {
+			t.Errorf("call %d: no error", i)
+		}
+		cancel()
+	}
+	isSlow.Store(false)
+	close(release)
+	health := pool.Health().Endpoints[0]
+	if health.State != "closed" || health.Faults != 0 {
+		t.Errorf("endpoint is %s with %d faults after its callers gave up", health.State, health.Faults)
+	}
+	if head, err := pool.BlockNumber(context.Background()); err != nil || head != 42 {
+		t.Errorf("head %d, %v after the callers gave up", head, err)
+	}
+}
+
+// jsonRPC answers eth_chainId with chainID, eth_blockNumber with 42 and
+// reverts every eth_call
+func jsonRPC(calls *atomic.Int64, chainID string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp := map[string]any{"jsonrpc": "2.0", "id": req.ID}
+		switch req.Method {
+		case "eth_chainId":
+			resp["result"] = chainID
+		case "eth_blockNumber":
+			resp["result"] = "0x2a"
+		default:
+			resp["error"] = map[string]any{"code": 3, "message": "execution reverted"}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
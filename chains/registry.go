@@ -0,0 +1,215 @@
+// Package chains is the registry of the EVM chains and tokens the platform
+// takes deposits in. It is read once from the JSON file named by CHAINS_FILE;
+// without one it holds USDT on Polygon and Ethereum, reached through
+// getblock.io with POS_API and ERC_API.
+//
+// Every entry is one token on one chain. Its Name is the tb_accounts balance
+// field and the chain of deposits and sweeps, its Asset the code it trades and
+// transfers under.
+package chains
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type Chain struct {
+	Name          string   `json:"name"`          // balance field and chain key (POS, ERC)
+	Asset         string   `json:"asset"`         // exchange code (USDT-POS)
+	Aliases       []string `json:"aliases"`       // other codes accepted for Asset (USDT-PoS)
+	Display       string   `json:"display"`       // USDT (Polygon)
+	ChainID       uint64   `json:"chainId"`       // checked when dialing, 0 skips the check
+	RPC           []string `json:"rpc"`           // endpoints in order of preference, ${VAR} is read from the environment
+	Token         string   `json:"token"`         // ERC-20 contract
+	Decimals      int      `json:"decimals"`      // token decimals, also the precision of the balance
+	Confirmations uint64   `json:"confirmations"` // blocks on top before a deposit is credited or a sweep settled
+	MinSweep      string   `json:"minSweep"`      // smallest deposit worth sweeping, in Asset
+	MinWithdraw   string   `json:"minWithdraw"`   // smallest withdrawal, in Asset
+	FeePrice      string   `json:"feePrice"`      // updatedFee key with the USD price of the native coin, empty disables withdrawals
+}
+
+// maxConfirmations keeps a reorg rescan, twice the confirmations, to a few
+// eth_getLogs calls
+const maxConfirmations = 1024
+
+// defaults apply without CHAINS_FILE
+var defaults = []Chain{
+	{
+		Name:          "POS",
+		Asset:         "USDT-POS",
+		Aliases:       []string{"USDT-PoS"},
+		Display:       "USDT (Polygon)",
+		ChainID:       137,
+		RPC:           []string{"https://go.getblock.io/${POS_API}"},
+		Token:         "0xc2132D05D31c914a87C6611C10748AEb04B58e8F",
+		Decimals:      6,
+		Confirmations: 64,
+		MinSweep:      "1",
+		MinWithdraw:   "5",
+		FeePrice:      "polygon",
+	},
+	{
+		Name:          "ERC",
+		Asset:         "USDT-ERC",
+		Display:       "USDT (Ethereum)",
+		ChainID:       1,
+		RPC:           []string{"https://go.getblock.io/${ERC_API}"},
+		Token:         "0xdAC17F958D2ee523a2206206994597C13D831ec7",
+		Decimals:      6,
+		Confirmations: 12,
+		MinSweep:      "20",
+		MinWithdraw:   "20",
+		FeePrice:      "eth",
+	},
+}
+
+// reserved are tb_accounts fields and codes a chain can't take
+var reserved = map[string]bool{
+	"ID": true, "TBT": true, "TBYT": true, "TBYT-POS": true, "NPT": true, "NPTP": true,
+	"EADD": true, "REF": true, "REFB": true, "REFS": true, "EKEY": true, "HDIX": true, "REFRESH": true,
+}
+
+var validName = regexp.MustCompile(`^[A-Z][A-Z0-9]{1,11}$`)
+
+var (
+	loadOnce sync.Once
+	loaded   []Chain
+	loadErr  error
+)
+
+// Load reads the registry on first use, after the .env file has been loaded.
+// A bad CHAINS_FILE leaves it empty and is returned on every call.
+func Load() ([]Chain, error) {
+	loadOnce.Do(func() {
+		loaded, loadErr = read(os.Getenv("CHAINS_FILE"))
+		if loadErr != nil {
+			loaded = nil
+		}
+	})
+	return loaded, loadErr
+}
+
+// All returns every chain, none when the registry doesn't load
+func All() []Chain {
+	all, _ := Load()
+	return all
+}
+
+// Get finds a chain by Name
+func Get(name string) (Chain, bool) {
+	for _, chain := range All() {
+		if chain.Name == name {
+			return chain, true
+		}
+	}
+	return Chain{}, false
+}
+
+// ByAsset finds a chain by its asset code or one of the aliases
+func ByAsset(code string) (Chain, bool) {
+	for _, chain := range All() {
+		if chain.Asset == code {
+			return chain, true
+		}
+		for _, alias := range chain.Aliases {
+			if alias == code {
+				return chain, true
+			}
+		}
+	}
+	return Chain{}, false
+}
+
+// Names lists the chain names in registry order
+func Names() []string {
+	var names []string
+	for _, chain := range All() {
+		names = append(names, chain.Name)
+	}
+	return names
+}
+
+// Endpoints returns the RPC URLs with the environment filled in, leaving out
+// those naming an unset variable
+func (c Chain) Endpoints() []string {
+	var endpoints []string
+	for _, rpc := range c.RPC {
+		isComplete := true
+		url := os.Expand(rpc, func(name string) string {
+			value := os.Getenv(name)
+			if value == "" {
+				isComplete = false
+			}
+			return value
+		})
+		if isComplete {
+			endpoints = append(endpoints, url)
+		}
+	}
+	return endpoints
+}
+
+func (c Chain) TokenAddress() common.Address {
+	return common.HexToAddress(c.Token)
+}
+
+func read(path string) ([]Chain, error) {
+	if path == "" {
+		return defaults, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("CHAINS_FILE: %w", err)
+	}
+	var registry []Chain
+	if err := json.Unmarshal(content, &registry); err != nil {
+		return nil, fmt.Errorf("CHAINS_FILE %s: %w", path, err)
+	}
+	if err := validate(registry); err != nil {
+		return nil, fmt.Errorf("CHAINS_FILE %s: %w", path, err)
+	}
+	return registry, nil
+}
+
+func validate(registry []Chain) error {
+	if len(registry) == 0 {
+		return errors.New("no chains")
+	}
+	taken := map[string]bool{}
+	for _, chain := range registry {
+		if !validName.MatchString(chain.Name) || reserved[chain.Name] {
+			return fmt.Errorf("chain name %q must be 2 to 12 upper case letters or digits and not an account field", chain.Name)
+		}
+		codes := append([]string{chain.Name, chain.Asset}, chain.Aliases...)
+		for i, code := range codes {
+			if code == "" || reserved[strings.ToUpper(code)] {
+				return fmt.Errorf("%s: code %q is not allowed", chain.Name, code)
+			}
+			// an asset code may equal its own chain name
+			if taken[code] && !(i > 0 && code == chain.Name) {
+				return fmt.Errorf("%s: code %q is used twice", chain.Name, code)
+			}
+			taken[code] = true
+		}
+		if !common.IsHexAddress(chain.Token) {
+			return fmt.Errorf("%s: token %q is not an address", chain.Name, chain.Token)
+		}
+		if chain.Decimals < 0 || chain.Decimals > 36 {
+			return fmt.Errorf("%s: decimals %d out of range", chain.Name, chain.Decimals)
+		}
+		if len(chain.RPC) == 0 {
+			return fmt.Errorf("%s: no rpc endpoint", chain.Name)
+		}
+		if chain.Confirmations == 0 || chain.Confirmations > maxConfirmations {
+			return fmt.Errorf("%s: confirmations %d out of range 1 to %d", chain.Name, chain.Confirmations, maxConfirmations)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// Package chaintest holds what the watcher, sweeper, signer, fee oracle and
+// RPC pool tests share to run against go-ethereum's simulated backend: a bare
+// 6 decimal ERC-20 seeded into the genesis and a node that loses broadcasts.
+package chaintest
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+)
+
+var (
+	// TokenAddress is where the genesis places the token of TokenCode
+	TokenAddress = common.HexToAddress("0x00000000000000000000000000000000000070a1")
+	Ether        = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+)
+
+// USDT is n whole USDT in token units
+func USDT(n int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(n), big.NewInt(1000000))
+}
+
+// Token is the genesis account of the token with the given balances
+func Token(balances map[common.Address]*big.Int) types.Account {
+	storage := map[common.Hash]common.Hash{}
+	for owner, balance := range balances {
+		storage[common.BytesToHash(owner.Bytes())] = common.BigToHash(balance)
+	}
+	return types.Account{Code: TokenCode(), Balance: big.NewInt(0), Storage: storage}
+}
+
+// TokenBalance calls balanceOf of owner
+func TokenBalance(ctx context.Context, backend ethereum.ContractCaller, owner common.Address) (*big.Int, error) {
+	data := append(common.FromHex("0x70a08231"), common.LeftPadBytes(owner.Bytes(), 32)...)
+	result, err := backend.CallContract(ctx, ethereum.CallMsg{To: &TokenAddress, Data: data}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(result), nil
+}
+
+// FlakyBackend loses the first broadcast of every transaction
+type FlakyBackend struct {
+	simulated.Client
+	mu   sync.Mutex
+	sent map[common.Hash]bool
+}
+
+func NewFlakyBackend(client simulated.Client) *FlakyBackend {
+	return &FlakyBackend{Client: client, sent: map[common.Hash]bool{}}
+}
+
+func (b *FlakyBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	b.mu.Lock()
+	first := !b.sent[tx.Hash()]
+	b.sent[tx.Hash()] = true
+	b.mu.Unlock()
+	if first {
+		return errors.New("connection reset")
+	}
+	return b.Client.SendTransaction(ctx, tx)
+}
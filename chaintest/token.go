@@ -0,0 +1,100 @@
+package chaintest
+
+import "github.com/ethereum/go-ethereum/crypto"
+
+// EVM opcodes used by the token
+const (
+	opSTOP         = 0x00
+	opADD          = 0x01
+	opSUB          = 0x03
+	opLT           = 0x10
+	opEQ           = 0x14
+	opSHR          = 0x1c
+	opCALLER       = 0x33
+	opCALLDATALOAD = 0x35
+	opMSTORE       = 0x52
+	opSLOAD        = 0x54
+	opSSTORE       = 0x55
+	opJUMPI        = 0x57
+	opJUMPDEST     = 0x5b
+	opPUSH1        = 0x60
+	opPUSH2        = 0x61
+	opPUSH4        = 0x63
+	opPUSH32       = 0x7f
+	opDUP1         = 0x80
+	opDUP2         = 0x81
+	opDUP3         = 0x82
+	opSWAP1        = 0x90
+	opLOG3         = 0xa3
+	opRETURN       = 0xf3
+	opREVERT       = 0xfd
+)
+
+// assembler resolves jump labels to PUSH2 offsets
+type assembler struct {
+	code   []byte
+	labels map[string]int
+	refs   map[int]string
+}
+
+func (a *assembler) op(code ...byte) {
+	a.code = append(a.code, code...)
+}
+
+func (a *assembler) label(name string) {
+	a.labels[name] = len(a.code)
+	a.code = append(a.code, opJUMPDEST)
+}
+
+func (a *assembler) jumpi(name string) {
+	a.code = append(a.code, opPUSH2, 0, 0)
+	a.refs[len(a.code)-2] = name
+	a.code = append(a.code, opJUMPI)
+}
+
+func (a *assembler) bytes() []byte {
+	for at, name := range a.refs {
+		target := a.labels[name]
+		a.code[at], a.code[at+1] = byte(target>>8), byte(target)
+	}
+	return a.code
+}
+
+// TokenCode is the runtime code of a bare 6 decimal ERC-20 with balanceOf,
+// transfer and decimals. A balance lives in the storage slot of its address,
+// so balances are seeded straight into the genesis storage.
+func TokenCode() []byte {
+	a := &assembler{labels: map[string]int{}, refs: map[int]string{}}
+	// selector
+	a.op(opPUSH1, 0, opCALLDATALOAD, opPUSH1, 0xe0, opSHR)
+	a.op(opDUP1, opPUSH4, 0x70, 0xa0, 0x82, 0x31, opEQ)
+	a.jumpi("balanceOf")
+	a.op(opDUP1, opPUSH4, 0xa9, 0x05, 0x9c, 0xbb, opEQ)
+	a.jumpi("transfer")
+	a.op(opDUP1, opPUSH4, 0x31, 0x3c, 0xe5, 0x67, opEQ)
+	a.jumpi("decimals")
+	a.op(opPUSH1, 0, opDUP1, opREVERT)
+
+	a.label("balanceOf")
+	a.op(opPUSH1, 4, opCALLDATALOAD, opSLOAD, opPUSH1, 0, opMSTORE, opPUSH1, 32, opPUSH1, 0, opRETURN)
+
+	a.label("decimals")
+	a.op(opPUSH1, 6, opPUSH1, 0, opMSTORE, opPUSH1, 32, opPUSH1, 0, opRETURN)
+
+	a.label("transfer")
+	// amount, sender balance; revert when the balance is short
+	a.op(opPUSH1, 36, opCALLDATALOAD, opCALLER, opSLOAD)
+	a.op(opDUP2, opDUP2, opLT)
+	a.jumpi("fail")
+	a.op(opDUP2, opSWAP1, opSUB, opCALLER, opSSTORE)
+	a.op(opPUSH1, 4, opCALLDATALOAD, opDUP1, opSLOAD, opDUP3, opADD, opSWAP1, opSSTORE)
+	// Transfer(from, to, amount)
+	a.op(opPUSH1, 0, opMSTORE)
+	a.op(opPUSH1, 4, opCALLDATALOAD, opCALLER, opPUSH32)
+	a.op(crypto.Keccak256([]byte("Transfer(address,address,uint256)"))...)
+	a.op(opPUSH1, 32, opPUSH1, 0, opLOG3, opSTOP)
+
+	a.label("fail")
+	a.op(opPUSH1, 0, opDUP1, opREVERT)
+	return a.bytes()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"log"
+	"tbapi/modals"
+	"tbapi/money"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// migrateBalances converts the "%f" formatted balance strings and the mined
+// supply to Decimal128 on the server, it only touches fields that are still
+// strings so it can be re-run
+func migrateBalances(db *mongo.Database, args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	accounts := db.Collection("tb_accounts")
+
+	for _, field := range modals.BalanceFields() {
+		filter := bson.M{field: bson.M{"$type": "string"}}
+		pipeline := mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{field: bson.M{"$toDecimal": "$" + field}}}},
+		}
+		result, err := accounts.UpdateMany(ctx, filter, pipeline)
+		if err != nil {
+			return err
+		}
+		log.Printf("%s: converted %d accounts", field, result.ModifiedCount)
+
+		// accounts created before the field existed start at zero
+		missing := bson.M{field: bson.M{"$exists": false}}
+		result, err = accounts.UpdateMany(ctx, missing, bson.M{"$set": bson.M{field: money.Zero().Decimal128()}})
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount > 0 {
+			log.Printf("%s: initialised %d accounts", field, result.ModifiedCount)
+		}
+	}
+
+	// stake payouts $inc the mined supply
+	filter := bson.M{"type": "currencyInfo", "mined": bson.M{"$type": "string"}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"mined": bson.M{"$toDecimal": "$mined"}}}},
+	}
+	result, err := db.Collection("platformInfo").UpdateOne(ctx, filter, pipeline)
+	if err != nil {
+		return err
+	}
+	log.Printf("mined: converted %d documents", result.ModifiedCount)
+	return nil
+}
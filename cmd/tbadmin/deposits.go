@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"tbapi/chains"
+	"tbapi/watcher"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// reconcileDeposits compares the Transfer logs into deposit addresses with the
+// credited deposits:
+//
+//	tbadmin deposits reconcile <chain> <fromBlock> [toBlock] [credit]
+//
+// toBlock defaults to the last block the watcher scanned. Orphaned deposits are
+// listed with what was taken back and what the account had spent already and
+// is held. With credit the missing deposits and orphaned ones back on chain
+// are credited, but only from the first block the watcher scanned, since
+// earlier deposits were credited from balanceOf without a deposit record.
+func reconcileDeposits(db *mongo.Database, args []string) error {
+	if len(args) < 2 || len(args) > 4 {
+		return errors.New("usage: deposits reconcile <chain> <fromBlock> [toBlock] [credit]")
+	}
+	chainName := args[0]
+	if _, err := chains.Load(); err != nil {
+		return err
+	}
+	config, found := chains.Get(chainName)
+	if !found {
+		return fmt.Errorf("unknown chain %q, the registry has %s", chainName, strings.Join(chains.Names(), ", "))
+	}
+	from, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("fromBlock: %w", err)
+	}
+	credit := args[len(args)-1] == "credit"
+	if credit {
+		args = args[:len(args)-1]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	store := watcher.NewMongoStore(db)
+	first, last, found, err := store.Scanned(ctx, chainName)
+	if err != nil {
+		return err
+	}
+	to := last
+	if len(args) == 3 {
+		to, err = strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("toBlock: %w", err)
+		}
+	} else if !found {
+		return fmt.Errorf("the %s watcher never ran, give toBlock", chainName)
+	}
+	if from > to {
+		return fmt.Errorf("fromBlock %d is after toBlock %d", from, to)
+	}
+	if credit && (!found || from < first) {
+		return fmt.Errorf("%s deposits before block %d were credited from balanceOf, reconcile them without credit", chainName, first)
+	}
+
+	pool, err := chains.Shared(chainName)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	w, err := watcher.New(ctx, watcher.Chain{Name: chainName, Token: config.TokenAddress()}, pool, store)
+	if err != nil {
+		return err
+	}
+	report, err := w.Reconcile(ctx, from, to, credit)
+	if err != nil {
+		return err
+	}
+
+	for _, deposit := range report.Missing {
+		log.Printf("missing %s:%d block %d, %s to %s", deposit.TXH, deposit.LIX, deposit.BLK, deposit.AMT, deposit.ID)
+	}
+	for _, deposit := range report.Unbacked {
+		log.Printf("no log for %s %s:%d block %d, %s credited to %s", deposit.STATUS, deposit.TXH, deposit.LIX, deposit.BLK, deposit.AMT, deposit.ID)
+	}
+	for _, deposit := range report.Mismatched {
+		log.Printf("log differs from %s:%d block %d, %s credited to %s", deposit.TXH, deposit.LIX, deposit.BLK, deposit.AMT, deposit.ID)
+	}
+	held := 0
+	for _, deposit := range report.Orphaned {
+		if deposit.Held().Sign() > 0 {
+			held++
+		}
+		log.Printf("orphaned %s:%d block %d, %s credited to %s, %s taken back, %s held", deposit.TXH, deposit.LIX, deposit.BLK, deposit.AMT, deposit.ID, deposit.REV, deposit.Held())
+	}
+	for _, deposit := range report.Returned {
+		log.Printf("orphaned %s:%d is back on chain, %s to %s", deposit.TXH, deposit.LIX, deposit.AMT, deposit.ID)
+	}
+	log.Printf("%s blocks %d to %d: %d transfers, %d matched, %d orphaned (%d held), %d returned, %d credited now",
+		chainName, from, to, report.Transfers, report.Matched, len(report.Orphaned), held, len(report.Returned), report.Credited)
+
+	if len(report.Unbacked) > 0 || len(report.Mismatched) > 0 || len(report.Missing)+len(report.Returned) > report.Credited {
+		return errors.New("deposits don't match the chain")
+	}
+	if held > 0 {
+		return fmt.Errorf("%d orphaned deposits hold amounts the accounts spent, settle them by hand", held)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"tbapi/keystore"
+	"tbapi/modals"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// migrateDepositAddresses moves every account still on a legacy random key to
+// an HD deposit address. The old address and its sealed key are kept in
+// legacyWallets so late deposits to it can still be swept.
+func migrateDepositAddresses(db *mongo.Database, args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	accounts := db.Collection("tb_accounts")
+	legacyWallets := db.Collection("legacyWallets")
+
+	filter := bson.M{"EKEY": bson.M{"$type": "string", "$ne": ""}}
+	cursor, err := accounts.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var legacy []modals.User
+	if err := cursor.All(ctx, &legacy); err != nil {
+		return err
+	}
+
+	moved, failed := 0, 0
+	for _, account := range legacy {
+		sealedKey := account.EKEY
+		if !keystore.IsSealed(sealedKey) {
+			sealedKey, err = keystore.Seal(account.EKEY, account.EADD)
+			if err != nil {
+				return err
+			}
+		}
+		isReserved, wallet, message := modals.NextDepositWallet(ctx, db)
+		if !isReserved {
+			return fmt.Errorf("%s: %s", account.ID, message)
+		}
+		err := modals.RunTransaction(db, func(sc mongo.SessionContext) error {
+			_, err := legacyWallets.InsertOne(sc, bson.M{"ID": account.ID, "ADD": account.EADD, "EKEY": sealedKey})
+			if err != nil {
+				return err
+			}
+			// skip accounts whose address was rotated since they were read
+			result, err := accounts.UpdateOne(sc,
+				bson.M{"ID": account.ID, "EADD": account.EADD, "EKEY": account.EKEY},
+				bson.M{"$set": bson.M{"EADD": wallet.Address, "HDIX": wallet.HDIX}, "$unset": bson.M{"EKEY": ""}})
+			if err != nil {
+				return err
+			}
+			if result.ModifiedCount == 0 {
+				return modals.Abort("account changed meanwhile")
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("%s: %v", account.ID, err)
+			failed++
+			continue
+		}
+		moved++
+	}
+	log.Printf("moved %d accounts to HD deposit addresses, %d failed", moved, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d accounts were not moved, run again", failed)
+	}
+	return nil
+}
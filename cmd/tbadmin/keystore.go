@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"tbapi/keystore"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// keyHolders are the collections storing an EKEY with the address it belongs to
+var keyHolders = []struct {
+	Collection   string
+	AddressField string
+}{
+	{"tb_accounts", "EADD"},
+	{"secretsWallets", "ADD"},
+	{"legacyWallets", "ADD"},
+}
+
+// migrateKeys seals every EKEY still stored in plaintext
+func migrateKeys(db *mongo.Database, args []string) error {
+	return resealKeys(db, false)
+}
+
+// rotateKeys rewraps every EKEY not sealed under the current master key, the
+// previous keys have to stay configured in KEYSTORE_OLD_KEYS until it is done
+func rotateKeys(db *mongo.Database, args []string) error {
+	return resealKeys(db, true)
+}
+
+func resealKeys(db *mongo.Database, rotate bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	failed := 0
+	for _, holder := range keyHolders {
+		collection := db.Collection(holder.Collection)
+		filter := bson.M{"EKEY": bson.M{"$type": "string", "$ne": ""}}
+		cursor, err := collection.Find(ctx, filter)
+		if err != nil {
+			return err
+		}
+		updated, skipped := 0, 0
+		for cursor.Next(ctx) {
+			var document bson.M
+			if err := cursor.Decode(&document); err != nil {
+				cursor.Close(ctx)
+				return err
+			}
+			ID, _ := document["_id"].(primitive.ObjectID)
+			value, _ := document["EKEY"].(string)
+			address, _ := document[holder.AddressField].(string)
+			if !rotate && keystore.IsSealed(value) {
+				continue
+			}
+			needsRewrap, err := keystore.NeedsRewrap(value)
+			if err != nil {
+				log.Printf("%s %s: %v", holder.Collection, ID.Hex(), err)
+				skipped++
+				continue
+			}
+			if !needsRewrap {
+				continue
+			}
+			resealed, err := keystore.Rewrap(value, address)
+			if err != nil {
+				log.Printf("%s %s: %v", holder.Collection, ID.Hex(), err)
+				skipped++
+				continue
+			}
+			// only replace the value that was read, a key rotated meanwhile stays
+			result, err := collection.UpdateOne(ctx, bson.M{"_id": ID, "EKEY": value}, bson.M{"$set": bson.M{"EKEY": resealed}})
+			if err != nil {
+				cursor.Close(ctx)
+				return err
+			}
+			updated += int(result.ModifiedCount)
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("%s: resealed %d keys, %d failed", holder.Collection, updated, skipped)
+		failed += skipped
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d keys could not be resealed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"tbapi/ledger"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// verifyLedger recomputes every account from ledger_entries and fails when a
+// stored balance or a posting doesn't add up
+func verifyLedger(db *mongo.Database, args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	report, err := ledger.Verify(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	for _, drift := range report.Drifts {
+		log.Printf("drift %s %s: stored %s, ledger %s", drift.Account, drift.Field, drift.Stored, drift.Ledger)
+	}
+	for _, TXN := range report.Unbalanced {
+		log.Printf("unbalanced posting %s", TXN.Hex())
+	}
+	system := make([]string, 0, len(report.System))
+	for key := range report.System {
+		system = append(system, key)
+	}
+	sort.Strings(system)
+	for _, key := range system {
+		log.Printf("%s: %s", key, report.System[key])
+	}
+	log.Printf("checked %d accounts", report.Accounts)
+
+	if len(report.Drifts) > 0 || len(report.Unbalanced) > 0 {
+		return fmt.Errorf("%d drifted balances, %d unbalanced postings", len(report.Drifts), len(report.Unbalanced))
+	}
+	return nil
+}
+
+// openLedger records the balances that predate the ledger as opening entries
+func openLedger(db *mongo.Database, args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	if err := ledger.EnsureIndexes(ctx, db); err != nil {
+		return err
+	}
+	opened, err := ledger.Open(ctx, db)
+	if err != nil {
+		return err
+	}
+	log.Printf("opened %d accounts", opened)
+	return nil
+}
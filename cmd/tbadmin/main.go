@@ -0,0 +1,73 @@
+// Command tbadmin runs one-off maintenance tasks against the platform database.
+//
+//	go run ./cmd/tbadmin <command> [args]
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"tbapi/modals"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type command struct {
+	Usage string
+	Run   func(db *mongo.Database, args []string) error
+}
+
+var commands = map[string]command{
+	"migrate-balances":    {"convert string balances of tb_accounts and the mined supply to Decimal128", migrateBalances},
+	"ledger open":         {"post existing balances as opening ledger entries", openLedger},
+	"ledger verify":       {"recompute balances from ledger_entries and report drift", verifyLedger},
+	"keystore migrate":    {"seal the deposit keys still stored in plaintext", migrateKeys},
+	"keystore rotate":     {"rewrap every deposit key under the current master key", rotateKeys},
+	"hdwallet migrate":    {"move accounts on legacy random keys to HD deposit addresses", migrateDepositAddresses},
+	"deposits reconcile":  {"compare credited deposits with the Transfer logs on chain", reconcileDeposits},
+	"withdrawals review":  {"list the withdrawals waiting for approval", reviewWithdrawals},
+	"withdrawals approve": {"send a reviewed withdrawal to the signer", approveWithdrawal},
+	"withdrawals reject":  {"refuse a reviewed withdrawal and refund it", rejectWithdrawal},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	name := os.Args[1]
+	args := os.Args[2:]
+	// two word commands such as "ledger verify"
+	if _, found := commands[name]; !found && len(args) > 0 {
+		name = name + " " + args[0]
+		args = args[1:]
+	}
+	cmd, found := commands[name]
+	if !found {
+		usage()
+	}
+
+	db, err := modals.ConnectDB()
+	if err != nil {
+		log.Fatalf("Can't connect to database: %v", err)
+	}
+	if err := cmd.Run(db, args); err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var builder strings.Builder
+	builder.WriteString("usage: tbadmin <command>\n\ncommands:\n")
+	for _, name := range names {
+		builder.WriteString(fmt.Sprintf("  %-24s %s\n", name, commands[name].Usage))
+	}
+	fmt.Fprint(os.Stderr, builder.String())
+	os.Exit(2)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"tbapi/money"
+	"tbapi/withdraw"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// reviewWithdrawals lists the withdrawals above WITHDRAW_REVIEW_ABOVE waiting
+// for a decision
+func reviewWithdrawals(db *mongo.Database, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: withdrawals review")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	waiting, err := withdraw.NewMongoStore(db).InReview(ctx)
+	if err != nil {
+		return err
+	}
+	for _, w := range waiting {
+		asset, _ := money.Lookup(w.CHAIN)
+		log.Printf("%s %s %s %s from %s to %s, requested %s",
+			w.EID.Hex(), w.CHAIN, w.Amount().Format(asset), asset.Code, w.ID, w.TO, time.Unix(w.TMP, 0).UTC().Format(time.RFC3339))
+	}
+	log.Printf("%d withdrawals in review", len(waiting))
+	return nil
+}
+
+// approveWithdrawal hands a reviewed withdrawal to the signer:
+//
+//	tbadmin withdrawals approve <id>
+func approveWithdrawal(db *mongo.Database, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: withdrawals approve <id>")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := withdraw.NewMongoStore(db).Approve(ctx, args[0]); err != nil {
+		return err
+	}
+	log.Printf("%s approved", args[0])
+	return nil
+}
+
+// rejectWithdrawal refunds a reviewed withdrawal:
+//
+//	tbadmin withdrawals reject <id> [reason]
+func rejectWithdrawal(db *mongo.Database, args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: withdrawals reject <id> [reason]")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := withdraw.NewMongoStore(db).Reject(ctx, args[0], strings.Join(args[1:], " ")); err != nil {
+		return err
+	}
+	log.Printf("%s rejected and refunded", args[0])
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"tbapi/chains"
+	"tbapi/exchange"
+	"tbapi/fetch"
+	"tbapi/ledger"
+	"tbapi/modals"
+	"tbapi/stream"
+	"tbapi/withdraw"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	err := godotenv.Load()
+	if err != nil {
+		log.Println("No .env file found, using process environment")
+	}
+
+	addr := os.Getenv("API_ADDR")
+	if addr == "" {
+		addr = ":2021"
+	}
+	if _, err := chains.Load(); err != nil {
+		log.Fatalf("Can't load the chain registry: %v", err)
+	}
+	ensureIndexes()
+
+	// probe the RPC endpoints shown by /v2/platform/rpc
+	probeEvery := 30 * time.Second
+	if value, err := time.ParseDuration(os.Getenv("RPC_PROBE_INTERVAL")); err == nil && value > 0 {
+		probeEvery = value
+	}
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	defer stopMonitor()
+	go chains.Monitor(monitorCtx, probeEvery)
+
+	// close GTT and DAY orders here, the books of the exchange live in this process
+	expireEvery := 30 * time.Second
+	if value, err := time.ParseDuration(os.Getenv("EXPIRE_INTERVAL")); err == nil && value > 0 {
+		expireEvery = value
+	}
+	go exchange.RunExpirer(monitorCtx, expireEvery)
+
+	// feed /v2/stream, checking for matured stakes every 30 seconds
+	go stream.Run(monitorCtx, 30*time.Second)
+
+	certFile := os.Getenv("TLS_CERT_FILE")
+	keyFile := os.Getenv("TLS_KEY_FILE")
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           newRouter(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      60 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		if certFile != "" && keyFile != "" {
+			log.Printf("tbapi listening on %s (TLS)", addr)
+			serverErr <- server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			log.Printf("tbapi listening on %s", addr)
+			serverErr <- server.ListenAndServe()
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server stopped: %v", err)
+		}
+	case sig := <-stop:
+		log.Printf("Received %s, shutting down", sig)
+	}
+
+	// let in-flight requests finish before exiting
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Graceful shutdown failed: %v", err)
+	}
+}
+
+// ensureIndexes creates the indexes the handlers rely on, a failure is logged
+// and the server still starts
+func ensureIndexes() {
+	db, err := modals.ConnectDB()
+	if err != nil {
+		log.Printf("Can't connect to database for indexes: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := modals.EnsureAuthIndexes(ctx, db); err != nil {
+		log.Printf("Can't create auth indexes: %v", err)
+	}
+	if err := ledger.EnsureIndexes(ctx, db); err != nil {
+		log.Printf("Can't create ledger indexes: %v", err)
+	}
+	if err := fetch.EnsureDepositIndexes(ctx, db); err != nil {
+		log.Printf("Can't create deposit indexes: %v", err)
+	}
+	if err := withdraw.NewMongoStore(db).EnsureIndexes(ctx); err != nil {
+		log.Printf("Can't create withdrawal indexes: %v", err)
+	}
+	if err := exchange.EnsureIndexes(ctx, db); err != nil {
+		log.Printf("Can't create exchange indexes: %v", err)
+	}
+}
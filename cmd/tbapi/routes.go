@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"tbapi/api"
+	"tbapi/exchange"
+	"tbapi/fetch"
+	"tbapi/modals"
+	"tbapi/staking"
+	"tbapi/transfer"
+)
+
+const maxBodySize = 1 << 20
+
+type handlerFunc func(r *http.Request) (string, string)
+
+type route struct {
+	Method  string
+	Path    string
+	Handler handlerFunc
+}
+
+var routes = []route{
+	// auth
+	{http.MethodPost, "/authChallenge", modals.AuthChallenge},
+	{http.MethodPost, "/authLogin", modals.AuthLogin},
+	{http.MethodPost, "/authLogout", modals.AuthLogout},
+
+	// accounts
+	{http.MethodPost, "/createAccount", modals.CreateAccount},
+	{http.MethodPost, "/recoverAccount", modals.RecoverAccount},
+	{http.MethodPost, "/refreshAccount", modals.RefreshAccount},
+	{http.MethodPost, "/fetchBalance", fetch.FetchChainBalance},
+
+	// platform
+	{http.MethodPost, "/platformInfo", modals.PlatformInfo},
+	{http.MethodGet, "/version", getVersion},
+
+	// transfers
+	{http.MethodPost, "/transfer", transfer.TransferAssets},
+	{http.MethodPost, "/transferOrders", transfer.GetOrderData},
+
+	// exchange
+	{http.MethodPost, "/exchange", placeExchange},
+	{http.MethodPost, "/cancelExchange", cancelExchange},
+	{http.MethodPost, "/exchangeOrders", exchange.GetExOrderData},
+	{http.MethodPost, "/swapAmounts", exchange.GetSwapAmounts},
+
+	// staking
+	{http.MethodPost, "/stake", staking.PlaceStake},
+	{http.MethodPost, "/unstake", staking.Unstake},
+	{http.MethodPost, "/stakeOrders", staking.GetStakeOrderData},
+}
+
+func newRouter() http.Handler {
+	mux := http.NewServeMux()
+	// v1 comma packed endpoints kept for older app builds
+	for _, rt := range routes {
+		mux.Handle(rt.Method+" "+rt.Path, serve(rt.Handler))
+	}
+	api.Register(mux, "/v2")
+	return limitBody(mux)
+}
+
+// limitBody caps every request body, the handlers read it whole
+func limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+		next.ServeHTTP(w, r)
+	})
+}
+
+type response struct {
+	Status string `json:"status"`
+	Data   string `json:"data"`
+}
+
+// serve turns the (status, data) tuple returned by the handlers into a JSON response
+func serve(handler handlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				log.Printf("Panic serving %s: %v", r.URL.Path, rec)
+				writeResponse(w, "false", "Problem at backend")
+			}
+		}()
+		status, data := handler(r)
+		writeResponse(w, status, data)
+	})
+}
+
+func writeResponse(w http.ResponseWriter, status string, data string) {
+	code := http.StatusOK
+	if status != "true" {
+		code = http.StatusBadRequest
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response{Status: status, Data: data})
+}
+
+func getVersion(r *http.Request) (string, string) {
+	return modals.GetVersion()
+}
+
+// placeExchange creates the order and immediately tries to match it, the app
+// expects the settlement result in the same response
+func placeExchange(r *http.Request) (string, string) {
+	isCreated, message, _, EID, _, _, _, _ := exchange.PlaceExchangeOrder(r)
+	if isCreated != "true" {
+		return "false", message
+	}
+	exStatus, amountSettled := exchange.MatchOrder(EID)
+	return "true", fmt.Sprintf("%s,%s,%s,%s", message, EID.Hex(), exStatus, amountSettled.Fixed(6))
+}
+
+func cancelExchange(r *http.Request) (string, string) {
+	isSettled, message, purpose := exchange.CencelExchange(r)
+	if isSettled != "true" {
+		return "false", message
+	}
+	return "true", fmt.Sprintf("%s,%s", message, purpose)
+}
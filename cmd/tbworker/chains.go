@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"tbapi/chains"
+)
+
+// chain is a chain of WORKER_CHAINS (default every chain of the registry) with
+// its RPC pool
+type chain struct {
+	chains.Chain
+	Client *chains.Pool
+}
+
+func poolChains() ([]chain, error) {
+	if _, err := chains.Load(); err != nil {
+		return nil, err
+	}
+	names := chains.Names()
+	if value := os.Getenv("WORKER_CHAINS"); value != "" {
+		names = strings.Split(value, ",")
+	}
+	var pooled []chain
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		config, found := chains.Get(name)
+		if !found {
+			return nil, fmt.Errorf("unknown chain %q in WORKER_CHAINS", name)
+		}
+		pool, err := chains.Shared(name)
+		if err != nil {
+			return nil, err
+		}
+		pooled = append(pooled, chain{Chain: config, Client: pool})
+	}
+	return pooled, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"tbapi/chains"
+	"tbapi/feeoracle"
+	"tbapi/modals"
+	"time"
+)
+
+// feeJobs builds a fee sampler for every chain and one pricer for the coins
+// the chains pay gas in, both writing the updatedFee document:
+//
+//	FEE_INTERVAL          time between fee samples (default 1m)
+//	PRICE_INTERVAL        time between price updates (default 5m)
+//	PRICE_SOURCE          coingecko (default) or static
+//	PRICE_IDS             coingecko ids by feePrice key, like eth=ethereum (adds to the defaults)
+//	COINGECKO_API_KEY     demo API key, optional
+//	COINGECKO_URL         API base, optional
+//	PRICE_STATIC          prices for the static source, like polygon=0.5,eth=2500
+func feeJobs(ctx context.Context, chains []chain) ([]job, error) {
+	source, err := priceSource()
+	if err != nil {
+		return nil, err
+	}
+	db, err := modals.ConnectDB()
+	if err != nil {
+		return nil, err
+	}
+	store := feeoracle.NewMongoStore(db)
+	feeEvery := interval("FEE_INTERVAL", time.Minute)
+	priceEvery := interval("PRICE_INTERVAL", 5*time.Minute)
+
+	var jobs []job
+	var keys []string
+	seen := map[string]bool{}
+	for _, c := range chains {
+		s := feeoracle.NewSampler(c.Name, c.Client, store)
+		jobs = append(jobs, job{name: "fees " + c.Name, run: func(ctx context.Context) { s.Run(ctx, feeEvery) }})
+		if c.FeePrice != "" && !seen[c.FeePrice] {
+			seen[c.FeePrice] = true
+			keys = append(keys, c.FeePrice)
+		}
+	}
+	if len(keys) > 0 {
+		p := feeoracle.NewPricer(source, keys, store)
+		jobs = append(jobs, job{name: "prices", run: func(ctx context.Context) { p.Run(ctx, priceEvery) }})
+	}
+	return jobs, nil
+}
+
+func priceSource() (feeoracle.PriceSource, error) {
+	switch source := os.Getenv("PRICE_SOURCE"); source {
+	case "", "coingecko":
+		ids := map[string]string{}
+		for key, id := range feeoracle.DefaultCoinGeckoIDs {
+			ids[key] = id
+		}
+		more, err := feeoracle.ParsePairs(os.Getenv("PRICE_IDS"))
+		if err != nil {
+			return nil, fmt.Errorf("PRICE_IDS: %w", err)
+		}
+		for key, id := range more {
+			ids[key] = id
+		}
+		return feeoracle.CoinGecko{
+			BaseURL: os.Getenv("COINGECKO_URL"),
+			APIKey:  os.Getenv("COINGECKO_API_KEY"),
+			IDs:     ids,
+		}, nil
+	case "static":
+		prices, err := feeoracle.ParseStaticPrices(os.Getenv("PRICE_STATIC"))
+		if err != nil {
+			return nil, fmt.Errorf("PRICE_STATIC: %w", err)
+		}
+		return prices, nil
+	default:
+		return nil, fmt.Errorf("unknown PRICE_SOURCE %q", source)
+	}
+}
+
+var _ feeoracle.Backend = (*chains.Pool)(nil)
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"tbapi/chains"
+	"time"
+)
+
+// healthJobs probe the RPC endpoints and, with WORKER_HEALTH_ADDR set, serve
+// their state as JSON on /health:
+//
+//	RPC_PROBE_INTERVAL           time between probes of every endpoint (default 30s)
+//	WORKER_HEALTH_ADDR           listen address of the health endpoint, unset serves none
+func healthJobs() []job {
+	every := interval("RPC_PROBE_INTERVAL", 30*time.Second)
+	jobs := []job{{name: "rpc monitor", run: func(ctx context.Context) { chains.Monitor(ctx, every) }}}
+	addr := os.Getenv("WORKER_HEALTH_ADDR")
+	if addr == "" {
+		return jobs
+	}
+	return append(jobs, job{name: "health on " + addr, run: func(ctx context.Context) { serveHealth(ctx, addr) }})
+}
+
+func serveHealth(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(chains.Health())
+	})
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("health endpoint stopped: %v", err)
+	}
+}
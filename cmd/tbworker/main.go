@@ -0,0 +1,92 @@
+// Command tbworker runs the background jobs that need chain access or key
+// material and so don't belong on the API hosts: the deposit watcher, the
+// sweeper, the withdrawal signer and the fee oracle, one loop per chain each,
+// and the probes of the RPC endpoints. WORKER_JOBS picks the loops (default
+// watch,sweep,fees; add withdraw once the hot wallet key is in place).
+//
+//	go run ./cmd/tbworker
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// job is one loop of one chain
+type job struct {
+	name string
+	run  func(ctx context.Context)
+}
+
+func main() {
+	err := godotenv.Load()
+	if err != nil {
+		log.Println("No .env file found, using process environment")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	pooled, err := poolChains()
+	if err != nil {
+		log.Fatalf("Can't load chains: %v", err)
+	}
+	enabled := os.Getenv("WORKER_JOBS")
+	if enabled == "" {
+		enabled = "watch,sweep,fees"
+	}
+	var jobs []job
+	for _, name := range strings.Split(enabled, ",") {
+		var more []job
+		switch strings.TrimSpace(name) {
+		case "watch":
+			more, err = watchJobs(ctx, pooled)
+		case "sweep":
+			more, err = sweepJobs(ctx, pooled)
+		case "withdraw":
+			more, err = withdrawJobs(ctx, pooled)
+		case "fees":
+			more, err = feeJobs(ctx, pooled)
+		default:
+			log.Fatalf("Unknown job %q in WORKER_JOBS", name)
+		}
+		if err != nil {
+			log.Fatalf("Can't start %s: %v", name, err)
+		}
+		jobs = append(jobs, more...)
+	}
+	jobs = append(jobs, healthJobs()...)
+
+	var wg sync.WaitGroup
+	for _, j := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			j.run(ctx)
+		}()
+		log.Printf("tbworker started %s", j.name)
+	}
+	wg.Wait()
+	log.Println("tbworker stopped")
+}
+
+// interval reads a duration variable
+func interval(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+	return parsed
+}
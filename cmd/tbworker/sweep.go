@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"tbapi/chains"
+	"tbapi/hdwallet"
+	"tbapi/modals"
+	"tbapi/money"
+	"tbapi/sweeper"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// sweepJobs builds a sweeper for every chain:
+//
+//	SWEEP_HOT_WALLET                         address the deposits are swept to
+//	SWEEP_GAS_KEY or SWEEP_GAS_KEY_FILE      hex key of the gas tank paying the top-ups
+//	HD_XPRV or HD_XPRV_FILE                  account level xprv matching HD_XPUB
+//	SWEEP_INTERVAL                           time between passes (default 1m)
+//
+// The smallest deposit worth sweeping is minSweep of the chain's registry entry.
+func sweepJobs(ctx context.Context, chains []chain) ([]job, error) {
+	hotWallet := os.Getenv("SWEEP_HOT_WALLET")
+	if !common.IsHexAddress(hotWallet) {
+		return nil, errors.New("SWEEP_HOT_WALLET is not an address")
+	}
+	gasKeyHex, err := readSecret("SWEEP_GAS_KEY", "SWEEP_GAS_KEY_FILE")
+	if err != nil {
+		return nil, err
+	}
+	gasTank, err := crypto.HexToECDSA(strings.TrimPrefix(gasKeyHex, "0x"))
+	if err != nil {
+		return nil, errors.New("SWEEP_GAS_KEY is not a private key")
+	}
+	xprv, err := accountXprv()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := modals.ConnectDB()
+	if err != nil {
+		return nil, err
+	}
+	store := sweeper.NewMongoStore(db)
+	if err := store.EnsureIndexes(ctx); err != nil {
+		return nil, err
+	}
+	every := interval("SWEEP_INTERVAL", time.Minute)
+
+	var jobs []job
+	for _, c := range chains {
+		asset := money.ChainAsset(c.Chain)
+		minAmount, err := money.Parse(asset, c.MinSweep)
+		if err != nil {
+			return nil, fmt.Errorf("%s minSweep: %w", c.Name, err)
+		}
+		s, err := sweeper.New(ctx, sweeper.Chain{
+			Name:          c.Name,
+			Token:         c.TokenAddress(),
+			HotWallet:     common.HexToAddress(hotWallet),
+			GasTank:       gasTank,
+			Confirmations: c.Confirmations,
+			MinAmount:     minAmount.TokenUnits(c.Decimals),
+		}, c.Client, store, sweeper.DepositKeys(xprv))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.Name, err)
+		}
+		jobs = append(jobs, job{name: "sweeper " + c.Name, run: func(ctx context.Context) { s.Run(ctx, every) }})
+	}
+	return jobs, nil
+}
+
+// accountXprv reads HD_XPRV, which may be left out while only legacy keys are
+// swept. It has to be the private side of HD_XPUB when both are set.
+func accountXprv() (*hdwallet.ExtendedKey, error) {
+	encoded, err := readSecret("HD_XPRV", "HD_XPRV_FILE")
+	if errors.Is(err, errNotSet) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	xprv, err := hdwallet.Parse(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("HD_XPRV: %w", err)
+	}
+	if !xprv.IsPrivate() {
+		return nil, errors.New("HD_XPRV must be an xprv")
+	}
+	if xpub := os.Getenv("HD_XPUB"); xpub != "" && xprv.Neuter().String() != xpub {
+		return nil, errors.New("HD_XPRV does not belong to HD_XPUB")
+	}
+	return xprv, nil
+}
+
+var errNotSet = errors.New("not set")
+
+// readSecret reads a value from valueVar, or from the file named by fileVar
+func readSecret(valueVar string, fileVar string) (string, error) {
+	if value := os.Getenv(valueVar); value != "" {
+		return strings.TrimSpace(value), nil
+	}
+	path := os.Getenv(fileVar)
+	if path == "" {
+		return "", fmt.Errorf("%s %w", valueVar, errNotSet)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", fileVar, err)
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// the sweeper talks to the nodes through the RPC pools
+var _ sweeper.Backend = (*chains.Pool)(nil)
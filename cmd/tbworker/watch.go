@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"tbapi/chains"
+	"tbapi/modals"
+	"tbapi/watcher"
+	"time"
+)
+
+// watchJobs builds a deposit watcher for every chain:
+//
+//	WATCH_INTERVAL               time between polls once caught up (default 15s)
+//	WATCH_START_<chain>          block to start from on the first run (default the current one)
+func watchJobs(ctx context.Context, chains []chain) ([]job, error) {
+	db, err := modals.ConnectDB()
+	if err != nil {
+		return nil, err
+	}
+	store := watcher.NewMongoStore(db)
+	if err := store.EnsureIndexes(ctx); err != nil {
+		return nil, err
+	}
+	every := interval("WATCH_INTERVAL", 15*time.Second)
+
+	var jobs []job
+	for _, c := range chains {
+		var start uint64
+		if value := os.Getenv("WATCH_START_" + c.Name); value != "" {
+			start, err = strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("WATCH_START_%s: %w", c.Name, err)
+			}
+		}
+		w, err := watcher.New(ctx, watcher.Chain{
+			Name:          c.Name,
+			Token:         c.TokenAddress(),
+			Confirmations: c.Confirmations,
+			StartBlock:    start,
+		}, c.Client, store)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.Name, err)
+		}
+		jobs = append(jobs, job{name: "watcher " + c.Name, run: func(ctx context.Context) { w.Run(ctx, every) }})
+	}
+	return jobs, nil
+}
+
+var _ watcher.Backend = (*chains.Pool)(nil)
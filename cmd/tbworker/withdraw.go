@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"tbapi/chains"
+	"tbapi/evmtx"
+	"tbapi/modals"
+	"tbapi/withdraw"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// withdrawJobs builds a withdrawal signer for every chain that takes
+// withdrawals (feePrice set in the registry):
+//
+//	WITHDRAW_KEY or WITHDRAW_KEY_FILE    hex key of the hot wallet, must be SWEEP_HOT_WALLET when that is set
+//	WITHDRAW_INTERVAL                    time between passes (default 30s)
+func withdrawJobs(ctx context.Context, chains []chain) ([]job, error) {
+	keyHex, err := readSecret("WITHDRAW_KEY", "WITHDRAW_KEY_FILE")
+	if err != nil {
+		return nil, err
+	}
+	hotWallet, err := crypto.HexToECDSA(strings.TrimPrefix(keyHex, "0x"))
+	if err != nil {
+		return nil, errors.New("WITHDRAW_KEY is not a private key")
+	}
+	if swept := os.Getenv("SWEEP_HOT_WALLET"); swept != "" && common.HexToAddress(swept) != evmtx.AddressOf(hotWallet) {
+		return nil, errors.New("WITHDRAW_KEY is not the key of SWEEP_HOT_WALLET")
+	}
+
+	db, err := modals.ConnectDB()
+	if err != nil {
+		return nil, err
+	}
+	store := withdraw.NewMongoStore(db)
+	if err := store.EnsureIndexes(ctx); err != nil {
+		return nil, err
+	}
+	every := interval("WITHDRAW_INTERVAL", 30*time.Second)
+
+	var jobs []job
+	for _, c := range chains {
+		if c.FeePrice == "" {
+			continue
+		}
+		s, err := withdraw.NewSigner(ctx, withdraw.Chain{
+			Name:          c.Name,
+			Token:         c.TokenAddress(),
+			Decimals:      c.Decimals,
+			HotWallet:     hotWallet,
+			Confirmations: c.Confirmations,
+		}, c.Client, store)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.Name, err)
+		}
+		jobs = append(jobs, job{name: "withdraw " + c.Name, run: func(ctx context.Context) { s.Run(ctx, every) }})
+	}
+	return jobs, nil
+}
+
+var _ withdraw.Backend = (*chains.Pool)(nil)
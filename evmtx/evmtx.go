@@ -0,0 +1,184 @@
+// Package evmtx signs, sends and follows the transactions of the sweeper and
+// the withdrawal signer. A signed transaction is stored encoded before it is
+// sent, Settle sends it again until it is mined and confirmed.
+package evmtx
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Backend is the part of an EVM node the helpers use
+type Backend interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+const tokenABI = `[
+	{"constant":true,"inputs":[{"name":"_owner","type":"address"}],"name":"balanceOf","outputs":[{"name":"balance","type":"uint256"}],"type":"function"},
+	{"constant":false,"inputs":[{"name":"_to","type":"address"},{"name":"_value","type":"uint256"}],"name":"transfer","outputs":[],"type":"function"}
+]`
+
+// Token is the part of the ERC-20 interface the platform calls. transfer
+// declares no outputs, USDT on Ethereum returns nothing from it.
+var Token = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(tokenABI))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// TokenBalance reads balanceOf(owner) of token
+func TokenBalance(ctx context.Context, caller ethereum.ContractCaller, token common.Address, owner common.Address) (*big.Int, error) {
+	data, err := Token.Pack("balanceOf", owner)
+	if err != nil {
+		return nil, err
+	}
+	result, err := caller.CallContract(ctx, ethereum.CallMsg{To: &token, Data: data}, nil)
+	if err != nil {
+		return nil, err
+	}
+	values, err := Token.Unpack("balanceOf", result)
+	if err != nil || len(values) != 1 {
+		return nil, errors.New("Can't read token balance")
+	}
+	return values[0].(*big.Int), nil
+}
+
+// Fees returns the tip and fee cap of a new transaction, the cap leaves room
+// for the base fee to double
+func Fees(ctx context.Context, backend Backend) (*big.Int, *big.Int, error) {
+	tip, err := backend.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	head, err := backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	feeCap := new(big.Int).Add(tip, new(big.Int).Mul(head.BaseFee, big.NewInt(2)))
+	return tip, feeCap, nil
+}
+
+// Sign builds and signs a dynamic fee transaction
+func Sign(chainID *big.Int, key *ecdsa.PrivateKey, nonce uint64, to common.Address, value *big.Int, gas uint64, tip *big.Int, feeCap *big.Int, data []byte) (*types.Transaction, error) {
+	tx := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     nonce,
+		GasTipCap: tip,
+		GasFeeCap: feeCap,
+		Gas:       gas,
+		To:        &to,
+		Value:     value,
+		Data:      data,
+	})
+	return types.SignTx(tx, types.LatestSignerForChainID(chainID), key)
+}
+
+// Broadcast sends a signed transaction. Sending it again is harmless, a node
+// that already has it answers "already known".
+func Broadcast(ctx context.Context, backend Backend, tx *types.Transaction) error {
+	err := backend.SendTransaction(ctx, tx)
+	if err != nil && strings.Contains(strings.ToLower(err.Error()), "already known") {
+		return nil
+	}
+	return err
+}
+
+func IsNonceTooLow(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "nonce too low")
+}
+
+func Encode(tx *types.Transaction) (string, error) {
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return common.Bytes2Hex(raw), nil
+}
+
+func Decode(encoded string) (*types.Transaction, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(common.FromHex(encoded)); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+func AddressOf(key *ecdsa.PrivateKey) common.Address {
+	return crypto.PubkeyToAddress(key.PublicKey)
+}
+
+// Settle reports whether the transaction hash is confirmed, resending raw
+// while no node has mined it. A non-empty failure means it never will be.
+func Settle(ctx context.Context, backend Backend, hash string, raw string, confirmations uint64) (bool, string, error) {
+	receipt, err := backend.TransactionReceipt(ctx, common.HexToHash(hash))
+	if errors.Is(err, ethereum.NotFound) {
+		tx, decodeErr := Decode(raw)
+		if decodeErr != nil {
+			return true, "can't be decoded", nil
+		}
+		if sendErr := Broadcast(ctx, backend, tx); !IsNonceTooLow(sendErr) {
+			return false, "", sendErr
+		}
+		// either it was mined meanwhile or another transaction took its nonce
+		receipt, err = backend.TransactionReceipt(ctx, tx.Hash())
+		if errors.Is(err, ethereum.NotFound) {
+			isReplaced, err := nonceConfirmed(ctx, backend, tx, confirmations)
+			if err != nil || !isReplaced {
+				return false, "", err
+			}
+			return true, "was replaced by another transaction with its nonce", nil
+		}
+	}
+	if err != nil {
+		return false, "", err
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return true, "reverted", nil
+	}
+	head, err := backend.BlockNumber(ctx)
+	if err != nil {
+		return false, "", err
+	}
+	mined := receipt.BlockNumber.Uint64()
+	if head < mined || head-mined+1 < confirmations {
+		return false, "", nil
+	}
+	return true, "", nil
+}
+
+// nonceConfirmed tells whether a transaction of the sender with the nonce of
+// tx is confirmations deep, so tx itself can no longer be mined
+func nonceConfirmed(ctx context.Context, backend Backend, tx *types.Transaction, confirmations uint64) (bool, error) {
+	sender, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		return false, err
+	}
+	head, err := backend.BlockNumber(ctx)
+	if err != nil {
+		return false, err
+	}
+	if head+1 < confirmations {
+		return false, nil
+	}
+	nonce, err := backend.NonceAt(ctx, sender, new(big.Int).SetUint64(head+1-confirmations))
+	if err != nil {
+		return false, err
+	}
+	return nonce > tx.Nonce(), nil
+}
@@ -0,0 +1,260 @@
+package exchange
+
+import (
+	"bytes"
+	"slices"
+	"sync"
+	"tbapi/money"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// bookOrder is the open part of an order as the book sees it
+type bookOrder struct {
+	ID      primitive.ObjectID
+	Account string
+	Side    string
+	Price   money.Amount // quote per base
+	Open    money.Amount // still locked, in the asset the order pays
+	Market  bool         // takes any price, only for quotes
+	// fee rates in percent the order was placed with
+	MakerRate money.Amount
+	TakerRate money.Amount
+}
+
+// Fill is one match of an incoming order (the taker) against a resting one
+// (the maker), at the maker's price. It is posted to the ledger on its own
+// under its ID.
+type Fill struct {
+	ID           primitive.ObjectID
+	TMP          int64
+	Pair         string
+	Maker        primitive.ObjectID
+	Taker        primitive.ObjectID
+	MakerAccount string
+	TakerAccount string
+	TakerSide    string
+	Price        money.Amount
+	Base         money.Amount // paid by the seller
+	Quote        money.Amount // paid by the buyer
+	MakerFee     money.Amount // kept from what the maker received
+	TakerFee     money.Amount // kept from what the taker received
+}
+
+// Buyer and Seller are the accounts on each side of the fill
+func (f Fill) Buyer() string {
+	if f.TakerSide == SideBuy {
+		return f.TakerAccount
+	}
+	return f.MakerAccount
+}
+
+func (f Fill) Seller() string {
+	if f.TakerSide == SideBuy {
+		return f.MakerAccount
+	}
+	return f.TakerAccount
+}
+
+// paidBy is what the order of side gave up in the fill, receivedBy what it got
+func (f Fill) paidBy(side string) money.Amount {
+	if side == SideBuy {
+		return f.Quote
+	}
+	return f.Base
+}
+
+// feeOf is the fee the order of side paid in the fill, out of what it received
+func (f Fill) feeOf(side string) money.Amount {
+	if side == f.TakerSide {
+		return f.TakerFee
+	}
+	return f.MakerFee
+}
+
+func (f Fill) receivedBy(side string) money.Amount {
+	if side == SideBuy {
+		return f.Base
+	}
+	return f.Quote
+}
+
+// Book holds the open orders of one pair: bids from the highest price down,
+// asks from the lowest up, and each price level in order of arrival. It is
+// only read and changed with mu held.
+type Book struct {
+	pair   Pair
+	bids   []*bookOrder
+	asks   []*bookOrder
+	mu     sync.Mutex
+	loaded bool
+}
+
+func newBook(pair Pair) *Book {
+	return &Book{pair: pair}
+}
+
+func (b *Book) orders(side string) *[]*bookOrder {
+	if side == SideBuy {
+		return &b.bids
+	}
+	return &b.asks
+}
+
+// ahead reports whether o has priority over other on the same side
+func ahead(o *bookOrder, other *bookOrder) bool {
+	c := o.Price.Cmp(other.Price)
+	if o.Side == SideSell {
+		c = -c
+	}
+	if c != 0 {
+		return c > 0
+	}
+	// object ids start with their creation time
+	return bytes.Compare(o.ID[:], other.ID[:]) < 0
+}
+
+func (b *Book) add(o *bookOrder) {
+	list := b.orders(o.Side)
+	i, _ := slices.BinarySearchFunc(*list, o, func(e *bookOrder, t *bookOrder) int {
+		if ahead(e, t) {
+			return -1
+		}
+		return 1
+	})
+	*list = slices.Insert(*list, i, o)
+}
+
+func (b *Book) get(id primitive.ObjectID) *bookOrder {
+	for _, list := range [][]*bookOrder{b.bids, b.asks} {
+		for _, o := range list {
+			if o.ID == id {
+				return o
+			}
+		}
+	}
+	return nil
+}
+
+func (b *Book) remove(id primitive.ObjectID) {
+	b.bids = slices.DeleteFunc(b.bids, func(o *bookOrder) bool { return o.ID == id })
+	b.asks = slices.DeleteFunc(b.asks, func(o *bookOrder) bool { return o.ID == id })
+}
+
+// baseOf is the base amount open on an order, a bid can buy no more than its
+// locked quote pays for at its price
+func (b *Book) baseOf(side string, price money.Amount, open money.Amount) money.Amount {
+	if side == SideBuy {
+		return open.Div(price).RoundDown(b.pair.Base)
+	}
+	return open
+}
+
+// fillable reports whether open is enough for at least one fill at price, an
+// order that isn't is closed and the rest refunded
+func (b *Book) fillable(side string, price money.Amount, open money.Amount) bool {
+	base := b.baseOf(side, price, open)
+	return base.Sign() > 0 && base.Mul(price).RoundDown(b.pair.Quote).Sign() > 0
+}
+
+// crosses reports whether a taker of side with limit accepts price
+func crosses(side string, limit money.Amount, price money.Amount) bool {
+	if side == SideBuy {
+		return price.Cmp(limit) <= 0
+	}
+	return price.Cmp(limit) >= 0
+}
+
+// match walks the other side of the book from the best price and returns the
+// fills taker would get, without changing the book
+func (b *Book) match(taker bookOrder) []Fill {
+	var fills []Fill
+	open := taker.Open
+	makers := b.asks
+	if taker.Side == SideSell {
+		makers = b.bids
+	}
+	for _, maker := range makers {
+		if open.Sign() <= 0 || (!taker.Market && !crosses(taker.Side, taker.Price, maker.Price)) {
+			break
+		}
+		base := b.baseOf(maker.Side, maker.Price, maker.Open)
+		base = money.Min(base, b.baseOf(taker.Side, maker.Price, open))
+		quote := base.Mul(maker.Price).RoundDown(b.pair.Quote)
+		if base.IsZero() || quote.IsZero() {
+			break
+		}
+		fills = append(fills, Fill{
+			Pair:         b.pair.Symbol(),
+			Maker:        maker.ID,
+			Taker:        taker.ID,
+			MakerAccount: maker.Account,
+			TakerAccount: taker.Account,
+			TakerSide:    taker.Side,
+			Price:        maker.Price,
+			Base:         base,
+			Quote:        quote,
+		})
+		open = open.Sub(fills[len(fills)-1].paidBy(taker.Side))
+	}
+	return fills
+}
+
+// apply takes settled fills off the makers, closing those left unfillable
+func (b *Book) apply(fills []Fill) {
+	for _, fill := range fills {
+		maker := b.get(fill.Maker)
+		if maker == nil {
+			continue
+		}
+		maker.Open = maker.Open.Sub(fill.paidBy(maker.Side))
+		if !b.fillable(maker.Side, maker.Price, maker.Open) {
+			b.remove(maker.ID)
+		}
+	}
+}
+
+// Level is the open base amount at one price
+type Level struct {
+	Price  money.Amount
+	Amount money.Amount
+	Orders int
+}
+
+// Depth is the book of one pair by price level, best prices first
+type Depth struct {
+	Pair Pair
+	Bids []Level
+	Asks []Level
+}
+
+// depth sums the book into at most levels price levels per side
+func (b *Book) depth(levels int) Depth {
+	return Depth{Pair: b.pair, Bids: b.levels(b.bids, levels), Asks: b.levels(b.asks, levels)}
+}
+
+func (b *Book) levels(orders []*bookOrder, levels int) []Level {
+	var out []Level
+	for _, o := range orders {
+		base := b.baseOf(o.Side, o.Price, o.Open)
+		if len(out) > 0 && out[len(out)-1].Price.Equal(o.Price) {
+			out[len(out)-1].Amount = out[len(out)-1].Amount.Add(base)
+			out[len(out)-1].Orders++
+			continue
+		}
+		if levels > 0 && len(out) == levels {
+			break
+		}
+		out = append(out, Level{Price: o.Price, Amount: base, Orders: 1})
+	}
+	return out
+}
+
+// open sums what the orders of side still have locked
+func (b *Book) open(side string) money.Amount {
+	total := money.Zero()
+	for _, o := range *b.orders(side) {
+		total = total.Add(o.Open)
+	}
+	return total
+}
@@ -3,13 +3,13 @@ package exchange
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
-	"log"
 	"net/http"
-	"strconv"
 	"strings"
+	"tbapi/ledger"
 	"tbapi/modals"
+	"tbapi/money"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -17,6 +17,41 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// CancelsCollection holds one document per closed order, with what it got back
+const CancelsCollection = "exchangeCancels"
+
+var (
+	// ErrOrderNotFound is also returned for orders of another account
+	ErrOrderNotFound  = errors.New("Order not found")
+	ErrOrderDone      = errors.New("Order already done")
+	ErrOrderCancelled = errors.New("Order already cancelled")
+	ErrOrderExpired   = errors.New("Order already expired")
+)
+
+// reasons a cancellation is recorded with
+const (
+	ReasonUser    = "user"
+	ReasonExpired = "expired"
+)
+
+// CancelRecord is the cancellation event of an order
+type CancelRecord struct {
+	CID primitive.ObjectID `bson:"_id,omitempty"`
+	OID primitive.ObjectID `bson:"OID"` // order
+	ID  string             `bson:"ID"`  // account
+	RSN string             `bson:"RSN"`
+	RFD string             `bson:"RFD"` // unfilled amount refunded, of FROM
+	PAY string             `bson:"PAY"` // proceeds paid out with it, of TO
+	TMP int64              `bson:"TMP"`
+}
+
+// Cancellation is a cancelled order as it was stored afterwards
+type Cancellation struct {
+	Order    ExOrder
+	Refunded money.Amount
+	Paid     money.Amount
+}
+
 func CencelExchange(r *http.Request) (string, string, string) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -45,137 +80,184 @@ func CencelExchange(r *http.Request) (string, string, string) {
 	walletKey := walletsDetailList[1]
 	orderID := walletsDetailList[2]
 
-	validKey := modals.CheckKey(walletKey, address)
+	validKey := modals.CheckAuth(walletKey, address)
 	if !validKey {
 		return "false", "Trying to bypass", ""
 	}
-	db, err := modals.ConnectDB()
-
-	if err != nil {
-		return "false", "API Database Error", ""
-	}
-	isSettled, message, purpose := settleOrder(db, orderID, address)
+	isSettled, message, purpose := CancelOrder(address, orderID)
 	if !isSettled {
 		return "false", message, ""
 	}
 	return "true", message, purpose
 }
 
-func settleOrder(db *mongo.Database, orderID string, address string) (bool, string, string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	exchangeCollection := db.Collection("exchangeOrders")
-	accountCollection := db.Collection("tb_accounts")
-	// for accounts
-	accountData, isFound := modals.GetAccountData(address, accountCollection)
-	if !isFound {
-		return false, "Can't fetch account details", ""
+// CancelOrder closes the swap and refunds what was not exchanged, purpose is
+// "deleted" for untouched orders and "settled" for partially filled ones as
+// the app expects, though the order is kept as cancelled either way
+func CancelOrder(address string, orderID string) (bool, string, string) {
+	cancelled, err := Cancel(address, orderID)
+	if err != nil {
+		return false, CancelMessage(err), ""
+	}
+	if settled, _ := money.ParseDecimal(cancelled.Order.SAMT); settled.IsZero() {
+		return true, "Successfully Settled", "deleted"
 	}
+	return true, "Successfully Settled", "settled"
+}
 
-	// for exchnage orders
-	objectID, err := primitive.ObjectIDFromHex(orderID)
+// Cancel closes an open order of address, see Engine.Cancel
+func Cancel(address string, orderID string) (Cancellation, error) {
+	EID, err := primitive.ObjectIDFromHex(orderID)
 	if err != nil {
-		log.Fatalf("Invalid ObjectID hex: %v", err)
+		return Cancellation{}, ErrOrderNotFound
 	}
-	exOrderData, isFound := getExOrderData(objectID, exchangeCollection)
-	if !isFound {
-		return false, "Can't fetch Swap details", ""
+	engine, isReady := mongoEngine()
+	if !isReady {
+		return Cancellation{}, modals.Abort("API Database Error")
 	}
+	return engine.Cancel(address, EID, StatusCancelled, ReasonUser)
+}
 
-	orderSAMT, err := strconv.ParseFloat(exOrderData.SAMT, 64)
-	if err != nil {
-		return false, "Settled Amount Conversion Error", ""
+// Cancel closes the open order EID of address with status, refunding the
+// part of its amount that never filled and paying out what it was owed, and
+// records the cancellation with reason. Failures other than the typed errors
+// are modals.AbortError or store errors.
+func (e *Engine) Cancel(address string, EID primitive.ObjectID, status string, reason string) (Cancellation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	order, err := e.store.Order(ctx, EID)
+	cancel()
+	if err != nil || order.ID != address {
+		return Cancellation{}, ErrOrderNotFound
+	}
+	if err := closedError(order); err != nil {
+		return Cancellation{}, err
+	}
+
+	// hold the book so the order can't be matched while it is cancelled
+	pair, _, isPair := PairOf(order.FROM, order.TO)
+	var book *Book
+	if isPair {
+		var isLoaded bool
+		book, isLoaded = e.lock(pair)
+		if !isLoaded {
+			return Cancellation{}, modals.Abort("Can't load order book")
+		}
+		defer book.unlock()
 	}
-	orderAMT, err := strconv.ParseFloat(exOrderData.AMT, 64)
+
+	var cancelled Cancellation
+	err = e.store.Transact(func(ctx context.Context) error {
+		var err error
+		cancelled, err = closeOrder(ctx, e.store, EID, status, reason)
+		return err
+	})
 	if err != nil {
-		return false, "Exchange Amount Conversion Error", ""
+		return Cancellation{}, err
 	}
-	pendingExchange := orderAMT - orderSAMT
-	fromCurrency := exOrderData.FROM
-	toCurrency := exOrderData.TO
+	if book != nil {
+		book.remove(EID)
+	}
+	return cancelled, nil
+}
 
-	tbtBalance, err := strconv.ParseFloat(accountData.TBT, 64)
+// CancelMessage is the user message for an error of Cancel
+func CancelMessage(err error) string {
+	if errors.Is(err, ErrOrderNotFound) || errors.Is(err, ErrOrderDone) || errors.Is(err, ErrOrderCancelled) || errors.Is(err, ErrOrderExpired) {
+		return err.Error()
+	}
+	return modals.AbortMessage(err, "Could not cancel swap")
+}
+
+// closedError is the typed error for an order that is no longer open
+func closedError(order ExOrder) error {
+	switch order.STAT {
+	case StatusPending, StatusPartial:
+		return nil
+	case StatusDone:
+		return ErrOrderDone
+	case StatusExpired:
+		return ErrOrderExpired
+	}
+	return ErrOrderCancelled
+}
+
+func closeOrder(ctx context.Context, store Store, EID primitive.ObjectID, status string, reason string) (Cancellation, error) {
+	// read again, a fill may have come first
+	order, err := store.Order(ctx, EID)
 	if err != nil {
-		return false, "TBT Balance Conversion Error", ""
+		return Cancellation{}, modals.Abort("Can't fetch Swap details")
+	}
+	if err := closedError(order); err != nil {
+		return Cancellation{}, err
+	}
+	from, isFrom := money.Lookup(order.FROM)
+	to, isTo := money.Lookup(order.TO)
+	if !isFrom || !isTo {
+		return Cancellation{}, modals.Abort("Invalid Asset Conversion ")
 	}
-	posBalance, err := strconv.ParseFloat(accountData.POS, 64)
+	amount, err := money.ParseDecimal(order.AMT)
 	if err != nil {
-		return false, "POS Balance Conversion Error", ""
+		return Cancellation{}, modals.Abort("Exchange Amount Conversion Error")
 	}
-	ercBalance, err := strconv.ParseFloat(accountData.ERC, 64)
+	settled, err := money.ParseDecimal(order.SAMT)
 	if err != nil {
-		return false, "POS Balance Conversion Error", ""
-	}
-
-	newTBTBal := tbtBalance
-	newPOSBal := posBalance
-	newERCBal := ercBalance
-	newAMT := orderAMT
-
-	if fromCurrency == "USDT-POS" && toCurrency == "TBYT" {
-		newPOSBal = posBalance + orderSAMT
-		newTBTBal = tbtBalance + pendingExchange
-		newAMT = orderSAMT
-	} else if fromCurrency == "USDT-POS" && toCurrency == "USDT-ERC" {
-		newPOSBal = posBalance + orderSAMT
-		newERCBal = ercBalance + pendingExchange
-		newAMT = orderSAMT
-	} else if fromCurrency == "USDT-ERC" && toCurrency == "TBYT" {
-		newERCBal = ercBalance + orderSAMT
-		newTBTBal = tbtBalance + pendingExchange
-		newAMT = orderSAMT
-	} else if fromCurrency == "USDT-ERC" && toCurrency == "USDT-POS" {
-		newERCBal = ercBalance + orderSAMT
-		newPOSBal = posBalance + pendingExchange
-		newAMT = orderSAMT
-	} else if fromCurrency == "TBYT" && toCurrency == "USDT-POS" {
-		newPOSBal = posBalance + orderSAMT
-		newTBTBal = tbtBalance + pendingExchange
-		newAMT = orderSAMT
-	} else if fromCurrency == "TBYT" && toCurrency == "USDT-ERC" {
-		newERCBal = ercBalance + orderSAMT
-		newTBTBal = tbtBalance + pendingExchange
-		newAMT = orderSAMT
-	}
-
-	newAMTString := fmt.Sprintf("%f", newAMT)
-	newERCString := fmt.Sprintf("%f", newERCBal)
-	newPOSBalString := fmt.Sprintf("%f", newPOSBal)
-	newTBTBalString := fmt.Sprintf("%f", newTBTBal)
-
-	updateAccount := bson.M{"$set": bson.M{
-		"TBT": newTBTBalString,
-		"POS": newPOSBalString,
-		"ERC": newERCString,
-	}}
-	filter := bson.M{"ID": address}
-	_, err = accountCollection.UpdateOne(ctx, filter, updateAccount)
+		return Cancellation{}, modals.Abort("Settled Amount Conversion Error")
+	}
+	received, err := order.received()
 	if err != nil {
-		return false, "Could not update balance info", ""
-	}
-	purpose := ""
-	if orderSAMT == 0.00 {
-		filterEx := bson.M{"_id": objectID}
-		_, err = exchangeCollection.DeleteOne(ctx, filterEx)
-		if err != nil {
-			return false, "Could not delete order", ""
-		}
-		purpose = "deleted"
-	} else {
-		updateExOrder := bson.M{"$set": bson.M{
-			"STAT": "done",
-			"AMT":  newAMTString,
-		}}
-		filterEx := bson.M{"_id": objectID}
-		_, err = exchangeCollection.UpdateOne(ctx, filterEx, updateExOrder)
-		if err != nil {
-			return false, "Could not update swap info", ""
-		}
-		purpose = "settled"
+		return Cancellation{}, modals.Abort("Received Amount Conversion Error")
 	}
+	refund := amount.Sub(settled)
+	owed := order.unpaid()
 
-	return true, "Successfully Settled", purpose
+	var legs []ledger.Leg
+	if refund.Sign() > 0 {
+		legs = append(legs, ledger.Debit(ledger.Escrow, from.Field, refund), ledger.Credit(order.ID, from.Field, refund))
+	}
+	if owed.Sign() > 0 {
+		legs = append(legs, ledger.Debit(ledger.Escrow, to.Field, owed), ledger.Credit(order.ID, to.Field, owed))
+	}
+	if len(legs) > 0 {
+		isRefunded, _ := store.Post(ctx, ledger.KindCancel, EID.Hex(), legs...)
+		if !isRefunded {
+			return Cancellation{}, modals.Abort("Could not update balance info")
+		}
+	}
+	if err := modals.Checkpoint("cancel:refunded"); err != nil {
+		return Cancellation{}, err
+	}
+	order.STAT = status
+	order.RCV = received.Add(owed).String()
+	if err := store.SaveProgress(ctx, order); err != nil {
+		return Cancellation{}, modals.Abort("Could not update swap info")
+	}
+	err = store.RecordCancel(ctx, CancelRecord{
+		OID: EID,
+		ID:  order.ID,
+		RSN: reason,
+		RFD: refund.String(),
+		PAY: owed.String(),
+		TMP: time.Now().UTC().Unix(),
+	})
+	if err != nil {
+		return Cancellation{}, modals.Abort("Could not record cancellation")
+	}
+	return Cancellation{Order: order, Refunded: refund, Paid: owed}, nil
+}
 
+// unpaid is what an open order filled before the order book is still owed.
+// Those orders were only paid out when done, so a partial one is owed its
+// settled amount at a price of 1, still held in escrow. The book writes RCV
+// when it first fills an order, after paying it.
+func (order ExOrder) unpaid() money.Amount {
+	if order.RCV != "" || closedError(order) != nil {
+		return money.Zero()
+	}
+	settled, err := money.ParseDecimal(order.SAMT)
+	if err != nil {
+		return money.Zero()
+	}
+	return settled
 }
 
 func getExOrderData(orderID primitive.ObjectID, collection *mongo.Collection) (ExOrder, bool) {
@@ -185,9 +267,7 @@ func getExOrderData(orderID primitive.ObjectID, collection *mongo.Collection) (E
 	var user ExOrder
 	err := collection.FindOne(ctx, filter).Decode(&user)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return user, false
-		}
+		return user, false
 	}
 	return user, true
 }
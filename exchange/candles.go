@@ -0,0 +1,236 @@
+package exchange
+
+import (
+	"context"
+	"tbapi/modals"
+	"tbapi/money"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CandlesCollection holds one OHLCV bar per pair, interval and period with
+// trades, written with the fills of the period
+const CandlesCollection = "exchangeCandles"
+
+// CandleIntervals are the bar lengths kept for every pair
+var CandleIntervals = []string{"1m", "5m", "1h", "1d"}
+
+var intervalSeconds = map[string]int64{"1m": 60, "5m": 300, "1h": 3600, "1d": 86400}
+
+// Candle is a bar of the fills of a pair from TMP, a multiple of the interval
+// in unix seconds (1d bars start at midnight UTC). Prices are in quote per
+// base, VOL is in the base asset and QVOL in the quote asset.
+type Candle struct {
+	PAIR string `bson:"PAIR"`
+	INT  string `bson:"INT"`
+	TMP  int64  `bson:"TMP"`
+	O    string `bson:"O"`
+	H    string `bson:"H"`
+	L    string `bson:"L"`
+	C    string `bson:"C"`
+	VOL  string `bson:"VOL"`
+	QVOL string `bson:"QVOL"`
+	N    int64  `bson:"N"` // fills
+}
+
+// add takes fill into the bar, fills come in the order they matched
+func (c Candle) add(fill FillRecord) (Candle, error) {
+	price, err := money.ParseDecimal(fill.PRC)
+	if err != nil {
+		return c, err
+	}
+	base, err := money.ParseDecimal(fill.AMT)
+	if err != nil {
+		return c, err
+	}
+	quote, err := money.ParseDecimal(fill.QAMT)
+	if err != nil {
+		return c, err
+	}
+	if c.N == 0 {
+		c.O, c.H, c.L, c.VOL, c.QVOL = fill.PRC, fill.PRC, fill.PRC, "0", "0"
+	}
+	high, _ := money.ParseDecimal(c.H)
+	low, _ := money.ParseDecimal(c.L)
+	volume, _ := money.ParseDecimal(c.VOL)
+	quoteVolume, _ := money.ParseDecimal(c.QVOL)
+	if price.Cmp(high) > 0 {
+		c.H = price.String()
+	}
+	if price.LessThan(low) {
+		c.L = price.String()
+	}
+	c.C = price.String()
+	c.VOL = volume.Add(base).String()
+	c.QVOL = quoteVolume.Add(quote).String()
+	c.N++
+	return c, nil
+}
+
+// addToCandles takes a stored fill into the bar of every interval
+func addToCandles(ctx context.Context, store Store, fill FillRecord) error {
+	for _, interval := range CandleIntervals {
+		start := fill.TMP - fill.TMP%intervalSeconds[interval]
+		candle, _, err := store.Candle(ctx, fill.PAIR, interval, start)
+		if err != nil {
+			return err
+		}
+		candle.PAIR, candle.INT, candle.TMP = fill.PAIR, interval, start
+		if candle, err = candle.add(fill); err != nil {
+			return err
+		}
+		if err := store.SaveCandle(ctx, candle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ensureCandleIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(CandlesCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "PAIR", Value: 1}, {Key: "INT", Value: 1}, {Key: "TMP", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// ListCandles returns one page (100 per page, newest first) of the interval
+// bars of the pair named by symbol. Periods without fills have no bar.
+func ListCandles(symbol string, interval string, page int) (bool, string, []Candle) {
+	if _, found := PairBySymbol(symbol); !found {
+		return false, "Unknown Pair", nil
+	}
+	if _, isInterval := intervalSeconds[interval]; !isInterval {
+		return false, "Unknown Interval", nil
+	}
+	if page <= 0 {
+		return false, "Invalid page number", nil
+	}
+	db, err := modals.ConnectDB()
+	if err != nil {
+		return false, "API Database Error", nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pageSize := int64(100)
+	opts := options.Find().
+		SetSort(bson.D{{Key: "TMP", Value: -1}}).
+		SetSkip(int64(page-1) * pageSize).
+		SetLimit(pageSize)
+	cursor, err := db.Collection(CandlesCollection).Find(ctx, bson.M{"PAIR": symbol, "INT": interval}, opts)
+	if err != nil {
+		return false, "API Database Error", nil
+	}
+	var candles []Candle
+	if err := cursor.All(ctx, &candles); err != nil {
+		return false, "API Database Error", nil
+	}
+	return true, "", candles
+}
+
+// Ticker sums up the last 24 hours of a pair. Open is the last price before
+// them, or the first within them when the pair didn't trade before; Change is
+// Last less Open. Without trades in the 24 hours High and Low are Last.
+type Ticker struct {
+	Pair          Pair
+	Last          money.Amount
+	Open          money.Amount
+	High          money.Amount
+	Low           money.Amount
+	Volume        money.Amount // base
+	QuoteVolume   money.Amount
+	Trades        int64
+	Change        money.Amount
+	ChangePercent money.Amount
+	TMP           int64 // of the last bar, 0 when the pair never traded
+}
+
+// summarize builds the ticker from the 1m bar before the day, nil when there
+// is none, and the 1m bars of the day, oldest first
+func summarize(pair Pair, before *Candle, day []Candle) Ticker {
+	t := Ticker{Pair: pair, Last: money.Zero(), Open: money.Zero(), High: money.Zero(), Low: money.Zero(),
+		Volume: money.Zero(), QuoteVolume: money.Zero(), Change: money.Zero(), ChangePercent: money.Zero()}
+	if before != nil {
+		t.Last, _ = money.ParseDecimal(before.C)
+		t.Open, t.TMP = t.Last, before.TMP
+	}
+	for i, c := range day {
+		open, _ := money.ParseDecimal(c.O)
+		high, _ := money.ParseDecimal(c.H)
+		low, _ := money.ParseDecimal(c.L)
+		volume, _ := money.ParseDecimal(c.VOL)
+		quoteVolume, _ := money.ParseDecimal(c.QVOL)
+		if before == nil && i == 0 {
+			t.Open = open
+		}
+		if i == 0 || high.Cmp(t.High) > 0 {
+			t.High = high
+		}
+		if i == 0 || low.LessThan(t.Low) {
+			t.Low = low
+		}
+		t.Last, _ = money.ParseDecimal(c.C)
+		t.Volume = t.Volume.Add(volume)
+		t.QuoteVolume = t.QuoteVolume.Add(quoteVolume)
+		t.Trades += c.N
+		t.TMP = c.TMP
+	}
+	if len(day) == 0 {
+		t.High, t.Low = t.Last, t.Last
+	}
+	t.Change = t.Last.Sub(t.Open)
+	if t.Open.Sign() > 0 {
+		t.ChangePercent = t.Change.Mul(money.MustParse("100")).Div(t.Open)
+	}
+	return t
+}
+
+// GetTickers returns the 24 hour ticker of the pair named by symbol, or of
+// every pair when symbol is empty, from its 1m bars
+func GetTickers(symbol string) (bool, string, []Ticker) {
+	pairs := Pairs()
+	if symbol != "" {
+		pair, found := PairBySymbol(symbol)
+		if !found {
+			return false, "Unknown Pair", nil
+		}
+		pairs = []Pair{pair}
+	}
+	db, err := modals.ConnectDB()
+	if err != nil {
+		return false, "API Database Error", nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	candles := db.Collection(CandlesCollection)
+
+	since := time.Now().UTC().Unix() - 86400
+	tickers := make([]Ticker, 0, len(pairs))
+	for _, pair := range pairs {
+		var before *Candle
+		var last Candle
+		opts := options.FindOne().SetSort(bson.D{{Key: "TMP", Value: -1}})
+		err := candles.FindOne(ctx, bson.M{"PAIR": pair.Symbol(), "INT": "1m", "TMP": bson.M{"$lte": since}}, opts).Decode(&last)
+		switch {
+		case err == nil:
+			before = &last
+		case err != mongo.ErrNoDocuments:
+			return false, "API Database Error", nil
+		}
+		cursor, err := candles.Find(ctx, bson.M{"PAIR": pair.Symbol(), "INT": "1m", "TMP": bson.M{"$gt": since}},
+			options.Find().SetSort(bson.D{{Key: "TMP", Value: 1}}))
+		if err != nil {
+			return false, "API Database Error", nil
+		}
+		var day []Candle
+		if err := cursor.All(ctx, &day); err != nil {
+			return false, "API Database Error", nil
+		}
+		tickers = append(tickers, summarize(pair, before, day))
+	}
+	return true, "", tickers
+}
@@ -0,0 +1,413 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"tbapi/ledger"
+	"tbapi/modals"
+	"tbapi/money"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Engine matches orders on one book per pair and settles every fill through
+// its store. A book is read from the store when first used and again after a
+// settlement found it out of date, so a store must have one engine: the
+// exchange endpoints are served by a single process.
+type Engine struct {
+	store Store
+	mu    sync.Mutex
+	books map[string]*Book
+}
+
+func NewEngine(store Store) *Engine {
+	return &Engine{store: store, books: map[string]*Book{}}
+}
+
+var (
+	defaultMu     sync.Mutex
+	defaultEngine *Engine
+)
+
+// mongoEngine is the engine of this process on the database of modals.ConnectDB
+func mongoEngine() (*Engine, bool) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultEngine == nil {
+		db, err := modals.ConnectDB()
+		if err != nil {
+			return nil, false
+		}
+		defaultEngine = NewEngine(NewMongoStore(db))
+	}
+	return defaultEngine, true
+}
+
+// ResetBooks drops the books of this process so they are read again, for
+// tools that change exchangeOrders behind the engine's back
+func ResetBooks() {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultEngine != nil {
+		defaultEngine.Reset()
+	}
+}
+
+// Reset drops every book
+func (e *Engine) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.books = map[string]*Book{}
+}
+
+// lock returns the book of pair with its lock held, loading it if needed
+func (e *Engine) lock(pair Pair) (*Book, bool) {
+	e.mu.Lock()
+	book, found := e.books[pair.Symbol()]
+	if !found {
+		book = newBook(pair)
+		e.books[pair.Symbol()] = book
+	}
+	e.mu.Unlock()
+
+	book.mu.Lock()
+	if !book.loaded {
+		if err := book.load(e.store); err != nil {
+			book.mu.Unlock()
+			return nil, false
+		}
+	}
+	return book, true
+}
+
+func (b *Book) unlock() {
+	b.mu.Unlock()
+}
+
+// load reads the open orders of the pair
+func (b *Book) load(store Store) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	orders, err := store.OpenOrders(ctx, b.pair)
+	if err != nil {
+		return err
+	}
+	b.bids, b.asks = nil, nil
+	for _, order := range orders {
+		o, err := order.view()
+		if err != nil {
+			return fmt.Errorf("order %s: %w", order.EID.Hex(), err)
+		}
+		if b.fillable(o.Side, o.Price, o.Open) {
+			b.add(o)
+		}
+	}
+	b.loaded = true
+	return nil
+}
+
+// view is the book entry of a stored order, orders from before prices trade at 1
+func (order ExOrder) view() (*bookOrder, error) {
+	_, side, isPair := PairOf(order.FROM, order.TO)
+	if !isPair {
+		return nil, fmt.Errorf("no pair trades %s for %s", order.FROM, order.TO)
+	}
+	price := money.MustParse("1")
+	if order.PRC != "" {
+		parsed, err := money.ParseDecimal(order.PRC)
+		if err != nil {
+			return nil, err
+		}
+		price = parsed
+	}
+	amount, err := money.ParseDecimal(order.AMT)
+	if err != nil {
+		return nil, err
+	}
+	settled, err := money.ParseDecimal(order.SAMT)
+	if err != nil {
+		return nil, err
+	}
+	makerRate, err := feeRate(order.MFR)
+	if err != nil {
+		return nil, err
+	}
+	takerRate, err := feeRate(order.TFR)
+	if err != nil {
+		return nil, err
+	}
+	return &bookOrder{ID: order.EID, Account: order.ID, Side: side, Price: price, Open: amount.Sub(settled), MakerRate: makerRate, TakerRate: takerRate}, nil
+}
+
+// received is the amount of TO the order got so far
+func (order ExOrder) received() (money.Amount, error) {
+	if order.RCV == "" {
+		return money.Zero(), nil
+	}
+	return money.ParseDecimal(order.RCV)
+}
+
+// Place stores a new order with the fee rates of the tier of its account and
+// moves amount of what it pays to escrow
+func (e *Engine) Place(order ExOrder, amount money.Amount) (bool, string, primitive.ObjectID) {
+	fromAsset, isKnown := money.Lookup(order.FROM)
+	if !isKnown {
+		return false, "Invalid Asset Conversion ", primitive.NilObjectID
+	}
+	pair, _, isPair := PairOf(order.FROM, order.TO)
+	if !isPair {
+		return false, "Invalid Asset Conversion ", primitive.NilObjectID
+	}
+	isPriced, message := e.price(&order, pair)
+	if !isPriced {
+		return false, message, primitive.NilObjectID
+	}
+	order.AMT = amount.String()
+	order.SAMT = "0"
+	order.RCV = "0"
+	order.TMP = fmt.Sprintf("%d", time.Now().UTC().Unix())
+	order.STAT = StatusPending
+
+	EID := primitive.NilObjectID
+	err := e.store.Transact(func(ctx context.Context) error {
+		var err error
+		EID, err = e.store.InsertOrder(ctx, order)
+		if err != nil {
+			return modals.Abort("Can't Update Order List")
+		}
+		if err := modals.Checkpoint("exchange:order-created"); err != nil {
+			return err
+		}
+		// it stays in escrow until filled or cancelled
+		isDeducted, _ := e.store.Post(ctx, ledger.KindSwap, EID.Hex(),
+			ledger.Debit(order.ID, fromAsset.Field, amount),
+			ledger.Credit(ledger.Escrow, fromAsset.Field, amount))
+		if !isDeducted {
+			return modals.Abort(fmt.Sprintf("Insufficient %s Amount ", order.FROM))
+		}
+		return nil
+	})
+	if err != nil {
+		return false, modals.AbortMessage(err, "Can't Update Order List"), primitive.NilObjectID
+	}
+	return true, "Exchange Order Created", EID
+}
+
+// price sets the fee tier and rates of order from the schedule and the volume
+// its account traded in the last 30 days
+func (e *Engine) price(order *ExOrder, pair Pair) (bool, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	schedule, err := e.store.FeeSchedule(ctx)
+	if err != nil {
+		return false, "Can't read fee schedule"
+	}
+	volume, err := e.store.TradedVolume(ctx, order.ID, time.Now().UTC().Unix()-feeWindow)
+	if err != nil {
+		return false, "Can't read traded volume"
+	}
+	tier, maker, taker, err := schedule.Rates(pair.Symbol(), volume)
+	if err != nil {
+		return false, "Invalid fee schedule"
+	}
+	order.TIER, order.MFR, order.TFR = tier, maker.String(), taker.String()
+	return true, ""
+}
+
+// MatchOrder matches a newly placed order against the book of its pair. Every
+// fill pays both sides at once at the maker's price; what can't be filled
+// stays in the book. It returns the order status and the amount of the paying
+// currency exchanged so far.
+func MatchOrder(EID primitive.ObjectID) (string, money.Amount) {
+	engine, isReady := mongoEngine()
+	if !isReady {
+		return StatusPending, money.Zero()
+	}
+	status, paid, _ := engine.Match(EID)
+	return status, paid
+}
+
+// Match is MatchOrder on the engine's store, it also returns the fills
+func (e *Engine) Match(EID primitive.ObjectID) (string, money.Amount, []Fill) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	order, err := e.store.Order(ctx, EID)
+	cancel()
+	if err != nil || order.STAT != StatusPending {
+		return StatusPending, money.Zero(), nil
+	}
+	pair, _, isPair := PairOf(order.FROM, order.TO)
+	if !isPair {
+		return StatusPending, money.Zero(), nil
+	}
+	book, isLoaded := e.lock(pair)
+	if !isLoaded {
+		return StatusPending, money.Zero(), nil
+	}
+	defer book.unlock()
+
+	taker, err := order.view()
+	if err != nil {
+		return StatusPending, money.Zero(), nil
+	}
+	// a book loaded just now already holds the new order
+	book.remove(EID)
+	fills := book.match(*taker)
+	// IOC and FOK orders never rest, a FOK that can't fill completely is killed
+	final := order.TIF == ImmediateOrCancel || order.TIF == FillOrKill
+	if order.TIF == FillOrKill && !book.completes(taker.Side, taker.Price, taker.Open, fills) {
+		fills = nil
+	}
+	now := time.Now().UTC().Unix()
+	for i := range fills {
+		fills[i].ID = primitive.NewObjectID()
+		fills[i].TMP = now
+	}
+	book.setFees(taker, fills)
+
+	var status string
+	var paid money.Amount
+	err = e.store.Transact(func(ctx context.Context) error {
+		var err error
+		status, paid, err = settle(ctx, e.store, book, taker, fills, final)
+		return err
+	})
+	if err != nil {
+		// the order is still open in the store, it is picked up again with the book
+		book.loaded = false
+		return StatusPending, money.Zero(), nil
+	}
+	book.apply(fills)
+	if status == StatusPending || status == StatusPartial {
+		taker.Open = taker.Open.Sub(paid)
+		book.add(taker)
+	}
+	return status, paid, fills
+}
+
+// settle posts every fill on its own, moving its base from escrow to the buyer
+// and its quote to the seller less their fees, which go to the revenue account.
+// It stores the fill in exchangeFills, the transfer history and its candles and
+// records it on both orders. An order that can't be filled any further is
+// closed and the rest of its locked amount refunded, as is the taker when final.
+func settle(ctx context.Context, store Store, book *Book, taker *bookOrder, fills []Fill, final bool) (string, money.Amount, error) {
+	pair := book.pair
+	paid, received, fees := money.Zero(), money.Zero(), money.Zero()
+	var last int64
+	for _, fill := range fills {
+		maker := book.get(fill.Maker)
+		if maker == nil {
+			return "", money.Zero(), modals.Abort("Order book out of date")
+		}
+		buyerFee, sellerFee := fill.feeOf(SideBuy), fill.feeOf(SideSell)
+		legs := []ledger.Leg{
+			ledger.Debit(ledger.Escrow, pair.Base.Field, fill.Base),
+			ledger.Credit(fill.Buyer(), pair.Base.Field, fill.Base.Sub(buyerFee)),
+			ledger.Debit(ledger.Escrow, pair.Quote.Field, fill.Quote),
+			ledger.Credit(fill.Seller(), pair.Quote.Field, fill.Quote.Sub(sellerFee)),
+		}
+		if buyerFee.Sign() > 0 {
+			legs = append(legs, ledger.Credit(ledger.Revenue, pair.Base.Field, buyerFee))
+		}
+		if sellerFee.Sign() > 0 {
+			legs = append(legs, ledger.Credit(ledger.Revenue, pair.Quote.Field, sellerFee))
+		}
+		isPosted, message := store.Post(ctx, ledger.KindFill, fill.ID.Hex(), legs...)
+		if !isPosted {
+			return "", money.Zero(), modals.Abort(message)
+		}
+		record := fill.record()
+		if err := store.InsertFill(ctx, record); err != nil {
+			return "", money.Zero(), modals.Abort("Could not record fill")
+		}
+		if err := store.RecordTransfers(ctx, fill.transfers(pair)); err != nil {
+			return "", money.Zero(), modals.Abort("Can't Update Order List")
+		}
+		if err := addToCandles(ctx, store, record); err != nil {
+			return "", money.Zero(), modals.Abort("Could not update candles")
+		}
+		if err := modals.Checkpoint("exchange:fill-posted"); err != nil {
+			return "", money.Zero(), err
+		}
+		if _, err := fillOrder(ctx, store, book, maker, fill.paidBy(maker.Side), fill.receivedBy(maker.Side), fill.MakerFee, fill.TMP, false); err != nil {
+			return "", money.Zero(), err
+		}
+		if err := modals.Checkpoint("exchange:maker-settled"); err != nil {
+			return "", money.Zero(), err
+		}
+		paid = paid.Add(fill.paidBy(taker.Side))
+		received = received.Add(fill.receivedBy(taker.Side))
+		fees = fees.Add(fill.TakerFee)
+		last = fill.TMP
+	}
+	status, err := fillOrder(ctx, store, book, taker, paid, received, fees, last, final)
+	if err != nil {
+		return "", money.Zero(), err
+	}
+	return status, paid, nil
+}
+
+// fillOrder adds paid, received and the fee kept from it to the stored order o,
+// after checking it still has what the book thinks is open, and returns its new
+// status. A final fill closes the order whatever is left.
+func fillOrder(ctx context.Context, store Store, book *Book, o *bookOrder, paid money.Amount, received money.Amount, fee money.Amount, at int64, final bool) (string, error) {
+	order, err := store.Order(ctx, o.ID)
+	if err != nil {
+		return "", modals.Abort("Order book out of date")
+	}
+	stored, err := order.view()
+	if err != nil || !stored.Open.Equal(o.Open) || (order.STAT != StatusPending && order.STAT != StatusPartial) {
+		return "", modals.Abort("Order book out of date")
+	}
+	storedReceived, err := order.received()
+	if err != nil {
+		return "", modals.Abort("Order book out of date")
+	}
+	// an order filled before the book is paid what it is owed first
+	if owed := order.unpaid(); owed.Sign() > 0 {
+		field := book.pair.gets(o.Side).Field
+		isPaid, message := store.Post(ctx, ledger.KindFill, o.ID.Hex(),
+			ledger.Debit(ledger.Escrow, field, owed),
+			ledger.Credit(o.Account, field, owed))
+		if !isPaid {
+			return "", modals.Abort(message)
+		}
+		storedReceived = storedReceived.Add(owed)
+	}
+	left := o.Open.Sub(paid)
+	switch {
+	case final && paid.IsZero() && storedReceived.IsZero():
+		order.STAT = StatusCancelled
+	case final || !book.fillable(o.Side, o.Price, left):
+		order.STAT = StatusDone
+	case paid.Sign() > 0:
+		order.STAT = StatusPartial
+	}
+	settled, _ := money.ParseDecimal(order.SAMT)
+	order.SAMT = settled.Add(paid).String()
+	order.RCV = storedReceived.Add(received).String()
+	if paid.Sign() > 0 {
+		order.LFT = at
+	}
+	if fee.Sign() > 0 {
+		order.FEE = order.Fees().Add(fee).String()
+	}
+	if err := store.SaveProgress(ctx, order); err != nil {
+		return "", modals.Abort("Could not update swap info")
+	}
+	if (order.STAT == StatusDone || order.STAT == StatusCancelled) && left.Sign() > 0 {
+		// too little left to trade at the order's price, or no time to wait
+		field := book.pair.pays(o.Side).Field
+		isRefunded, message := store.Post(ctx, ledger.KindCancel, o.ID.Hex(),
+			ledger.Debit(ledger.Escrow, field, left),
+			ledger.Credit(o.Account, field, left))
+		if !isRefunded {
+			return "", modals.Abort(message)
+		}
+		if err := modals.Checkpoint("exchange:rest-refunded"); err != nil {
+			return "", err
+		}
+	}
+	return order.STAT, nil
+}
@@ -0,0 +1,668 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"tbapi/ledger"
+	"tbapi/money"
+	"tbapi/transfer"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	maker = "maker"
+	taker = "taker"
+)
+
+// directions trades half of a resting maker, then the rest. Amounts are in
+// the base asset.
+var directions = []struct {
+	pair  string
+	side  string // of the taker
+	price string
+}{
+	{"TBYT/USDT-POS", SideBuy, "0.25"},
+	{"TBYT/USDT-POS", SideSell, "0.25"},
+	{"TBYT/USDT-ERC", SideBuy, "0.3"},
+	{"TBYT/USDT-ERC", SideSell, "0.3"},
+	{"USDT-POS/USDT-ERC", SideBuy, "0.998"},
+	{"USDT-POS/USDT-ERC", SideSell, "1.002"},
+}
+
+func TestEngine(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{"directions", testDirections},
+		{"priority", testPriority},
+		{"ioc", testIOC},
+		{"fok", testFOK},
+		{"dust", testDust},
+		{"cancel", testCancel},
+		{"legacy", testLegacy},
+		{"expiry", testExpiry},
+		{"candles", testCandles},
+		{"ticker", testTicker},
+		{"fees", testFees},
+	} {
+		t.Run(tc.name, tc.run)
+	}
+}
+
+// market is an engine on a fresh store where maker and taker hold 1000 of
+// every asset
+type market struct {
+	t      *testing.T
+	store  *memStore
+	engine *Engine
+}
+
+func newMarket(t *testing.T) *market {
+	store := newMemStore()
+	for _, account := range []string{maker, taker} {
+		for _, field := range ledger.Fields() {
+			store.deposit(account, field, money.MustParse("1000"))
+		}
+	}
+	return &market{t: t, store: store, engine: NewEngine(store)}
+}
+
+// order places and matches an order of account offering amount of what side
+// pays, and returns its id, status and fills
+func (m *market) order(account string, pair Pair, side string, price string, amount money.Amount, tif string) (primitive.ObjectID, string, []Fill) {
+	return m.submit(limitOrder(account, pair, side, price, tif), amount)
+}
+
+func limitOrder(account string, pair Pair, side string, price string, tif string) ExOrder {
+	from, to := pair.Base, pair.Quote
+	if side == SideBuy {
+		from, to = to, from
+	}
+	return ExOrder{
+		ID:   account,
+		FROM: from.Code,
+		TO:   to.Code,
+		PAIR: pair.Symbol(),
+		SIDE: side,
+		PRC:  price,
+		TYP:  TypeLimit,
+		TIF:  tif,
+	}
+}
+
+func (m *market) submit(order ExOrder, amount money.Amount) (primitive.ObjectID, string, []Fill) {
+	isPlaced, message, EID := m.engine.Place(order, amount)
+	if !isPlaced {
+		m.t.Fatalf("placing %s %s: %s", order.SIDE, order.PAIR, message)
+	}
+	status, _, fills := m.engine.Match(EID)
+	return EID, status, fills
+}
+
+// offer is what an order of side pays for base at price
+func offer(side string, base money.Amount, price money.Amount, pair Pair) money.Amount {
+	if side == SideBuy {
+		return base.Mul(price).RoundDown(pair.Quote)
+	}
+	return base
+}
+
+// pays is the balance field an order of side locks
+func pays(pair Pair, side string) string {
+	if side == SideBuy {
+		return pair.Quote.Field
+	}
+	return pair.Base.Field
+}
+
+func other(side string) string {
+	if side == SideBuy {
+		return SideSell
+	}
+	return SideBuy
+}
+
+// escrowed is what the open orders of the store still lock, per field
+func (m *market) escrowed() map[string]money.Amount {
+	locked := map[string]money.Amount{}
+	for _, order := range m.store.orders {
+		if order.STAT != StatusPending && order.STAT != StatusPartial {
+			continue
+		}
+		asset, _ := money.Lookup(order.FROM)
+		amount, _ := money.ParseDecimal(order.AMT)
+		settled, _ := money.ParseDecimal(order.SAMT)
+		locked[asset.Field] = locked[asset.Field].Add(amount.Sub(settled))
+	}
+	return locked
+}
+
+// checkEscrow compares the escrow account with the open orders
+func (m *market) checkEscrow(t *testing.T, label string) {
+	locked := m.escrowed()
+	for _, field := range ledger.Fields() {
+		held := m.store.balance(ledger.Escrow, field)
+		if !held.Equal(locked[field]) {
+			t.Errorf("%s: escrow holds %s %s, open orders lock %s", label, held, field, locked[field])
+		}
+	}
+}
+
+// checkFills looks for one balanced fill posting per fill, under its id
+func (m *market) checkFills(t *testing.T, label string, fills []Fill) {
+	for _, fill := range fills {
+		found := 0
+		for _, p := range m.store.postings {
+			if p.kind == ledger.KindFill && p.ref == fill.ID.Hex() {
+				found++
+			}
+		}
+		if found != 1 {
+			t.Errorf("%s: fill %s posted %d times", label, fill.ID.Hex(), found)
+		}
+	}
+}
+
+// testDirections trades every pair both ways
+func testDirections(t *testing.T) {
+	for _, d := range directions {
+		t.Run(d.pair+" "+d.side, func(t *testing.T) {
+			pair, found := PairBySymbol(d.pair)
+			if !found {
+				t.Fatalf("no such pair %s", d.pair)
+			}
+			m := newMarket(t)
+			price := money.MustParse(d.price)
+			makerSide := other(d.side)
+			base := money.MustParse("10")
+			makerID, status, fills := m.order(maker, pair, makerSide, d.price, offer(makerSide, base, price, pair), GoodTillCancelled)
+			if !(status == StatusPending && len(fills) == 0) {
+				t.Errorf("maker %s with %d fills", status, len(fills))
+			}
+
+			for i, part := range []string{"4", "6"} {
+				partBase := money.MustParse(part)
+				partQuote := partBase.Mul(price).RoundDown(pair.Quote)
+				before := map[string]money.Amount{}
+				for _, account := range []string{maker, taker} {
+					for _, asset := range []money.Asset{pair.Base, pair.Quote} {
+						before[account+"/"+asset.Field] = m.store.balance(account, asset.Field)
+					}
+				}
+				// a limit above the ask or below the bid still trades at the maker's price
+				limit := price.Add(money.MustParse("0.01"))
+				if d.side == SideSell {
+					limit = price.Sub(money.MustParse("0.01"))
+				}
+				_, status, fills := m.order(taker, pair, d.side, limit.String(), offer(d.side, partBase, price, pair), GoodTillCancelled)
+				step := fmt.Sprintf("part %d", i+1)
+				if status != StatusDone {
+					t.Errorf("%s: taker %s", step, status)
+				}
+				if len(fills) != 1 {
+					t.Errorf("%s: %d fills", step, len(fills))
+				}
+				for _, fill := range fills {
+					if !fill.Price.Equal(price) {
+						t.Errorf("%s: filled at %s, maker asks %s", step, fill.Price, price)
+					}
+					if !(fill.Base.Equal(partBase) && fill.Quote.Equal(partQuote)) {
+						t.Errorf("%s: fill of %s for %s", step, fill.Base, fill.Quote)
+					}
+				}
+				m.checkFills(t, step, fills)
+				if len(m.store.fills) != i+1 {
+					t.Errorf("%s: %d fills stored, want %d", step, len(m.store.fills), i+1)
+				}
+				makerOrder := m.store.orders[makerID]
+				if !makerOrder.AveragePrice().Equal(price) {
+					t.Errorf("%s: maker averages %s, want %s", step, makerOrder.AveragePrice(), price)
+				}
+				if makerOrder.LFT == 0 {
+					t.Errorf("%s: maker has no last fill time", step)
+				}
+
+				buyer, seller := taker, maker
+				if d.side == SideSell {
+					buyer, seller = maker, taker
+				}
+				// both sides are paid by the fill, the maker's own share came from escrow
+				want := map[string]money.Amount{
+					buyer + "/" + pair.Base.Field:    before[buyer+"/"+pair.Base.Field].Add(partBase),
+					seller + "/" + pair.Quote.Field:  before[seller+"/"+pair.Quote.Field].Add(partQuote),
+					taker + "/" + pays(pair, d.side): before[taker+"/"+pays(pair, d.side)].Sub(offer(d.side, partBase, price, pair)),
+				}
+				for key, amount := range want {
+					if !m.store.balances[key].Equal(amount) {
+						t.Errorf("%s: %s is %s, want %s", step, key, m.store.balances[key], amount)
+					}
+				}
+				wantMaker := StatusPartial
+				if i == 1 {
+					wantMaker = StatusDone
+				}
+				if m.store.orders[makerID].STAT != wantMaker {
+					t.Errorf("%s: maker %s, want %s", step, m.store.orders[makerID].STAT, wantMaker)
+				}
+				m.checkEscrow(t, step)
+			}
+		})
+	}
+}
+
+func tbytPOS() Pair {
+	pair, _ := PairBySymbol("TBYT/USDT-POS")
+	return pair
+}
+
+// testPriority rests asks at two prices, two of them at the same one, and
+// checks a buy takes the best price first and then the older ask
+func testPriority(t *testing.T) {
+	m := newMarket(t)
+	pair := tbytPOS()
+	five := money.MustParse("5")
+	older, _, _ := m.order(maker, pair, SideSell, "0.3", five, GoodTillCancelled)
+	newer, _, _ := m.order(maker, pair, SideSell, "0.3", five, GoodTillCancelled)
+	best, _, _ := m.order(maker, pair, SideSell, "0.2", five, GoodTillCancelled)
+
+	// 5 at 0.2 and 3 at 0.3
+	_, status, fills := m.order(taker, pair, SideBuy, "0.3", money.MustParse("1.9"), GoodTillCancelled)
+	if status != StatusDone {
+		t.Errorf("taker %s", status)
+	}
+	if len(fills) != 2 {
+		t.Errorf("%d fills, want 2", len(fills))
+	}
+	if len(fills) == 2 {
+		if !(fills[0].Maker == best && fills[0].Base.Equal(five)) {
+			t.Errorf("first fill %s of %s, want all of the 0.2 ask", fills[0].Maker.Hex(), fills[0].Base)
+		}
+		if !(fills[1].Maker == older && fills[1].Base.Equal(money.MustParse("3"))) {
+			t.Errorf("second fill %s of %s, want 3 of the older ask", fills[1].Maker.Hex(), fills[1].Base)
+		}
+	}
+	if m.store.orders[newer].STAT != StatusPending {
+		t.Errorf("newer ask %s, want it untouched", m.store.orders[newer].STAT)
+	}
+	m.checkFills(t, "priority", fills)
+	m.checkEscrow(t, "priority")
+}
+
+// testIOC sends an IOC buy larger than the book and checks the rest is
+// refunded instead of resting
+func testIOC(t *testing.T) {
+	m := newMarket(t)
+	pair := tbytPOS()
+	m.order(maker, pair, SideSell, "0.5", money.MustParse("4"), GoodTillCancelled)
+	before := m.store.balance(taker, pair.Quote.Field)
+	ID, status, fills := m.order(taker, pair, SideBuy, "0.5", money.MustParse("3"), ImmediateOrCancel)
+	if !(status == StatusDone && len(fills) == 1) {
+		t.Errorf("taker %s with %d fills", status, len(fills))
+	}
+	spent := before.Sub(m.store.balance(taker, pair.Quote.Field))
+	if !spent.Equal(money.MustParse("2")) {
+		t.Errorf("taker spent %s, want the 2 filled", spent)
+	}
+	if m.store.orders[ID].SAMT != "2" {
+		t.Errorf("taker settled %s", m.store.orders[ID].SAMT)
+	}
+	m.checkEscrow(t, "ioc")
+}
+
+// testFOK sends a FOK buy the book can't fill and checks nothing trades
+func testFOK(t *testing.T) {
+	m := newMarket(t)
+	pair := tbytPOS()
+	askID, _, _ := m.order(maker, pair, SideSell, "0.5", money.MustParse("4"), GoodTillCancelled)
+	before := m.store.balance(taker, pair.Quote.Field)
+	ID, status, fills := m.order(taker, pair, SideBuy, "0.5", money.MustParse("3"), FillOrKill)
+	if !(status == StatusCancelled && len(fills) == 0) {
+		t.Errorf("taker %s with %d fills", status, len(fills))
+	}
+	if !m.store.balance(taker, pair.Quote.Field).Equal(before) {
+		t.Errorf("taker has %s, had %s", m.store.balance(taker, pair.Quote.Field), before)
+	}
+	if m.store.orders[ID].SAMT != "0" {
+		t.Errorf("taker settled %s", m.store.orders[ID].SAMT)
+	}
+	if m.store.orders[askID].STAT != StatusPending {
+		t.Errorf("ask %s, want it untouched", m.store.orders[askID].STAT)
+	}
+	for _, p := range m.store.postings {
+		if p.kind == ledger.KindFill {
+			t.Errorf("fill posted for a killed order")
+		}
+	}
+	m.checkEscrow(t, "fok")
+}
+
+// testDust fills a bid at a price that leaves it a remainder too small to
+// buy anything and checks it is closed and refunded
+func testDust(t *testing.T) {
+	m := newMarket(t)
+	pair := tbytPOS()
+	bidID, _, _ := m.order(maker, pair, SideBuy, "3", money.MustParse("10"), GoodTillCancelled)
+	before := m.store.balance(maker, pair.Quote.Field)
+	_, status, _ := m.order(taker, pair, SideSell, "3", money.MustParse("3.333333"), GoodTillCancelled)
+	if status != StatusDone {
+		t.Errorf("taker %s", status)
+	}
+	bid := m.store.orders[bidID]
+	if bid.STAT != StatusDone {
+		t.Errorf("bid %s, want it closed", bid.STAT)
+	}
+	refund := m.store.balance(maker, pair.Quote.Field).Sub(before)
+	if !refund.Equal(money.MustParse("0.000001")) {
+		t.Errorf("bid refunded %s, want 0.000001", refund)
+	}
+	m.checkEscrow(t, "dust")
+}
+
+// testCancel cancels a partly filled ask and checks exactly its unfilled
+// part comes back, the book forgets it and cancelling again, cancelling a done
+// order or the order of someone else fails with the typed errors
+func testCancel(t *testing.T) {
+	m := newMarket(t)
+	pair := tbytPOS()
+	askID, _, _ := m.order(maker, pair, SideSell, "0.5", money.MustParse("10"), GoodTillCancelled)
+	bidID, _, _ := m.order(taker, pair, SideBuy, "0.5", money.MustParse("2"), GoodTillCancelled)
+
+	_, err := m.engine.Cancel(taker, askID, StatusCancelled, ReasonUser)
+	if !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("cancelling another account's order: %v", err)
+	}
+
+	before := m.store.balance(maker, pair.Base.Field)
+	cancelled, err := m.engine.Cancel(maker, askID, StatusCancelled, ReasonUser)
+	if err != nil {
+		t.Errorf("cancel: %v", err)
+	}
+	if !(cancelled.Refunded.Equal(money.MustParse("6")) && cancelled.Paid.IsZero()) {
+		t.Errorf("refunded %s and paid %s, want 6 and 0", cancelled.Refunded, cancelled.Paid)
+	}
+	refund := m.store.balance(maker, pair.Base.Field).Sub(before)
+	if !refund.Equal(money.MustParse("6")) {
+		t.Errorf("maker got %s TBYT back, want the 6 unfilled", refund)
+	}
+	ask := m.store.orders[askID]
+	if !(ask.STAT == StatusCancelled && ask.SAMT == "4" && ask.RCV == "2") {
+		t.Errorf("ask %s with %s settled and %s received", ask.STAT, ask.SAMT, ask.RCV)
+	}
+	if !(len(m.store.cancels) == 1 && m.store.cancels[0].RFD == "6" && m.store.cancels[0].RSN == ReasonUser) {
+		t.Errorf("cancellation events %+v", m.store.cancels)
+	}
+	m.checkEscrow(t, "cancel")
+
+	_, err = m.engine.Cancel(maker, askID, StatusCancelled, ReasonUser)
+	if !errors.Is(err, ErrOrderCancelled) {
+		t.Errorf("cancelling twice: %v", err)
+	}
+	_, err = m.engine.Cancel(taker, bidID, StatusCancelled, ReasonUser)
+	if !errors.Is(err, ErrOrderDone) {
+		t.Errorf("cancelling a done order: %v", err)
+	}
+
+	_, status, fills := m.order(taker, pair, SideBuy, "0.5", money.MustParse("1"), GoodTillCancelled)
+	if !(status == StatusPending && len(fills) == 0) {
+		t.Errorf("bid after the cancel %s with %d fills, want it to rest", status, len(fills))
+	}
+}
+
+// testLegacy gives orders filled in part before the order book, which were
+// never paid for that part, and checks a cancel and a fill pay it from escrow
+func testLegacy(t *testing.T) {
+	m := newMarket(t)
+	pair := tbytPOS()
+	legacy := func(account string, from money.Asset, to money.Asset) primitive.ObjectID {
+		EID, _ := m.store.InsertOrder(context.Background(), ExOrder{ID: account, FROM: from.Code, TO: to.Code, AMT: "10", SAMT: "4", TMP: "1", STAT: StatusPartial})
+		m.store.deposit(ledger.Escrow, from.Field, money.MustParse("6"))
+		m.store.deposit(ledger.Escrow, to.Field, money.MustParse("4"))
+		// written behind the engine's back
+		m.engine.Reset()
+		return EID
+	}
+
+	bidID := legacy(maker, pair.Quote, pair.Base)
+	before := m.store.balance(maker, pair.Base.Field)
+	cancelled, err := m.engine.Cancel(maker, bidID, StatusCancelled, ReasonUser)
+	if err != nil {
+		t.Errorf("cancel: %v", err)
+	}
+	if !(cancelled.Refunded.Equal(money.MustParse("6")) && cancelled.Paid.Equal(money.MustParse("4"))) {
+		t.Errorf("refunded %s and paid %s, want 6 and 4", cancelled.Refunded, cancelled.Paid)
+	}
+	paid := m.store.balance(maker, pair.Base.Field).Sub(before)
+	if !paid.Equal(money.MustParse("4")) {
+		t.Errorf("maker was paid %s TBYT, want the 4 filled", paid)
+	}
+	if m.store.orders[bidID].RCV != "4" {
+		t.Errorf("bid received %s", m.store.orders[bidID].RCV)
+	}
+
+	askID := legacy(maker, pair.Base, pair.Quote)
+	before = m.store.balance(maker, pair.Quote.Field)
+	_, status, _ := m.order(taker, pair, SideBuy, "1", money.MustParse("3"), GoodTillCancelled)
+	if status != StatusDone {
+		t.Errorf("taker %s", status)
+	}
+	paid = m.store.balance(maker, pair.Quote.Field).Sub(before)
+	if !paid.Equal(money.MustParse("7")) {
+		t.Errorf("ask was paid %s, want the 4 owed and the 3 filled", paid)
+	}
+	if m.store.orders[askID].RCV != "7" {
+		t.Errorf("ask received %s", m.store.orders[askID].RCV)
+	}
+	m.checkEscrow(t, "legacy")
+}
+
+// testExpiry rests a GTT bid that expires, one that doesn't yet and a GTC
+// one, and checks only the first is closed as expired and refunded
+func testExpiry(t *testing.T) {
+	m := newMarket(t)
+	pair := tbytPOS()
+	now := time.Now().UTC().Unix()
+	gtt := func(expiry int64) primitive.ObjectID {
+		order := limitOrder(maker, pair, SideBuy, "0.5", GoodTillTime)
+		order.EXP = expiry
+		EID, _, _ := m.submit(order, money.MustParse("5"))
+		return EID
+	}
+	expiring, later := gtt(now+60), gtt(now+3600)
+	gtcID, _, _ := m.order(maker, pair, SideBuy, "0.5", money.MustParse("5"), GoodTillCancelled)
+	// partly filled before it expires
+	m.order(taker, pair, SideSell, "0.5", money.MustParse("4"), GoodTillCancelled)
+
+	before := m.store.balance(maker, pair.Quote.Field)
+	expired, err := m.engine.Expire(context.Background(), now+60)
+	if !(err == nil && expired == 1) {
+		t.Errorf("expired %d orders: %v", expired, err)
+	}
+	order := m.store.orders[expiring]
+	if order.STAT != StatusExpired {
+		t.Errorf("expiring bid %s", order.STAT)
+	}
+	refund := m.store.balance(maker, pair.Quote.Field).Sub(before)
+	if !refund.Equal(money.MustParse("3")) {
+		t.Errorf("expiring bid refunded %s, want the 3 unfilled", refund)
+	}
+	if !(m.store.orders[later].STAT == StatusPending && m.store.orders[gtcID].STAT == StatusPending) {
+		t.Errorf("later %s and GTC %s, want both open", m.store.orders[later].STAT, m.store.orders[gtcID].STAT)
+	}
+	last := m.store.cancels[len(m.store.cancels)-1]
+	if !(last.OID == expiring && last.RSN == ReasonExpired) {
+		t.Errorf("cancellation %+v", last)
+	}
+	m.checkEscrow(t, "expiry")
+
+	_, err = m.engine.Cancel(maker, expiring, StatusCancelled, ReasonUser)
+	if !errors.Is(err, ErrOrderExpired) {
+		t.Errorf("cancelling an expired order: %v", err)
+	}
+	expired, err = m.engine.Expire(context.Background(), now+60)
+	if !(err == nil && expired == 0) {
+		t.Errorf("expired %d orders again: %v", expired, err)
+	}
+
+	// the book no longer holds it, the later bid is next
+	_, _, fills := m.order(taker, pair, SideSell, "0.5", money.MustParse("1"), GoodTillCancelled)
+	if !(len(fills) == 1 && fills[0].Maker == later) {
+		t.Errorf("sell after expiry filled %+v, want the later bid", fills)
+	}
+}
+
+// testCandles trades at 0.5, 0.6 and 0.4 and checks every interval has
+// bars opening at 0.5, reaching 0.6 and 0.4, closing at 0.4 and holding the
+// volume of the three fills
+func testCandles(t *testing.T) {
+	m := newMarket(t)
+	pair := tbytPOS()
+	two := money.MustParse("2")
+	for _, price := range []string{"0.5", "0.6", "0.4"} {
+		m.order(maker, pair, SideSell, price, two, GoodTillCancelled)
+		m.order(taker, pair, SideBuy, price, offer(SideBuy, two, money.MustParse(price), pair), GoodTillCancelled)
+	}
+
+	for _, interval := range CandleIntervals {
+		var bars []Candle
+		for _, candle := range m.store.candles {
+			if candle.INT == interval {
+				bars = append(bars, candle)
+			}
+		}
+		fills, volume, quoteVolume := int64(0), money.Zero(), money.Zero()
+		for _, bar := range bars {
+			if bar.PAIR != pair.Symbol() {
+				t.Errorf("%s bar of %s", interval, bar.PAIR)
+			}
+			base, _ := money.ParseDecimal(bar.VOL)
+			quote, _ := money.ParseDecimal(bar.QVOL)
+			fills, volume, quoteVolume = fills+bar.N, volume.Add(base), quoteVolume.Add(quote)
+		}
+		if !(fills == 3 && volume.Equal(money.MustParse("6")) && quoteVolume.Equal(money.MustParse("3"))) {
+			t.Errorf("%s bars hold %d fills of %s for %s, want 3 of 6 for 3", interval, fills, volume, quoteVolume)
+		}
+		// the fills may straddle the start of a minute
+		if len(bars) == 1 {
+			bar := bars[0]
+			if !(bar.O == "0.5" && bar.H == "0.6" && bar.L == "0.4" && bar.C == "0.4") {
+				t.Errorf("%s bar %s/%s/%s/%s, want 0.5/0.6/0.4/0.4", interval, bar.O, bar.H, bar.L, bar.C)
+			}
+		}
+	}
+}
+
+// testTicker sums up two 1m bars of the day after one from the day before
+func testTicker(t *testing.T) {
+	before := &Candle{C: "0.45", TMP: 60}
+	day := []Candle{
+		{O: "0.5", H: "0.6", L: "0.5", C: "0.6", VOL: "4", QVOL: "2.2", N: 2, TMP: 86460},
+		{O: "0.4", H: "0.4", L: "0.4", C: "0.4", VOL: "2", QVOL: "0.8", N: 1, TMP: 86520},
+	}
+	ticker := summarize(tbytPOS(), before, day)
+	if !ticker.Open.Equal(money.MustParse("0.45")) || !ticker.Last.Equal(money.MustParse("0.4")) {
+		t.Errorf("open %s and last %s, want 0.45 and 0.4", ticker.Open, ticker.Last)
+	}
+	if !ticker.High.Equal(money.MustParse("0.6")) || !ticker.Low.Equal(money.MustParse("0.4")) {
+		t.Errorf("high %s and low %s, want 0.6 and 0.4", ticker.High, ticker.Low)
+	}
+	if !ticker.Volume.Equal(money.MustParse("6")) || !ticker.QuoteVolume.Equal(money.MustParse("3")) || ticker.Trades != 3 {
+		t.Errorf("volume %s for %s in %d fills, want 6 for 3 in 3", ticker.Volume, ticker.QuoteVolume, ticker.Trades)
+	}
+	if !ticker.Change.Equal(money.MustParse("0.05").Neg()) || ticker.ChangePercent.Fixed(2) != "-11.11" {
+		t.Errorf("change %s (%s%%), want -0.05 (-11.11%%)", ticker.Change, ticker.ChangePercent.Fixed(2))
+	}
+
+	quiet := summarize(tbytPOS(), before, nil)
+	if !quiet.High.Equal(money.MustParse("0.45")) || !quiet.Low.Equal(money.MustParse("0.45")) || !quiet.Change.IsZero() {
+		t.Errorf("quiet day high %s low %s change %s, want the last price and no change", quiet.High, quiet.Low, quiet.Change)
+	}
+}
+
+// feeSchedule charges 0.1% to makers and 0.2% to takers, nothing to makers and
+// 0.05% to takers between the stablecoins, and 0.1% to takers from 2 of volume
+func feeSchedule() FeeSchedule {
+	return FeeSchedule{Tiers: []FeeTier{
+		{Name: "base", Volume: "0", FeeRates: FeeRates{Maker: "0.1", Taker: "0.2"},
+			Pairs: map[string]FeeRates{"USDT-POS/USDT-ERC": {Maker: "0", Taker: "0.05"}}},
+		{Name: "volume", Volume: "2", FeeRates: FeeRates{Maker: "0", Taker: "0.1"}},
+	}}
+}
+
+// testFees buys half of an ask and checks each side is charged its rate
+// of what it received, the revenue account gets both fees and the fill and
+// orders show them; the buyer's next order is in the volume tier
+func testFees(t *testing.T) {
+	schedule := feeSchedule()
+	tier, maker0, taker0, err := schedule.Rates("USDT-POS/USDT-ERC", money.Zero())
+	if !(err == nil && tier == "base" && maker0.IsZero() && taker0.Equal(money.MustParse("0.05"))) {
+		t.Errorf("stablecoin rates %s %s/%s: %v", tier, maker0, taker0, err)
+	}
+
+	m := newMarket(t)
+	m.store.schedule = schedule
+	pair := tbytPOS()
+	askID, _, _ := m.order(maker, pair, SideSell, "0.5", money.MustParse("10"), GoodTillCancelled)
+	makerQuote := m.store.balance(maker, pair.Quote.Field)
+	takerBase := m.store.balance(taker, pair.Base.Field)
+	bidID, status, fills := m.order(taker, pair, SideBuy, "0.5", money.MustParse("2.5"), GoodTillCancelled)
+	if !(status == StatusDone && len(fills) == 1) {
+		t.Errorf("taker %s with %d fills", status, len(fills))
+	}
+	m.checkFills(t, "fees", fills)
+
+	got := m.store.balance(taker, pair.Base.Field).Sub(takerBase)
+	if !got.Equal(money.MustParse("4.99")) {
+		t.Errorf("taker got %s TBYT, want 5 less 0.2%%", got)
+	}
+	got = m.store.balance(maker, pair.Quote.Field).Sub(makerQuote)
+	if !got.Equal(money.MustParse("2.4975")) {
+		t.Errorf("maker got %s USDT, want 2.5 less 0.1%%", got)
+	}
+	baseRevenue, quoteRevenue := m.store.balance(ledger.Revenue, pair.Base.Field), m.store.balance(ledger.Revenue, pair.Quote.Field)
+	if !(baseRevenue.Equal(money.MustParse("0.01")) && quoteRevenue.Equal(money.MustParse("0.0025"))) {
+		t.Errorf("revenue got %s TBYT and %s USDT", baseRevenue, quoteRevenue)
+	}
+
+	record := m.store.fills[len(m.store.fills)-1]
+	if !(record.MFEE == "0.0025" && record.TFEE == "0.01") {
+		t.Errorf("fill fees %s and %s", record.MFEE, record.TFEE)
+	}
+	ask, bid := m.store.orders[askID], m.store.orders[bidID]
+	if !(ask.TIER == "base" && ask.MFR == "0.1" && ask.FEE == "0.0025" && ask.RCV == "2.5") {
+		t.Errorf("ask tier %s rate %s fee %s received %s", ask.TIER, ask.MFR, ask.FEE, ask.RCV)
+	}
+	if !(bid.TFR == "0.2" && bid.FEE == "0.01" && bid.RCV == "5") {
+		t.Errorf("bid rate %s fee %s received %s", bid.TFR, bid.FEE, bid.RCV)
+	}
+	m.checkEscrow(t, "fees")
+
+	if len(m.store.transfers) != 2 {
+		t.Fatalf("%d transfer history entries, want one per side", len(m.store.transfers))
+	}
+	for _, entry := range m.store.transfers {
+		want := transfer.Order{SADD: taker, CADD: bidID.Hex(), AMT: "5.000000", CTP: pair.Base.Field, FEE: "0.010000"}
+		if entry.SADD == maker {
+			want = transfer.Order{SADD: maker, CADD: askID.Hex(), AMT: "2.500000", CTP: pair.Quote.Field, FEE: "0.002500"}
+		}
+		if entry.CADD != want.CADD || entry.AMT != want.AMT || entry.CTP != want.CTP || entry.FEE != want.FEE || entry.TYP != SwapOrderType || entry.REF != record.FID.Hex() {
+			t.Errorf("transfer history %+v, want %+v", entry, want)
+		}
+	}
+
+	nextID, _, _ := m.order(taker, pair, SideBuy, "0.4", money.MustParse("1"), GoodTillCancelled)
+	next := m.store.orders[nextID]
+	if !(next.TIER == "volume" && next.TFR == "0.1") {
+		t.Errorf("order after 2.5 of volume in tier %s at %s", next.TIER, next.TFR)
+	}
+
+	m.store.schedule = FeeSchedule{Tiers: []FeeTier{{Name: "bad", Volume: "0", FeeRates: FeeRates{Maker: "100"}}}}
+	isPlaced, message, _ := m.engine.Place(ExOrder{ID: taker, FROM: pair.Quote.Code, TO: pair.Base.Code, PAIR: pair.Symbol(), SIDE: SideBuy, PRC: "0.4"}, money.MustParse("1"))
+	if isPlaced {
+		t.Errorf("placed with a 100%% fee: %s", message)
+	}
+}
@@ -3,12 +3,11 @@ package exchange
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
-	"strconv"
 	"strings"
 	"tbapi/modals"
+	"tbapi/money"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -16,17 +15,93 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// OrdersCollection holds the exchange orders
+const OrdersCollection = "exchangeOrders"
+
+// order statuses, a cancelled order was closed before anything filled
+const (
+	StatusPending   = "pending"
+	StatusPartial   = "partial"
+	StatusDone      = "done"
+	StatusCancelled = "cancelled"
+	StatusExpired   = "expired"
+)
+
+// order types
+const (
+	TypeLimit  = "limit"
+	TypeMarket = "market"
+)
+
+// times in force
+const (
+	GoodTillCancelled = "GTC" // rests in the book until filled or cancelled
+	ImmediateOrCancel = "IOC" // fills what it can at once, the rest is refunded
+	FillOrKill        = "FOK" // fills completely at once or not at all
+	GoodTillTime      = "GTT" // rests until filled, cancelled or its expiry
+	Day               = "DAY" // rests until filled, cancelled or the end of the UTC day
+)
+
+// OrderSpec is an order as the client asks for it. Price is the limit of a
+// limit order; a market order gives MaxSlippage instead, in percent away from
+// the best price in the book, and is IOC or FOK (the default). ExpiresAt is the
+// unix time a GTT order expires at.
+type OrderSpec struct {
+	From        string
+	To          string
+	Amount      string
+	Price       string
+	Type        string
+	TimeInForce string
+	MaxSlippage string
+	ExpiresAt   int64
+}
+
+// ExOrder is a limit order swapping AMT of FROM into TO. AMT, SAMT and RCV are
+// decimals: the locked amount, the part of it exchanged and what was received
+// for it, before the fees in FEE. PRC is the limit in quote per base of PAIR,
+// for a market order the one its slippage allowed; orders stored before it
+// existed trade at 1 and are GTC limit orders. GTT and DAY orders end expired
+// once EXP passes.
 type ExOrder struct {
 	EID  primitive.ObjectID `bson:"_id,omitempty"`
 	ID   string             `bson:"ID"`
 	FROM string             `bson:"FROM"`
 	TO   string             `bson:"TO"`
+	PAIR string             `bson:"PAIR,omitempty"`
+	SIDE string             `bson:"SIDE,omitempty"`
+	PRC  string             `bson:"PRC,omitempty"`
+	TYP  string             `bson:"TYP,omitempty"`
+	TIF  string             `bson:"TIF,omitempty"`
 	AMT  string             `bson:"AMT"`
 	SAMT string             `bson:"SAMT"`
+	RCV  string             `bson:"RCV,omitempty"`
 	TMP  string             `bson:"TMP"`
+	LFT  int64              `bson:"LFT,omitempty"`  // last fill
+	EXP  int64              `bson:"EXP,omitempty"`  // expiry of GTT and DAY orders
+	TIER string             `bson:"TIER,omitempty"` // fee tier when placed
+	MFR  string             `bson:"MFR,omitempty"`  // maker fee rate, percent
+	TFR  string             `bson:"TFR,omitempty"`  // taker fee rate, percent
+	FEE  string             `bson:"FEE,omitempty"`  // fees paid so far, of TO
 	STAT string             `bson:"STAT"`
 }
 
+// EnsureIndexes creates the indexes the books and the order lists rely on
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(OrdersCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "PAIR", Value: 1}, {Key: "STAT", Value: 1}}},
+		{Keys: bson.D{{Key: "ID", Value: 1}, {Key: "TMP", Value: -1}}},
+		{Keys: bson.D{{Key: "STAT", Value: 1}, {Key: "EXP", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	if err := ensureFillIndexes(ctx, db); err != nil {
+		return err
+	}
+	return ensureCandleIndexes(ctx, db)
+}
+
 func PlaceExchangeOrder(r *http.Request) (string, string, string, primitive.ObjectID, string, string, string, string) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -48,159 +123,139 @@ func PlaceExchangeOrder(r *http.Request) (string, string, string, primitive.Obje
 		return "false", "Request Malformed No Data", "", primitive.NilObjectID, "", "", "", ""
 	}
 	walletsDetailList := strings.Split(walletsDetailString, ",")
-	if len(walletsDetailList) != 5 {
+	if len(walletsDetailList) != 5 && len(walletsDetailList) != 6 {
 		return "false", "Request Malformed", "", primitive.NilObjectID, "", "", "", ""
 	}
 
-	db, err := modals.ConnectDB()
-
-	if err != nil {
-		return "false", "API Database Error", "", primitive.NilObjectID, "", "", "", ""
-	}
-	// Database collections
-	accounts := db.Collection("tb_accounts")
-
 	address := walletsDetailList[0]
 	walletKey := walletsDetailList[1]
 	fromCurrency := walletsDetailList[2]
 	fromAmount := walletsDetailList[3]
 	toCurrency := walletsDetailList[4]
-	validKey := modals.CheckKey(walletKey, address)
+	// app builds from before the order book send no price and swap at 1
+	price := "1"
+	if len(walletsDetailList) == 6 {
+		price = walletsDetailList[5]
+	}
+	validKey := modals.CheckAuth(walletKey, address)
 	if !validKey {
 		return "false", "Trying to bypass", "", primitive.NilObjectID, "", "", "", ""
 	}
 
-	accountData, isFound := modals.GetAccountData(address, accounts)
-	if !isFound {
-		return "false", "No Account Found", "", primitive.NilObjectID, "", "", "", ""
-	}
-
-	isCreated, orderStatus, exStatus, EID := createExOrder(accountData, fromCurrency, toCurrency, fromAmount, db)
+	spec := OrderSpec{From: fromCurrency, To: toCurrency, Amount: fromAmount, Price: price}
+	isCreated, orderStatus, exStatus, EID := PlaceOrder(address, spec)
 
 	return isCreated, orderStatus, exStatus, EID, fromAmount, fromCurrency, toCurrency, address
 }
 
-func createExOrder(accountData modals.User, fromCurrency string, toCurrency string, fromAmount string, db *mongo.Database) (string, string, string, primitive.ObjectID) {
-	tbtBalance, err := strconv.ParseFloat(accountData.TBT, 64)
+// PlaceOrder locks the amount of spec.From and opens a pending swap to spec.To
+// on the pair the two currencies trade on
+func PlaceOrder(address string, spec OrderSpec) (string, string, string, primitive.ObjectID) {
+	db, err := modals.ConnectDB()
+
 	if err != nil {
-		return "false", "Can't convert Recipient Balance to Integer ", "", primitive.NilObjectID
+		return "false", "API Database Error", "", primitive.NilObjectID
 	}
+	// Database collections
+	accounts := db.Collection("tb_accounts")
 
-	posBalance, err := strconv.ParseFloat(accountData.POS, 64)
-	if err != nil {
-		return "false", "Can't convert Recipient Balance to Integer ", "", primitive.NilObjectID
+	accountData, isFound := modals.GetAccountData(address, accounts)
+	if !isFound {
+		return "false", "No Account Found", "", primitive.NilObjectID
 	}
 
-	ercBalance, err := strconv.ParseFloat(accountData.ERC, 64)
-	if err != nil {
-		return "false", "Can't convert Recipient Balance to Integer ", "", primitive.NilObjectID
+	engine, isReady := mongoEngine()
+	if !isReady {
+		return "false", "API Database Error", "", primitive.NilObjectID
 	}
+	return createExOrder(accountData, spec, engine)
+}
 
-	fromAmountFloat, err := strconv.ParseFloat(fromAmount, 64)
+func createExOrder(accountData modals.User, spec OrderSpec, engine *Engine) (string, string, string, primitive.ObjectID) {
+	pair, side, isPair := PairOf(spec.From, spec.To)
+	if !isPair {
+		return "false", "Invalid Asset Conversion ", "", primitive.NilObjectID
+	}
+	amount, err := money.ParsePositive(pair.pays(side), spec.Amount)
 	if err != nil {
-		return "false", "Can't convert Recipient Balance to Integer ", "", primitive.NilObjectID
+		return "false", err.Error(), "", primitive.NilObjectID
+	}
+	order := ExOrder{
+		ID:   accountData.ID,
+		FROM: pair.pays(side).Code,
+		TO:   pair.gets(side).Code,
+		PAIR: pair.Symbol(),
+		SIDE: side,
+		TYP:  spec.Type,
+		TIF:  spec.TimeInForce,
 	}
 
-	fromAmountFloat = RoundToNDecimals(fromAmountFloat, 4)
-	isOrderCreated := false
-	errorMessage := ""
-	EID := primitive.NilObjectID
-	if fromCurrency == "USDT-ERC" {
-		if ercBalance < fromAmountFloat {
-			return "false", "Insufficient USDT-ERC Amount ", "", primitive.NilObjectID
+	var limit money.Amount
+	switch order.TYP {
+	case "", TypeLimit:
+		order.TYP = TypeLimit
+		if order.TIF == "" {
+			order.TIF = GoodTillCancelled
 		}
-		isOrderCreated, errorMessage, EID = makeOrder(accountData, fromAmount, fromCurrency, toCurrency, db, tbtBalance, fromAmountFloat, posBalance, ercBalance)
-	} else if fromCurrency == "USDT-POS" {
-		if posBalance < fromAmountFloat {
-			return "false", "Insufficient USDT-POS Amount ", "", primitive.NilObjectID
+		limit, err = money.ParsePositive(pair.Quote, spec.Price)
+		if err != nil {
+			return "false", "Invalid Price: " + err.Error(), "", primitive.NilObjectID
 		}
-		isOrderCreated, errorMessage, EID = makeOrder(accountData, fromAmount, fromCurrency, toCurrency, db, tbtBalance, fromAmountFloat, posBalance, ercBalance)
-	} else if fromCurrency == "TBYT" {
-		if tbtBalance < fromAmountFloat {
-			return "false", "Insufficient TBYT Amount ", "", primitive.NilObjectID
+	case TypeMarket:
+		if order.TIF == "" {
+			order.TIF = FillOrKill
+		}
+		if order.TIF != ImmediateOrCancel && order.TIF != FillOrKill {
+			return "false", "Market orders are IOC or FOK", "", primitive.NilObjectID
+		}
+		slippage, err := money.ParseDecimal(spec.MaxSlippage)
+		if err != nil || slippage.Cmp(money.MustParse("100")) >= 0 {
+			return "false", "Invalid Slippage, give a percentage below 100", "", primitive.NilObjectID
+		}
+		isPriced, message, best := engine.bestPrice(pair, side)
+		if !isPriced {
+			return "false", message, "", primitive.NilObjectID
+		}
+		limit = marketLimit(pair, side, best, slippage)
+	default:
+		return "false", "Invalid Order Type", "", primitive.NilObjectID
+	}
+	now := time.Now().UTC()
+	switch order.TIF {
+	case GoodTillCancelled, ImmediateOrCancel, FillOrKill:
+	case GoodTillTime:
+		if spec.ExpiresAt <= now.Unix() {
+			return "false", "GTT orders need an expiry in the future", "", primitive.NilObjectID
+		}
+		order.EXP = spec.ExpiresAt
+	case Day:
+		order.EXP = dayEnd(now)
+	default:
+		return "false", "Invalid Time In Force", "", primitive.NilObjectID
+	}
+	if spec.ExpiresAt != 0 && order.TIF != GoodTillTime {
+		return "false", "Only GTT orders take an expiry", "", primitive.NilObjectID
+	}
+	order.PRC = limit.String()
+	if !newBook(pair).fillable(side, limit, amount) {
+		return "false", "Amount too small for this price", "", primitive.NilObjectID
+	}
+	// refuse a fill or kill the book can't fill before locking anything,
+	// matching checks again
+	if order.TIF == FillOrKill {
+		isQuoted, message, quote := engine.quote(pair, side, amount, limit, false)
+		if !isQuoted {
+			return "false", message, "", primitive.NilObjectID
+		}
+		if !quote.Complete {
+			return "false", "Not enough orders to fill completely at this price", "", primitive.NilObjectID
 		}
-		isOrderCreated, errorMessage, EID = makeOrder(accountData, fromAmount, fromCurrency, toCurrency, db, tbtBalance, fromAmountFloat, posBalance, ercBalance)
-	} else {
-		return "false", "Invalid Asset Conversion ", "", primitive.NilObjectID
 	}
 
+	isOrderCreated, errorMessage, EID := engine.Place(order, amount)
 	if !isOrderCreated {
 		return "false", errorMessage, "", primitive.NilObjectID
 	}
 	return "true", "Placed Successfully", "pending", EID
 
 }
-
-func makeOrder(
-	accountData modals.User,
-	fromAmount string,
-	fromCurrency string,
-	toCurrency string,
-	db *mongo.Database,
-	tbtBalance float64,
-	fromAmountFloat float64,
-	posBalance float64,
-	ercBalance float64,
-) (bool, string, primitive.ObjectID) {
-	// Database collections
-	exOrders := db.Collection("exchangeOrders")
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	// Calculating deductions
-	posNewBalance := posBalance
-	ercNewBalance := ercBalance
-	tbtNewBalance := tbtBalance
-	if fromCurrency == "USDT-POS" {
-		posNewBalance = posBalance - fromAmountFloat
-	} else if fromCurrency == "USDT-ERC" {
-		ercNewBalance = ercBalance - fromAmountFloat
-	} else if fromCurrency == "TBYT" {
-		tbtNewBalance = tbtBalance - fromAmountFloat
-	} else {
-		return false, "Can't Update Order List", primitive.NilObjectID
-	}
-
-	utcNow := time.Now().UTC()
-	unixTimestamp := utcNow.Unix()
-	timeString := fmt.Sprintf("%d", unixTimestamp)
-	orderData := bson.M{
-		"ID":   accountData.ID,
-		"FROM": fromCurrency,
-		"TO":   toCurrency,
-		"SAMT": "0.00",
-		"AMT":  fromAmount,
-		"TMP":  timeString,
-		"STAT": "pending",
-	}
-
-	result, err := exOrders.InsertOne(ctx, orderData)
-	if err != nil {
-		return false, "Can't Update Order List", primitive.NilObjectID
-	}
-
-	accountsColl := db.Collection("tb_accounts")
-
-	// Deduct Balance from account
-
-	tbtNewBalString := fmt.Sprintf("%f", tbtNewBalance)
-	posNewBalString := fmt.Sprintf("%f", posNewBalance)
-	ercNewBalString := fmt.Sprintf("%f", ercNewBalance)
-
-	update2 := bson.M{"$set": bson.M{
-		"TBT": tbtNewBalString,
-		"POS": posNewBalString,
-		"ERC": ercNewBalString,
-	}}
-	filter2 := bson.M{"ID": accountData.ID}
-	_, err = accountsColl.UpdateOne(ctx, filter2, update2)
-	if err != nil {
-		// revert if not added
-		_, err := exOrders.DeleteOne(context.TODO(), bson.M{"_id": result.InsertedID.(primitive.ObjectID)})
-		if err != nil {
-			return false, "Can not delete redundent order", primitive.NilObjectID
-		}
-	}
-
-	return true, "Exchange Order Created", result.InsertedID.(primitive.ObjectID)
-}
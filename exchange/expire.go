@@ -0,0 +1,62 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// orders expired per pass, the rest waits for the next one
+const expireBatch = 100
+
+// dayEnd is the expiry of a DAY order placed at now, midnight UTC after it
+func dayEnd(now time.Time) int64 {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC).Unix()
+}
+
+// Expire closes the open orders whose expiry is at or before now through the
+// same path as a cancellation, ending them expired, and returns how many it
+// closed. An order filled or cancelled in the meantime is skipped.
+func (e *Engine) Expire(ctx context.Context, now int64) (int, error) {
+	orders, err := e.store.ExpiredOrders(ctx, now, expireBatch)
+	if err != nil {
+		return 0, err
+	}
+	expired := 0
+	for _, order := range orders {
+		_, err := e.Cancel(order.ID, order.EID, StatusExpired, ReasonExpired)
+		switch {
+		case err == nil:
+			expired++
+		case errors.Is(err, ErrOrderDone), errors.Is(err, ErrOrderCancelled), errors.Is(err, ErrOrderExpired):
+		default:
+			return expired, err
+		}
+	}
+	return expired, nil
+}
+
+// RunExpirer expires orders every interval until ctx is done. It belongs in
+// the API process, the one holding the books.
+func RunExpirer(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if engine, isReady := mongoEngine(); isReady {
+			expired, err := engine.Expire(ctx, time.Now().UTC().Unix())
+			if err != nil {
+				log.Printf("expire orders: %v", err)
+			}
+			if expired > 0 {
+				log.Printf("expired %d exchange orders", expired)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
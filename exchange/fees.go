@@ -0,0 +1,136 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"tbapi/modals"
+	"tbapi/money"
+	"time"
+)
+
+// FeeScheduleType marks the fee schedule document of platformInfo
+const FeeScheduleType = "exchangeFees"
+
+// feeWindow is the time the traded volume deciding the tier is summed over
+const feeWindow = 30 * 86400
+
+// FeeRates are percents of what each side of a fill receives
+type FeeRates struct {
+	Maker string `bson:"maker"`
+	Taker string `bson:"taker"`
+}
+
+// FeeTier applies from Volume, the quote amount the account traded in the last
+// 30 days. Pairs overrides the rates of the tier by pair symbol.
+type FeeTier struct {
+	Name     string `bson:"name"`
+	Volume   string `bson:"volume"`
+	FeeRates `bson:",inline"`
+	Pairs    map[string]FeeRates `bson:"pairs,omitempty"`
+}
+
+// FeeSchedule is the exchangeFees document of platformInfo. Without it, or
+// below the volume of every tier, trading is free.
+type FeeSchedule struct {
+	Tiers []FeeTier `bson:"tiers"`
+}
+
+// Rates returns the tier an account that traded volume is in and its maker and
+// taker rates on the pair named by symbol
+func (s FeeSchedule) Rates(symbol string, volume money.Amount) (string, money.Amount, money.Amount, error) {
+	tier, from, isIn := "", money.Zero(), false
+	rates := FeeRates{Maker: "0", Taker: "0"}
+	for _, t := range s.Tiers {
+		threshold, err := money.ParseDecimal(t.Volume)
+		if err != nil {
+			return "", money.Zero(), money.Zero(), fmt.Errorf("tier %s: volume %q", t.Name, t.Volume)
+		}
+		if volume.LessThan(threshold) || (isIn && threshold.LessThan(from)) {
+			continue
+		}
+		tier, from, isIn, rates = t.Name, threshold, true, t.FeeRates
+		if pairRates, found := t.Pairs[symbol]; found {
+			rates = pairRates
+		}
+	}
+	maker, err := feeRate(rates.Maker)
+	if err != nil {
+		return "", money.Zero(), money.Zero(), fmt.Errorf("tier %s: maker rate %q", tier, rates.Maker)
+	}
+	taker, err := feeRate(rates.Taker)
+	if err != nil {
+		return "", money.Zero(), money.Zero(), fmt.Errorf("tier %s: taker rate %q", tier, rates.Taker)
+	}
+	return tier, maker, taker, nil
+}
+
+// feeRate parses a percent from 0 up to 100
+func feeRate(value string) (money.Amount, error) {
+	if value == "" {
+		return money.Zero(), nil
+	}
+	rate, err := money.ParseDecimal(value)
+	if err != nil || rate.Sign() < 0 || !rate.LessThan(money.MustParse("100")) {
+		return money.Zero(), fmt.Errorf("fee rate %q", value)
+	}
+	return rate, nil
+}
+
+// fee is what an order at rate gives up of received, rounded down to asset
+func fee(received money.Amount, rate money.Amount, asset money.Asset) money.Amount {
+	if rate.IsZero() {
+		return money.Zero()
+	}
+	return received.Percent(rate).RoundDown(asset)
+}
+
+// setFees prices the fees of fills from the rates its maker and taker were
+// placed with
+func (b *Book) setFees(taker *bookOrder, fills []Fill) {
+	for i := range fills {
+		maker := b.get(fills[i].Maker)
+		if maker == nil {
+			continue
+		}
+		fills[i].MakerFee = fee(fills[i].receivedBy(maker.Side), maker.MakerRate, b.pair.gets(maker.Side))
+		fills[i].TakerFee = fee(fills[i].receivedBy(taker.Side), taker.TakerRate, b.pair.gets(taker.Side))
+	}
+}
+
+// Fees is what the order paid in fees so far, in the asset it receives
+func (order ExOrder) Fees() money.Amount {
+	fees, err := money.ParseDecimal(order.FEE)
+	if err != nil {
+		return money.Zero()
+	}
+	return fees
+}
+
+// Fee is what the maker or taker (role) on side paid in the fill and the asset
+// it paid in, the one it received
+func (r FillRecord) Fee(role string, side string) (string, string) {
+	fee := r.TFEE
+	if role == "maker" {
+		fee = r.MFEE
+	}
+	if fee == "" {
+		fee = "0"
+	}
+	pair, _ := PairBySymbol(r.PAIR)
+	return fee, pair.gets(side).Code
+}
+
+// GetFeeSchedule reads the fee schedule clients are charged by
+func GetFeeSchedule() (bool, string, FeeSchedule) {
+	db, err := modals.ConnectDB()
+	if err != nil {
+		return false, "API Database Error", FeeSchedule{}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	schedule, err := NewMongoStore(db).FeeSchedule(ctx)
+	if err != nil {
+		return false, "Can't read fee schedule", FeeSchedule{}
+	}
+	return true, "", schedule
+}
@@ -0,0 +1,173 @@
+package exchange
+
+import (
+	"context"
+	"strconv"
+	"tbapi/modals"
+	"tbapi/money"
+	"tbapi/transfer"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FillsCollection holds one document per fill, written with its ledger posting
+const FillsCollection = "exchangeFills"
+
+// SwapOrderType marks the fills of an account in transferOrders
+const SwapOrderType = "SWP"
+
+// FillRecord is a stored fill, its id is the ref of the fill posting
+type FillRecord struct {
+	FID  primitive.ObjectID `bson:"_id"`
+	PAIR string             `bson:"PAIR"`
+	MKR  primitive.ObjectID `bson:"MKR"`  // maker order
+	TKR  primitive.ObjectID `bson:"TKR"`  // taker order
+	MACC string             `bson:"MACC"` // maker account
+	TACC string             `bson:"TACC"` // taker account
+	SIDE string             `bson:"SIDE"` // of the taker
+	PRC  string             `bson:"PRC"`
+	AMT  string             `bson:"AMT"`            // base
+	QAMT string             `bson:"QAMT"`           // quote
+	MFEE string             `bson:"MFEE,omitempty"` // maker fee, of what it received
+	TFEE string             `bson:"TFEE,omitempty"` // taker fee, of what it received
+	TMP  int64              `bson:"TMP"`
+}
+
+func (f Fill) record() FillRecord {
+	return FillRecord{
+		FID:  f.ID,
+		PAIR: f.Pair,
+		MKR:  f.Maker,
+		TKR:  f.Taker,
+		MACC: f.MakerAccount,
+		TACC: f.TakerAccount,
+		SIDE: f.TakerSide,
+		PRC:  f.Price.String(),
+		AMT:  f.Base.String(),
+		QAMT: f.Quote.String(),
+		MFEE: f.MakerFee.String(),
+		TFEE: f.TakerFee.String(),
+		TMP:  f.TMP,
+	}
+}
+
+// transfers are the transferOrders entries of the buyer and the seller of the
+// fill: what each received before fees, the fee and the order it filled
+func (f Fill) transfers(pair Pair) []transfer.Order {
+	var orders []transfer.Order
+	for _, side := range []string{SideBuy, SideSell} {
+		account, order := f.MakerAccount, f.Maker
+		if side == f.TakerSide {
+			account, order = f.TakerAccount, f.Taker
+		}
+		asset := pair.gets(side)
+		orders = append(orders, transfer.Order{
+			SADD: account,
+			CADD: order.Hex(),
+			RADD: "EXCHANGE",
+			AMT:  f.receivedBy(side).Format(asset),
+			CTP:  asset.Field,
+			TYP:  SwapOrderType,
+			TMP:  strconv.FormatInt(f.TMP, 10),
+			STAT: StatusDone,
+			FEE:  f.feeOf(side).Format(asset),
+			REF:  f.ID.Hex(),
+		})
+	}
+	return orders
+}
+
+// Role tells whether the order orderID, or account when orderID is empty, was
+// the maker or the taker of the fill, with its own order, the side it traded on
+// and the order on the other side
+func (r FillRecord) Role(orderID string, account string) (string, primitive.ObjectID, string, primitive.ObjectID) {
+	if r.TKR.Hex() == orderID || (orderID == "" && r.TACC == account) {
+		return "taker", r.TKR, r.SIDE, r.MKR
+	}
+	makerSide := SideBuy
+	if r.SIDE == SideBuy {
+		makerSide = SideSell
+	}
+	return "maker", r.MKR, makerSide, r.TKR
+}
+
+func ensureFillIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(FillsCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "MKR", Value: 1}}},
+		{Keys: bson.D{{Key: "TKR", Value: 1}}},
+		{Keys: bson.D{{Key: "MACC", Value: 1}, {Key: "TMP", Value: -1}}},
+		{Keys: bson.D{{Key: "TACC", Value: 1}, {Key: "TMP", Value: -1}}},
+		{Keys: bson.D{{Key: "PAIR", Value: 1}, {Key: "TMP", Value: 1}}},
+	})
+	return err
+}
+
+// ListFills returns one page (20 per page, newest first) of the fills of an
+// order of address, or of every order of address when orderID is empty
+func ListFills(address string, orderID string, page int) (bool, string, []FillRecord) {
+	if page <= 0 {
+		return false, "Invalid page number", nil
+	}
+	db, err := modals.ConnectDB()
+	if err != nil {
+		return false, "API Database Error", nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"$or": []bson.M{{"MACC": address}, {"TACC": address}}}
+	if orderID != "" {
+		EID, err := primitive.ObjectIDFromHex(orderID)
+		if err != nil {
+			return false, "Invalid Order ID", nil
+		}
+		order, isFound := getExOrderData(EID, db.Collection(OrdersCollection))
+		if !isFound || order.ID != address {
+			return false, "No Order Found", nil
+		}
+		filter = bson.M{"$or": []bson.M{{"MKR": EID}, {"TKR": EID}}}
+	}
+	pageSize := int64(20)
+	opts := options.Find().
+		SetSort(bson.D{{Key: "TMP", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(page-1) * pageSize).
+		SetLimit(pageSize)
+	cursor, err := db.Collection(FillsCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return false, "API Database Error", nil
+	}
+	var fills []FillRecord
+	if err := cursor.All(ctx, &fills); err != nil {
+		return false, "API Database Error", nil
+	}
+	return true, "", fills
+}
+
+// AveragePrice is the quote per base the order traded at so far, zero before
+// its first fill. Orders from before prices traded at 1.
+func (order ExOrder) AveragePrice() money.Amount {
+	settled, err := money.ParseDecimal(order.SAMT)
+	if err != nil || settled.Sign() <= 0 {
+		return money.Zero()
+	}
+	received, err := order.received()
+	if err != nil || received.Sign() <= 0 {
+		return money.MustParse("1")
+	}
+	if _, side, _ := PairOf(order.FROM, order.TO); side == SideSell {
+		return received.Div(settled)
+	}
+	return settled.Div(received)
+}
+
+// LastFill is the unix time of the last fill of the order, empty before any
+func (order ExOrder) LastFill() string {
+	if order.LFT == 0 {
+		return ""
+	}
+	return strconv.FormatInt(order.LFT, 10)
+}
@@ -18,14 +18,6 @@ import (
 )
 
 func GetExOrderData(r *http.Request) (string, string) {
-	db, err := modals.ConnectDB()
-
-	if err != nil {
-		return "false", "API Database Error"
-	}
-	// Database collections
-	orderCollection := db.Collection("exchangeOrders")
-
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		return "false", "API Database Error"
@@ -52,14 +44,14 @@ func GetExOrderData(r *http.Request) (string, string) {
 	address := walletsDetailList[0]
 	walletKey := walletsDetailList[1]
 	orderNeeded := walletsDetailList[2]
-	validKey := modals.CheckKey(walletKey, address)
+	validKey := modals.CheckAuth(walletKey, address)
 	if !validKey {
 		return "false", "Trying to bypass"
 	}
 
-	orderData, isFound := getExLastTenOrders(address, orderCollection, orderNeeded)
+	isFound, message, orderData := ListExOrders(address, orderNeeded)
 	if !isFound {
-		return "false", "No Account Found"
+		return "false", message
 	}
 
 	csvData := exOrderToCSV(orderData)
@@ -67,6 +59,23 @@ func GetExOrderData(r *http.Request) (string, string) {
 
 }
 
+// ListExOrders returns one page (10 per page, newest first) of the account swaps
+func ListExOrders(address string, orderNeeded string) (bool, string, []ExOrder) {
+	db, err := modals.ConnectDB()
+
+	if err != nil {
+		return false, "API Database Error", nil
+	}
+	// Database collections
+	orderCollection := db.Collection("exchangeOrders")
+
+	orders, isFound := getExLastTenOrders(address, orderCollection, orderNeeded)
+	if !isFound {
+		return false, "No Account Found", nil
+	}
+	return true, "", orders
+}
+
 func getExLastTenOrders(walletAddress string, orderCollection *mongo.Collection, orderNeeded string) ([]ExOrder, bool) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -105,11 +114,16 @@ func getExLastTenOrders(walletAddress string, orderCollection *mongo.Collection,
 
 	return orders, true
 }
+
+// exOrderToCSV writes id,from,to,amount,settled,amount,timestamp,status and
+// then received,average price,last fill time,fees, which are newer
 func exOrderToCSV(orders []ExOrder) string {
 	var builder strings.Builder
 	for i, order := range orders {
-		builder.WriteString(fmt.Sprintf("%s,%s,%s,%s,%s,%s,%s,%s",
-			order.EID.Hex(), order.FROM, order.TO, order.AMT, order.SAMT, order.AMT, order.TMP, order.STAT))
+		received, _ := order.received()
+		builder.WriteString(fmt.Sprintf("%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s",
+			order.EID.Hex(), order.FROM, order.TO, order.AMT, order.SAMT, order.AMT, order.TMP, order.STAT,
+			received, order.AveragePrice(), order.LastFill(), order.Fees()))
 
 		if i < len(orders)-1 {
 			builder.WriteString("#") // Separate orders with slash, but not after the last one
@@ -1,29 +1,26 @@
 package exchange
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"strconv"
 	"strings"
 	"tbapi/modals"
-	"time"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
+	"tbapi/money"
 )
 
-func GetSwapAmounts(r *http.Request) (string, string) {
-	db, err := modals.ConnectDB()
-
-	if err != nil {
-		return "false", "API Database Error"
-	}
-	// Database collections
-	exchangeCollection := db.Collection("exchangeOrders")
+// SwapTotals holds the amount still waiting to be exchanged in each direction
+type SwapTotals struct {
+	TBYTtoPOS money.Amount
+	TBYTtoERC money.Amount
+	POStoERC  money.Amount
+	POStoTBYT money.Amount
+	ERCtoPOS  money.Amount
+	ERCtoTBYT money.Amount
+}
 
+func GetSwapAmounts(r *http.Request) (string, string) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		return "false", "API Database Error"
@@ -49,70 +46,68 @@ func GetSwapAmounts(r *http.Request) (string, string) {
 	}
 	address := walletsDetailList[0]
 	walletKey := walletsDetailList[1]
-	validKey := modals.CheckKey(walletKey, address)
+	validKey := modals.CheckAuth(walletKey, address)
 	if !validKey {
 		return "false", "Invalid Account Key"
 	}
-	orderAmountData := getExchangeAmount(exchangeCollection)
-	if orderAmountData == "nil" {
-		return "true", "0.00,0.00,0.00,0.00,0.00,0.00"
+	isGot, message, totals := GetSwapTotals()
+	if !isGot {
+		return "false", message
 	}
-	// csvData := exOrderToCSV(accountData)
-	return "true", orderAmountData
+	return "true", fmt.Sprintf("%s,%s,%s,%s,%s,%s",
+		totals.TBYTtoPOS.Fixed(2), totals.TBYTtoERC.Fixed(2), totals.POStoERC.Fixed(2), totals.POStoTBYT.Fixed(2), totals.ERCtoPOS.Fixed(2), totals.ERCtoTBYT.Fixed(2))
 }
 
-func getExchangeAmount(exchangeOrders *mongo.Collection) string {
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer cancel()
-
-	filter := bson.M{
-		"STAT": bson.M{
-			"$in": []string{"pending", "partial"},
-		},
-	}
-	cursor, err := exchangeOrders.Find(ctx, filter)
-	if err != nil {
-		return "nil"
-	}
-	var orders []ExOrder
-	err = cursor.All(ctx, &orders)
-	if err != nil {
-		return "nil"
-	}
+// GetSwapTotals sums what the open orders still have locked in each direction
+// of the default pairs, for app builds that show totals instead of depth
+func GetSwapTotals() (bool, string, SwapTotals) {
+	var totals SwapTotals
+	isRead, message := eachBook("", func(book *Book) {
+		sells, buys := book.open(SideSell), book.open(SideBuy)
+		switch book.pair.Symbol() {
+		case "TBYT/USDT-POS":
+			totals.TBYTtoPOS, totals.POStoTBYT = sells, buys
+		case "TBYT/USDT-ERC":
+			totals.TBYTtoERC, totals.ERCtoTBYT = sells, buys
+		case "USDT-POS/USDT-ERC":
+			totals.POStoERC, totals.ERCtoPOS = sells, buys
+		}
+	})
+	return isRead, message, totals
+}
 
-	TBYTtoPOS := 0.00
-	POStoTBYT := 0.00
-	ERCtoPOS := 0.00
-	POStoERC := 0.00
-	ERCtoTBYT := 0.00
-	TBYTtoERC := 0.00
+// GetDepth returns the book of the pair named by symbol, or of every pair when
+// symbol is empty, with at most levels price levels per side (all when 0)
+func GetDepth(symbol string, levels int) (bool, string, []Depth) {
+	var depths []Depth
+	isRead, message := eachBook(symbol, func(book *Book) {
+		depths = append(depths, book.depth(levels))
+	})
+	return isRead, message, depths
+}
 
-	for i := range orders {
-		orderAMT, err := strconv.ParseFloat(orders[i].AMT, 64)
-		if err != nil {
-			break
+// eachBook calls fn with the locked book of the pair named by symbol, or of
+// every pair when symbol is empty
+func eachBook(symbol string, fn func(book *Book)) (bool, string) {
+	pairs := Pairs()
+	if symbol != "" {
+		pair, found := PairBySymbol(symbol)
+		if !found {
+			return false, "Unknown Pair"
 		}
-		orderSAMT, err := strconv.ParseFloat(orders[i].SAMT, 64)
-		if err != nil {
-			break
-		}
-		pendingAMT := orderAMT - orderSAMT
-
-		if orders[i].FROM == "TBYT" && orders[i].TO == "USDT-POS" {
-			TBYTtoPOS = TBYTtoPOS + pendingAMT
-		} else if orders[i].FROM == "USDT-POS" && orders[i].TO == "TBYT" {
-			POStoTBYT = POStoTBYT + pendingAMT
-		} else if orders[i].FROM == "TBYT" && orders[i].TO == "USDT-ERC" {
-			TBYTtoERC = TBYTtoERC + pendingAMT
-		} else if orders[i].FROM == "USDT-ERC" && orders[i].TO == "TBYT" {
-			ERCtoTBYT = ERCtoTBYT + pendingAMT
-		} else if orders[i].FROM == "USDT-ERC" && orders[i].TO == "USDT-POS" {
-			ERCtoPOS = ERCtoPOS + pendingAMT
-		} else if orders[i].FROM == "USDT-POS" && orders[i].TO == "USDT-ERC" {
-			POStoERC = POStoERC + pendingAMT
+		pairs = []Pair{pair}
+	}
+	engine, isReady := mongoEngine()
+	if !isReady {
+		return false, "API Database Error"
+	}
+	for _, pair := range pairs {
+		book, isLoaded := engine.lock(pair)
+		if !isLoaded {
+			return false, "Can't load order book"
 		}
+		fn(book)
+		book.unlock()
 	}
-
-	return fmt.Sprintf("%.2f,%.2f,%.2f,%.2f,%.2f,%.2f",
-		TBYTtoPOS, TBYTtoERC, POStoERC, POStoTBYT, ERCtoPOS, ERCtoTBYT)
+	return true, ""
 }
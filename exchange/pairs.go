@@ -0,0 +1,86 @@
+package exchange
+
+import (
+	"tbapi/chains"
+	"tbapi/money"
+)
+
+// order sides, a buy pays the quote asset for the base asset
+const (
+	SideBuy  = "buy"
+	SideSell = "sell"
+)
+
+// Pair is a market of Base priced in Quote
+type Pair struct {
+	Base  money.Asset
+	Quote money.Asset
+}
+
+// Symbol names the pair, like TBYT/USDT-POS
+func (p Pair) Symbol() string {
+	return p.Base.Code + "/" + p.Quote.Code
+}
+
+// Pairs lists TBYT against the asset of every chain, then the chain assets
+// against each other in registry order (USDT-POS/USDT-ERC by default)
+func Pairs() []Pair {
+	var assets []money.Asset
+	for _, chain := range chains.All() {
+		assets = append(assets, money.ChainAsset(chain))
+	}
+	var pairs []Pair
+	for _, quote := range assets {
+		pairs = append(pairs, Pair{Base: money.TBYT(), Quote: quote})
+	}
+	for i, base := range assets {
+		for _, quote := range assets[i+1:] {
+			pairs = append(pairs, Pair{Base: base, Quote: quote})
+		}
+	}
+	return pairs
+}
+
+// PairBySymbol finds a pair by its symbol
+func PairBySymbol(symbol string) (Pair, bool) {
+	for _, pair := range Pairs() {
+		if pair.Symbol() == symbol {
+			return pair, true
+		}
+	}
+	return Pair{}, false
+}
+
+// PairOf finds the pair a swap from one currency to another trades on and the
+// side it takes. Any code money.Lookup knows is accepted.
+func PairOf(fromCurrency string, toCurrency string) (Pair, string, bool) {
+	from, isFrom := money.Lookup(fromCurrency)
+	to, isTo := money.Lookup(toCurrency)
+	if !isFrom || !isTo {
+		return Pair{}, "", false
+	}
+	for _, pair := range Pairs() {
+		switch {
+		case pair.Base.Code == from.Code && pair.Quote.Code == to.Code:
+			return pair, SideSell, true
+		case pair.Quote.Code == from.Code && pair.Base.Code == to.Code:
+			return pair, SideBuy, true
+		}
+	}
+	return Pair{}, "", false
+}
+
+// pays is the asset an order of side locks, gets the one it receives
+func (p Pair) pays(side string) money.Asset {
+	if side == SideBuy {
+		return p.Quote
+	}
+	return p.Base
+}
+
+func (p Pair) gets(side string) money.Asset {
+	if side == SideBuy {
+		return p.Base
+	}
+	return p.Quote
+}
@@ -0,0 +1,106 @@
+package exchange
+
+import (
+	"tbapi/money"
+)
+
+// Quote is what an order would get from the book as it stands
+type Quote struct {
+	Pair     Pair
+	Side     string
+	Amount   money.Amount // offered, in the asset the order pays
+	Paid     money.Amount // part of Amount that would be exchanged
+	Received money.Amount
+	Best     money.Amount // price of the first fill
+	Worst    money.Amount // price of the last fill
+	Average  money.Amount // quote per base over every fill
+	Complete bool         // nothing that could still trade would be left
+}
+
+// quote walks the book for an order of side offering amount up to limit, or
+// at any price when isMarket
+func (b *Book) quote(side string, amount money.Amount, limit money.Amount, isMarket bool) Quote {
+	fills := b.match(bookOrder{Side: side, Price: limit, Open: amount, Market: isMarket})
+	q := Quote{Pair: b.pair, Side: side, Amount: amount}
+	base, quote := money.Zero(), money.Zero()
+	for _, fill := range fills {
+		q.Paid = q.Paid.Add(fill.paidBy(side))
+		q.Received = q.Received.Add(fill.receivedBy(side))
+		base, quote = base.Add(fill.Base), quote.Add(fill.Quote)
+	}
+	if len(fills) == 0 {
+		return q
+	}
+	q.Best, q.Worst = fills[0].Price, fills[len(fills)-1].Price
+	q.Average = quote.Div(base)
+	if isMarket {
+		limit = q.Worst
+	}
+	q.Complete = b.completes(side, limit, amount, fills)
+	return q
+}
+
+// completes reports whether fills leave an order of side offering amount at
+// limit with nothing it could still trade
+func (b *Book) completes(side string, limit money.Amount, amount money.Amount, fills []Fill) bool {
+	left := amount
+	for _, fill := range fills {
+		left = left.Sub(fill.paidBy(side))
+	}
+	return !b.fillable(side, limit, left)
+}
+
+func (e *Engine) quote(pair Pair, side string, amount money.Amount, limit money.Amount, isMarket bool) (bool, string, Quote) {
+	book, isLoaded := e.lock(pair)
+	if !isLoaded {
+		return false, "Can't load order book", Quote{}
+	}
+	defer book.unlock()
+	return true, "", book.quote(side, amount, limit, isMarket)
+}
+
+// bestPrice is the price an order of side would trade at first
+func (e *Engine) bestPrice(pair Pair, side string) (bool, string, money.Amount) {
+	book, isLoaded := e.lock(pair)
+	if !isLoaded {
+		return false, "Can't load order book", money.Zero()
+	}
+	defer book.unlock()
+	makers := book.asks
+	if side == SideSell {
+		makers = book.bids
+	}
+	if len(makers) == 0 {
+		return false, "No orders to trade against", money.Zero()
+	}
+	return true, "", makers[0].Price
+}
+
+// marketLimit is the worst price a market order accepts, slippage percent
+// above the best ask for a buy or below the best bid for a sell, rounded to
+// the quote asset towards the best price
+func marketLimit(pair Pair, side string, best money.Amount, slippage money.Amount) money.Amount {
+	allowed := best.Percent(slippage).RoundDown(pair.Quote)
+	if side == SideBuy {
+		return best.Add(allowed)
+	}
+	return best.Sub(allowed)
+}
+
+// QuoteOrder walks the book for a swap of amount from one currency to another
+// at any price, the client checks average and worst price before ordering
+func QuoteOrder(fromCurrency string, toCurrency string, amount string) (bool, string, Quote) {
+	pair, side, isPair := PairOf(fromCurrency, toCurrency)
+	if !isPair {
+		return false, "Invalid Asset Conversion ", Quote{}
+	}
+	offered, err := money.ParsePositive(pair.pays(side), amount)
+	if err != nil {
+		return false, err.Error(), Quote{}
+	}
+	engine, isReady := mongoEngine()
+	if !isReady {
+		return false, "API Database Error", Quote{}
+	}
+	return engine.quote(pair, side, offered, money.Zero(), true)
+}
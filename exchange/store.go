@@ -0,0 +1,195 @@
+package exchange
+
+import (
+	"context"
+	"tbapi/ledger"
+	"tbapi/modals"
+	"tbapi/money"
+	"tbapi/transfer"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Store keeps the orders and balances the engine works on. Inside Transact
+// every method is called with the context Transact handed out.
+type Store interface {
+	// Transact runs fn so that nothing it wrote stays when it fails
+	Transact(fn func(ctx context.Context) error) error
+	OpenOrders(ctx context.Context, pair Pair) ([]ExOrder, error)
+	// ExpiredOrders reads up to limit open orders expiring at or before now
+	ExpiredOrders(ctx context.Context, now int64, limit int) ([]ExOrder, error)
+	Order(ctx context.Context, EID primitive.ObjectID) (ExOrder, error)
+	InsertOrder(ctx context.Context, order ExOrder) (primitive.ObjectID, error)
+	// SaveProgress stores SAMT, RCV, FEE, LFT and STAT of order
+	SaveProgress(ctx context.Context, order ExOrder) error
+	InsertFill(ctx context.Context, fill FillRecord) error
+	// RecordTransfers adds orders to the transfer history
+	RecordTransfers(ctx context.Context, orders []transfer.Order) error
+	// Candle reads the bar of pair and interval starting at start, false when
+	// there is none yet
+	Candle(ctx context.Context, pair string, interval string, start int64) (Candle, bool, error)
+	// SaveCandle stores candle in place of its bar
+	SaveCandle(ctx context.Context, candle Candle) error
+	RecordCancel(ctx context.Context, record CancelRecord) error
+	FeeSchedule(ctx context.Context) (FeeSchedule, error)
+	// TradedVolume sums the quote amount of the fills of account since, as
+	// maker or taker
+	TradedVolume(ctx context.Context, account string, since int64) (money.Amount, error)
+	// Post moves balances like ledger.Post, never overdrawing a user account
+	Post(ctx context.Context, kind string, ref string, legs ...ledger.Leg) (bool, string)
+}
+
+// MongoStore keeps orders in exchangeOrders and balances in the ledger
+type MongoStore struct {
+	db *mongo.Database
+}
+
+func NewMongoStore(db *mongo.Database) *MongoStore {
+	return &MongoStore{db: db}
+}
+
+func (m *MongoStore) Transact(fn func(ctx context.Context) error) error {
+	return modals.RunTransaction(m.db, func(sc mongo.SessionContext) error {
+		return fn(sc)
+	})
+}
+
+// OpenOrders reads the pending and partial orders of pair, orders stored
+// before pairs existed are found by their currencies
+func (m *MongoStore) OpenOrders(ctx context.Context, pair Pair) ([]ExOrder, error) {
+	base, quote := pair.Base.Code, pair.Quote.Code
+	filter := bson.M{
+		"STAT": bson.M{"$in": []string{StatusPending, StatusPartial}},
+		"$or": []bson.M{
+			{"PAIR": pair.Symbol()},
+			{"PAIR": bson.M{"$exists": false}, "FROM": base, "TO": quote},
+			{"PAIR": bson.M{"$exists": false}, "FROM": quote, "TO": base},
+		},
+	}
+	cursor, err := m.db.Collection(OrdersCollection).Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var orders []ExOrder
+	if err := cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (m *MongoStore) ExpiredOrders(ctx context.Context, now int64, limit int) ([]ExOrder, error) {
+	filter := bson.M{
+		"STAT": bson.M{"$in": []string{StatusPending, StatusPartial}},
+		"EXP":  bson.M{"$gt": 0, "$lte": now},
+	}
+	opts := options.Find().SetSort(bson.M{"EXP": 1}).SetLimit(int64(limit))
+	cursor, err := m.db.Collection(OrdersCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var orders []ExOrder
+	if err := cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (m *MongoStore) Order(ctx context.Context, EID primitive.ObjectID) (ExOrder, error) {
+	var order ExOrder
+	err := m.db.Collection(OrdersCollection).FindOne(ctx, bson.M{"_id": EID}).Decode(&order)
+	return order, err
+}
+
+func (m *MongoStore) InsertOrder(ctx context.Context, order ExOrder) (primitive.ObjectID, error) {
+	result, err := m.db.Collection(OrdersCollection).InsertOne(ctx, order)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+func (m *MongoStore) SaveProgress(ctx context.Context, order ExOrder) error {
+	set := bson.M{"SAMT": order.SAMT, "RCV": order.RCV, "STAT": order.STAT}
+	if order.LFT != 0 {
+		set["LFT"] = order.LFT
+	}
+	if order.FEE != "" {
+		set["FEE"] = order.FEE
+	}
+	_, err := m.db.Collection(OrdersCollection).UpdateOne(ctx, bson.M{"_id": order.EID}, bson.M{"$set": set})
+	return err
+}
+
+func (m *MongoStore) InsertFill(ctx context.Context, fill FillRecord) error {
+	_, err := m.db.Collection(FillsCollection).InsertOne(ctx, fill)
+	return err
+}
+
+func (m *MongoStore) RecordTransfers(ctx context.Context, orders []transfer.Order) error {
+	docs := make([]interface{}, 0, len(orders))
+	for _, order := range orders {
+		docs = append(docs, order)
+	}
+	_, err := m.db.Collection("transferOrders").InsertMany(ctx, docs)
+	return err
+}
+
+func (m *MongoStore) Candle(ctx context.Context, pair string, interval string, start int64) (Candle, bool, error) {
+	var candle Candle
+	err := m.db.Collection(CandlesCollection).FindOne(ctx, bson.M{"PAIR": pair, "INT": interval, "TMP": start}).Decode(&candle)
+	if err == mongo.ErrNoDocuments {
+		return Candle{}, false, nil
+	}
+	return candle, err == nil, err
+}
+
+func (m *MongoStore) SaveCandle(ctx context.Context, candle Candle) error {
+	filter := bson.M{"PAIR": candle.PAIR, "INT": candle.INT, "TMP": candle.TMP}
+	_, err := m.db.Collection(CandlesCollection).UpdateOne(ctx, filter, bson.M{"$set": candle}, options.Update().SetUpsert(true))
+	return err
+}
+
+func (m *MongoStore) RecordCancel(ctx context.Context, record CancelRecord) error {
+	_, err := m.db.Collection(CancelsCollection).InsertOne(ctx, record)
+	return err
+}
+
+// FeeSchedule reads the exchangeFees document of platformInfo, empty when
+// there is none
+func (m *MongoStore) FeeSchedule(ctx context.Context) (FeeSchedule, error) {
+	var schedule FeeSchedule
+	err := m.db.Collection("platformInfo").FindOne(ctx, bson.M{"type": FeeScheduleType}).Decode(&schedule)
+	if err == mongo.ErrNoDocuments {
+		return FeeSchedule{}, nil
+	}
+	return schedule, err
+}
+
+func (m *MongoStore) TradedVolume(ctx context.Context, account string, since int64) (money.Amount, error) {
+	pipeline := []bson.M{
+		{"$match": bson.M{"$or": []bson.M{{"MACC": account}, {"TACC": account}}, "TMP": bson.M{"$gte": since}}},
+		{"$group": bson.M{"_id": nil, "volume": bson.M{"$sum": bson.M{"$toDecimal": "$QAMT"}}}},
+	}
+	cursor, err := m.db.Collection(FillsCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return money.Zero(), err
+	}
+	defer cursor.Close(ctx)
+	if !cursor.Next(ctx) {
+		return money.Zero(), cursor.Err()
+	}
+	var sum struct {
+		Volume primitive.Decimal128 `bson:"volume"`
+	}
+	if err := cursor.Decode(&sum); err != nil {
+		return money.Zero(), err
+	}
+	return money.FromDecimal128(sum.Volume)
+}
+
+func (m *MongoStore) Post(ctx context.Context, kind string, ref string, legs ...ledger.Leg) (bool, string) {
+	return ledger.Post(ctx, m.db, kind, ref, legs...)
+}
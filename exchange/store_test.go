@@ -0,0 +1,186 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"tbapi/ledger"
+	"tbapi/money"
+	"tbapi/transfer"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// posting is a ledger posting as the memory store recorded it
+type posting struct {
+	kind string
+	ref  string
+	legs []ledger.Leg
+}
+
+// memStore keeps orders, fills, cancels, transfers, candles, balances and
+// postings in memory, with the fee schedule. Transact restores them all when fn
+// fails, like an aborted mongo transaction.
+type memStore struct {
+	orders    map[primitive.ObjectID]ExOrder
+	fills     []FillRecord
+	cancels   []CancelRecord
+	transfers []transfer.Order
+	candles   map[string]Candle       // pair + "/" + interval + "/" + start
+	balances  map[string]money.Amount // account + "/" + field
+	postings  []posting
+	schedule  FeeSchedule
+}
+
+func newMemStore() *memStore {
+	return &memStore{orders: map[primitive.ObjectID]ExOrder{}, candles: map[string]Candle{}, balances: map[string]money.Amount{}}
+}
+
+func (m *memStore) balance(account string, field string) money.Amount {
+	return m.balances[account+"/"+field]
+}
+
+func (m *memStore) deposit(account string, field string, amount money.Amount) {
+	m.balances[account+"/"+field] = m.balance(account, field).Add(amount)
+}
+
+func (m *memStore) Transact(fn func(ctx context.Context) error) error {
+	orders, candles, balances := maps.Clone(m.orders), maps.Clone(m.candles), maps.Clone(m.balances)
+	fills, cancels, transfers, postings := len(m.fills), len(m.cancels), len(m.transfers), len(m.postings)
+	if err := fn(context.Background()); err != nil {
+		m.orders, m.candles, m.balances = orders, candles, balances
+		m.fills, m.cancels, m.postings = m.fills[:fills], m.cancels[:cancels], m.postings[:postings]
+		m.transfers = m.transfers[:transfers]
+		return err
+	}
+	return nil
+}
+
+func (m *memStore) OpenOrders(ctx context.Context, pair Pair) ([]ExOrder, error) {
+	var open []ExOrder
+	for _, order := range m.orders {
+		symbol := order.PAIR
+		if symbol == "" {
+			// stored before pairs, like MongoStore finds them by currency
+			orderPair, _, _ := PairOf(order.FROM, order.TO)
+			symbol = orderPair.Symbol()
+		}
+		if symbol == pair.Symbol() && (order.STAT == StatusPending || order.STAT == StatusPartial) {
+			open = append(open, order)
+		}
+	}
+	return open, nil
+}
+
+func (m *memStore) ExpiredOrders(ctx context.Context, now int64, limit int) ([]ExOrder, error) {
+	var expired []ExOrder
+	for _, order := range m.orders {
+		if order.EXP > 0 && order.EXP <= now && (order.STAT == StatusPending || order.STAT == StatusPartial) && len(expired) < limit {
+			expired = append(expired, order)
+		}
+	}
+	return expired, nil
+}
+
+func (m *memStore) Order(ctx context.Context, EID primitive.ObjectID) (ExOrder, error) {
+	order, found := m.orders[EID]
+	if !found {
+		return ExOrder{}, errors.New("no such order")
+	}
+	return order, nil
+}
+
+func (m *memStore) InsertOrder(ctx context.Context, order ExOrder) (primitive.ObjectID, error) {
+	order.EID = primitive.NewObjectID()
+	m.orders[order.EID] = order
+	return order.EID, nil
+}
+
+func (m *memStore) SaveProgress(ctx context.Context, order ExOrder) error {
+	stored, found := m.orders[order.EID]
+	if !found {
+		return errors.New("no such order")
+	}
+	stored.SAMT, stored.RCV, stored.STAT = order.SAMT, order.RCV, order.STAT
+	if order.LFT != 0 {
+		stored.LFT = order.LFT
+	}
+	if order.FEE != "" {
+		stored.FEE = order.FEE
+	}
+	m.orders[order.EID] = stored
+	return nil
+}
+
+func (m *memStore) InsertFill(ctx context.Context, fill FillRecord) error {
+	m.fills = append(m.fills, fill)
+	return nil
+}
+
+func (m *memStore) RecordTransfers(ctx context.Context, orders []transfer.Order) error {
+	m.transfers = append(m.transfers, orders...)
+	return nil
+}
+
+func candleKey(pair string, interval string, start int64) string {
+	return fmt.Sprintf("%s/%s/%d", pair, interval, start)
+}
+
+func (m *memStore) Candle(ctx context.Context, pair string, interval string, start int64) (Candle, bool, error) {
+	candle, found := m.candles[candleKey(pair, interval, start)]
+	return candle, found, nil
+}
+
+func (m *memStore) SaveCandle(ctx context.Context, candle Candle) error {
+	m.candles[candleKey(candle.PAIR, candle.INT, candle.TMP)] = candle
+	return nil
+}
+
+func (m *memStore) RecordCancel(ctx context.Context, record CancelRecord) error {
+	m.cancels = append(m.cancels, record)
+	return nil
+}
+
+func (m *memStore) FeeSchedule(ctx context.Context) (FeeSchedule, error) {
+	return m.schedule, nil
+}
+
+func (m *memStore) TradedVolume(ctx context.Context, account string, since int64) (money.Amount, error) {
+	volume := money.Zero()
+	for _, fill := range m.fills {
+		if (fill.MACC == account || fill.TACC == account) && fill.TMP >= since {
+			quote, err := money.ParseDecimal(fill.QAMT)
+			if err != nil {
+				return money.Zero(), err
+			}
+			volume = volume.Add(quote)
+		}
+	}
+	return volume, nil
+}
+
+// Post checks the legs balance and that no user account goes below zero, the
+// store is left as it was when it fails
+func (m *memStore) Post(ctx context.Context, kind string, ref string, legs ...ledger.Leg) (bool, string) {
+	sums := map[string]money.Amount{}
+	for _, leg := range legs {
+		sums[leg.Field] = sums[leg.Field].Add(leg.Amount)
+	}
+	for _, sum := range sums {
+		if !sum.IsZero() {
+			return false, "Unbalanced ledger posting"
+		}
+	}
+	balances := maps.Clone(m.balances)
+	for _, leg := range legs {
+		key := leg.Account + "/" + leg.Field
+		balances[key] = balances[key].Add(leg.Amount)
+		if !ledger.IsSystem(leg.Account) && balances[key].Sign() < 0 {
+			return false, "Insufficient Balance"
+		}
+	}
+	m.balances = balances
+	m.postings = append(m.postings, posting{kind: kind, ref: ref, legs: legs})
+	return true, ""
+}
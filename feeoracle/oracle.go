@@ -0,0 +1,148 @@
+// Package feeoracle keeps the updatedFee document of platformInfo current. A
+// Sampler per chain stores the EIP-1559 base fee and priority fee read with
+// eth_feeHistory, a Pricer stores the USD prices of the chains' coins taken
+// from a PriceSource.
+package feeoracle
+
+import (
+	"context"
+	"log"
+	"math/big"
+	"slices"
+	"tbapi/modals"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+)
+
+const (
+	// historyBlocks is how many recent blocks a sample looks at
+	historyBlocks = 20
+	// tipPercentile of the tips paid in each block is taken as its tip
+	tipPercentile = 50
+)
+
+// Backend is the part of an EVM node the sampler uses
+type Backend interface {
+	FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error)
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
+}
+
+// Store saves what the oracle found
+type Store interface {
+	SaveChainFee(ctx context.Context, chain string, fee modals.ChainFee) error
+	// SavePrices stores USD prices by their updatedFee key
+	SavePrices(ctx context.Context, prices map[string]float64) error
+}
+
+// Sample reads the fee market of the newest blocks: the base fee of the next
+// block and the median tip of the recent blocks that had transactions. When
+// none had, the node's suggestion stands in for the tip.
+func Sample(ctx context.Context, backend Backend) (modals.ChainFee, error) {
+	history, err := backend.FeeHistory(ctx, historyBlocks, nil, []float64{tipPercentile})
+	if err != nil {
+		return modals.ChainFee{}, err
+	}
+	if len(history.BaseFee) == 0 {
+		return modals.ChainFee{}, ethereum.NotFound
+	}
+	// BaseFee holds one entry more than the blocks, for the next one
+	base := history.BaseFee[len(history.BaseFee)-1]
+
+	var tips []*big.Int
+	for i, reward := range history.Reward {
+		if len(reward) > 0 && i < len(history.GasUsedRatio) && history.GasUsedRatio[i] > 0 {
+			tips = append(tips, reward[0])
+		}
+	}
+	var tip *big.Int
+	if len(tips) == 0 {
+		if tip, err = backend.SuggestGasTipCap(ctx); err != nil {
+			return modals.ChainFee{}, err
+		}
+	} else {
+		slices.SortFunc(tips, func(a, b *big.Int) int { return a.Cmp(b) })
+		tip = tips[len(tips)/2]
+	}
+
+	newest := new(big.Int).Add(history.OldestBlock, big.NewInt(int64(len(history.GasUsedRatio)-1)))
+	return modals.ChainFee{
+		BaseFee:     base.String(),
+		PriorityFee: tip.String(),
+		Block:       newest.Int64(),
+		Updated:     time.Now().UTC().Unix(),
+	}, nil
+}
+
+// Sampler samples the fees of one chain
+type Sampler struct {
+	chain   string
+	backend Backend
+	store   Store
+}
+
+func NewSampler(chain string, backend Backend, store Store) *Sampler {
+	return &Sampler{chain: chain, backend: backend, store: store}
+}
+
+// Run samples every interval until ctx is done
+func (s *Sampler) Run(ctx context.Context, interval time.Duration) {
+	run(ctx, interval, "fees "+s.chain, s.Pass)
+}
+
+func (s *Sampler) Pass(ctx context.Context) error {
+	fee, err := Sample(ctx, s.backend)
+	if err != nil {
+		return err
+	}
+	return s.store.SaveChainFee(ctx, s.chain, fee)
+}
+
+// Pricer stores the prices of a set of coins
+type Pricer struct {
+	source PriceSource
+	keys   []string
+	store  Store
+}
+
+// NewPricer prices the coins named by keys, the feePrice keys of the registry
+func NewPricer(source PriceSource, keys []string, store Store) *Pricer {
+	return &Pricer{source: source, keys: keys, store: store}
+}
+
+// Run updates the prices every interval until ctx is done
+func (p *Pricer) Run(ctx context.Context, interval time.Duration) {
+	run(ctx, interval, "prices", p.Pass)
+}
+
+// Pass stores the prices the source knows, a missing one keeps its last value
+func (p *Pricer) Pass(ctx context.Context) error {
+	prices, err := p.source.Prices(ctx, p.keys)
+	if err != nil {
+		return err
+	}
+	for _, key := range p.keys {
+		if _, found := prices[key]; !found {
+			log.Printf("prices: no price for %s", key)
+		}
+	}
+	if len(prices) == 0 {
+		return nil
+	}
+	return p.store.SavePrices(ctx, prices)
+}
+
+func run(ctx context.Context, interval time.Duration, name string, pass func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := pass(ctx); err != nil {
+			log.Printf("%s: %v", name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
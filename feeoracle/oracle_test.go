@@ -0,0 +1,169 @@
+package feeoracle_test
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"tbapi/chains"
+	"tbapi/chaintest"
+	"tbapi/evmtx"
+	"tbapi/feeoracle"
+	"tbapi/modals"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+)
+
+// TestOracle samples the fee market of a chain carrying tipped transfers, prices
+// its coin from a static list and from a fake CoinGecko, and checks the quoted
+// transfer cost is 65000 gas of the sampled base fee and median tip
+func TestOracle(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	sim := simulated.NewBackend(types.GenesisAlloc{sender: {Balance: new(big.Int).Mul(chaintest.Ether, big.NewInt(10))}})
+	defer sim.Close()
+	client := sim.Client()
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// blocks with transfers tipping 1, 2 and 3 gwei, then an empty one
+	gwei := big.NewInt(1000000000)
+	for i := int64(1); i <= 3; i++ {
+		nonce, err := client.PendingNonceAt(ctx, sender)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, err := client.HeaderByNumber(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tip := new(big.Int).Mul(gwei, big.NewInt(i))
+		feeCap := new(big.Int).Add(tip, new(big.Int).Mul(header.BaseFee, big.NewInt(2)))
+		tx, err := evmtx.Sign(chainID, key, nonce, common.HexToAddress("0xfee"), big.NewInt(1), 21000, tip, feeCap, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.SendTransaction(ctx, tx); err != nil {
+			t.Fatal(err)
+		}
+		sim.Commit()
+	}
+	sim.Commit()
+
+	store := &memFeeStore{fees: modals.Fees{Chains: map[string]modals.ChainFee{}}}
+	if err := feeoracle.NewSampler("SIM", client, store).Pass(ctx); err != nil {
+		t.Fatal(err)
+	}
+	fee, found := store.fees.Chains["SIM"]
+	if !found {
+		t.Errorf("no fee sampled")
+	}
+	if fee.PriorityFee != new(big.Int).Mul(gwei, big.NewInt(2)).String() {
+		t.Errorf("priority fee %s, want the 2 gwei median", fee.PriorityFee)
+	}
+	head, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee.Block != head.Number.Int64() {
+		t.Errorf("sampled block %d, head is %d", fee.Block, head.Number.Int64())
+	}
+	if fee.BaseFee == "" || fee.BaseFee == "0" {
+		t.Errorf("base fee %q", fee.BaseFee)
+	}
+	if fee.Updated <= 0 {
+		t.Errorf("sample has no time")
+	}
+
+	static, err := feeoracle.ParseStaticPrices("eth=2500, polygon=0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := feeoracle.NewPricer(static, []string{"eth"}, store).Pass(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if store.fees.Eth != "2500" || store.fees.Polygon != "" {
+		t.Errorf("static prices eth %q polygon %q", store.fees.Eth, store.fees.Polygon)
+	}
+
+	chain := chains.Chain{Name: "SIM", FeePrice: "eth"}
+	cost, isPriced := store.fees.TransferCost(chain)
+	base, _ := new(big.Int).SetString(fee.BaseFee, 10)
+	tip, _ := new(big.Int).SetString(fee.PriorityFee, 10)
+	gas := new(big.Int).Mul(base.Add(base, tip), big.NewInt(modals.TransferGas*2500))
+	want := new(big.Rat).SetFrac(gas, big.NewInt(1e18))
+	if !isPriced || cost.Cmp(want) != 0 {
+		t.Errorf("transfer cost %v, want %v", cost, want)
+	}
+	_, isPriced = store.fees.TransferCost(chains.Chain{Name: "SIM", FeePrice: "polygon"})
+	if isPriced {
+		t.Errorf("a chain without a price is quoted")
+	}
+	legacy := modals.Fees{Gwei: "0.00000003", Eth: "2500"}
+	cost, isPriced = legacy.TransferCost(chain)
+	if !isPriced || cost.Cmp(big.NewRat(4875, 1000)) != 0 {
+		t.Errorf("legacy transfer cost %v, want 4.875", cost)
+	}
+
+	gecko := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/simple/price" || r.URL.Query().Get("vs_currencies") != "usd" || r.Header.Get("x-cg-demo-api-key") != "demo" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"ethereum":{"usd":3100.5},"polygon-ecosystem-token":{"usd":0.25}}`)
+	}))
+	defer gecko.Close()
+	source := feeoracle.CoinGecko{BaseURL: gecko.URL, APIKey: "demo", IDs: feeoracle.DefaultCoinGeckoIDs}
+	if err := feeoracle.NewPricer(source, []string{"eth", "polygon", "unknown"}, store).Pass(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if store.fees.Eth != "3100.5" || store.fees.Polygon != "0.25" {
+		t.Errorf("coingecko prices eth %q polygon %q", store.fees.Eth, store.fees.Polygon)
+	}
+	if store.fees.PricesUpdated <= 0 {
+		t.Errorf("prices have no time")
+	}
+	failing := feeoracle.CoinGecko{BaseURL: gecko.URL, IDs: feeoracle.DefaultCoinGeckoIDs}
+	if feeoracle.NewPricer(failing, []string{"eth"}, store).Pass(ctx) == nil {
+		t.Errorf("a refused price request passed")
+	}
+	if store.fees.Eth != "3100.5" {
+		t.Errorf("a failed price request changed the price")
+	}
+}
+
+// memFeeStore keeps the updatedFee document in memory
+type memFeeStore struct {
+	fees modals.Fees
+}
+
+func (m *memFeeStore) SaveChainFee(ctx context.Context, chain string, fee modals.ChainFee) error {
+	m.fees.Chains[chain] = fee
+	return nil
+}
+
+func (m *memFeeStore) SavePrices(ctx context.Context, prices map[string]float64) error {
+	for key, price := range prices {
+		value := strconv.FormatFloat(price, 'f', -1, 64)
+		switch key {
+		case "polygon":
+			m.fees.Polygon = value
+		case "eth":
+			m.fees.Eth = value
+		}
+	}
+	m.fees.PricesUpdated = 1
+	return nil
+}
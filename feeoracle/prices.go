@@ -0,0 +1,129 @@
+package feeoracle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PriceSource gives the USD prices of coins by their updatedFee key (polygon,
+// eth or another feePrice of the registry). Keys it doesn't know are left out
+// of the result.
+type PriceSource interface {
+	Prices(ctx context.Context, keys []string) (map[string]float64, error)
+}
+
+// StaticPrices is a fixed price list, for development chains and tests
+type StaticPrices map[string]float64
+
+func (s StaticPrices) Prices(ctx context.Context, keys []string) (map[string]float64, error) {
+	prices := map[string]float64{}
+	for _, key := range keys {
+		if price, found := s[key]; found {
+			prices[key] = price
+		}
+	}
+	return prices, nil
+}
+
+// ParsePairs reads a "key=value,key=value" list
+func ParsePairs(list string) (map[string]string, error) {
+	pairs := map[string]string{}
+	for _, pair := range strings.Split(list, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, found := strings.Cut(pair, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !found || key == "" || value == "" {
+			return nil, fmt.Errorf("%q is not key=value", pair)
+		}
+		pairs[key] = value
+	}
+	return pairs, nil
+}
+
+// ParseStaticPrices reads a "polygon=0.5,eth=2500" list
+func ParseStaticPrices(list string) (StaticPrices, error) {
+	pairs, err := ParsePairs(list)
+	if err != nil {
+		return nil, err
+	}
+	prices := StaticPrices{}
+	for key, value := range pairs {
+		price, err := strconv.ParseFloat(value, 64)
+		if err != nil || price <= 0 {
+			return nil, fmt.Errorf("price of %s: %q is not a positive number", key, value)
+		}
+		prices[key] = price
+	}
+	return prices, nil
+}
+
+// DefaultCoinGeckoIDs are the CoinGecko ids of the coins of the default chains
+var DefaultCoinGeckoIDs = map[string]string{
+	"polygon": "polygon-ecosystem-token",
+	"eth":     "ethereum",
+}
+
+// CoinGecko reads the simple price endpoint of the CoinGecko API
+type CoinGecko struct {
+	BaseURL string            // https://api.coingecko.com/api/v3 when empty
+	APIKey  string            // demo API key, optional
+	IDs     map[string]string // CoinGecko id by updatedFee key
+	Client  *http.Client
+}
+
+func (c CoinGecko) Prices(ctx context.Context, keys []string) (map[string]float64, error) {
+	var ids []string
+	for _, key := range keys {
+		if id, found := c.IDs[key]; found {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return map[string]float64{}, nil
+	}
+	base := c.BaseURL
+	if base == "" {
+		base = "https://api.coingecko.com/api/v3"
+	}
+	query := url.Values{"ids": {strings.Join(ids, ",")}, "vs_currencies": {"usd"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(base, "/")+"/simple/price?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.APIKey != "" {
+		req.Header.Set("x-cg-demo-api-key", c.APIKey)
+	}
+	client := c.Client
+	if client == nil {
+		client = &http.Client{Timeout: 15 * time.Second}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("coingecko: %s", res.Status)
+	}
+	var body map[string]map[string]float64
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, errors.New("coingecko: unreadable answer")
+	}
+	prices := map[string]float64{}
+	for _, key := range keys {
+		if price := body[c.IDs[key]]["usd"]; price > 0 {
+			prices[key] = price
+		}
+	}
+	return prices, nil
+}
@@ -0,0 +1,40 @@
+package feeoracle
+
+import (
+	"context"
+	"strconv"
+	"tbapi/modals"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore writes the updatedFee document of platformInfo, creating it on
+// first use. Each write only sets its own fields.
+type MongoStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoStore(db *mongo.Database) *MongoStore {
+	return &MongoStore{coll: db.Collection("platformInfo")}
+}
+
+func (m *MongoStore) SaveChainFee(ctx context.Context, chain string, fee modals.ChainFee) error {
+	return m.set(ctx, bson.M{"chains." + chain: fee})
+}
+
+// SavePrices stores the prices as strings like the rest of the document
+func (m *MongoStore) SavePrices(ctx context.Context, prices map[string]float64) error {
+	set := bson.M{"pricesUpdated": time.Now().UTC().Unix()}
+	for key, price := range prices {
+		set[key] = strconv.FormatFloat(price, 'f', -1, 64)
+	}
+	return m.set(ctx, set)
+}
+
+func (m *MongoStore) set(ctx context.Context, set bson.M) error {
+	_, err := m.coll.UpdateOne(ctx, bson.M{"type": "updatedFee"}, bson.M{"$set": set}, options.Update().SetUpsert(true))
+	return err
+}
@@ -0,0 +1,322 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"tbapi/ledger"
+	"tbapi/modals"
+	"tbapi/money"
+	"tbapi/transfer"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DepositsCollection holds one document per credited Transfer log
+const DepositsCollection = "deposits"
+
+// Deposit states
+const (
+	DepositCredited = "credited"
+	DepositOrphaned = "orphaned" // its block left the chain after the credit, see REV
+)
+
+// Deposit is a token Transfer log into a deposit address. CHAIN, TXH and LIX
+// identify it, so crediting the same log twice is a no-op.
+type Deposit struct {
+	EID    primitive.ObjectID   `bson:"_id,omitempty"`
+	CHAIN  string               `bson:"CHAIN"`
+	TXH    string               `bson:"TXH"` // transaction hash
+	LIX    int64                `bson:"LIX"` // log index within the block
+	BLK    int64                `bson:"BLK"`
+	BHASH  string               `bson:"BHASH"`
+	FROM   string               `bson:"FROM"`
+	ADD    string               `bson:"ADD"` // deposit address, lower case
+	ID     string               `bson:"ID"`  // credited account
+	AMT    primitive.Decimal128 `bson:"AMT"`
+	STATUS string               `bson:"STATUS"`
+	REV    primitive.Decimal128 `bson:"REV,omitempty"` // taken back when orphaned, the rest of AMT is held for review
+	SEEN   bool                 `bson:"SEEN"`          // reported to the account by a refresh
+	TMP    int64                `bson:"TMP"`
+}
+
+// DepositOwner is the account behind a deposit address and the key material
+// the sweeper needs for it
+type DepositOwner struct {
+	ID   string
+	HDIX int64
+	EKEY string
+}
+
+var errAlreadyCredited = errors.New("deposit already credited")
+
+// Held is what an orphaned deposit couldn't take back from the account because
+// it was spent already, zero for a credited deposit
+func (d Deposit) Held() money.Amount {
+	if d.STATUS != DepositOrphaned {
+		return money.Zero()
+	}
+	return modals.Balance(d.AMT).Sub(modals.Balance(d.REV))
+}
+
+// depositRef is the ledger reference of a deposit
+func depositRef(deposit Deposit) string {
+	return deposit.CHAIN + ":" + deposit.TXH + ":" + strconv.FormatInt(deposit.LIX, 10)
+}
+
+// EnsureDepositIndexes makes (CHAIN, TXH, LIX) unique
+func EnsureDepositIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(DepositsCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "CHAIN", Value: 1}, {Key: "TXH", Value: 1}, {Key: "LIX", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "ID", Value: 1}, {Key: "SEEN", Value: 1}}},
+		{Keys: bson.D{{Key: "CHAIN", Value: 1}, {Key: "BLK", Value: 1}}},
+	})
+	return err
+}
+
+// CreditDeposit records deposit, queues its address for sweeping, credits the
+// owner and writes the order history entry in one transaction. It returns
+// false with no error when the log was credited before. An orphaned deposit
+// whose log is back on the chain is credited again with what was taken back.
+func CreditDeposit(db *mongo.Database, deposit Deposit, owner DepositOwner, amount money.Amount) (bool, error) {
+	deposits := db.Collection(DepositsCollection)
+	secretsWallets := db.Collection("secretsWallets")
+	orderCollection := db.Collection("transferOrders")
+	asset, isKnown := money.Lookup(deposit.CHAIN)
+	if !isKnown {
+		return false, fmt.Errorf("chain %q is not in the registry", deposit.CHAIN)
+	}
+	deposit.ID = owner.ID
+	deposit.AMT = amount.Decimal128()
+	deposit.STATUS = DepositCredited
+	deposit.TMP = time.Now().UTC().Unix()
+	err := modals.RunTransaction(db, func(sc mongo.SessionContext) error {
+		var recorded Deposit
+		err := deposits.FindOne(sc, bson.M{"CHAIN": deposit.CHAIN, "TXH": deposit.TXH, "LIX": deposit.LIX}).Decode(&recorded)
+		switch {
+		case err == nil && recorded.STATUS == DepositOrphaned:
+			return reinstateDeposit(sc, db, recorded, deposit, asset)
+		case err == nil:
+			return errAlreadyCredited
+		case err != mongo.ErrNoDocuments:
+			return err
+		}
+
+		_, err = deposits.InsertOne(sc, deposit)
+		if mongo.IsDuplicateKeyError(err) {
+			return errAlreadyCredited
+		}
+		if err != nil {
+			return err
+		}
+		if err := modals.Checkpoint("deposit:recorded"); err != nil {
+			return err
+		}
+		isInserted := modals.InsertIntoSecWallets(sc, deposit.ADD, owner.EKEY, owner.HDIX, deposit.CHAIN, amount, secretsWallets)
+		if !isInserted {
+			return modals.Abort("Can't record deposit wallet")
+		}
+		if err := modals.Checkpoint("deposit:wallet-recorded"); err != nil {
+			return err
+		}
+		isPosted, _ := ledger.Post(sc, db, ledger.KindDeposit, depositRef(deposit),
+			ledger.Debit(ledger.External, deposit.CHAIN, amount),
+			ledger.Credit(owner.ID, deposit.CHAIN, amount))
+		if !isPosted {
+			return modals.Abort("Can't update balance")
+		}
+		if err := modals.Checkpoint("deposit:credited"); err != nil {
+			return err
+		}
+		isRecorded, message, _ := transfer.RecordOrder(sc, money.Zero().Format(asset), "ON-CHAIN", owner.ID, deposit.ADD, amount.Format(asset), deposit.CHAIN, "EXT", orderCollection)
+		if !isRecorded {
+			return modals.Abort(message)
+		}
+		return nil
+	})
+	if errors.Is(err, errAlreadyCredited) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// reinstateDeposit credits an orphaned deposit again with what was taken back
+// when it was orphaned, now that its log is in block of deposit
+func reinstateDeposit(sc mongo.SessionContext, db *mongo.Database, orphaned Deposit, deposit Deposit, asset money.Asset) error {
+	reversed := modals.Balance(orphaned.REV)
+	update := bson.M{
+		"$set":   bson.M{"STATUS": DepositCredited, "BLK": deposit.BLK, "BHASH": deposit.BHASH},
+		"$unset": bson.M{"REV": ""},
+	}
+	result, err := db.Collection(DepositsCollection).UpdateOne(sc, bson.M{"_id": orphaned.EID, "STATUS": DepositOrphaned}, update)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return errAlreadyCredited
+	}
+	if err := modals.Checkpoint("deposit:reinstated"); err != nil {
+		return err
+	}
+	if reversed.IsZero() {
+		return nil
+	}
+	isPosted, _ := ledger.Post(sc, db, ledger.KindDeposit, depositRef(orphaned),
+		ledger.Debit(ledger.External, orphaned.CHAIN, reversed),
+		ledger.Credit(orphaned.ID, orphaned.CHAIN, reversed))
+	if !isPosted {
+		return modals.Abort("Can't update balance")
+	}
+	if err := modals.Checkpoint("deposit:credited"); err != nil {
+		return err
+	}
+	isRecorded, message, _ := transfer.RecordOrder(sc, money.Zero().Format(asset), "ON-CHAIN", orphaned.ID, orphaned.ADD, reversed.Format(asset), orphaned.CHAIN, "EXT", db.Collection("transferOrders"))
+	if !isRecorded {
+		return modals.Abort(message)
+	}
+	return nil
+}
+
+// DepositsInBlocks lists the deposits of chain credited from blocks first to last
+func DepositsInBlocks(ctx context.Context, db *mongo.Database, chain string, first int64, last int64) ([]Deposit, error) {
+	filter := bson.M{"CHAIN": chain, "BLK": bson.M{"$gte": first, "$lte": last}}
+	cursor, err := db.Collection(DepositsCollection).Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "BLK", Value: 1}, {Key: "LIX", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var deposits []Deposit
+	if err := cursor.All(ctx, &deposits); err != nil {
+		return nil, err
+	}
+	return deposits, nil
+}
+
+// DepositKey is the "TXH:LIX" key of a deposit within its chain
+func DepositKey(deposit Deposit) string {
+	return deposit.TXH + ":" + strconv.FormatInt(deposit.LIX, 10)
+}
+
+// OrphanDeposits flags the deposits credited from blocks first to last of
+// chain whose logs are no longer part of the chain and takes them back. keep
+// holds the keys found again when the range was rescanned.
+func OrphanDeposits(ctx context.Context, db *mongo.Database, chain string, first int64, last int64, keep map[string]bool) ([]Deposit, error) {
+	credited, err := DepositsInBlocks(ctx, db, chain, first, last)
+	if err != nil {
+		return nil, err
+	}
+	var orphaned []Deposit
+	for _, deposit := range credited {
+		if deposit.STATUS != DepositCredited || keep[DepositKey(deposit)] {
+			continue
+		}
+		isOrphaned, err := orphanDeposit(db, &deposit)
+		if err != nil {
+			return orphaned, err
+		}
+		if !isOrphaned {
+			continue
+		}
+		log.Printf("deposit %s %s:%d of %s left the chain after it was credited, %s taken back, %s held for review",
+			chain, deposit.TXH, deposit.LIX, deposit.ID, modals.Balance(deposit.REV), deposit.Held())
+		orphaned = append(orphaned, deposit)
+	}
+	return orphaned, nil
+}
+
+// orphanDeposit flags a credited deposit and reverses its credit as far as the
+// balance of the account still covers it in one transaction. What the account
+// spent already stays held on the deposit for an admin to reconcile.
+func orphanDeposit(db *mongo.Database, deposit *Deposit) (bool, error) {
+	deposits := db.Collection(DepositsCollection)
+	accounts := db.Collection("tb_accounts")
+	asset, isKnown := money.Lookup(deposit.CHAIN)
+	if !isKnown {
+		return false, fmt.Errorf("chain %q is not in the registry", deposit.CHAIN)
+	}
+	amount := modals.Balance(deposit.AMT)
+	reversed := money.Zero()
+	err := modals.RunTransaction(db, func(sc mongo.SessionContext) error {
+		var account modals.User
+		if err := accounts.FindOne(sc, bson.M{"ID": deposit.ID}).Decode(&account); err != nil {
+			return err
+		}
+		reversed = money.Min(amount, account.BalanceOf(deposit.CHAIN))
+		if reversed.Sign() < 0 {
+			reversed = money.Zero()
+		}
+		update := bson.M{"$set": bson.M{"STATUS": DepositOrphaned, "REV": reversed.Decimal128()}}
+		result, err := deposits.UpdateOne(sc, bson.M{"_id": deposit.EID, "STATUS": DepositCredited}, update)
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount == 0 {
+			return errAlreadyCredited
+		}
+		if err := modals.Checkpoint("orphan:flagged"); err != nil {
+			return err
+		}
+		if reversed.IsZero() {
+			return nil
+		}
+		isPosted, _ := ledger.Post(sc, db, ledger.KindReversal, depositRef(*deposit),
+			ledger.Debit(deposit.ID, deposit.CHAIN, reversed),
+			ledger.Credit(ledger.External, deposit.CHAIN, reversed))
+		if !isPosted {
+			return modals.Abort("Can't update balance")
+		}
+		if err := modals.Checkpoint("orphan:reversed"); err != nil {
+			return err
+		}
+		isRecorded, message, _ := transfer.RecordOrder(sc, money.Zero().Format(asset), deposit.ID, "ON-CHAIN", deposit.ADD, reversed.Format(asset), deposit.CHAIN, "REV", db.Collection("transferOrders"))
+		if !isRecorded {
+			return modals.Abort(message)
+		}
+		return nil
+	})
+	if errors.Is(err, errAlreadyCredited) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	deposit.STATUS, deposit.REV = DepositOrphaned, reversed.Decimal128()
+	return true, nil
+}
+
+// takeNewDeposits returns the deposits of account ID not reported yet and
+// marks them reported
+func takeNewDeposits(ctx context.Context, db *mongo.Database, ID string) ([]Deposit, error) {
+	deposits := db.Collection(DepositsCollection)
+	cursor, err := deposits.Find(ctx, bson.M{"ID": ID, "STATUS": DepositCredited, "SEEN": false}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var unseen []Deposit
+	if err := cursor.All(ctx, &unseen); err != nil {
+		return nil, err
+	}
+	if len(unseen) == 0 {
+		return nil, nil
+	}
+	EIDs := make([]primitive.ObjectID, len(unseen))
+	for i, deposit := range unseen {
+		EIDs[i] = deposit.EID
+	}
+	_, err = deposits.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": EIDs}}, bson.M{"$set": bson.M{"SEEN": true}})
+	if err != nil {
+		return nil, err
+	}
+	return unseen, nil
+}
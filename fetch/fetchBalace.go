@@ -8,32 +8,27 @@ import (
 	"log"
 	"math/big"
 	"net/http"
-	"os"
-	"strconv"
 	"strings"
+	"tbapi/chains"
 	"tbapi/modals"
-	"tbapi/transfer"
+	"tbapi/money"
 	"time"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/joho/godotenv"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func FetchChainBalance(r *http.Request) (string, string) {
-	db, err := modals.ConnectDB()
-
-	if err != nil {
-		return "false", "API Database Error"
-	}
-	// Database collections
-	accounts := db.Collection("tb_accounts")
-	secretsWallets := db.Collection("secretsWallets")
+// ChainRefresh is the outcome of a deposit check, credited amounts are zero
+// when nothing new arrived on that chain
+type ChainRefresh struct {
+	TMP      int64
+	EADD     string                  // deposit address of the account
+	Credited map[string]money.Amount // every chain of the registry by name
+	Balances map[string]money.Amount
+}
 
+func FetchChainBalance(r *http.Request) (string, string) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		return "false", "API Database Error"
@@ -59,187 +54,66 @@ func FetchChainBalance(r *http.Request) (string, string) {
 	}
 	address := walletsDetailList[0]
 	walletKey := walletsDetailList[1]
-	validKey := modals.CheckKey(walletKey, address)
+	validKey := modals.CheckAuth(walletKey, address)
 	if !validKey {
 		return "false", "Trying to bypass"
 	}
 
-	accountData, isFound := modals.GetAccountData(address, accounts)
-	if !isFound {
-		return "false", "No Account Found"
-	}
-	isRefreshAble, message := checkRefreshCount(accounts, accountData.REFRESH, address)
-	if !isRefreshAble {
+	isRefreshed, message, refresh := RefreshChainBalance(address)
+	if !isRefreshed {
 		return "false", message
 	}
-	oldPosBalance, err := strconv.ParseFloat(accountData.POS, 64)
-	if err != nil {
-		return "false", "Problem at backend"
+	posCredited, ercCredited := refresh.Credited["POS"], refresh.Credited["ERC"]
+	if posCredited.IsZero() && ercCredited.IsZero() {
+		return "true", "false"
 	}
 
-	oldERCBalance, err := strconv.ParseFloat(accountData.ERC, 64)
-	if err != nil {
-		return "false", "Problem at backend"
-	}
-	evmAddress := accountData.EADD
-	oldEvmAddress := accountData.EADD
-	// fetching balance from chain POS
-	newPosBalance := oldPosBalance
-	newERCBalance := oldERCBalance
-	isERCUpdated := false
-	isPOSUpdated := false
-	isChecked, chainPOSBalance, _ := CheckChainBalance(accountData.EADD, "POS")
-	isCheckedERC, chainERCBalance, _ := CheckChainBalance(accountData.EADD, "ERC")
-	changeAddress := false
-	if isChecked {
-		if chainPOSBalance != 0.00 {
-			isInserted := modals.InsertIntoSecWallets(accountData.EADD, accountData.EKEY, chainPOSBalance, secretsWallets)
-			if isInserted {
-				newPosBalance = oldPosBalance + chainPOSBalance
-				isUpdated := modals.UpdateBalance("POS", newPosBalance, accounts, address)
-				if isUpdated {
-					isPOSUpdated = true
-					changeAddress = true
-				}
-			}
-		}
-	}
-
-	if isCheckedERC {
-		if chainERCBalance != 0.00 {
-			isInserted := modals.InsertIntoSecWallets(accountData.EADD, accountData.EKEY, chainERCBalance, secretsWallets)
-			if isInserted {
-				newERCBalance = oldERCBalance + chainERCBalance
-				isUpdated := modals.UpdateBalance("ERC", newERCBalance, accounts, address)
-				if isUpdated {
-					isERCUpdated = true
-					changeAddress = true
-				}
-			}
-		}
-	}
-
-	if changeAddress {
-		isGenerated, newAddress := modals.CreateAndSaveNewAddress(address, accounts)
-		if isGenerated {
-			evmAddress = newAddress
-		}
-	}
-
-	orderCollection := db.Collection("transferOrders")
-	returnString := ""
-	totalPOSBalanceString := fmt.Sprintf("%f", newPosBalance)
-	totalERCBalanceString := fmt.Sprintf("%f", newERCBalance)
-	if isPOSUpdated && isERCUpdated {
-		// POS Handling
-		chainPOSBalanceString := fmt.Sprintf("%f", chainPOSBalance)
-		isOrderListUpdatedPOS, txnDataPOS := transfer.UpdateOrderList("0.00", "ON-CHAIN", address, oldEvmAddress, chainPOSBalanceString, "POS", "EXT", orderCollection)
-
-		// ERC Handling
-		chainERCBalanceString := fmt.Sprintf("%f", chainERCBalance)
-		isOrderListUpdatedERC, txnDataERC := transfer.UpdateOrderList("0.00", "ON-CHAIN", address, oldEvmAddress, chainERCBalanceString, "ERC", "EXT", orderCollection)
-
-		if !isOrderListUpdatedPOS && !isOrderListUpdatedERC {
-			returnString = "false"
-		} else {
-			returnTXN := ""
-
-			if isOrderListUpdatedPOS && isOrderListUpdatedERC {
-				txnDetails := strings.Split(txnDataPOS, ",")
-				unixTimeInt64, _ := strconv.ParseInt(txnDetails[0], 10, 64)
-				unixTimeString := fmt.Sprintf("%d", unixTimeInt64)
-				newChainBalance := chainPOSBalance + chainERCBalance
-				newChainBalanceString := fmt.Sprintf("%f", newChainBalance)
-				returnTXN = fmt.Sprintf("true,BTH,%s,%s,%s,%s,%s", newChainBalanceString, unixTimeString, evmAddress, totalPOSBalanceString, totalERCBalanceString)
-			} else if isOrderListUpdatedERC {
-				txnDetails := strings.Split(txnDataERC, ",")
-				unixTimeInt64, _ := strconv.ParseInt(txnDetails[0], 10, 64)
-				unixTimeString := fmt.Sprintf("%d", unixTimeInt64)
-				returnTXN = fmt.Sprintf("true,ERC,%s,%s,%s,%s,%s", chainERCBalanceString, unixTimeString, evmAddress, totalPOSBalanceString, totalERCBalanceString)
-			} else if isOrderListUpdatedPOS {
-				txnDetails := strings.Split(txnDataPOS, ",")
-				unixTimeInt64, _ := strconv.ParseInt(txnDetails[0], 10, 64)
-				unixTimeString := fmt.Sprintf("%d", unixTimeInt64)
-				returnTXN = fmt.Sprintf("true,ERC,%s,%s,%s,%s,%s", chainPOSBalanceString, unixTimeString, evmAddress, totalPOSBalanceString, totalERCBalanceString)
-			} else {
-				returnTXN = "false"
-			}
-
-			returnString = returnTXN
-		}
-	} else if isPOSUpdated {
-		chainPOSBalanceString := fmt.Sprintf("%f", chainPOSBalance)
-		isOrderListUpdatedPOS, txnDataPOS := transfer.UpdateOrderList("0.00", "ON-CHAIN", address, oldEvmAddress, chainPOSBalanceString, "POS", "EXT", orderCollection)
-		if !isOrderListUpdatedPOS {
-			returnString = "false"
-		} else {
-			txnDetails := strings.Split(txnDataPOS, ",")
-			unixTime := txnDetails[0]
-			unixTimeInt64, _ := strconv.ParseInt(unixTime, 10, 64)
-			unixTimeString := fmt.Sprintf("%d", unixTimeInt64)
-			returnString = fmt.Sprintf("%s,%s,%s,%s,%s,%s,%s", "true", "POS", chainPOSBalanceString, unixTimeString, evmAddress, totalPOSBalanceString, totalERCBalanceString)
-		}
-	} else if isERCUpdated {
-		chainERCBalanceString := fmt.Sprintf("%f", chainERCBalance)
-		isOrderListUpdatedERC, txnDataERC := transfer.UpdateOrderList("0.00", "ON-CHAIN", address, oldEvmAddress, chainERCBalanceString, "ERC", "EXT", orderCollection)
-
-		if !isOrderListUpdatedERC {
-			returnString = "false"
-		} else {
-			txnDetails := strings.Split(txnDataERC, ",")
-			unixTime := txnDetails[0]
-			unixTimeInt64, _ := strconv.ParseInt(unixTime, 10, 64)
-			unixTimeString := fmt.Sprintf("%d", unixTimeInt64)
-			returnString = fmt.Sprintf("%s,%s,%s,%s,%s,%s,%s", "true", "POS", chainERCBalanceString, unixTimeString, evmAddress, totalPOSBalanceString, totalERCBalanceString)
-		}
-	} else {
-		returnString = "false"
+	creditType := "BTH"
+	if ercCredited.IsZero() {
+		creditType = "POS"
+	} else if posCredited.IsZero() {
+		creditType = "ERC"
 	}
+	returnString := fmt.Sprintf("true,%s,%s,%d,%s,%s,%s", creditType, posCredited.Add(ercCredited).Fixed(6), refresh.TMP, refresh.EADD, refresh.Balances["POS"].Fixed(6), refresh.Balances["ERC"].Fixed(6))
 	return "true", returnString
 }
 
-func checkRefreshCount(accounts *mongo.Collection, currentRefresh string, address string) (bool, string) {
-	refreshLimitTime := int64(3600)
-	currentRefreshList := strings.Split(currentRefresh, ",")
-	if len(currentRefreshList) != 2 {
-		return false, "Can not get refresh counts"
-	}
-	utcNow := time.Now().UTC()
-	unixTimestamp := utcNow.Unix()
-	oneHourLater := unixTimestamp + refreshLimitTime
-	refreshCount, err := strconv.ParseInt(currentRefreshList[0], 10, 64)
+// RefreshChainBalance reports the deposits the watcher credited to the account
+// since its last refresh. Deposits are credited from their Transfer logs by
+// the watcher in cmd/tbworker, a refresh no longer touches the chain.
+func RefreshChainBalance(address string) (bool, string, ChainRefresh) {
+	db, err := modals.ConnectDB()
+
 	if err != nil {
-		return false, "Can not get refresh counts"
+		return false, "API Database Error", ChainRefresh{}
 	}
-	futureTime, err := strconv.ParseInt(currentRefreshList[1], 10, 64)
-	if err != nil {
-		return false, "Can not get refresh limit"
+	// Database collections
+	accounts := db.Collection("tb_accounts")
+
+	accountData, isFound := modals.GetAccountData(address, accounts)
+	if !isFound {
+		return false, "No Account Found", ChainRefresh{}
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	if refreshCount == 0 || futureTime == 0 || futureTime < unixTimestamp {
-		newData := fmt.Sprintf("%d,%d", 1, oneHourLater)
-		update := bson.M{"$set": bson.M{
-			"REFRESH": newData,
-		}}
-		filter := bson.M{"ID": address}
-		accounts.UpdateOne(ctx, filter, update)
-		return true, ""
-	} else {
-		if refreshCount >= 20 {
-			return false, "Refresh Limit exceeded"
-		} else {
-			newRefreshCount := refreshCount + 1
-			// Allow sync and increment count
-			newData := fmt.Sprintf("%d,%d", newRefreshCount, futureTime)
-			update := bson.M{"$set": bson.M{
-				"REFRESH": newData,
-			}}
-			filter := bson.M{"ID": address}
-			accounts.UpdateOne(ctx, filter, update)
-			return true, ""
-		}
+	deposits, err := takeNewDeposits(ctx, db, address)
+	if err != nil {
+		return false, "API Database Error", ChainRefresh{}
 	}
+	refresh := ChainRefresh{
+		EADD:     accountData.EADD,
+		Credited: map[string]money.Amount{},
+		Balances: map[string]money.Amount{},
+	}
+	for _, chain := range chains.All() {
+		refresh.Credited[chain.Name] = money.Zero()
+		refresh.Balances[chain.Name] = accountData.BalanceOf(chain.Name)
+	}
+	for _, deposit := range deposits {
+		refresh.Credited[deposit.CHAIN] = refresh.Credited[deposit.CHAIN].Add(modals.Balance(deposit.AMT))
+		refresh.TMP = deposit.TMP
+	}
+	return true, "", refresh
 }
 
 // ERC20 ABI including balanceOf and decimals functions for robustness.
@@ -279,102 +153,58 @@ const erc20ABI = `[
     }
 ]`
 
-func CheckChainBalance(walletAddressStr string, chainChoice string) (bool, float64, string) {
-	var rpcURL string
-	var tokenContractAddressStr string
-	var tokenSymbol string
-	err := godotenv.Load()
+// erc20 is parsed once, the calls only pack and unpack
+var erc20 = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(erc20ABI))
 	if err != nil {
-		return false, 0.00, "Problem at Backend"
-	}
-
-	ERCAPI := os.Getenv("ERC_API")
-	if ERCAPI == "" {
-		return false, 0.00, "Problem at Backend"
+		panic(err)
 	}
+	return parsed
+}()
 
-	POSAPI := os.Getenv("POS_API")
-	if POSAPI == "" {
-		return false, 0.00, "Problem at Backend"
-	}
-	if chainChoice == "POS" {
-		rpcURL = fmt.Sprintf("%s%s", "https://go.getblock.io/", POSAPI)
-		tokenContractAddressStr = "0xc2132D05D31c914a87C6611C10748AEb04B58e8F"
-		tokenSymbol = "USDT (Polygon)"
-	} else if chainChoice == "ERC" {
-		rpcURL = fmt.Sprintf("%s%s", "https://go.getblock.io/", ERCAPI)
-		tokenContractAddressStr = "0xdAC17F958D2ee523a2206206994597C13D831ec7"
-		tokenSymbol = "USDT (Ethereum)"
-	} else {
-		return false, 0.00, "Invalid chain choice. Use 'POLYGON' or 'ETHEREUM'."
+// CheckChainBalance reads the token balance of a wallet on a chain of the
+// chains registry through the shared RPC pool of the chain
+func CheckChainBalance(walletAddressStr string, chainChoice string) (bool, money.Amount, string) {
+	chain, isKnown := chains.Get(chainChoice)
+	if !isKnown {
+		return false, money.Zero(), fmt.Sprintf("Invalid chain choice. Use one of %s.", strings.Join(chains.Names(), ", "))
 	}
-
-	client, err := ethclient.Dial(rpcURL)
+	tokenSymbol := chain.Display
+	pool, err := chains.Shared(chainChoice)
 	if err != nil {
 		log.Printf("Failed to connect to %s client: %v", chainChoice, err)
-		return false, 0.00, fmt.Sprintf("Failed to connect to %s blockchain", chainChoice)
-	}
-	defer client.Close()
-
-	contractAbi, err := abi.JSON(strings.NewReader(erc20ABI)) // Use the more complete ABI
-	if err != nil {
-		return false, 0.00, "Internal error: Failed to parse contract ABI"
+		return false, money.Zero(), fmt.Sprintf("Failed to connect to %s blockchain", chainChoice)
 	}
 
-	contractAddress := common.HexToAddress(tokenContractAddressStr)
-	walletAddress := common.HexToAddress(walletAddressStr)
-
-	// --- Get Token Decimals (Dynamic) ---
-	var decimals uint8
-	callDataDecimals, err := contractAbi.Pack("decimals")
-	if err != nil {
-		return false, 0.00, "Internal error: Failed to prepare decimals call"
-	}
-
-	resultDecimals, err := client.CallContract(context.Background(), ethereum.CallMsg{
-		To:   &contractAddress,
-		Data: callDataDecimals,
-	}, nil)
-	if err != nil {
-		return false, 0.00, fmt.Sprintf("Failed to retrieve %s decimals (check contract address/RPC)", tokenSymbol)
-	}
-
-	err = contractAbi.UnpackIntoInterface(&decimals, "decimals", resultDecimals)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	contractAddress := chain.TokenAddress()
+	decimals, err := pool.TokenDecimals(ctx, contractAddress)
 	if err != nil {
-		return false, 0.00, fmt.Sprintf("Failed to parse %s decimals", tokenSymbol)
+		return false, money.Zero(), fmt.Sprintf("Failed to retrieve %s decimals (check contract address/RPC)", tokenSymbol)
 	}
 
-	// --- Get Token Balance ---
-	callDataBalanceOf, err := contractAbi.Pack("balanceOf", walletAddress)
+	callDataBalanceOf, err := erc20.Pack("balanceOf", common.HexToAddress(walletAddressStr))
 	if err != nil {
-		return false, 0.00, "Internal error: Failed to prepare balance call"
+		return false, money.Zero(), "Internal error: Failed to prepare balance call"
 	}
-
-	resultBalanceOf, err := client.CallContract(context.Background(), ethereum.CallMsg{
+	resultBalanceOf, err := pool.CallContract(ctx, ethereum.CallMsg{
 		To:   &contractAddress,
 		Data: callDataBalanceOf,
 	}, nil)
 	if err != nil {
 		if strings.Contains(err.Error(), "403 Forbidden") || strings.Contains(err.Error(), "access denied") {
-			return false, 0.00, "Access denied by RPC provider"
+			return false, money.Zero(), "Access denied by RPC provider"
 		}
-		return false, 0.00, fmt.Sprintf("Failed to retrieve %s balance", tokenSymbol)
+		return false, money.Zero(), fmt.Sprintf("Failed to retrieve %s balance", tokenSymbol)
 	}
 
 	rawBalance := new(big.Int)
-	err = contractAbi.UnpackIntoInterface(&rawBalance, "balanceOf", resultBalanceOf)
+	err = erc20.UnpackIntoInterface(&rawBalance, "balanceOf", resultBalanceOf)
 	if err != nil {
-		return false, 0.00, fmt.Sprintf("Failed to parse %s balance", tokenSymbol)
+		return false, money.Zero(), fmt.Sprintf("Failed to parse %s balance", tokenSymbol)
 	}
 
-	fRawBalance := new(big.Float).SetInt(rawBalance)
-	divisor := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))
-
-	humanReadableBalance := new(big.Float).Quo(fRawBalance, divisor)
-
-	finalBalance, err := strconv.ParseFloat(humanReadableBalance.String(), 64)
-	if err != nil {
-		return false, 0.00, "Internal error: Balance conversion failed"
-	}
-	return true, finalBalance, ""
+	// token balances beyond money.Scale places are dust and truncated
+	return true, money.FromTokenUnits(rawBalance, decimals), ""
 }
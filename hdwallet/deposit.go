@@ -0,0 +1,59 @@
+package hdwallet
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+// ExternalChain is the BIP-44 change level used for deposit addresses
+const ExternalChain uint32 = 0
+
+var ErrNotConfigured = errors.New("HD wallet not configured")
+
+var (
+	xpubOnce sync.Once
+	xpub     *ExtendedKey
+	xpubErr  error
+)
+
+// accountKey reads HD_XPUB on first use, after the .env file has been loaded.
+// It must be the xpub of m/44'/60'/0', an xprv is refused so that it never sits
+// on the API hosts.
+func accountKey() (*ExtendedKey, error) {
+	xpubOnce.Do(func() {
+		encoded := os.Getenv("HD_XPUB")
+		if encoded == "" {
+			xpubErr = ErrNotConfigured
+			return
+		}
+		xpub, xpubErr = Parse(encoded)
+		if xpubErr == nil && xpub.IsPrivate() {
+			xpub, xpubErr = nil, errors.New("HD_XPUB must be an xpub, keep the xprv on the signing service")
+		}
+	})
+	return xpub, xpubErr
+}
+
+// DepositAddress is the address of m/44'/60'/0'/0/index
+func DepositAddress(index uint32) (string, error) {
+	account, err := accountKey()
+	if err != nil {
+		return "", err
+	}
+	child, err := account.Derive(ExternalChain, index)
+	if err != nil {
+		return "", err
+	}
+	return child.Address()
+}
+
+// DepositKey is the private key of m/44'/60'/0'/0/index under the account
+// level xprv, for the signing service
+func DepositKey(xprv *ExtendedKey, index uint32) (string, error) {
+	child, err := xprv.Derive(ExternalChain, index)
+	if err != nil {
+		return "", err
+	}
+	return child.PrivateKeyHex()
+}
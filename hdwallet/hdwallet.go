@@ -0,0 +1,246 @@
+// Package hdwallet derives deposit addresses with BIP-32 from one extended key.
+// The backend holds the account level xpub of m/44'/60'/0' and derives the
+// deposit address of index i at m/44'/60'/0'/0/i; the signing service holding
+// the matching xprv derives the key of the same path.
+package hdwallet
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/mr-tron/base58"
+	"golang.org/x/crypto/ripemd160"
+)
+
+// HardenedStart is the first hardened child index
+const HardenedStart uint32 = 0x80000000
+
+var (
+	versionPublic  = []byte{0x04, 0x88, 0xb2, 0x1e} // xpub
+	versionPrivate = []byte{0x04, 0x88, 0xad, 0xe4} // xprv
+)
+
+var (
+	ErrInvalidKey  = errors.New("Invalid extended key")
+	ErrHardened    = errors.New("Hardened derivation needs a private key")
+	ErrPublicOnly  = errors.New("Extended key has no private key")
+	ErrInvalidPath = errors.New("Derived key is invalid, use the next index")
+)
+
+// ExtendedKey is a BIP-32 extended public or private key
+type ExtendedKey struct {
+	key       []byte // 33 byte compressed public key or 32 byte private key
+	chainCode []byte
+	depth     uint8
+	parentFP  []byte
+	childNum  uint32
+	private   bool
+}
+
+// Parse reads a base58check xpub or xprv
+func Parse(encoded string) (*ExtendedKey, error) {
+	raw, err := base58.Decode(encoded)
+	if err != nil || len(raw) != 82 {
+		return nil, ErrInvalidKey
+	}
+	payload, checksum := raw[:78], raw[78:]
+	if !bytes.Equal(doubleSHA256(payload)[:4], checksum) {
+		return nil, ErrInvalidKey
+	}
+	k := &ExtendedKey{
+		depth:     payload[4],
+		parentFP:  payload[5:9],
+		childNum:  binary.BigEndian.Uint32(payload[9:13]),
+		chainCode: payload[13:45],
+	}
+	keyData := payload[45:78]
+	switch {
+	case bytes.Equal(payload[:4], versionPublic):
+		if _, err := btcec.ParsePubKey(keyData); err != nil {
+			return nil, ErrInvalidKey
+		}
+		k.key = keyData
+	case bytes.Equal(payload[:4], versionPrivate):
+		if keyData[0] != 0 {
+			return nil, ErrInvalidKey
+		}
+		k.key = keyData[1:]
+		k.private = true
+	default:
+		return nil, ErrInvalidKey
+	}
+	return k, nil
+}
+
+// NewMaster builds the master key of a seed (BIP-32 "Bitcoin seed" HMAC)
+func NewMaster(seed []byte) (*ExtendedKey, error) {
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	var scalar btcec.ModNScalar
+	if overflow := scalar.SetByteSlice(sum[:32]); overflow || scalar.IsZero() {
+		return nil, ErrInvalidKey
+	}
+	return &ExtendedKey{
+		key:       sum[:32],
+		chainCode: sum[32:],
+		parentFP:  []byte{0, 0, 0, 0},
+		private:   true,
+	}, nil
+}
+
+func (k *ExtendedKey) IsPrivate() bool {
+	return k.private
+}
+
+// Child derives child i, indexes from HardenedStart on need a private key
+func (k *ExtendedKey) Child(i uint32) (*ExtendedKey, error) {
+	if k.depth == 255 {
+		return nil, ErrInvalidKey
+	}
+	data := make([]byte, 0, 37)
+	if i >= HardenedStart {
+		if !k.private {
+			return nil, ErrHardened
+		}
+		data = append(data, 0)
+		data = append(data, k.key...)
+	} else {
+		data = append(data, k.publicKey()...)
+	}
+	data = binary.BigEndian.AppendUint32(data, i)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+	var tweak btcec.ModNScalar
+	if overflow := tweak.SetByteSlice(sum[:32]); overflow {
+		return nil, ErrInvalidPath
+	}
+
+	child := &ExtendedKey{
+		chainCode: sum[32:],
+		depth:     k.depth + 1,
+		parentFP:  hash160(k.publicKey())[:4],
+		childNum:  i,
+		private:   k.private,
+	}
+	if k.private {
+		var parent btcec.ModNScalar
+		parent.SetByteSlice(k.key)
+		tweak.Add(&parent)
+		if tweak.IsZero() {
+			return nil, ErrInvalidPath
+		}
+		childKey := tweak.Bytes()
+		child.key = childKey[:]
+		return child, nil
+	}
+
+	parentKey, err := btcec.ParsePubKey(k.key)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	var tweakPoint, parentPoint, sumPoint btcec.JacobianPoint
+	btcec.ScalarBaseMultNonConst(&tweak, &tweakPoint)
+	parentKey.AsJacobian(&parentPoint)
+	btcec.AddNonConst(&tweakPoint, &parentPoint, &sumPoint)
+	if (sumPoint.X.IsZero() && sumPoint.Y.IsZero()) || sumPoint.Z.IsZero() {
+		return nil, ErrInvalidPath
+	}
+	sumPoint.ToAffine()
+	child.key = btcec.NewPublicKey(&sumPoint.X, &sumPoint.Y).SerializeCompressed()
+	return child, nil
+}
+
+// Derive follows a path of child indexes from k
+func (k *ExtendedKey) Derive(path ...uint32) (*ExtendedKey, error) {
+	current := k
+	for _, i := range path {
+		child, err := current.Child(i)
+		if err != nil {
+			return nil, err
+		}
+		current = child
+	}
+	return current, nil
+}
+
+// Neuter returns the extended public key of k
+func (k *ExtendedKey) Neuter() *ExtendedKey {
+	if !k.private {
+		return k
+	}
+	return &ExtendedKey{
+		key:       k.publicKey(),
+		chainCode: k.chainCode,
+		depth:     k.depth,
+		parentFP:  k.parentFP,
+		childNum:  k.childNum,
+	}
+}
+
+// String serializes k as xpub or xprv
+func (k *ExtendedKey) String() string {
+	payload := make([]byte, 0, 82)
+	if k.private {
+		payload = append(payload, versionPrivate...)
+	} else {
+		payload = append(payload, versionPublic...)
+	}
+	payload = append(payload, k.depth)
+	payload = append(payload, k.parentFP...)
+	payload = binary.BigEndian.AppendUint32(payload, k.childNum)
+	payload = append(payload, k.chainCode...)
+	if k.private {
+		payload = append(payload, 0)
+	}
+	payload = append(payload, k.key...)
+	payload = append(payload, doubleSHA256(payload)[:4]...)
+	return base58.Encode(payload)
+}
+
+// Address is the EVM address of the key, lower case like the stored EADD
+func (k *ExtendedKey) Address() (string, error) {
+	pubKey, err := crypto.DecompressPubkey(k.publicKey())
+	if err != nil {
+		return "", ErrInvalidKey
+	}
+	return fmt.Sprintf("0x%x", crypto.PubkeyToAddress(*pubKey).Bytes()), nil
+}
+
+// PrivateKeyHex is the hex private key of an extended private key
+func (k *ExtendedKey) PrivateKeyHex() (string, error) {
+	if !k.private {
+		return "", ErrPublicOnly
+	}
+	return fmt.Sprintf("0x%x", k.key), nil
+}
+
+func (k *ExtendedKey) publicKey() []byte {
+	if !k.private {
+		return k.key
+	}
+	privKey, _ := btcec.PrivKeyFromBytes(k.key)
+	return privKey.PubKey().SerializeCompressed()
+}
+
+func doubleSHA256(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
+
+func hash160(data []byte) []byte {
+	sha := sha256.Sum256(data)
+	hasher := ripemd160.New()
+	hasher.Write(sha[:])
+	return hasher.Sum(nil)
+}
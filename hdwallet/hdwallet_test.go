@@ -0,0 +1,125 @@
+package hdwallet
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// BIP-32 test vector 1
+var vector1 = struct {
+	seed string
+	keys []struct {
+		path []uint32
+		xpub string
+		xprv string
+	}
+}{
+	seed: "000102030405060708090a0b0c0d0e0f",
+	keys: []struct {
+		path []uint32
+		xpub string
+		xprv string
+	}{
+		{nil,
+			"xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8",
+			"xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi"},
+		{[]uint32{HardenedStart},
+			"xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw",
+			"xprv9uHRZZhk6KAJC1avXpDAp4MDc3sQKNxDiPvvkX8Br5ngLNv1TxvUxt4cV1rGL5hj6KCesnDYUhd7oWgT11eZG7XnxHrnYeSvkzY7d2bhkJ7"},
+		{[]uint32{HardenedStart, 1},
+			"xpub6ASuArnXKPbfEwhqN6e3mwBcDTgzisQN1wXN9BJcM47sSikHjJf3UFHKkNAWbWMiGj7Wf5uMash7SyYq527Hqck2AxYysAA7xmALppuCkwQ",
+			"xprv9wTYmMFdV23N2TdNG573QoEsfRrWKQgWeibmLntzniatZvR9BmLnvSxqu53Kw1UmYPxLgboyZQaXwTCg8MSY3H2EU4pWcQDnRnrVA1xe8fs"},
+		{[]uint32{HardenedStart, 1, HardenedStart + 2},
+			"xpub6D4BDPcP2GT577Vvch3R8wDkScZWzQzMMUm3PWbmWvVJrZwQY4VUNgqFJPMM3No2dFDFGTsxxpG5uJh7n7epu4trkrX7x7DogT5Uv6fcLW5",
+			"xprv9z4pot5VBttmtdRTWfWQmoH1taj2axGVzFqSb8C9xaxKymcFzXBDptWmT7FwuEzG3ryjH4ktypQSAewRiNMjANTtpgP4mLTj34bhnZX7UiM"},
+		{[]uint32{HardenedStart, 1, HardenedStart + 2, 2},
+			"xpub6FHa3pjLCk84BayeJxFW2SP4XRrFd1JYnxeLeU8EqN3vDfZmbqBqaGJAyiLjTAwm6ZLRQUMv1ZACTj37sR62cfN7fe5JnJ7dh8zL4fiyLHV",
+			"xprvA2JDeKCSNNZky6uBCviVfJSKyQ1mDYahRjijr5idH2WwLsEd4Hsb2Tyh8RfQMuPh7f7RtyzTtdrbdqqsunu5Mm3wDvUAKRHSC34sJ7in334"},
+		{[]uint32{HardenedStart, 1, HardenedStart + 2, 2, 1000000000},
+			"xpub6H1LXWLaKsWFhvm6RVpEL9P4KfRZSW7abD2ttkWP3SSQvnyA8FSVqNTEcYFgJS2UaFcxupHiYkro49S8yGasTvXEYBVPamhGW6cFJodrTHy",
+			"xprvA41z7zogVVwxVSgdKUHDy1SKmdb533PjDz7J6N6mV6uS3ze1ai8FHa8kmHScGpWmj4WggLyQjgPie1rFSruoUihUZREPSL39UNdE3BBDu76"},
+	},
+}
+
+func TestVector1(t *testing.T) {
+	seed, _ := hex.DecodeString(vector1.seed)
+	master, err := NewMaster(seed)
+	if err != nil {
+		t.Fatalf("master: %v", err)
+	}
+	for _, want := range vector1.keys {
+		key, err := master.Derive(want.path...)
+		if err != nil {
+			t.Fatalf("%v: %v", want.path, err)
+		}
+		if got := key.String(); got != want.xprv {
+			t.Errorf("%v: xprv %s, want %s", want.path, got, want.xprv)
+		}
+		if got := key.Neuter().String(); got != want.xpub {
+			t.Errorf("%v: xpub %s, want %s", want.path, got, want.xpub)
+		}
+
+		parsed, err := Parse(want.xprv)
+		if err != nil || parsed.String() != want.xprv {
+			t.Errorf("%v: xprv doesn't survive Parse (%v)", want.path, err)
+		}
+		parsed, err = Parse(want.xpub)
+		if err != nil || parsed.IsPrivate() || parsed.String() != want.xpub {
+			t.Errorf("%v: xpub doesn't survive Parse (%v)", want.path, err)
+		}
+	}
+}
+
+func TestPublicDerivation(t *testing.T) {
+	xpub, _ := Parse(vector1.keys[1].xpub)
+	if _, err := xpub.Child(HardenedStart); err != ErrHardened {
+		t.Errorf("hardened child of an xpub: %v, want %v", err, ErrHardened)
+	}
+	// non-hardened children of the xpub are the public keys of the xprv's
+	xprv, _ := Parse(vector1.keys[1].xprv)
+	fromPrivate, _ := xprv.Child(1)
+	fromPublic, err := xpub.Child(1)
+	if err != nil {
+		t.Fatalf("child of xpub: %v", err)
+	}
+	if fromPublic.String() != fromPrivate.Neuter().String() {
+		t.Errorf("m/0'/1 from the xpub is %s, want %s", fromPublic, fromPrivate.Neuter())
+	}
+}
+
+// TestDepositAddress derives m/44'/60'/0'/0/i from HD_XPUB as the backend
+// does and from the xprv as the signing service does
+func TestDepositAddress(t *testing.T) {
+	seed, _ := hex.DecodeString(vector1.seed)
+	master, _ := NewMaster(seed)
+	account, err := master.Derive(HardenedStart+44, HardenedStart+60, HardenedStart)
+	if err != nil {
+		t.Fatalf("account key: %v", err)
+	}
+	t.Setenv("HD_XPUB", account.Neuter().String())
+
+	for i := uint32(0); i < 5; i++ {
+		address, err := DepositAddress(i)
+		if err != nil {
+			t.Fatalf("address %d: %v", i, err)
+		}
+		keyHex, err := DepositKey(account, i)
+		if err != nil {
+			t.Fatalf("key %d: %v", i, err)
+		}
+		key, err := crypto.HexToECDSA(strings.TrimPrefix(keyHex, "0x"))
+		if err != nil {
+			t.Fatalf("key %d: %v", i, err)
+		}
+		signer := strings.ToLower(crypto.PubkeyToAddress(key.PublicKey).Hex())
+		if address != signer {
+			t.Errorf("index %d: xpub gives %s, the xprv signs for %s", i, address, signer)
+		}
+	}
+	if _, err := DepositKey(account.Neuter(), 0); err == nil {
+		t.Errorf("deposit key from an xpub")
+	}
+}
@@ -0,0 +1,166 @@
+// Package keystore encrypts the custodial EVM keys stored in EKEY. Every key is
+// encrypted with its own random data key, and the data key is wrapped by the
+// master key of the Keyring:
+//
+//	tbk1:<master key ID>:<wrapped data key>:<nonce || ciphertext>
+//
+// Rotating the master key only rewraps the data keys, the keys themselves are
+// decrypted by the sweeper alone.
+package keystore
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+const prefix = "tbk1:"
+
+var (
+	ErrNotConfigured = errors.New("Keystore not configured")
+	ErrUnknownKey    = errors.New("Sealed with an unknown master key")
+	ErrCorrupt       = errors.New("Sealed key is corrupt")
+	ErrNotSealed     = errors.New("Key is stored in plaintext")
+)
+
+var encoding = base64.RawStdEncoding
+
+// IsSealed reports whether value is in the sealed format, anything else is a
+// plaintext key from before the keystore
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Seal encrypts key for the deposit address that owns it, a sealed key only
+// opens for the same address
+func Seal(key string, address string) (string, error) {
+	k, err := loadedKeyring()
+	if err != nil {
+		return "", err
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(aead, []byte(key), additionalData(address))
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := k.Current.Wrap(dataKey)
+	if err != nil {
+		return "", err
+	}
+	return format(k.Current.ID(), wrapped, ciphertext), nil
+}
+
+// Open decrypts a sealed key. Only the sweeper needs key material, nothing
+// else should call it.
+func Open(sealed string, address string) (string, error) {
+	if !IsSealed(sealed) {
+		return "", ErrNotSealed
+	}
+	k, err := loadedKeyring()
+	if err != nil {
+		return "", err
+	}
+	ID, wrapped, ciphertext, err := parse(sealed)
+	if err != nil {
+		return "", err
+	}
+	wrapper, found := k.wrapper(ID)
+	if !found {
+		return "", ErrUnknownKey
+	}
+	dataKey, err := wrapper.Unwrap(wrapped)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	key, err := open(aead, ciphertext, additionalData(address))
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+// NeedsRewrap reports whether value is plaintext or sealed under a master key
+// other than the current one
+func NeedsRewrap(value string) (bool, error) {
+	if !IsSealed(value) {
+		return true, nil
+	}
+	k, err := loadedKeyring()
+	if err != nil {
+		return false, err
+	}
+	ID, _, _, err := parse(value)
+	if err != nil {
+		return false, err
+	}
+	return ID != k.Current.ID(), nil
+}
+
+// Rewrap brings value under the current master key. Plaintext keys are sealed,
+// sealed keys only get their data key rewrapped and stay encrypted throughout.
+func Rewrap(value string, address string) (string, error) {
+	if !IsSealed(value) {
+		return Seal(value, address)
+	}
+	k, err := loadedKeyring()
+	if err != nil {
+		return "", err
+	}
+	ID, wrapped, ciphertext, err := parse(value)
+	if err != nil {
+		return "", err
+	}
+	if ID == k.Current.ID() {
+		return value, nil
+	}
+	wrapper, found := k.wrapper(ID)
+	if !found {
+		return "", ErrUnknownKey
+	}
+	dataKey, err := wrapper.Unwrap(wrapped)
+	if err != nil {
+		return "", err
+	}
+	rewrapped, err := k.Current.Wrap(dataKey)
+	if err != nil {
+		return "", err
+	}
+	return format(k.Current.ID(), rewrapped, ciphertext), nil
+}
+
+// additionalData binds a sealed key to its deposit address
+func additionalData(address string) []byte {
+	return []byte(strings.ToLower(address))
+}
+
+func format(ID string, wrapped []byte, ciphertext []byte) string {
+	return prefix + ID + ":" + encoding.EncodeToString(wrapped) + ":" + encoding.EncodeToString(ciphertext)
+}
+
+func parse(sealed string) (string, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(sealed, prefix), ":")
+	if len(parts) != 3 || parts[0] == "" {
+		return "", nil, nil, ErrCorrupt
+	}
+	wrapped, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, ErrCorrupt
+	}
+	ciphertext, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, ErrCorrupt
+	}
+	return parts[0], wrapped, ciphertext, nil
+}
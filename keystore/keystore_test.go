@@ -0,0 +1,127 @@
+package keystore_test
+
+import (
+	"bytes"
+	"strings"
+	"tbapi/keystore"
+	"testing"
+)
+
+const (
+	address = "0x00000000000000000000000000000000000A11CE"
+	key     = "4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318"
+)
+
+func localKey(t *testing.T, b byte) *keystore.LocalKey {
+	wrapper, err := keystore.NewLocalKey(bytes.Repeat([]byte{b}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wrapper
+}
+
+func TestSealOpen(t *testing.T) {
+	keystore.Use(&keystore.Keyring{Current: localKey(t, 1)})
+	sealed, err := keystore.Seal(key, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !keystore.IsSealed(sealed) || strings.Contains(sealed, key) {
+		t.Fatalf("sealed key %s", sealed)
+	}
+	again, _ := keystore.Seal(key, address)
+	if again == sealed {
+		t.Errorf("sealing twice gives the same value")
+	}
+
+	// the address is compared case insensitively
+	opened, err := keystore.Open(sealed, strings.ToLower(address))
+	if err != nil || opened != key {
+		t.Errorf("opened %q, %v", opened, err)
+	}
+	if _, err := keystore.Open(sealed, "0x0000000000000000000000000000000000000b0b"); err != keystore.ErrCorrupt {
+		t.Errorf("opening for another address: %v", err)
+	}
+	if _, err := keystore.Open(key, address); err != keystore.ErrNotSealed {
+		t.Errorf("opening a plaintext key: %v", err)
+	}
+	if isStale, err := keystore.NeedsRewrap(sealed); err != nil || isStale {
+		t.Errorf("a key sealed under the current master key needs a rewrap (%v)", err)
+	}
+}
+
+func TestTampered(t *testing.T) {
+	keystore.Use(&keystore.Keyring{Current: localKey(t, 1)})
+	sealed, err := keystore.Seal(key, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(sealed, ":")
+	// flip one character of the wrapped data key and of the ciphertext
+	for _, part := range []int{2, 3} {
+		tampered := append([]string(nil), parts...)
+		encoded := []byte(tampered[part])
+		if encoded[10] == 'A' {
+			encoded[10] = 'B'
+		} else {
+			encoded[10] = 'A'
+		}
+		tampered[part] = string(encoded)
+		if _, err := keystore.Open(strings.Join(tampered, ":"), address); err != keystore.ErrCorrupt {
+			t.Errorf("part %d tampered: %v", part, err)
+		}
+	}
+	truncated := sealed[:len(sealed)-4]
+	if _, err := keystore.Open(truncated, address); err != keystore.ErrCorrupt {
+		t.Errorf("truncated: %v", err)
+	}
+	for _, corrupt := range []string{"tbk1:", "tbk1::AAAA:AAAA", parts[0] + ":" + parts[1] + ":!!:" + parts[3], parts[0] + ":" + parts[1] + ":" + parts[2]} {
+		if _, err := keystore.Open(corrupt, address); err != keystore.ErrCorrupt {
+			t.Errorf("%q: %v", corrupt, err)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	old, current := localKey(t, 1), localKey(t, 2)
+	keystore.Use(&keystore.Keyring{Current: old})
+	sealed, err := keystore.Seal(key, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keystore.Use(&keystore.Keyring{Current: current, Previous: []keystore.KeyWrapper{old}})
+	if isStale, err := keystore.NeedsRewrap(sealed); err != nil || !isStale {
+		t.Errorf("a key sealed under the previous master key needs no rewrap (%v)", err)
+	}
+	rewrapped, err := keystore.Rewrap(sealed, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(rewrapped, "tbk1:"+current.ID()+":") {
+		t.Errorf("rewrapped under %s", rewrapped)
+	}
+	// only the data key changes, the key stays encrypted as it was
+	if strings.Split(rewrapped, ":")[3] != strings.Split(sealed, ":")[3] {
+		t.Errorf("rewrap changed the ciphertext")
+	}
+	if again, err := keystore.Rewrap(rewrapped, address); err != nil || again != rewrapped {
+		t.Errorf("rewrapping a current key changed it (%v)", err)
+	}
+	plaintext, err := keystore.Rewrap(key, address)
+	if err != nil || !keystore.IsSealed(plaintext) {
+		t.Errorf("plaintext key rewrapped to %q, %v", plaintext, err)
+	}
+
+	// once the old master key is gone only the rewrapped key opens
+	keystore.Use(&keystore.Keyring{Current: current})
+	if _, err := keystore.Open(sealed, address); err != keystore.ErrUnknownKey {
+		t.Errorf("opening under a retired master key: %v", err)
+	}
+	for _, value := range []string{rewrapped, plaintext} {
+		opened, err := keystore.Open(value, address)
+		if err != nil || opened != key {
+			t.Errorf("opened %q, %v", opened, err)
+		}
+	}
+}
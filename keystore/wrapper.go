@@ -0,0 +1,188 @@
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// KeyWrapper wraps and unwraps data keys with a master key. LocalKey keeps the
+// master key in process memory, a KMS backed implementation can be installed
+// with Use.
+type KeyWrapper interface {
+	// ID names the master key, it is stored next to every data key it wraps
+	// and must not contain ":"
+	ID() string
+	Wrap(dataKey []byte) ([]byte, error)
+	Unwrap(wrapped []byte) ([]byte, error)
+}
+
+// Keyring wraps new data keys with Current and can still unwrap data keys of
+// the previous master keys until they are rotated away
+type Keyring struct {
+	Current  KeyWrapper
+	Previous []KeyWrapper
+}
+
+func (k *Keyring) wrapper(ID string) (KeyWrapper, bool) {
+	if k.Current.ID() == ID {
+		return k.Current, true
+	}
+	for _, wrapper := range k.Previous {
+		if wrapper.ID() == ID {
+			return wrapper, true
+		}
+	}
+	return nil, false
+}
+
+// LocalKey is a 32 byte AES-256-GCM master key
+type LocalKey struct {
+	id  string
+	aes cipher.AEAD
+}
+
+// NewLocalKey builds a master key, its ID is derived from the key so the same
+// key always gets the same ID
+func NewLocalKey(key []byte) (*LocalKey, error) {
+	if len(key) != 32 {
+		return nil, errors.New("master key must be 32 bytes")
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &LocalKey{id: "local-" + hex.EncodeToString(sum[:4]), aes: aead}, nil
+}
+
+func (k *LocalKey) ID() string {
+	return k.id
+}
+
+func (k *LocalKey) Wrap(dataKey []byte) ([]byte, error) {
+	return seal(k.aes, dataKey, []byte(k.id))
+}
+
+func (k *LocalKey) Unwrap(wrapped []byte) ([]byte, error) {
+	return open(k.aes, wrapped, []byte(k.id))
+}
+
+var (
+	keyringOnce sync.Once
+	keyring     *Keyring
+	keyringErr  error
+	keyringMu   sync.RWMutex
+)
+
+// Use installs the keyring every later Seal, Open and Rewrap call uses, for
+// example one backed by a KMS
+func Use(k *Keyring) {
+	keyringOnce.Do(func() {})
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+	keyring, keyringErr = k, nil
+}
+
+// loadedKeyring reads the keyring from the environment on first use, after the
+// .env file has been loaded:
+//
+//	KEYSTORE_MASTER_KEY or KEYSTORE_MASTER_KEY_FILE   current master key (64 hex chars)
+//	KEYSTORE_OLD_KEYS or KEYSTORE_OLD_KEY_FILES       comma separated previous master keys
+func loadedKeyring() (*Keyring, error) {
+	keyringOnce.Do(func() {
+		keyring, keyringErr = keyringFromEnv()
+	})
+	keyringMu.RLock()
+	defer keyringMu.RUnlock()
+	return keyring, keyringErr
+}
+
+func keyringFromEnv() (*Keyring, error) {
+	current, err := readKeys("KEYSTORE_MASTER_KEY", "KEYSTORE_MASTER_KEY_FILE")
+	if err != nil {
+		return nil, err
+	}
+	if len(current) == 0 {
+		return nil, ErrNotConfigured
+	}
+	if len(current) > 1 {
+		return nil, errors.New("keystore: set only one of KEYSTORE_MASTER_KEY or KEYSTORE_MASTER_KEY_FILE")
+	}
+	previous, err := readKeys("KEYSTORE_OLD_KEYS", "KEYSTORE_OLD_KEY_FILES")
+	if err != nil {
+		return nil, err
+	}
+	return &Keyring{Current: current[0], Previous: previous}, nil
+}
+
+// readKeys parses the hex keys listed in valueVar and in the files listed in fileVar
+func readKeys(valueVar string, fileVar string) ([]KeyWrapper, error) {
+	encoded := splitList(os.Getenv(valueVar))
+	for _, path := range splitList(os.Getenv(fileVar)) {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("keystore: %s: %w", fileVar, err)
+		}
+		encoded = append(encoded, strings.TrimSpace(string(content)))
+	}
+	var wrappers []KeyWrapper
+	for _, value := range encoded {
+		key, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("keystore: %s is not hex", valueVar)
+		}
+		wrapper, err := NewLocalKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("keystore: %w", err)
+		}
+		wrappers = append(wrappers, wrapper)
+	}
+	return wrappers, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns nonce || ciphertext
+func seal(aead cipher.AEAD, plaintext []byte, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrCorrupt
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additional)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,171 @@
+// Package ledger keeps the append-only ledger_entries collection. Every balance
+// change is posted as a set of entries that sum to zero per asset, the balance
+// fields of tb_accounts are a projection of those entries kept up to date in
+// the same transaction.
+package ledger
+
+import (
+	"context"
+	"strings"
+	"tbapi/chains"
+	"tbapi/modals"
+	"tbapi/money"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const Collection = "ledger_entries"
+
+// system accounts have no tb_accounts document, they only exist in the ledger
+const (
+	External    = "system:external"    // funds that entered or left through a chain
+	Escrow      = "system:escrow"      // amounts locked by open swap orders
+	Staking     = "system:staking"     // TBYT locked by active stakes
+	Mining      = "system:mining"      // TBYT issued as staking rewards
+	Opening     = "system:opening"     // balances that existed before the ledger
+	Withdrawing = "system:withdrawing" // withdrawals not yet confirmed on chain
+	Fees        = "system:fees"        // network fees charged on withdrawals
+	Revenue     = "system:revenue"     // trading fees kept from exchange fills
+)
+
+// posting kinds
+const (
+	KindDeposit    = "deposit"
+	KindTransfer   = "transfer"
+	KindSwap       = "swap"
+	KindFill       = "fill"
+	KindCancel     = "cancel"
+	KindStake      = "stake"
+	KindPayout     = "payout"
+	KindOpening    = "opening"
+	KindWithdrawal = "withdrawal"
+	KindReversal   = "reversal" // a credited deposit whose block left the chain
+)
+
+// Fields are the balance fields of tb_accounts that hold assets, TBT and one
+// per chain of the registry. NPT and NPTP are statistics and stay out of the
+// ledger.
+func Fields() []string {
+	return append([]string{"TBT"}, chains.Names()...)
+}
+
+// Entry is one side of a posting, AMT is positive for a credit and negative for
+// a debit of ACC
+type Entry struct {
+	EID  primitive.ObjectID   `bson:"_id,omitempty"`
+	TXN  primitive.ObjectID   `bson:"TXN"`  // posting the entry belongs to
+	ACC  string               `bson:"ACC"`  // account ID or system account
+	AST  string               `bson:"AST"`  // balance field
+	AMT  primitive.Decimal128 `bson:"AMT"`  // signed amount
+	KIND string               `bson:"KIND"` // posting kind
+	REF  string               `bson:"REF"`  // order, stake or deposit the posting belongs to
+	TMP  int64                `bson:"TMP"`
+}
+
+// Leg is one balance change of a posting
+type Leg struct {
+	Account string
+	Field   string
+	Amount  money.Amount
+}
+
+func Debit(account string, field string, amount money.Amount) Leg {
+	return Leg{Account: account, Field: field, Amount: amount.Neg()}
+}
+
+func Credit(account string, field string, amount money.Amount) Leg {
+	return Leg{Account: account, Field: field, Amount: amount}
+}
+
+// IsSystem reports whether account is a ledger only system account
+func IsSystem(account string) bool {
+	return strings.HasPrefix(account, "system:")
+}
+
+// Post applies the legs to the account balances and appends their entries. The
+// legs of each field must sum to zero. Debits of user accounts carry the
+// balance floor of modals.IncBalances, so ctx should be the session context of
+// the surrounding transaction for a failed posting to leave nothing behind.
+func Post(ctx context.Context, db *mongo.Database, kind string, ref string, legs ...Leg) (bool, string) {
+	if !isBalanced(legs) {
+		return false, "Unbalanced ledger posting"
+	}
+	accounts := db.Collection("tb_accounts")
+
+	// one update per user account so a debit and a credit of the same account
+	// can't be split
+	deltas := map[string]map[string]money.Amount{}
+	var order []string
+	for _, leg := range legs {
+		if IsSystem(leg.Account) {
+			continue
+		}
+		if deltas[leg.Account] == nil {
+			deltas[leg.Account] = map[string]money.Amount{}
+			order = append(order, leg.Account)
+		}
+		deltas[leg.Account][leg.Field] = deltas[leg.Account][leg.Field].Add(leg.Amount)
+	}
+	for _, account := range order {
+		isUpdated, message := modals.IncBalances(ctx, accounts, account, deltas[account])
+		if !isUpdated {
+			return false, message
+		}
+	}
+
+	return appendEntries(ctx, db, kind, ref, legs)
+}
+
+// appendEntries writes the entries of a posting without touching tb_accounts
+func appendEntries(ctx context.Context, db *mongo.Database, kind string, ref string, legs []Leg) (bool, string) {
+	TXN := primitive.NewObjectID()
+	TMP := time.Now().UTC().Unix()
+	entries := make([]interface{}, 0, len(legs))
+	for _, leg := range legs {
+		if leg.Amount.IsZero() {
+			continue
+		}
+		entries = append(entries, Entry{
+			TXN:  TXN,
+			ACC:  leg.Account,
+			AST:  leg.Field,
+			AMT:  leg.Amount.Decimal128(),
+			KIND: kind,
+			REF:  ref,
+			TMP:  TMP,
+		})
+	}
+	if len(entries) == 0 {
+		return true, ""
+	}
+	if _, err := db.Collection(Collection).InsertMany(ctx, entries); err != nil {
+		return false, "Could not record ledger entries"
+	}
+	return true, ""
+}
+
+func isBalanced(legs []Leg) bool {
+	sums := map[string]money.Amount{}
+	for _, leg := range legs {
+		sums[leg.Field] = sums[leg.Field].Add(leg.Amount)
+	}
+	for _, sum := range sums {
+		if !sum.IsZero() {
+			return false
+		}
+	}
+	return true
+}
+
+// EnsureIndexes creates the indexes verify and the account history rely on
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(Collection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "ACC", Value: 1}, {Key: "TMP", Value: 1}}},
+		{Keys: bson.D{{Key: "TXN", Value: 1}}},
+		{Keys: bson.D{{Key: "REF", Value: 1}}},
+	})
+	return err
+}
@@ -0,0 +1,178 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"tbapi/modals"
+	"tbapi/money"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Drift is a balance field that doesn't match the sum of its ledger entries
+type Drift struct {
+	Account string
+	Field   string
+	Stored  money.Amount
+	Ledger  money.Amount
+}
+
+// Report is the outcome of Verify
+type Report struct {
+	Accounts   int
+	Drifts     []Drift
+	Unbalanced []primitive.ObjectID    // postings whose entries don't sum to zero
+	System     map[string]money.Amount // "account field" totals of the system accounts
+}
+
+type balanceKey struct {
+	Account string
+	Field   string
+}
+
+// Verify recomputes every account from its ledger entries and compares the
+// result with the stored balances
+func Verify(ctx context.Context, db *mongo.Database) (Report, error) {
+	report := Report{System: map[string]money.Amount{}}
+	sums, err := ledgerBalances(ctx, db)
+	if err != nil {
+		return report, err
+	}
+
+	seen := map[string]bool{}
+	err = eachAccount(ctx, db, func(account modals.User) {
+		report.Accounts++
+		seen[account.ID] = true
+		for _, field := range Fields() {
+			stored := account.BalanceOf(field)
+			projected := sums[balanceKey{account.ID, field}]
+			if !stored.Equal(projected) {
+				report.Drifts = append(report.Drifts, Drift{account.ID, field, stored, projected})
+			}
+		}
+	})
+	if err != nil {
+		return report, err
+	}
+	for key, sum := range sums {
+		if IsSystem(key.Account) {
+			report.System[key.Account+" "+key.Field] = sum
+		} else if !seen[key.Account] && !sum.IsZero() {
+			report.Drifts = append(report.Drifts, Drift{key.Account, key.Field, money.Zero(), sum})
+		}
+	}
+
+	report.Unbalanced, err = unbalancedPostings(ctx, db)
+	return report, err
+}
+
+// Open posts the balances that predate the ledger as opening entries against
+// Opening, so Verify starts from zero drift. It only covers the difference to
+// the existing entries and can be run again safely.
+func Open(ctx context.Context, db *mongo.Database) (int, error) {
+	sums, err := ledgerBalances(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	var accounts []modals.User
+	err = eachAccount(ctx, db, func(account modals.User) {
+		accounts = append(accounts, account)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	opened := 0
+	for _, account := range accounts {
+		var legs []Leg
+		for _, field := range Fields() {
+			missing := account.BalanceOf(field).Sub(sums[balanceKey{account.ID, field}])
+			if missing.IsZero() {
+				continue
+			}
+			legs = append(legs, Credit(account.ID, field, missing), Debit(Opening, field, missing))
+		}
+		if len(legs) == 0 {
+			continue
+		}
+		isRecorded, message := appendEntries(ctx, db, KindOpening, account.ID, legs)
+		if !isRecorded {
+			return opened, errors.New(message)
+		}
+		opened++
+	}
+	return opened, nil
+}
+
+func eachAccount(ctx context.Context, db *mongo.Database, fn func(account modals.User)) error {
+	cursor, err := db.Collection("tb_accounts").Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var account modals.User
+		if err := cursor.Decode(&account); err != nil {
+			return err
+		}
+		fn(account)
+	}
+	return cursor.Err()
+}
+
+func ledgerBalances(ctx context.Context, db *mongo.Database) (map[balanceKey]money.Amount, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"ACC": "$ACC", "AST": "$AST"},
+			"SUM": bson.M{"$sum": "$AMT"},
+		}}},
+	}
+	cursor, err := db.Collection(Collection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID struct {
+			ACC string `bson:"ACC"`
+			AST string `bson:"AST"`
+		} `bson:"_id"`
+		SUM primitive.Decimal128 `bson:"SUM"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	sums := map[balanceKey]money.Amount{}
+	for _, row := range rows {
+		sums[balanceKey{row.ID.ACC, row.ID.AST}] = modals.Balance(row.SUM)
+	}
+	return sums, nil
+}
+
+func unbalancedPostings(ctx context.Context, db *mongo.Database) ([]primitive.ObjectID, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"TXN": "$TXN", "AST": "$AST"},
+			"SUM": bson.M{"$sum": "$AMT"},
+		}}},
+		{{Key: "$match", Value: bson.M{"SUM": bson.M{"$ne": 0}}}},
+	}
+	cursor, err := db.Collection(Collection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID struct {
+			TXN primitive.ObjectID `bson:"TXN"`
+		} `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	var postings []primitive.ObjectID
+	for _, row := range rows {
+		postings = append(postings, row.ID.TXN)
+	}
+	return postings, nil
+}
@@ -2,28 +2,21 @@ package modals
 
 import (
 	"context"
-	"crypto/ecdsa"
 	"encoding/json"
-	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"strings"
+	"tbapi/hdwallet"
+	"tbapi/money"
 	"time"
 
-	"github.com/ethereum/go-ethereum/crypto"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func CreateAccount(r *http.Request) (string, string) {
-	db, err := ConnectDB()
-
-	if err != nil {
-		return "false", "API Database Error"
-	}
-	// Database collections
-	accounts := db.Collection("tb_accounts")
-
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		return "false", "API Request Body Error"
@@ -48,9 +41,23 @@ func CreateAccount(r *http.Request) (string, string) {
 		return "false", "Request Malformed"
 	}
 
+	return NewAccount(walletsDetailList[0], walletsDetailList[1])
+}
+
+// NewAccount registers the account ID with an optional referrer ("NIL" for none)
+// and returns the generated deposit address
+func NewAccount(ID string, REF string) (string, string) {
+	db, err := ConnectDB()
+
+	if err != nil {
+		return "false", "API Database Error"
+	}
+	// Database collections
+	accounts := db.Collection("tb_accounts")
+
 	walletsDetail := make(map[string]string)
-	walletsDetail["ID"] = walletsDetailList[0]
-	walletsDetail["REF"] = walletsDetailList[1]
+	walletsDetail["ID"] = ID
+	walletsDetail["REF"] = REF
 	// Check referral
 	var addREF = false
 	var refs []string
@@ -67,7 +74,9 @@ func CreateAccount(r *http.Request) (string, string) {
 	}
 
 	// Insert a new account
-	isCreated, backendWalletData, message := CreateWallet()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	isCreated, backendWalletData, message := NextDepositWallet(ctx, accounts.Database())
 	if !isCreated {
 		return "false", message
 	}
@@ -96,24 +105,23 @@ func checkReferal(tbWallets *mongo.Collection, referal string) (bool, string, []
 	return true, "", result.REFS
 }
 
-func insertAccount(walletsDetail map[string]string, accounts *mongo.Collection, isAddREF bool, REFS []string, backendWalletData CrWallet) bool {
+func insertAccount(walletsDetail map[string]string, accounts *mongo.Collection, isAddREF bool, REFS []string, backendWalletData DepositWallet) bool {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	upperCaseAddress := strings.ToLower(backendWalletData.Address)
 	accountJson := bson.M{
 		"ID":      walletsDetail["ID"],
 		"EADD":    upperCaseAddress,
-		"EKEY":    backendWalletData.Key,
-		"TBT":     "0.00",
-		"POS":     "0.00",
-		"ERC":     "0.00",
-		"NPT":     "0.00",
-		"NPTP":    "0.00",
+		"HDIX":    backendWalletData.HDIX,
 		"REFB":    walletsDetail["REF"],
 		"REFS":    []string{},
 		"REFRESH": "0,0",
 	}
 
+	for _, field := range BalanceFields() {
+		accountJson[field] = money.Zero().Decimal128()
+	}
+
 	_, err := accounts.InsertOne(ctx, accountJson)
 	if err != nil {
 		return false
@@ -136,33 +144,38 @@ func AddReferrals(accounts *mongo.Collection, refree string, referer string, REF
 	return err == nil
 }
 
-type CrWallet struct {
+// DepositWallet is a deposit address derived from the HD wallet, only its
+// index is stored, the signing service re-derives the key
+type DepositWallet struct {
 	Address string
-	Key     string
+	HDIX    int64
 }
 
-func CreateWallet() (bool, CrWallet, string) {
-	var CreatedWallet CrWallet
-	privateKey, err := crypto.GenerateKey()
-	if err != nil {
-		return false, CrWallet{}, "Can't generate private key"
-	}
-
-	// Convert private key to bytes
-	privateKeyBytes := crypto.FromECDSA(privateKey)
-	privateKeyHex := fmt.Sprintf("0x%x", privateKeyBytes)
-
-	// Get public key
-	publicKey := privateKey.Public()
-	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
-	if !ok {
-		return false, CrWallet{}, "Can't generate backend wallets"
+// NextDepositWallet reserves the next HD index and derives its address. Indexes
+// start at 1, an HDIX of 0 marks an account still on a legacy random key.
+func NextDepositWallet(ctx context.Context, db *mongo.Database) (bool, DepositWallet, string) {
+	counters := db.Collection("counters")
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	for {
+		var counter struct {
+			SEQ int64 `bson:"SEQ"`
+		}
+		err := counters.FindOneAndUpdate(ctx, bson.M{"_id": "depositIndex"}, bson.M{"$inc": bson.M{"SEQ": 1}}, opts).Decode(&counter)
+		if err != nil {
+			return false, DepositWallet{}, "Can't reserve deposit address"
+		}
+		if counter.SEQ >= int64(hdwallet.HardenedStart) {
+			return false, DepositWallet{}, "Deposit addresses exhausted"
+		}
+		address, err := hdwallet.DepositAddress(uint32(counter.SEQ))
+		if err == hdwallet.ErrInvalidPath {
+			// BIP-32 skips the rare index without a valid key
+			continue
+		}
+		if err != nil {
+			log.Printf("Can't derive deposit address: %v", err)
+			return false, DepositWallet{}, "Can't generate backend wallets"
+		}
+		return true, DepositWallet{Address: address, HDIX: counter.SEQ}, ""
 	}
-
-	// Get wallet address
-	address := crypto.PubkeyToAddress(*publicKeyECDSA).Hex()
-	CreatedWallet.Address = address
-	CreatedWallet.Key = privateKeyHex
-
-	return true, CreatedWallet, ""
 }
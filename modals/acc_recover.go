@@ -19,19 +19,11 @@ import (
 )
 
 type Wallet struct {
-	EADD string `"bson":  "EADD"` // Tulobyte Balance
-	TADD string `"bson":  "TADD"` // Polygon USDT Bal
+	EADD string `bson:"EADD"` // Tulobyte Balance
+	TADD string `bson:"TADD"` // Polygon USDT Bal
 }
 
 func RecoverAccount(r *http.Request) (string, string) {
-	db, err := ConnectDB()
-
-	if err != nil {
-		return "false", "API Database Error"
-	}
-	// Database collections
-	accounts := db.Collection("tb_accounts")
-
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		return "false", "API Database Error"
@@ -57,19 +49,36 @@ func RecoverAccount(r *http.Request) (string, string) {
 	}
 	address := walletsDetailList[0]
 	walletKey := walletsDetailList[1]
-	validKey := CheckKey(walletKey, address)
+	validKey := CheckAuth(walletKey, address)
 	if !validKey {
 		return "false", "You're trying to bypass"
 	}
+	isRecovered, message, walletData := RecoverWallet(address)
+	if !isRecovered {
+		return "false", message
+	}
+	return "true", fmt.Sprintf("%s,%s", walletData.EADD, walletData.TADD)
+}
+
+// RecoverWallet returns the deposit wallet of an already authenticated account
+func RecoverWallet(address string) (bool, string, Wallet) {
+	db, err := ConnectDB()
+
+	if err != nil {
+		return false, "API Database Error", Wallet{}
+	}
+	// Database collections
+	accounts := db.Collection("tb_accounts")
+
 	isReferal, _, _ := checkReferal(accounts, address)
 	if !isReferal {
-		return "false", "No Account Found"
+		return false, "No Account Found", Wallet{}
 	}
 	walletData, isWallet := getWalletDetail(address, accounts)
 	if !isWallet {
-		return "false", "No Account Found"
+		return false, "No Account Found", Wallet{}
 	}
-	return "true", fmt.Sprintf("%s,%s", walletData.EADD, walletData.TADD)
+	return true, "", walletData
 }
 
 func getWalletDetail(address string, tbWallets *mongo.Collection) (Wallet, bool) {
@@ -98,7 +107,11 @@ func CheckKey(walletKey string, caddress string) bool {
 
 	// Get uncompressed public key
 	pubKey := privKey.PubKey().SerializeUncompressed()
+	return tronAddress(pubKey) == caddress
+}
 
+// tronAddress is the Tron style base58check address of an uncompressed public key
+func tronAddress(pubKey []byte) string {
 	// Hash the public key (skip the first byte - 0x04)
 	hash := crypto.Keccak256(pubKey[1:])
 
@@ -108,12 +121,7 @@ func CheckKey(walletKey string, caddress string) bool {
 	// Prepend Tron address prefix 0x41
 	tronAddress := append([]byte{0x41}, address...)
 	// Base58Check encode
-	base58CheckAddr := base58.Encode(addCheckSum(tronAddress))
-	if base58CheckAddr == caddress {
-		return true
-	} else {
-		return false
-	}
+	return base58.Encode(addCheckSum(tronAddress))
 }
 
 func addCheckSum(input []byte) []byte {
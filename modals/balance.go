@@ -0,0 +1,61 @@
+package modals
+
+import (
+	"context"
+	"tbapi/chains"
+	"tbapi/money"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BalanceFields are the account fields stored as Decimal128: TBT, one per
+// chain of the registry, NPT and NPTP
+func BalanceFields() []string {
+	fields := append([]string{"TBT"}, chains.Names()...)
+	return append(fields, "NPT", "NPTP")
+}
+
+// Balance reads a stored balance, unset or unreadable fields read as zero
+func Balance(d primitive.Decimal128) money.Amount {
+	amount, err := money.FromDecimal128(d)
+	if err != nil {
+		return money.Zero()
+	}
+	return amount
+}
+
+// IncBalance atomically adds delta to one balance field of the account. A debit
+// (negative delta) only matches while the stored balance still covers it, so two
+// concurrent requests can neither overdraw the account nor overwrite each other.
+func IncBalance(ctx context.Context, accounts *mongo.Collection, address string, field string, delta money.Amount) (bool, string) {
+	return IncBalances(ctx, accounts, address, map[string]money.Amount{field: delta})
+}
+
+// IncBalances applies several balance changes to one account in a single update,
+// every debit carries the same floor filter as IncBalance
+func IncBalances(ctx context.Context, accounts *mongo.Collection, address string, deltas map[string]money.Amount) (bool, string) {
+	filter := bson.M{"ID": address}
+	inc := bson.M{}
+	for field, delta := range deltas {
+		if delta.IsZero() {
+			continue
+		}
+		if delta.Sign() < 0 {
+			filter[field] = bson.M{"$gte": delta.Neg().Decimal128()}
+		}
+		inc[field] = delta.Decimal128()
+	}
+	if len(inc) == 0 {
+		return true, ""
+	}
+	result, err := accounts.UpdateOne(ctx, filter, bson.M{"$inc": inc})
+	if err != nil {
+		return false, "Could not update balance"
+	}
+	if result.MatchedCount == 0 {
+		return false, "Insufficient Balance"
+	}
+	return true, ""
+}
@@ -2,9 +2,10 @@ package modals
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -12,35 +13,41 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// MongoDB connection URI
-
-// MongoDB client instance (singleton)
-var client *mongo.Client
+// MongoDB client instance (singleton), set up by the first ConnectDB
+var (
+	client     *mongo.Client
+	clientErr  error
+	clientOnce sync.Once
+)
 
 // ConnectDB establishes a connection to MongoDB
 func ConnectDB() (*mongo.Database, error) {
+	clientOnce.Do(func() {
+		client, clientErr = connect()
+	})
+	if clientErr != nil {
+		return nil, clientErr
+	}
 
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
+	dbName := os.Getenv("DB_NAME")
+	if dbName == "" {
+		dbName = "tulobyte_db"
 	}
+	return client.Database(dbName), nil
+}
+
+// connect creates the client. A missing .env is fine, the process environment
+// is used as it is.
+func connect() (*mongo.Client, error) {
+	_ = godotenv.Load()
 
 	dbPassword := os.Getenv("DB_PASSWORD")
 	if dbPassword == "" {
-		return nil, err
+		return nil, errors.New("DB_PASSWORD is not set")
 	}
 
 	uri := fmt.Sprintf("mongodb://superadmin:%s@localhost:27017/admin", dbPassword)
-	if client == nil { // Create client if not initialized
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		var err error
-		client, err = mongo.Connect(ctx, options.Client().ApplyURI(uri))
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	return client.Database("tulobyte_db"), nil
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return mongo.Connect(ctx, options.Client().ApplyURI(uri))
 }
@@ -2,45 +2,40 @@ package modals
 
 import (
 	"context"
-	"fmt"
-	"strings"
-	"time"
+	"tbapi/keystore"
+	"tbapi/money"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func InsertIntoSecWallets(walletAddress string, walletKey string, usdtAmount float64, secWallets *mongo.Collection) bool {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	usdtAmountString := fmt.Sprintf("%f", usdtAmount)
+// InsertIntoSecWallets queues the deposit wallet for sweeping on chainChoice.
+// HD wallets are queued by index; a legacy walletKey is copied sealed, and only
+// sealed now if the account still holds it in plaintext.
+func InsertIntoSecWallets(ctx context.Context, walletAddress string, walletKey string, HDIX int64, chainChoice string, amount money.Amount, secWallets *mongo.Collection) bool {
+	asset, isKnown := money.Lookup(chainChoice)
+	if !isKnown {
+		return false
+	}
 	accountJson := bson.M{
-		"ADD":  walletAddress,
-		"EKEY": walletKey,
-		"AMT":  usdtAmountString,
+		"ADD":    walletAddress,
+		"AMT":    amount.Format(asset),
+		"CHAIN":  chainChoice,
+		"STATUS": "pending",
+	}
+	if HDIX > 0 {
+		accountJson["HDIX"] = HDIX
+	} else {
+		if !keystore.IsSealed(walletKey) {
+			sealedKey, err := keystore.Seal(walletKey, walletAddress)
+			if err != nil {
+				return false
+			}
+			walletKey = sealedKey
+		}
+		accountJson["EKEY"] = walletKey
 	}
 
 	_, err := secWallets.InsertOne(ctx, accountJson)
 	return err == nil
 }
-
-func CreateAndSaveNewAddress(walletAddress string, accountCollection *mongo.Collection) (bool, string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	isCreated, WalletData, message := CreateWallet()
-	if !isCreated {
-		return false, message
-	}
-	newAddress := WalletData.Address
-	newKey := WalletData.Key
-	update := bson.M{"$set": bson.M{
-		"EADD": strings.ToLower(newAddress),
-		"EKEY": newKey,
-	}}
-	filter := bson.M{"ID": walletAddress}
-	_, err := accountCollection.UpdateOne(ctx, filter, update)
-	if err != nil {
-		return false, ""
-	}
-	return true, strings.ToLower(newAddress)
-}
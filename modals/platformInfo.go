@@ -4,18 +4,96 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math/big"
 	"net/http"
-	"strconv"
+	"tbapi/chains"
+	"tbapi/money"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// Fees is the updatedFee document of platformInfo, kept current by the fee
+// oracle of tbworker. polygon and eth are USD prices, the coins of other chains
+// are priced under their feePrice key. gwei is the single gas price of
+// documents from before the oracle, in coin per gas.
 type Fees struct {
-	Polygon string `bson:"polygon"`
-	Eth     string `bson:"eth"`
-	Gwei    string `bson:"gwei"`
+	Polygon       string              `bson:"polygon"`
+	Eth           string              `bson:"eth"`
+	Gwei          string              `bson:"gwei"`
+	Chains        map[string]ChainFee `bson:"chains"` // by chain name
+	PricesUpdated int64               `bson:"pricesUpdated"`
+	Other         bson.M              `bson:",inline"` // prices of the coins of other chains
+}
+
+// ChainFee is the EIP-1559 fee market of one chain as last sampled, in wei
+type ChainFee struct {
+	BaseFee     string `bson:"baseFee"`     // base fee of the next block
+	PriorityFee string `bson:"priorityFee"` // median tip of recent blocks
+	Block       int64  `bson:"block"`       // newest block sampled
+	Updated     int64  `bson:"updated"`
+}
+
+// TransferGas is the gas an ERC-20 transfer is quoted at
+const TransferGas = 65000
+
+// Price reads the USD price stored under key (polygon, eth or the feePrice of
+// a registry chain)
+func (f Fees) Price(key string) (*big.Rat, bool) {
+	var value string
+	switch key {
+	case "polygon":
+		value = f.Polygon
+	case "eth":
+		value = f.Eth
+	default:
+		value = fmt.Sprint(f.Other[key])
+	}
+	return positiveRat(value)
+}
+
+// GasPrice is the price of one unit of gas on the named chain in its coin,
+// from the sampled fees or else the legacy gwei field
+func (f Fees) GasPrice(chain string) (*big.Rat, bool) {
+	if fee, found := f.Chains[chain]; found {
+		base, isBase := new(big.Int).SetString(fee.BaseFee, 10)
+		tip, isTip := new(big.Int).SetString(fee.PriorityFee, 10)
+		if isBase && isTip && base.Add(base, tip).Sign() > 0 {
+			return new(big.Rat).SetFrac(base, big.NewInt(1e18)), true
+		}
+	}
+	return positiveRat(f.Gwei)
+}
+
+// TransferCost is the exact USD cost of a token transfer on chain
+func (f Fees) TransferCost(chain chains.Chain) (*big.Rat, bool) {
+	gasPrice, isKnown := f.GasPrice(chain.Name)
+	if !isKnown {
+		return nil, false
+	}
+	price, isPriced := f.Price(chain.FeePrice)
+	if !isPriced {
+		return nil, false
+	}
+	cost := new(big.Rat).Mul(gasPrice, price)
+	return cost.Mul(cost, big.NewRat(TransferGas, 1)), true
+}
+
+func positiveRat(value string) (*big.Rat, bool) {
+	r, isNumber := new(big.Rat).SetString(value)
+	if !isNumber || r.Sign() <= 0 {
+		return nil, false
+	}
+	return r, true
+}
+
+type PlatformSummary struct {
+	PolygonFee float64
+	EthFee     float64
+	Transfer   map[string]float64 // USD cost of a token transfer by asset code
+	Currency   Currency
 }
 
 func PlatformInfo(r *http.Request) (string, string) {
@@ -24,36 +102,52 @@ func PlatformInfo(r *http.Request) (string, string) {
 	if err != nil {
 		return "false", "API Database Error"
 	}
-	Cost, isGot := getFees()
+	isGot, message, summary := GetPlatformSummary()
 	if !isGot {
-		return "false", "Cannot Get Fees Data"
+		return "false", message
 	}
-	gweiCostFloat, err := strconv.ParseFloat(Cost.Gwei, 64)
-	if err != nil {
-		return "false", "Backend Error"
+	Info := summary.Currency
+
+	returnString := fmt.Sprintf("%f,%f,%s,%s,%s,%s", summary.PolygonFee, summary.EthFee, Info.TotalSupply, Info.MaxSupply, Info.Mined, Info.Holder)
+	return "true", returnString
+}
+
+// GetPlatformSummary returns the USD cost of a token transfer on each chain
+// that can be priced together with the TBYT supply figures
+func GetPlatformSummary() (bool, string, PlatformSummary) {
+	Cost, isGot := GetFees()
+	if !isGot {
+		return false, "Cannot Get Fees Data", PlatformSummary{}
 	}
-	ethFloat, err := strconv.ParseFloat(Cost.Eth, 64)
-	if err != nil {
-		return "false", "Backend Error"
+	summary := PlatformSummary{Transfer: map[string]float64{}}
+	for _, chain := range chains.All() {
+		cost, isPriced := Cost.TransferCost(chain)
+		if !isPriced {
+			continue
+		}
+		usd, _ := cost.Float64()
+		summary.Transfer[chain.Asset] = usd
+		switch chain.Name {
+		case "POS":
+			summary.PolygonFee = usd
+		case "ERC":
+			summary.EthFee = usd
+		}
 	}
-	polFloat, err := strconv.ParseFloat(Cost.Polygon, 64)
-	if err != nil {
-		return "false", "Backend Error"
+	if len(summary.Transfer) == 0 {
+		return false, "Backend Error", PlatformSummary{}
 	}
-	evmFees := 21000.0 * gweiCostFloat
-	ethFeesUSD := evmFees * ethFloat
-
-	polFees := evmFees * polFloat
 	Info, isGot := GetPlatformInfo()
 	if !isGot {
-		return "false", "Cannot Get Info Data"
+		return false, "Cannot Get Info Data", PlatformSummary{}
 	}
 
-	returnString := fmt.Sprintf("%f,%f,%s,%s,%s,%s", polFees, ethFeesUSD, Info.TotalSupply, Info.MaxSupply, Info.Mined, Info.Holder)
-	return "true", returnString
+	summary.Currency = Info
+	return true, "", summary
 }
 
-func getFees() (Fees, bool) {
+// GetFees reads the updatedFee document of platformInfo
+func GetFees() (Fees, bool) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	db, err := ConnectDB()
@@ -68,9 +162,7 @@ func getFees() (Fees, bool) {
 	var result Fees
 	err = fees.FindOne(ctx, filter).Decode(&result)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return result, false
-		}
+		return result, false
 	}
 
 	return result, true
@@ -80,10 +172,31 @@ func getFees() (Fees, bool) {
 type Currency struct {
 	TotalSupply string `bson:"totalSupply"`
 	MaxSupply   string `bson:"maxSupply"`
-	Mined       string `bson:"mined"`
+	Mined       Supply `bson:"mined"`
 	Holder      string `bson:"holders"`
 }
 
+// Supply is a TBYT figure of currencyInfo. mined is a Decimal128 the payouts
+// $inc, documents from before that hold a string.
+type Supply string
+
+func (s *Supply) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	value := bson.RawValue{Type: t, Value: data}
+	switch t {
+	case bsontype.Decimal128:
+		amount, err := money.FromDecimal128(value.Decimal128())
+		if err != nil {
+			return err
+		}
+		*s = Supply(amount.Fixed(6))
+	case bsontype.String:
+		*s = Supply(value.StringValue())
+	default:
+		return fmt.Errorf("supply of bson type %s", t)
+	}
+	return nil
+}
+
 func GetPlatformInfo() (Currency, bool) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
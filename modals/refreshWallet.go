@@ -6,8 +6,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"strconv"
 	"strings"
+	"tbapi/money"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -25,28 +25,42 @@ type Stake struct {
 	STAT string             `bson:"STAT"`
 }
 type User struct {
-	ID      string   `bson:"ID"`      // Unique ID
-	TBT     string   `bson:"TBT"`     // Tulobyte Balance
-	POS     string   `bson:"POS"`     // Polygon USDT Bal
-	ERC     string   `bson:"ERC"`     // ERC Usdt Bal
-	NPT     string   `bson:"NPT"`     // Net Profit
-	NPTP    string   `bson:"NPTP"`    // Net Profit Percentage
-	EADD    string   `bson:"EADD"`    // Ethereium Address
-	REFB    string   `bson:"REF"`     // Referred By Address
-	REFS    []string `bson:"REFS"`    // Refererals
-	EKEY    string   `bson:"EKEY"`    // EVM private key
-	REFRESH string   `bson:"REFRESH"` // EVM private key
+	ID      string               `bson:"ID"`      // Unique ID
+	TBT     primitive.Decimal128 `bson:"TBT"`     // Tulobyte Balance
+	POS     primitive.Decimal128 `bson:"POS"`     // Polygon USDT Bal
+	ERC     primitive.Decimal128 `bson:"ERC"`     // ERC Usdt Bal
+	NPT     primitive.Decimal128 `bson:"NPT"`     // Net Profit
+	NPTP    primitive.Decimal128 `bson:"NPTP"`    // Net Profit Percentage
+	EADD    string               `bson:"EADD"`    // Ethereium Address
+	REFB    string               `bson:"REF"`     // Referred By Address
+	REFS    []string             `bson:"REFS"`    // Refererals
+	EKEY    string               `bson:"EKEY"`    // legacy EVM private key, sealed by keystore
+	HDIX    int64                `bson:"HDIX"`    // HD wallet index of EADD, 0 for legacy keys
+	REFRESH string               `bson:"REFRESH"` // EVM private key
+	Other   bson.M               `bson:",inline"` // balances of the other chains in the registry
 }
 
-func RefreshAccount(r *http.Request) (string, string) {
-	db, err := ConnectDB()
-
-	if err != nil {
-		return "false", "API Database Error"
+// BalanceOf reads the balance field of the account, zero when it is unset
+func (u User) BalanceOf(field string) money.Amount {
+	switch field {
+	case "TBT":
+		return Balance(u.TBT)
+	case "POS":
+		return Balance(u.POS)
+	case "ERC":
+		return Balance(u.ERC)
+	case "NPT":
+		return Balance(u.NPT)
+	case "NPTP":
+		return Balance(u.NPTP)
 	}
-	// Database collections
-	accounts := db.Collection("tb_accounts")
+	if stored, isDecimal := u.Other[field].(primitive.Decimal128); isDecimal {
+		return Balance(stored)
+	}
+	return money.Zero()
+}
 
+func RefreshAccount(r *http.Request) (string, string) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		return "false", "API Database Error"
@@ -72,30 +86,36 @@ func RefreshAccount(r *http.Request) (string, string) {
 	}
 	address := walletsDetailList[0]
 	walletKey := walletsDetailList[1]
-	validKey := CheckKey(walletKey, address)
+	validKey := CheckAuth(walletKey, address)
 	if !validKey {
 		return "false", "Trying to bypass"
 	}
 
-	accountData, isFound := GetAccountData(address, accounts)
+	isFound, message, accountData, REFStatus := GetAccountOverview(address)
 	if !isFound {
-		return "false", "No Account Found"
+		return "false", message
 	}
 
-	oldPosBalance, err := strconv.ParseFloat(accountData.POS, 64)
-	if err != nil {
-		return "false", "Problem at backend"
-	}
+	csvData := userToCSV(accountData, REFStatus, accountData.EADD, Balance(accountData.ERC), Balance(accountData.POS))
+	return "true", csvData
+
+}
+
+// GetAccountOverview returns the account with the stake status (1 active, 0 idle)
+// of each of its referrals
+func GetAccountOverview(address string) (bool, string, User, []int) {
+	db, err := ConnectDB()
 
-	oldERCBalance, err := strconv.ParseFloat(accountData.ERC, 64)
 	if err != nil {
-		return "false", "Problem at backend"
+		return false, "API Database Error", User{}, nil
 	}
+	// Database collections
+	accounts := db.Collection("tb_accounts")
 
-	evmAddress := accountData.EADD
-	// fetching balance from chain POS
-	newPosBalance := oldPosBalance
-	newERCBalance := oldERCBalance
+	accountData, isFound := GetAccountData(address, accounts)
+	if !isFound {
+		return false, "No Account Found", User{}, nil
+	}
 
 	stakeCollection := db.Collection("stakesCollection")
 	var REFStatus = []int{}
@@ -108,28 +128,16 @@ func RefreshAccount(r *http.Request) (string, string) {
 			REFStatus = append(REFStatus, 0)
 		}
 	}
-
-	csvData := userToCSV(accountData, REFStatus, evmAddress, newERCBalance, newPosBalance)
-	return "true", csvData
-
-}
-
-func UpdateBalance(assetChoice string, newBalance float64, accounts *mongo.Collection, address string) bool {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	newBalanceString := fmt.Sprintf("%f", newBalance)
-	update := bson.M{"$set": bson.M{
-		assetChoice: newBalanceString,
-	}}
-	filter := bson.M{"ID": address}
-	_, err := accounts.UpdateOne(ctx, filter, update)
-	return err == nil
+	return true, "", accountData, REFStatus
 }
 
-func userToCSV(accountData User, REFStatus []int, newAddress string, erc float64, pos float64) string {
-	ercString := fmt.Sprintf("%f", erc)
-	posString := fmt.Sprintf("%f", pos)
-	return fmt.Sprintf("%s,%s,%s,%s,%s,%d,%s,%s", accountData.TBT, posString, ercString, accountData.NPT, accountData.REFS, REFStatus, accountData.NPTP, newAddress)
+func userToCSV(accountData User, REFStatus []int, newAddress string, erc money.Amount, pos money.Amount) string {
+	ercString := erc.Fixed(6)
+	posString := pos.Fixed(6)
+	tbtString := Balance(accountData.TBT).Fixed(6)
+	nptString := Balance(accountData.NPT).Fixed(6)
+	nptpString := Balance(accountData.NPTP).Fixed(6)
+	return fmt.Sprintf("%s,%s,%s,%s,%s,%d,%s,%s", tbtString, posString, ercString, nptString, accountData.REFS, REFStatus, nptpString, newAddress)
 }
 func GetAccountData(walletAddress string, accounts *mongo.Collection) (User, bool) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -0,0 +1,298 @@
+package modals
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/crypto"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	challengeTTL = 5 * time.Minute
+	sessionTTL   = time.Hour
+	tokenPrefix  = "tbs_"
+)
+
+// Challenge is the login message a client signs with the account key
+type Challenge struct {
+	Nonce   string
+	Message string
+	EXP     int64
+}
+
+// Session is an issued session token, only its hash is stored
+type Session struct {
+	Token string
+	EXP   int64
+}
+
+type challengeDoc struct {
+	ID    string    `bson:"ID"`
+	NONCE string    `bson:"NONCE"`
+	EXP   time.Time `bson:"EXP"`
+}
+
+type sessionDoc struct {
+	HASH string    `bson:"HASH"`
+	ID   string    `bson:"ID"`
+	EXP  time.Time `bson:"EXP"`
+}
+
+// AuthChallenge takes "address" and returns "nonce,expires"; the client signs
+// the login message built from them
+func AuthChallenge(r *http.Request) (string, string) {
+	fields, message := readFields(r, 1)
+	if fields == nil {
+		return "false", message
+	}
+	isIssued, message, challenge := IssueChallenge(fields[0])
+	if !isIssued {
+		return "false", message
+	}
+	return "true", fmt.Sprintf("%s,%d", challenge.Nonce, challenge.EXP)
+}
+
+// AuthLogin takes "address,nonce,signature" and returns "token,expires"
+func AuthLogin(r *http.Request) (string, string) {
+	fields, message := readFields(r, 3)
+	if fields == nil {
+		return "false", message
+	}
+	isLoggedIn, message, session := Login(fields[0], fields[1], fields[2])
+	if !isLoggedIn {
+		return "false", message
+	}
+	return "true", fmt.Sprintf("%s,%d", session.Token, session.EXP)
+}
+
+// AuthLogout takes "address,token"
+func AuthLogout(r *http.Request) (string, string) {
+	fields, message := readFields(r, 2)
+	if fields == nil {
+		return "false", message
+	}
+	isLoggedOut, message := Logout(fields[0], fields[1])
+	if !isLoggedOut {
+		return "false", message
+	}
+	return "true", message
+}
+
+// readFields reads the comma packed v1 body, it returns nil and the error
+// message unless it holds exactly count fields
+func readFields(r *http.Request, count int) ([]string, string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, "API Database Error"
+	}
+	defer r.Body.Close()
+	if len(body) == 0 {
+		return nil, "Request Empty"
+	}
+	var data map[string]string
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, "API Database Error"
+	}
+	fieldsString, exists := data["data"]
+	if !exists {
+		return nil, "Request Malformed No Data"
+	}
+	fields := strings.Split(fieldsString, ",")
+	if len(fields) != count {
+		return nil, "Request Malformed"
+	}
+	return fields, ""
+}
+
+// challengeMessage is the text signed as an EIP-191 personal message
+func challengeMessage(address string, nonce string) string {
+	return fmt.Sprintf("Tulobyte login\naddress: %s\nnonce: %s", address, nonce)
+}
+
+// IssueChallenge stores a single use nonce for address
+func IssueChallenge(address string) (bool, string, Challenge) {
+	db, err := ConnectDB()
+	if err != nil {
+		return false, "API Database Error", Challenge{}
+	}
+	accounts := db.Collection("tb_accounts")
+	if _, isFound := GetAccountData(address, accounts); !isFound {
+		return false, "No Account Found", Challenge{}
+	}
+
+	nonce, err := randomHex(16)
+	if err != nil {
+		return false, "Problem at Backend", Challenge{}
+	}
+	expires := time.Now().UTC().Add(challengeTTL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = mongoChallenges{db.Collection("authChallenges")}.Put(ctx, challengeDoc{ID: address, NONCE: nonce, EXP: expires})
+	if err != nil {
+		return false, "Can't create challenge", Challenge{}
+	}
+	return true, "", Challenge{Nonce: nonce, Message: challengeMessage(address, nonce), EXP: expires.Unix()}
+}
+
+// Login consumes the challenge and, when signature is the account key's
+// signature of its message, issues a session token
+func Login(address string, nonce string, signature string) (bool, string, Session) {
+	db, err := ConnectDB()
+	if err != nil {
+		return false, "API Database Error", Session{}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	isValid, message := answerChallenge(ctx, mongoChallenges{db.Collection("authChallenges")}, address, nonce, signature, time.Now())
+	if !isValid {
+		return false, message, Session{}
+	}
+
+	token, err := randomHex(32)
+	if err != nil {
+		return false, "Problem at Backend", Session{}
+	}
+	token = tokenPrefix + token
+	expires := time.Now().UTC().Add(sessionTTL)
+	_, err = db.Collection("sessions").InsertOne(ctx, sessionDoc{HASH: hashToken(token), ID: address, EXP: expires})
+	if err != nil {
+		return false, "Can't create session", Session{}
+	}
+	return true, "Logged in", Session{Token: token, EXP: expires.Unix()}
+}
+
+// challengeStore holds the issued challenges, Take hands each out only once
+type challengeStore interface {
+	Put(ctx context.Context, challenge challengeDoc) error
+	Take(ctx context.Context, address string, nonce string) (challengeDoc, bool, error)
+}
+
+type mongoChallenges struct {
+	collection *mongo.Collection
+}
+
+func (m mongoChallenges) Put(ctx context.Context, challenge challengeDoc) error {
+	_, err := m.collection.InsertOne(ctx, challenge)
+	return err
+}
+
+func (m mongoChallenges) Take(ctx context.Context, address string, nonce string) (challengeDoc, bool, error) {
+	var challenge challengeDoc
+	err := m.collection.FindOneAndDelete(ctx, bson.M{"ID": address, "NONCE": nonce}).Decode(&challenge)
+	if err == mongo.ErrNoDocuments {
+		return challengeDoc{}, false, nil
+	}
+	return challenge, err == nil, err
+}
+
+// answerChallenge consumes the challenge of address and nonce and checks that
+// signature signs its message. The challenge is gone after the first answer,
+// right or wrong, so a replayed signature finds nothing.
+func answerChallenge(ctx context.Context, challenges challengeStore, address string, nonce string, signature string, now time.Time) (bool, string) {
+	challenge, found, err := challenges.Take(ctx, address, nonce)
+	if err != nil {
+		return false, "API Database Error"
+	}
+	if !found || !now.Before(challenge.EXP) {
+		return false, "Invalid or expired challenge"
+	}
+	if !checkSignature(address, challengeMessage(address, nonce), signature) {
+		return false, "Invalid Signature"
+	}
+	return true, ""
+}
+
+// Logout revokes the session token of address
+func Logout(address string, token string) (bool, string) {
+	db, err := ConnectDB()
+	if err != nil {
+		return false, "API Database Error"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = db.Collection("sessions").DeleteOne(ctx, bson.M{"HASH": hashToken(token), "ID": address})
+	if err != nil {
+		return false, "Can't end session"
+	}
+	return true, "Logged out"
+}
+
+// CheckAuth reports whether credential authorizes address. The credential is a
+// session token from Login; the raw account key older app builds send is only
+// accepted while LEGACY_KEY_AUTH is "true".
+func CheckAuth(credential string, address string) bool {
+	if !strings.HasPrefix(credential, tokenPrefix) {
+		return os.Getenv("LEGACY_KEY_AUTH") == "true" && CheckKey(credential, address)
+	}
+	db, err := ConnectDB()
+	if err != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{"HASH": hashToken(credential), "ID": address, "EXP": bson.M{"$gt": time.Now().UTC()}}
+	err = db.Collection("sessions").FindOne(ctx, filter).Err()
+	return err == nil
+}
+
+// EnsureAuthIndexes lets MongoDB drop expired challenges and sessions
+func EnsureAuthIndexes(ctx context.Context, db *mongo.Database) error {
+	expiry := mongo.IndexModel{
+		Keys:    bson.D{{Key: "EXP", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	if _, err := db.Collection("authChallenges").Indexes().CreateOne(ctx, expiry); err != nil {
+		return err
+	}
+	_, err := db.Collection("sessions").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		expiry,
+		{Keys: bson.D{{Key: "HASH", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+	return err
+}
+
+// checkSignature recovers the signer of message from a 65 byte [R || S || V]
+// hex signature and compares its address with address
+func checkSignature(address string, message string, signature string) bool {
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "0x"))
+	if err != nil || len(sig) != crypto.SignatureLength {
+		return false
+	}
+	// wallets produce V as 27/28
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	pubKey, err := crypto.SigToPub(accounts.TextHash([]byte(message)), sig)
+	if err != nil {
+		return false
+	}
+	return tronAddress(crypto.FromECDSAPub(pubKey)) == address
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,108 @@
+package modals
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// memChallenges is a challengeStore in memory
+type memChallenges map[string]challengeDoc
+
+func (m memChallenges) Put(ctx context.Context, challenge challengeDoc) error {
+	m[challenge.ID+":"+challenge.NONCE] = challenge
+	return nil
+}
+
+func (m memChallenges) Take(ctx context.Context, address string, nonce string) (challengeDoc, bool, error) {
+	challenge, found := m[address+":"+nonce]
+	delete(m, address+":"+nonce)
+	return challenge, found, nil
+}
+
+// signLogin signs the login message of nonce as a wallet does, with V 27/28
+func signLogin(t *testing.T, key *ecdsa.PrivateKey, address string, nonce string) []byte {
+	sig, err := crypto.Sign(accounts.TextHash([]byte(challengeMessage(address, nonce))), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig[crypto.RecoveryIDOffset] += 27
+	return sig
+}
+
+func TestCheckSignature(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+	address := tronAddress(crypto.FromECDSAPub(&key.PublicKey))
+	message := challengeMessage(address, "00ff")
+
+	wallet := signLogin(t, key, address, "00ff")
+	raw := append([]byte(nil), wallet...)
+	raw[crypto.RecoveryIDOffset] -= 27
+	tests := []struct {
+		name      string
+		signature string
+		want      bool
+	}{
+		{"V 27/28", "0x" + hex.EncodeToString(wallet), true},
+		{"V 0/1", "0x" + hex.EncodeToString(raw), true},
+		{"no 0x prefix", hex.EncodeToString(wallet), true},
+		{"other key", "0x" + hex.EncodeToString(signLogin(t, other, address, "00ff")), false},
+		{"other nonce", "0x" + hex.EncodeToString(signLogin(t, key, address, "0100")), false},
+		{"not hex", "0x" + hex.EncodeToString(wallet)[:128] + "zz", false},
+		{"odd length", "0x" + hex.EncodeToString(wallet)[1:], false},
+		{"short", "0x" + hex.EncodeToString(wallet[:64]), false},
+		{"empty", "", false},
+		{"bad V", "0x" + hex.EncodeToString(append(wallet[:64:64], 29)), false},
+	}
+	for _, test := range tests {
+		if got := checkSignature(address, message, test.signature); got != test.want {
+			t.Errorf("%s: checkSignature %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAnswerChallenge(t *testing.T) {
+	ctx := context.Background()
+	key, _ := crypto.GenerateKey()
+	address := tronAddress(crypto.FromECDSAPub(&key.PublicKey))
+	now := time.Now().UTC()
+	challenges := memChallenges{}
+	challenges.Put(ctx, challengeDoc{ID: address, NONCE: "live", EXP: now.Add(challengeTTL)})
+	challenges.Put(ctx, challengeDoc{ID: address, NONCE: "stale", EXP: now.Add(-time.Second)})
+	challenges.Put(ctx, challengeDoc{ID: address, NONCE: "wrong", EXP: now.Add(challengeTTL)})
+	answer := func(nonce string) string {
+		return "0x" + hex.EncodeToString(signLogin(t, key, address, nonce))
+	}
+
+	if isValid, message := answerChallenge(ctx, challenges, address, "live", answer("live"), now); !isValid {
+		t.Errorf("valid answer refused: %s", message)
+	}
+	if isValid, message := answerChallenge(ctx, challenges, address, "live", answer("live"), now); isValid || message != "Invalid or expired challenge" {
+		t.Errorf("replayed answer: %v %s", isValid, message)
+	}
+	if isValid, message := answerChallenge(ctx, challenges, address, "stale", answer("stale"), now); isValid || message != "Invalid or expired challenge" {
+		t.Errorf("expired challenge: %v %s", isValid, message)
+	}
+	if isValid, message := answerChallenge(ctx, challenges, address, "never", answer("never"), now); isValid || message != "Invalid or expired challenge" {
+		t.Errorf("challenge never issued: %v %s", isValid, message)
+	}
+
+	// a wrong signature uses the challenge up as well
+	other, _ := crypto.GenerateKey()
+	forged := "0x" + hex.EncodeToString(signLogin(t, other, address, "wrong"))
+	if isValid, message := answerChallenge(ctx, challenges, address, "wrong", forged, now); isValid || message != "Invalid Signature" {
+		t.Errorf("signature of another key: %v %s", isValid, message)
+	}
+	if isValid, _ := answerChallenge(ctx, challenges, address, "wrong", answer("wrong"), now); isValid {
+		t.Errorf("challenge answered again after a wrong signature")
+	}
+	if len(challenges) != 0 {
+		t.Errorf("%d challenges left", len(challenges))
+	}
+}
@@ -0,0 +1,71 @@
+package modals
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// FailPoint, when set, is consulted at every Checkpoint inside a transaction.
+// Returning an error aborts the transaction at that step, the fault test in
+// txn_test.go uses it to prove that no step can leave funds half moved.
+var FailPoint func(step string) error
+
+// Checkpoint marks a step between two writes of a transaction
+func Checkpoint(step string) error {
+	if FailPoint != nil {
+		return FailPoint(step)
+	}
+	return nil
+}
+
+// AbortError stops a transaction with a message meant for the user
+type AbortError struct {
+	Message string
+}
+
+func (e *AbortError) Error() string {
+	return e.Message
+}
+
+func Abort(message string) error {
+	return &AbortError{Message: message}
+}
+
+// AbortMessage returns the user message of an aborted transaction or fallback
+// when it failed for another reason (network, commit, write conflict)
+func AbortMessage(err error, fallback string) string {
+	var abortErr *AbortError
+	if errors.As(err, &abortErr) {
+		return abortErr.Message
+	}
+	return fallback
+}
+
+// RunTransaction runs fn inside a multi-document transaction. The driver retries
+// the whole callback on TransientTransactionError and the commit on
+// UnknownTransactionCommitResult until the timeout below expires.
+func RunTransaction(db *mongo.Database, fn func(sc mongo.SessionContext) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	session, err := db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	txnOptions := options.Transaction().
+		SetReadConcern(readconcern.Snapshot()).
+		SetWriteConcern(writeconcern.Majority())
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	}, txnOptions)
+	return err
+}
@@ -0,0 +1,414 @@
+package modals_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"tbapi/exchange"
+	"tbapi/fetch"
+	"tbapi/ledger"
+	"tbapi/modals"
+	"tbapi/money"
+	"tbapi/staking"
+	"tbapi/transfer"
+	"tbapi/withdraw"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestCheckpoint(t *testing.T) {
+	if err := modals.Checkpoint("step"); err != nil {
+		t.Fatalf("checkpoint without a fail point: %v", err)
+	}
+	var at string
+	modals.FailPoint = func(step string) error {
+		at = step
+		return errInjected
+	}
+	defer func() { modals.FailPoint = nil }()
+	if err := modals.Checkpoint("step"); err != errInjected || at != "step" {
+		t.Errorf("checkpoint returned %v at %q, want the fault at step", err, at)
+	}
+}
+
+func TestAbortMessage(t *testing.T) {
+	wrapped := fmt.Errorf("commit: %w", modals.Abort("Insufficient Balance"))
+	if message := modals.AbortMessage(wrapped, "fallback"); message != "Insufficient Balance" {
+		t.Errorf("abort message %q", message)
+	}
+	if message := modals.AbortMessage(errInjected, "fallback"); message != "fallback" {
+		t.Errorf("message of another error %q", message)
+	}
+}
+
+// TestTransactionFaults replays every balance moving operation against the
+// scratch database named by TXFAULT_DB and aborts it at each transaction
+// checkpoint in turn. After every aborted run the database must be exactly as
+// it was before the operation, otherwise funds could be left half moved.
+// Transactions need MongoDB running as a replica set, so the test is skipped
+// without TXFAULT_DB.
+func TestTransactionFaults(t *testing.T) {
+	dbName := os.Getenv("TXFAULT_DB")
+	if testing.Short() || dbName == "" {
+		t.Skip("set TXFAULT_DB to a scratch database on a replica set")
+	}
+	if dbName == "tulobyte_db" {
+		t.Fatal("TXFAULT_DB must be a scratch database, the test drops its collections")
+	}
+	t.Setenv("DB_NAME", dbName)
+	db, err := modals.ConnectDB()
+	if err != nil {
+		t.Fatalf("Can't connect to database: %v", err)
+	}
+
+	for _, sc := range scenarios {
+		t.Run(sc.name, func(t *testing.T) {
+			steps, problems := exercise(t, db, sc)
+			for _, problem := range problems {
+				t.Error(problem)
+			}
+			t.Logf("%d checkpoints", steps)
+		})
+	}
+}
+
+var errInjected = errors.New("injected fault")
+
+var collections = []string{"tb_accounts", "exchangeOrders", "exchangeFills", "exchangeCancels", "exchangeCandles", "stakesCollection", "transferOrders", "secretsWallets", "platformInfo", "ledger_entries", "deposits", "withdrawals", "withdrawalLimits"}
+
+// external is a withdrawal address outside the platform
+const external = "0x00000000000000000000000000000000000000e5"
+
+type scenario struct {
+	name string
+	// setup seeds whatever the operation needs and returns its argument
+	setup func(t *testing.T, db *mongo.Database) string
+	run   func(arg string) bool
+}
+
+var scenarios = []scenario{
+	{
+		name:  "internal transfer",
+		setup: func(t *testing.T, db *mongo.Database) string { return "" },
+		run: func(arg string) bool {
+			isTransfered, _, _ := transfer.SendAsset("alice", "0xb0b", "10", "USDT-PoS")
+			return isTransfered
+		},
+	},
+	{
+		name:  "on-chain deposit",
+		setup: func(t *testing.T, db *mongo.Database) string { return "" },
+		run: func(arg string) bool {
+			db, err := modals.ConnectDB()
+			if err != nil {
+				return false
+			}
+			deposit := fetch.Deposit{CHAIN: "POS", TXH: "0x01", LIX: 0, BLK: 1, ADD: "0xa11ce"}
+			isCredited, _ := fetch.CreditDeposit(db, deposit, fetch.DepositOwner{ID: "alice", HDIX: 1}, money.MustParse("25"))
+			return isCredited
+		},
+	},
+	{
+		name:  "orphan deposit",
+		setup: func(t *testing.T, db *mongo.Database) string { creditDeposit(t, db); return "" },
+		run: func(arg string) bool {
+			db, err := modals.ConnectDB()
+			if err != nil {
+				return false
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			orphaned, err := fetch.OrphanDeposits(ctx, db, "POS", 0, 10, nil)
+			return err == nil && len(orphaned) == 1
+		},
+	},
+	{
+		name: "credit orphaned deposit again",
+		setup: func(t *testing.T, db *mongo.Database) string {
+			creditDeposit(t, db)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if _, err := fetch.OrphanDeposits(ctx, db, "POS", 0, 10, nil); err != nil {
+				t.Fatalf("Can't seed orphan: %v", err)
+			}
+			return ""
+		},
+		run: func(arg string) bool {
+			db, err := modals.ConnectDB()
+			if err != nil {
+				return false
+			}
+			deposit := fetch.Deposit{CHAIN: "POS", TXH: "0x01", LIX: 0, BLK: 2, ADD: "0xa11ce"}
+			isCredited, _ := fetch.CreditDeposit(db, deposit, fetch.DepositOwner{ID: "alice", HDIX: 1}, money.MustParse("25"))
+			return isCredited
+		},
+	},
+	{
+		name:  "place stake",
+		setup: func(t *testing.T, db *mongo.Database) string { return "" },
+		run: func(arg string) bool {
+			isStaked, _, _ := staking.CreateStake("alice", "10", "7")
+			return isStaked
+		},
+	},
+	{
+		name: "unstake",
+		setup: func(t *testing.T, db *mongo.Database) string {
+			past := fmt.Sprintf("%d", time.Now().Add(-time.Hour).Unix())
+			return insert(t, db, "stakesCollection", bson.M{
+				"ADD": "alice", "AMT": "10", "STKP": "0.56", "STMP": past, "MTMP": past, "OPT": "7", "STAT": "active",
+			})
+		},
+		run: func(arg string) bool {
+			isUnstaked, _ := staking.UnstakeByID("alice", arg)
+			return isUnstaked
+		},
+	},
+	{
+		name:  "place exchange order",
+		setup: func(t *testing.T, db *mongo.Database) string { return "" },
+		run: func(arg string) bool {
+			isCreated, _, _, _ := exchange.PlaceOrder("alice", exchange.OrderSpec{From: "USDT-POS", To: "TBYT", Amount: "10", Price: "0.5"})
+			return isCreated == "true"
+		},
+	},
+	{
+		name: "match exchange orders",
+		setup: func(t *testing.T, db *mongo.Database) string {
+			now := fmt.Sprintf("%d", time.Now().Unix())
+			insert(t, db, "exchangeOrders", bson.M{
+				"ID": "bob", "FROM": "TBYT", "TO": "USDT-POS", "PAIR": "TBYT/USDT-POS", "SIDE": "sell", "PRC": "0.5",
+				"AMT": "10", "SAMT": "0", "RCV": "0", "TMP": now, "STAT": "pending",
+			})
+			return insert(t, db, "exchangeOrders", bson.M{
+				"ID": "alice", "FROM": "USDT-POS", "TO": "TBYT", "PAIR": "TBYT/USDT-POS", "SIDE": "buy", "PRC": "0.6",
+				"AMT": "4", "SAMT": "0", "RCV": "0", "TMP": now, "STAT": "pending",
+			})
+		},
+		run: func(arg string) bool {
+			EID, _ := primitive.ObjectIDFromHex(arg)
+			status, _ := exchange.MatchOrder(EID)
+			return status == "done"
+		},
+	},
+	{
+		name: "immediate or cancel",
+		setup: func(t *testing.T, db *mongo.Database) string {
+			now := fmt.Sprintf("%d", time.Now().Unix())
+			insert(t, db, "exchangeOrders", bson.M{
+				"ID": "bob", "FROM": "TBYT", "TO": "USDT-POS", "PAIR": "TBYT/USDT-POS", "SIDE": "sell", "PRC": "0.5",
+				"AMT": "10", "SAMT": "0", "RCV": "0", "TMP": now, "STAT": "pending",
+			})
+			return insert(t, db, "exchangeOrders", bson.M{
+				"ID": "alice", "FROM": "USDT-POS", "TO": "TBYT", "PAIR": "TBYT/USDT-POS", "SIDE": "buy", "PRC": "0.55",
+				"TYP": "market", "TIF": "IOC", "AMT": "8", "SAMT": "0", "RCV": "0", "TMP": now, "STAT": "pending",
+			})
+		},
+		run: func(arg string) bool {
+			EID, _ := primitive.ObjectIDFromHex(arg)
+			status, _ := exchange.MatchOrder(EID)
+			return status == "done"
+		},
+	},
+	{
+		name: "cancel exchange order",
+		setup: func(t *testing.T, db *mongo.Database) string {
+			return insert(t, db, "exchangeOrders", bson.M{
+				"ID": "alice", "FROM": "USDT-POS", "TO": "TBYT", "AMT": "10", "SAMT": "4", "TMP": "1", "STAT": "partial",
+			})
+		},
+		run: func(arg string) bool {
+			isCancelled, _, _ := exchange.CancelOrder("alice", arg)
+			return isCancelled
+		},
+	},
+	{
+		name:  "request withdrawal",
+		setup: func(t *testing.T, db *mongo.Database) string { return "" },
+		run: func(arg string) bool {
+			isQueued, _, _ := withdraw.Request("alice", "USDT-PoS", external, "10")
+			return isQueued
+		},
+	},
+	{
+		name:  "confirm withdrawal",
+		setup: func(t *testing.T, db *mongo.Database) string { return queueWithdrawal(t, db, withdraw.StatusBroadcast) },
+		run: func(arg string) bool {
+			return finishWithdrawal(arg, withdraw.StatusBroadcast, withdraw.StatusConfirmed)
+		},
+	},
+	{
+		name:  "reject withdrawal",
+		setup: func(t *testing.T, db *mongo.Database) string { return queueWithdrawal(t, db, withdraw.StatusReview) },
+		run: func(arg string) bool {
+			db, err := modals.ConnectDB()
+			if err != nil {
+				return false
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			return withdraw.NewMongoStore(db).Reject(ctx, arg, "fault test") == nil
+		},
+	},
+}
+
+// creditDeposit credits alice a deposit of 25 from block 1
+func creditDeposit(t *testing.T, db *mongo.Database) {
+	deposit := fetch.Deposit{CHAIN: "POS", TXH: "0x01", LIX: 0, BLK: 1, ADD: "0xa11ce"}
+	if _, err := fetch.CreditDeposit(db, deposit, fetch.DepositOwner{ID: "alice", HDIX: 1}, money.MustParse("25")); err != nil {
+		t.Fatalf("Can't seed deposit: %v", err)
+	}
+}
+
+// queueWithdrawal requests a withdrawal of alice and moves it to status
+func queueWithdrawal(t *testing.T, db *mongo.Database, status string) string {
+	isQueued, message, w := withdraw.Request("alice", "USDT-PoS", external, "10")
+	if !isQueued {
+		t.Fatalf("Can't seed withdrawal: %s", message)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := db.Collection(withdraw.Collection).UpdateOne(ctx, bson.M{"_id": w.EID}, bson.M{"$set": bson.M{"STATUS": status}}); err != nil {
+		t.Fatalf("Can't seed withdrawal: %v", err)
+	}
+	return w.EID.Hex()
+}
+
+func finishWithdrawal(hexID string, from string, to string) bool {
+	db, err := modals.ConnectDB()
+	if err != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	store := withdraw.NewMongoStore(db)
+	w, err := store.Get(ctx, hexID)
+	if err != nil {
+		return false
+	}
+	w.STATUS = to
+	isFinished, err := store.Finish(ctx, w, from)
+	return isFinished && err == nil
+}
+
+// exercise aborts the operation at checkpoint 0, 1, 2 ... until it runs through
+// without hitting the fault, and checks the database after every attempt
+func exercise(t *testing.T, db *mongo.Database, sc scenario) (int, []string) {
+	var problems []string
+	for target := 0; ; target++ {
+		seed(t, db)
+		arg := sc.setup(t, db)
+		before := snapshot(t, db)
+
+		hits := 0
+		failedAt := ""
+		modals.FailPoint = func(step string) error {
+			defer func() { hits++ }()
+			if hits == target {
+				failedAt = step
+				return errInjected
+			}
+			return nil
+		}
+		ok := sc.run(arg)
+		modals.FailPoint = nil
+		after := snapshot(t, db)
+
+		if failedAt == "" {
+			if !ok {
+				problems = append(problems, "operation failed without an injected fault")
+			} else if after == before {
+				problems = append(problems, "operation succeeded but wrote nothing")
+			} else if problem := verifyLedger(db); problem != "" {
+				problems = append(problems, problem)
+			}
+			return target, problems
+		}
+		if ok {
+			problems = append(problems, fmt.Sprintf("reported success although aborted at %s", failedAt))
+		}
+		if after != before {
+			problems = append(problems, fmt.Sprintf("partial write left behind when aborted at %s", failedAt))
+		}
+	}
+}
+
+// verifyLedger checks the balances written by a successful run against the ledger
+func verifyLedger(db *mongo.Database) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	report, err := ledger.Verify(ctx, db)
+	if err != nil {
+		return fmt.Sprintf("ledger verify failed: %v", err)
+	}
+	if len(report.Drifts) > 0 || len(report.Unbalanced) > 0 {
+		return fmt.Sprintf("ledger drift after success: %d balances, %d postings", len(report.Drifts), len(report.Unbalanced))
+	}
+	return ""
+}
+
+func seed(t *testing.T, db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, name := range collections {
+		if _, err := db.Collection(name).DeleteMany(ctx, bson.M{}); err != nil {
+			t.Fatalf("Can't clear %s: %v", name, err)
+		}
+	}
+	exchange.ResetBooks()
+	hundred := money.MustParse("100").Decimal128()
+	for ID, EADD := range map[string]string{"alice": "0xa11ce", "bob": "0xb0b"} {
+		insert(t, db, "tb_accounts", bson.M{
+			"ID": ID, "EADD": EADD, "EKEY": "",
+			"TBT": hundred, "POS": hundred, "ERC": hundred,
+			"NPT": money.Zero().Decimal128(), "NPTP": money.Zero().Decimal128(), "REFB": "", "REFS": []string{}, "REFRESH": "0,0",
+		})
+	}
+	insert(t, db, "platformInfo", bson.M{
+		"type": "currencyInfo", "totalSupply": "20000000000", "maxSupply": "20000000000", "mined": money.Zero().Decimal128(), "holders": "0",
+	})
+	insert(t, db, "platformInfo", bson.M{"type": "updatedFee", "polygon": "0.5", "eth": "2500", "gwei": "0.00000003"})
+	if _, err := ledger.Open(ctx, db); err != nil {
+		t.Fatalf("Can't open ledger: %v", err)
+	}
+}
+
+func insert(t *testing.T, db *mongo.Database, collection string, document bson.M) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := db.Collection(collection).InsertOne(ctx, document)
+	if err != nil {
+		t.Fatalf("Can't seed %s: %v", collection, err)
+	}
+	return result.InsertedID.(primitive.ObjectID).Hex()
+}
+
+// snapshot dumps every collection the operations touch in _id order
+func snapshot(t *testing.T, db *mongo.Database) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var builder strings.Builder
+	for _, name := range collections {
+		cursor, err := db.Collection(name).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+		if err != nil {
+			t.Fatalf("Can't read %s: %v", name, err)
+		}
+		var documents []bson.M
+		if err := cursor.All(ctx, &documents); err != nil {
+			t.Fatalf("Can't read %s: %v", name, err)
+		}
+		builder.WriteString(name)
+		for _, document := range documents {
+			extJSON, _ := bson.MarshalExtJSON(document, true, false)
+			builder.Write(extJSON)
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,56 @@
+package money
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"tbapi/chains"
+)
+
+// Asset is a currency held on the platform with the precision it settles in
+type Asset struct {
+	Code     string // exchange code (TBYT, or the asset of a chain such as USDT-POS)
+	Field    string // balance field of tb_accounts
+	Decimals int
+}
+
+// defaultTBYTDecimals applies when TBYT_DECIMALS is not set
+const defaultTBYTDecimals = 6
+
+var tbytOnce sync.Once
+var tbyt Asset
+
+// TBYT reads its precision from TBYT_DECIMALS on first use, after the .env
+// file has been loaded
+func TBYT() Asset {
+	tbytOnce.Do(func() {
+		tbyt = Asset{Code: "TBYT", Field: "TBT", Decimals: defaultTBYTDecimals}
+		decimals, err := strconv.Atoi(os.Getenv("TBYT_DECIMALS"))
+		if err == nil && decimals >= 0 && decimals <= Scale {
+			tbyt.Decimals = decimals
+		}
+	})
+	return tbyt
+}
+
+// Lookup finds an asset by exchange code, transfer code or balance field. The
+// chain tokens come from the chains registry.
+func Lookup(code string) (Asset, bool) {
+	switch code {
+	case "TBYT", "TBYT-PoS", "TBT":
+		return TBYT(), true
+	}
+	chain, found := chains.Get(code)
+	if !found {
+		chain, found = chains.ByAsset(code)
+	}
+	if !found {
+		return Asset{}, false
+	}
+	return ChainAsset(chain), true
+}
+
+// ChainAsset is the token of chain, held at no more than Scale places
+func ChainAsset(chain chains.Chain) Asset {
+	return Asset{Code: chain.Asset, Field: chain.Name, Decimals: min(chain.Decimals, Scale)}
+}
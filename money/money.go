@@ -0,0 +1,293 @@
+// Package money is the exact decimal amount type shared by every package.
+// Amounts are fixed point with Scale decimals; each asset only accepts as many
+// decimals as it actually has and input is parsed strictly, so negative, NaN,
+// Inf or exponent notation never reaches a balance.
+package money
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Scale is the number of decimals every Amount carries internally, no asset
+// may be configured with more
+const Scale = 8
+
+var (
+	ErrInvalid   = errors.New("Invalid Amount")
+	ErrNegative  = errors.New("Amount must not be negative")
+	ErrZero      = errors.New("Amount must be greater than zero")
+	ErrPrecision = errors.New("Too many decimal places")
+	ErrTooLarge  = errors.New("Amount too large")
+)
+
+var scaleFactor = new(big.Int).Exp(big.NewInt(10), big.NewInt(Scale), nil)
+
+// maxUnits caps parsed input far above the TBYT supply cap (20 billion)
+var maxUnits = new(big.Int).Mul(big.NewInt(1_000_000_000_000), scaleFactor)
+
+// Amount is an exact decimal, the zero value is 0
+type Amount struct {
+	units *big.Int // value * 10^Scale
+}
+
+func fromUnits(units *big.Int) Amount {
+	return Amount{units: units}
+}
+
+func (a Amount) raw() *big.Int {
+	if a.units == nil {
+		return new(big.Int)
+	}
+	return a.units
+}
+
+// Zero returns 0
+func Zero() Amount {
+	return Amount{}
+}
+
+// ParseDecimal parses a plain non-negative decimal ("12", "0.5") with up to
+// Scale decimals. Signs, exponents, spaces and NaN/Inf are rejected.
+func ParseDecimal(s string) (Amount, error) {
+	if s == "" {
+		return Amount{}, ErrInvalid
+	}
+	if s[0] == '-' {
+		return Amount{}, ErrNegative
+	}
+	intPart, fracPart, hasPoint := strings.Cut(s, ".")
+	if intPart == "" || (hasPoint && fracPart == "") {
+		return Amount{}, ErrInvalid
+	}
+	if !isDigits(intPart) || !isDigits(fracPart) {
+		return Amount{}, ErrInvalid
+	}
+	if len(fracPart) > Scale {
+		return Amount{}, ErrPrecision
+	}
+	digits := intPart + fracPart + strings.Repeat("0", Scale-len(fracPart))
+	units, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return Amount{}, ErrInvalid
+	}
+	if units.Cmp(maxUnits) > 0 {
+		return Amount{}, ErrTooLarge
+	}
+	return fromUnits(units), nil
+}
+
+// Parse reads user input for asset, it accepts at most the asset decimals
+func Parse(asset Asset, s string) (Amount, error) {
+	amount, err := ParseDecimal(s)
+	if err != nil {
+		return Amount{}, err
+	}
+	if !amount.Equal(amount.RoundDown(asset)) {
+		return Amount{}, ErrPrecision
+	}
+	return amount, nil
+}
+
+// ParsePositive is Parse for amounts that must be greater than zero
+func ParsePositive(asset Asset, s string) (Amount, error) {
+	amount, err := Parse(asset, s)
+	if err != nil {
+		return Amount{}, err
+	}
+	if amount.Sign() <= 0 {
+		return Amount{}, ErrZero
+	}
+	return amount, nil
+}
+
+// MustParse is ParseDecimal for constants, it panics on bad input
+func MustParse(s string) Amount {
+	amount, err := ParseDecimal(s)
+	if err != nil {
+		panic("money: bad constant " + s)
+	}
+	return amount
+}
+
+// FromTokenUnits converts an on-chain integer amount with decimals places,
+// anything below Scale is truncated
+func FromTokenUnits(raw *big.Int, decimals int) Amount {
+	units := new(big.Int).Set(raw)
+	if decimals <= Scale {
+		units.Mul(units, pow10(Scale-decimals))
+	} else {
+		units.Quo(units, pow10(decimals-Scale))
+	}
+	return fromUnits(units)
+}
+
+// TokenUnits converts back to an on-chain integer amount with decimals places
+func (a Amount) TokenUnits(decimals int) *big.Int {
+	units := new(big.Int).Set(a.raw())
+	if decimals >= Scale {
+		return units.Mul(units, pow10(decimals-Scale))
+	}
+	return units.Quo(units, pow10(Scale-decimals))
+}
+
+// FromDecimal128 reads a stored amount, unset fields read as zero
+func FromDecimal128(d primitive.Decimal128) (Amount, error) {
+	if d.IsNaN() || d.IsInf() != 0 {
+		return Amount{}, ErrInvalid
+	}
+	coefficient, exp, err := d.BigInt()
+	if err != nil {
+		return Amount{}, ErrInvalid
+	}
+	shift := exp + Scale
+	if shift >= 0 {
+		return fromUnits(coefficient.Mul(coefficient, pow10(shift))), nil
+	}
+	return fromUnits(coefficient.Quo(coefficient, pow10(-shift))), nil
+}
+
+// Decimal128 converts the amount for storage
+func (a Amount) Decimal128() primitive.Decimal128 {
+	d, ok := primitive.ParseDecimal128FromBigInt(a.raw(), -Scale)
+	if !ok {
+		return primitive.NewDecimal128(0, 0)
+	}
+	return d
+}
+
+func (a Amount) Add(b Amount) Amount {
+	return fromUnits(new(big.Int).Add(a.raw(), b.raw()))
+}
+
+func (a Amount) Sub(b Amount) Amount {
+	return fromUnits(new(big.Int).Sub(a.raw(), b.raw()))
+}
+
+func (a Amount) Neg() Amount {
+	return fromUnits(new(big.Int).Neg(a.raw()))
+}
+
+// Mul multiplies two amounts (for example an amount by a price), the result is
+// truncated to Scale decimals
+func (a Amount) Mul(b Amount) Amount {
+	product := new(big.Int).Mul(a.raw(), b.raw())
+	return fromUnits(product.Quo(product, scaleFactor))
+}
+
+// Div divides two amounts, the result is truncated to Scale decimals. Dividing
+// by zero returns zero.
+func (a Amount) Div(b Amount) Amount {
+	if b.Sign() == 0 {
+		return Amount{}
+	}
+	numerator := new(big.Int).Mul(a.raw(), scaleFactor)
+	return fromUnits(numerator.Quo(numerator, b.raw()))
+}
+
+// Percent returns pct percent of the amount, truncated to Scale decimals
+func (a Amount) Percent(pct Amount) Amount {
+	return a.Mul(pct).Div(MustParse("100"))
+}
+
+// RoundDown truncates to the asset decimals
+func (a Amount) RoundDown(asset Asset) Amount {
+	step := pow10(Scale - asset.Decimals)
+	units := new(big.Int).Quo(a.raw(), step)
+	return fromUnits(units.Mul(units, step))
+}
+
+// Ceil rounds an exact value up to the asset decimals
+func Ceil(value *big.Rat, asset Asset) Amount {
+	scaled := new(big.Int).Mul(value.Num(), pow10(asset.Decimals))
+	steps, rest := new(big.Int).QuoRem(scaled, value.Denom(), new(big.Int))
+	if rest.Sign() > 0 {
+		steps.Add(steps, big.NewInt(1))
+	}
+	return fromUnits(steps.Mul(steps, pow10(Scale-asset.Decimals)))
+}
+
+func (a Amount) Cmp(b Amount) int {
+	return a.raw().Cmp(b.raw())
+}
+
+func (a Amount) Equal(b Amount) bool {
+	return a.Cmp(b) == 0
+}
+
+func (a Amount) LessThan(b Amount) bool {
+	return a.Cmp(b) < 0
+}
+
+func (a Amount) Sign() int {
+	return a.raw().Sign()
+}
+
+func (a Amount) IsZero() bool {
+	return a.Sign() == 0
+}
+
+// Min returns the smaller of a and b
+func Min(a Amount, b Amount) Amount {
+	if a.LessThan(b) {
+		return a
+	}
+	return b
+}
+
+// Fixed formats with exactly decimals places, extra digits are truncated
+func (a Amount) Fixed(decimals int) string {
+	if decimals > Scale {
+		decimals = Scale
+	}
+	units := a.raw()
+	sign := ""
+	if units.Sign() < 0 {
+		sign = "-"
+		units = new(big.Int).Neg(units)
+	}
+	digits := units.String()
+	if len(digits) <= Scale {
+		digits = strings.Repeat("0", Scale-len(digits)+1) + digits
+	}
+	intPart := digits[:len(digits)-Scale]
+	fracPart := digits[len(digits)-Scale:][:decimals]
+	if decimals == 0 {
+		return sign + intPart
+	}
+	return sign + intPart + "." + fracPart
+}
+
+// Format formats with the decimals of asset
+func (a Amount) Format(asset Asset) string {
+	return a.Fixed(asset.Decimals)
+}
+
+// String is the shortest exact representation ("12.5", "0")
+func (a Amount) String() string {
+	s := a.Fixed(Scale)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// Float64 is only meant for display and statistics, never for arithmetic
+func (a Amount) Float64() float64 {
+	value, _ := new(big.Rat).SetFrac(a.raw(), scaleFactor).Float64()
+	return value
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
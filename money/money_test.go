@@ -0,0 +1,211 @@
+package money_test
+
+import (
+	"math/big"
+	"tbapi/money"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var usdt = money.Asset{Code: "USDT", Field: "USDT", Decimals: 6}
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{"12", "12", nil},
+		{"0.5", "0.5", nil},
+		{"007.10", "7.1", nil},
+		{"0.00000001", "0.00000001", nil},
+		{"1000000000000", "1000000000000", nil},
+		{"1000000000000.00000001", "", money.ErrTooLarge},
+		{"0.000000001", "", money.ErrPrecision},
+		{"", "", money.ErrInvalid},
+		{"-1", "", money.ErrNegative},
+		{"+1", "", money.ErrInvalid},
+		{"1e5", "", money.ErrInvalid},
+		{"1E-2", "", money.ErrInvalid},
+		{".5", "", money.ErrInvalid},
+		{"5.", "", money.ErrInvalid},
+		{"1.2.3", "", money.ErrInvalid},
+		{" 1", "", money.ErrInvalid},
+		{"1,5", "", money.ErrInvalid},
+		{"NaN", "", money.ErrInvalid},
+		{"Inf", "", money.ErrInvalid},
+		{"0x10", "", money.ErrInvalid},
+	}
+	for _, test := range tests {
+		got, err := money.ParseDecimal(test.in)
+		if err != test.err {
+			t.Errorf("ParseDecimal(%q) error %v, want %v", test.in, err, test.err)
+			continue
+		}
+		if err == nil && got.String() != test.want {
+			t.Errorf("ParseDecimal(%q) = %s, want %s", test.in, got, test.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in          string
+		err         error
+		positiveErr error
+	}{
+		{"1.5", nil, nil},
+		{"0.000001", nil, nil},
+		{"0.0000001", money.ErrPrecision, money.ErrPrecision},
+		{"0", nil, money.ErrZero},
+		{"0.000000", nil, money.ErrZero},
+		{"-0.5", money.ErrNegative, money.ErrNegative},
+		{"", money.ErrInvalid, money.ErrInvalid},
+		{"2e3", money.ErrInvalid, money.ErrInvalid},
+	}
+	for _, test := range tests {
+		if _, err := money.Parse(usdt, test.in); err != test.err {
+			t.Errorf("Parse(%q) error %v, want %v", test.in, err, test.err)
+		}
+		amount, err := money.ParsePositive(usdt, test.in)
+		if err != test.positiveErr {
+			t.Errorf("ParsePositive(%q) error %v, want %v", test.in, err, test.positiveErr)
+		}
+		if err == nil && amount.Sign() <= 0 {
+			t.Errorf("ParsePositive(%q) = %s", test.in, amount)
+		}
+	}
+	whole := money.Asset{Code: "WHOLE", Decimals: 0}
+	if _, err := money.Parse(whole, "1.0"); err != nil {
+		t.Errorf("Parse of 1.0 with no decimals: %v", err)
+	}
+	if _, err := money.Parse(whole, "1.5"); err != money.ErrPrecision {
+		t.Errorf("Parse of 1.5 with no decimals: %v", err)
+	}
+}
+
+func TestFixed(t *testing.T) {
+	tests := []struct {
+		in       string
+		decimals int
+		fixed    string
+		str      string
+	}{
+		{"0", 2, "0.00", "0"},
+		{"12.5", 0, "12", "12.5"},
+		{"12.5", 3, "12.500", "12.5"},
+		{"0.99999999", 2, "0.99", "0.99999999"},
+		{"0.00000001", 8, "0.00000001", "0.00000001"},
+		{"1.23456789", 12, "1.23456789", "1.23456789"},
+		{"100", 6, "100.000000", "100"},
+	}
+	for _, test := range tests {
+		amount := money.MustParse(test.in)
+		if got := amount.Fixed(test.decimals); got != test.fixed {
+			t.Errorf("%s.Fixed(%d) = %s, want %s", test.in, test.decimals, got, test.fixed)
+		}
+		if got := amount.String(); got != test.str {
+			t.Errorf("%s.String() = %s, want %s", test.in, got, test.str)
+		}
+		if got := amount.Neg().Fixed(test.decimals); amount.Sign() > 0 && got != "-"+test.fixed {
+			t.Errorf("-%s.Fixed(%d) = %s, want -%s", test.in, test.decimals, got, test.fixed)
+		}
+	}
+	if got := money.MustParse("1.2345679").Format(usdt); got != "1.234567" {
+		t.Errorf("Format truncates to %s, want 1.234567", got)
+	}
+	if got := money.Zero().String(); got != "0" {
+		t.Errorf("zero is %q", got)
+	}
+}
+
+func TestDecimal128(t *testing.T) {
+	for _, in := range []string{"0", "1", "0.00000001", "12.5", "999999999999.99999999"} {
+		amount := money.MustParse(in)
+		back, err := money.FromDecimal128(amount.Decimal128())
+		if err != nil || !back.Equal(amount) {
+			t.Errorf("%s comes back from Decimal128 as %s, %v", in, back, err)
+		}
+	}
+	negative := money.MustParse("3.25").Neg()
+	if back, err := money.FromDecimal128(negative.Decimal128()); err != nil || !back.Equal(negative) {
+		t.Errorf("-3.25 comes back as %s, %v", back, err)
+	}
+
+	// values written by other tools, with any exponent
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"1.5E+3", "1500"},
+		{"0.123456789", "0.12345678"},
+		{"7", "7"},
+	}
+	for _, test := range tests {
+		d, err := primitive.ParseDecimal128(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := money.FromDecimal128(d)
+		if err != nil || got.String() != test.want {
+			t.Errorf("FromDecimal128(%s) = %s, %v, want %s", test.in, got, err, test.want)
+		}
+	}
+	if got, err := money.FromDecimal128(primitive.Decimal128{}); err != nil || !got.IsZero() {
+		t.Errorf("unset Decimal128 reads as %s, %v", got, err)
+	}
+	for _, in := range []string{"NaN", "Infinity"} {
+		d, _ := primitive.ParseDecimal128(in)
+		if _, err := money.FromDecimal128(d); err != money.ErrInvalid {
+			t.Errorf("FromDecimal128(%s) error %v", in, err)
+		}
+	}
+}
+
+func TestTokenUnits(t *testing.T) {
+	tests := []struct {
+		raw      string
+		decimals int
+		want     string
+		back     string
+	}{
+		{"1234567", 6, "1.234567", "1234567"},
+		{"1", 0, "1", "1"},
+		{"1", 8, "0.00000001", "1"},
+		// below Scale is truncated
+		{"1999999999999999999", 18, "1.99999999", "1999999990000000000"},
+		{"9999999999", 18, "0", "0"},
+	}
+	for _, test := range tests {
+		raw, _ := new(big.Int).SetString(test.raw, 10)
+		amount := money.FromTokenUnits(raw, test.decimals)
+		if amount.String() != test.want {
+			t.Errorf("FromTokenUnits(%s, %d) = %s, want %s", test.raw, test.decimals, amount, test.want)
+		}
+		if back := amount.TokenUnits(test.decimals); back.String() != test.back {
+			t.Errorf("%s.TokenUnits(%d) = %s, want %s", amount, test.decimals, back, test.back)
+		}
+	}
+}
+
+func TestCeil(t *testing.T) {
+	tests := []struct {
+		num, denom int64
+		want       string
+	}{
+		{0, 1, "0"},
+		{3, 2, "1.5"},
+		{1, 3, "0.333334"},
+		{1, 1000000, "0.000001"},
+		{1, 1000000000, "0.000001"},
+		{4875, 1000, "4.875"},
+		{-1, 3, "-0.333333"},
+	}
+	for _, test := range tests {
+		got := money.Ceil(big.NewRat(test.num, test.denom), usdt)
+		if got.String() != test.want {
+			t.Errorf("Ceil(%d/%d) = %s, want %s", test.num, test.denom, got, test.want)
+		}
+	}
+}
@@ -18,14 +18,6 @@ import (
 )
 
 func GetStakeOrderData(r *http.Request) (string, string) {
-	db, err := modals.ConnectDB()
-
-	if err != nil {
-		return "false", "API Database Error"
-	}
-	// Database collections
-	stakesCollection := db.Collection("stakesCollection")
-
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		return "false", "API Database Error"
@@ -52,14 +44,14 @@ func GetStakeOrderData(r *http.Request) (string, string) {
 	address := walletsDetailList[0]
 	walletKey := walletsDetailList[1]
 	orderNeeded := walletsDetailList[2]
-	validKey := modals.CheckKey(walletKey, address)
+	validKey := modals.CheckAuth(walletKey, address)
 	if !validKey {
 		return "false", "Invalid Account Key"
 	}
 
-	accountData, isFound := getStakeLastTenOrders(address, stakesCollection, orderNeeded)
+	isFound, message, accountData := ListStakes(address, orderNeeded)
 	if !isFound {
-		return "false", "No Account Found"
+		return "false", message
 	}
 
 	csvData := exOrderToCSV(accountData)
@@ -67,6 +59,23 @@ func GetStakeOrderData(r *http.Request) (string, string) {
 
 }
 
+// ListStakes returns one page (10 per page, newest first) of the account stakes
+func ListStakes(address string, orderNeeded string) (bool, string, []Stake) {
+	db, err := modals.ConnectDB()
+
+	if err != nil {
+		return false, "API Database Error", nil
+	}
+	// Database collections
+	stakesCollection := db.Collection("stakesCollection")
+
+	stakes, isFound := getStakeLastTenOrders(address, stakesCollection, orderNeeded)
+	if !isFound {
+		return false, "No Account Found", nil
+	}
+	return true, "", stakes
+}
+
 func getStakeLastTenOrders(walletAddress string, stakeCollection *mongo.Collection, orderNeeded string) ([]Stake, bool) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
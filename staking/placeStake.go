@@ -8,7 +8,9 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"tbapi/ledger"
 	"tbapi/modals"
+	"tbapi/money"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -55,38 +57,56 @@ func PlaceStake(r *http.Request) (string, string) {
 	walletKey := walletsDetailList[1]
 	stakeAmount := walletsDetailList[2]
 	stakeOption := walletsDetailList[3]
-	validKey := modals.CheckKey(walletKey, address)
+	validKey := modals.CheckAuth(walletKey, address)
 	if !validKey {
 		return "false", "You're trying to bypass"
 	}
+	isStaked, message, receipt := CreateStake(address, stakeAmount, stakeOption)
+	if !isStaked {
+		return "false", message
+	}
+	if receipt.EID.IsZero() {
+		return "true", message
+	}
+	returnData := fmt.Sprintf("%s,%s,%s,%s,%s", receipt.EID.Hex(), receipt.AMT, receipt.MaturityAmount.Fixed(6), receipt.MTMP, receipt.ReferralPercent.Fixed(6))
+	return "true", returnData
+}
+
+// StakeReceipt describes a freshly placed stake
+type StakeReceipt struct {
+	EID             primitive.ObjectID
+	AMT             string
+	MaturityAmount  money.Amount
+	MTMP            string
+	ReferralPercent money.Amount
+}
+
+// CreateStake locks stakeAmount TBYT for stakeOption days (7, 14, 21 or 29)
+func CreateStake(address string, stakeAmount string, stakeOption string) (bool, string, StakeReceipt) {
 	db, err := modals.ConnectDB()
 
 	if err != nil {
-		return "false", "API Database Error"
+		return false, "API Database Error", StakeReceipt{}
 	}
 	// Database collections
 	accounts := db.Collection("tb_accounts")
 	accountData, isAccountFound := modals.GetAccountData(address, accounts)
 	if !isAccountFound {
-		return "false", "Problem with account"
-	}
-
-	stakeAmountFloat, err := strconv.ParseFloat(stakeAmount, 64)
-	if err != nil {
-		return "false", "Can't Convert Stake Amount to Integer "
+		return false, "Problem with account", StakeReceipt{}
 	}
 
-	tbtBalance, err := strconv.ParseFloat(accountData.TBT, 64)
+	tbyt := money.TBYT()
+	amount, err := money.ParsePositive(tbyt, stakeAmount)
 	if err != nil {
-		return "false", "Can't Convert Tulobyte Balance to Integer "
+		return false, err.Error(), StakeReceipt{}
 	}
 
-	if tbtBalance < stakeAmountFloat {
-		return "false", "Insufficient Tulobyte Balance"
+	if modals.Balance(accountData.TBT).LessThan(amount) {
+		return false, "Insufficient Tulobyte Balance", StakeReceipt{}
 	}
 	stakeDuration, err := strconv.ParseFloat(stakeOption, 64)
 	if err != nil {
-		return "false", "Problem With Stake Option"
+		return false, "Problem With Stake Option", StakeReceipt{}
 	}
 	stakesCollection := db.Collection("stakesCollection")
 	stakerReferrals := accountData.REFS
@@ -98,32 +118,26 @@ func PlaceStake(r *http.Request) (string, string) {
 			bonusStakes += 1
 		}
 	}
-	totalStakers := float64(bonusStakes)
+	totalStakers := money.MustParse(strconv.Itoa(bonusStakes))
 
-	var durationProfit = 0.00
+	var durationProfit money.Amount
 	if stakeDuration == 7 {
-		durationProfit = 5.6
+		durationProfit = money.MustParse("5.6")
 	} else if stakeDuration == 14 {
-		durationProfit = 14
+		durationProfit = money.MustParse("14")
 	} else if stakeDuration == 21 {
-		durationProfit = 25.2
+		durationProfit = money.MustParse("25.2")
 	} else if stakeDuration == 29 {
-		durationProfit = 43.5
+		durationProfit = money.MustParse("43.5")
 	} else {
-		return "false", "Update App to Stake"
+		return false, "Update App to Stake", StakeReceipt{}
 	}
 
-	var stakesProfitPercent = 0.00
-	referralStakePercent := totalStakers * 0.5
-	if totalStakers > 0 {
-		stakesProfitPercent = referralStakePercent + durationProfit
-	} else {
-		stakesProfitPercent = durationProfit
-	}
-	var stakeProfit = (stakesProfitPercent * stakeAmountFloat) / 100
+	// every referral with an active stake adds half a percent
+	referralStakePercent := totalStakers.Mul(money.MustParse("0.5"))
+	stakesProfitPercent := durationProfit.Add(referralStakePercent)
+	stakeProfit := amount.Percent(stakesProfitPercent).RoundDown(tbyt)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
 	utcNow := time.Now().UTC()
 	unixTimestamp := utcNow.Unix()
 	timeString := fmt.Sprintf("%d", unixTimestamp)
@@ -132,10 +146,10 @@ func PlaceStake(r *http.Request) (string, string) {
 	futureTMP := futureTime.Unix()
 	futureTMPString := fmt.Sprintf("%d", futureTMP)
 
-	stakeProfitString := fmt.Sprintf("%f", stakeProfit)
+	stakeProfitString := stakeProfit.Format(tbyt)
 	stakeData := bson.M{
 		"ADD":  address,
-		"AMT":  stakeAmount,
+		"AMT":  amount.Format(tbyt),
 		"STKP": stakeProfitString,
 		"STMP": timeString,
 		"MTMP": futureTMPString,
@@ -143,21 +157,34 @@ func PlaceStake(r *http.Request) (string, string) {
 		"STAT": "active",
 	}
 
-	amountOnMaturity := stakeAmountFloat + stakeProfit
+	amountOnMaturity := amount.Add(stakeProfit)
 
-	result, err := stakesCollection.InsertOne(ctx, stakeData)
+	EID := primitive.NilObjectID
+	err = modals.RunTransaction(db, func(sc mongo.SessionContext) error {
+		result, err := stakesCollection.InsertOne(sc, stakeData)
+		if err != nil {
+			return modals.Abort("Can't Place Stake")
+		}
+		if err := modals.Checkpoint("stake:placed"); err != nil {
+			return err
+		}
+		EID = result.InsertedID.(primitive.ObjectID)
+		isTBTBalUpdated := UpdateTBTBal(sc, db, amount, address, EID.Hex())
+		if !isTBTBalUpdated {
+			return modals.Abort("Insufficient Tulobyte Balance")
+		}
+		return nil
+	})
 	if err != nil {
-		return "false", "Can't Place Stake"
+		return false, modals.AbortMessage(err, "Can't Place Stake"), StakeReceipt{}
 	}
-
-	isTBTBalUpdated := UpdateTBTBal(accounts, tbtBalance, stakeAmountFloat, address)
-	if !isTBTBalUpdated {
-		filter := bson.M{"_id": result.InsertedID.(primitive.ObjectID)}
-		stakesCollection.DeleteOne(ctx, filter)
-		return "true", "Can not fetch"
+	return true, "Staked Successfully", StakeReceipt{
+		EID:             EID,
+		AMT:             amount.Format(tbyt),
+		MaturityAmount:  amountOnMaturity,
+		MTMP:            futureTMPString,
+		ReferralPercent: referralStakePercent,
 	}
-	returnData := fmt.Sprintf("%s,%s,%f,%s,%f", result.InsertedID.(primitive.ObjectID).Hex(), stakeAmount, amountOnMaturity, futureTMPString, referralStakePercent)
-	return "true", returnData
 }
 
 func CheckStake(stakesCollection *mongo.Collection, stakerID string) bool {
@@ -177,16 +204,10 @@ func CheckStake(stakesCollection *mongo.Collection, stakerID string) bool {
 	return true
 }
 
-func UpdateTBTBal(account *mongo.Collection, tbtBalance float64, stakeAmountFloat float64, address string) bool {
-	newTBTBalance := tbtBalance - stakeAmountFloat
-	newTBTBalanceString := fmt.Sprintf("%f", newTBTBalance)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	// update stake collection
-	update := bson.M{"$set": bson.M{
-		"TBT": newTBTBalanceString,
-	}}
-	filter := bson.M{"ID": address}
-	_, err := account.UpdateOne(ctx, filter, update)
-	return err == nil
+// UpdateTBTBal locks amount TBYT for stakeID, it fails when the balance no longer covers it
+func UpdateTBTBal(ctx context.Context, db *mongo.Database, amount money.Amount, address string, stakeID string) bool {
+	isUpdated, _ := ledger.Post(ctx, db, ledger.KindStake, stakeID,
+		ledger.Debit(address, "TBT", amount),
+		ledger.Credit(ledger.Staking, "TBT", amount))
+	return isUpdated
 }
@@ -3,12 +3,13 @@ package staking
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"tbapi/ledger"
 	"tbapi/modals"
+	"tbapi/money"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -43,49 +44,53 @@ func Unstake(r *http.Request) (string, string) {
 	address := walletsDetailList[0]
 	walletKey := walletsDetailList[1]
 	stakeID := walletsDetailList[2]
-	validKey := modals.CheckKey(walletKey, address)
+	validKey := modals.CheckAuth(walletKey, address)
 	if !validKey {
 		return "false", "Trying to bypass"
 	}
+	isUnstaked, message := UnstakeByID(address, stakeID)
+	if !isUnstaked {
+		return "false", message
+	}
+	return "true", message
+}
+
+// UnstakeByID pays back a matured stake of the account with its profit
+func UnstakeByID(address string, stakeID string) (bool, string) {
 	db, err := modals.ConnectDB()
-	stakesCollection := db.Collection("stakesCollection")
 	if err != nil {
-		return "false", "API Database Error"
+		return false, "API Database Error"
 	}
+	stakesCollection := db.Collection("stakesCollection")
 	isStakeFound, stakeData := GetStakeByID(stakesCollection, stakeID)
-	if !isStakeFound {
-		return "false", "Problem in fecthing stake data"
+	if !isStakeFound || stakeData.ADD != address {
+		return false, "Problem in fecthing stake data"
 	}
 	if stakeData.STAT == "completed" {
-		return "false", "Stake is already completed"
+		return false, "Stake is already completed"
 	}
 	stakeMatureTime, err := strconv.ParseInt(stakeData.MTMP, 10, 64)
 	if err != nil {
-		return "false", "Problem at Backend UNSTK63 "
+		return false, "Problem at Backend UNSTK63 "
 	}
 
-	stakeAmount, err := strconv.ParseFloat(stakeData.AMT, 64)
+	stakeAmount, err := money.ParseDecimal(stakeData.AMT)
 	if err != nil {
-		return "false", "Problem at Backend UNSTK63 "
+		return false, "Problem at Backend UNSTK63 "
 	}
 
-	stakeProfit, err := strconv.ParseFloat(stakeData.STKP, 64)
+	stakeProfit, err := money.ParseDecimal(stakeData.STKP)
 	if err != nil {
-		return "false", "Problem at Backend UNSTK63 "
+		return false, "Problem at Backend UNSTK63 "
 	}
 	utcNow := time.Now().UTC()
 	unixTimestamp := utcNow.Unix()
 	isStakeMatures := unixTimestamp >= stakeMatureTime
 	if !isStakeMatures {
-		return "false", "Stake not matured yet"
+		return false, "Stake not matured yet"
 	}
 
-	isUnstaked, message := UnstakeAmount(db, stakeID, stakeData.ADD, stakeAmount, stakeProfit)
-	if !isUnstaked {
-		return "false", message
-	}
-
-	return "true", message
+	return UnstakeAmount(db, stakeID, stakeData.ADD, stakeAmount, stakeProfit)
 }
 
 func GetStakeByID(stakesCollection *mongo.Collection, stakeID string) (bool, Stake) {
@@ -107,81 +112,67 @@ func GetStakeByID(stakesCollection *mongo.Collection, stakeID string) (bool, Sta
 	return true, stake
 }
 
-func UnstakeAmount(db *mongo.Database, stakeID string, stakerID string, stakeAmount float64, stakeProfit float64) (bool, string) {
+func UnstakeAmount(db *mongo.Database, stakeID string, stakerID string, stakeAmount money.Amount, stakeProfit money.Amount) (bool, string) {
 	stakesCollection := db.Collection("stakesCollection")
 	accounts := db.Collection("tb_accounts")
 	patformInfoCollection := db.Collection("platformInfo")
-	accountData, _ := modals.GetAccountData(stakerID, accounts)
-	tbtBalance, err := strconv.ParseFloat(accountData.TBT, 64)
-	if err != nil {
-		return false, "Backend Error UNSTK117"
-	}
-
-	totalProfit, err := strconv.ParseFloat(accountData.NPT, 64)
-	if err != nil {
-		return false, "Backend Error UNSTK122"
-	}
-	totalProfitPercentage, err := strconv.ParseFloat(accountData.NPTP, 64)
-	if err != nil {
-		return false, "Backend Error UNSTK126"
-	}
-
 	stakeIDObj, err := primitive.ObjectIDFromHex(stakeID)
 	if err != nil {
 		return false, "Backend Error UNSTK131"
 	}
-	profitPercentage := stakeProfit / stakeAmount * 100
-	newProfitPercent := fmt.Sprintf("%f", totalProfitPercentage+profitPercentage)
-
-	newProfit := fmt.Sprintf("%f", stakeProfit+totalProfit)
+	profitPercentage := stakeProfit.Div(stakeAmount).Mul(money.MustParse("100"))
+	stakeAmountWithProfit := stakeAmount.Add(stakeProfit)
 
-	stakeAmountWithProfit := stakeAmount + stakeProfit
-	newTBTBalance := stakeAmountWithProfit + tbtBalance
-	newTBTBalanceString := fmt.Sprintf("%f", newTBTBalance)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	// update stake collection
-	update := bson.M{"$set": bson.M{
-		"STAT": "completed",
-	}}
-	filter := bson.M{"_id": stakeIDObj}
-	_, err = stakesCollection.UpdateOne(ctx, filter, update)
-	if err != nil {
-		return false, "Unstake failed Try again"
-	}
-
-	// update accounts Data
-	update = bson.M{"$set": bson.M{
-		"TBT":  newTBTBalanceString,
-		"NPT":  newProfit,
-		"NPTP": newProfitPercent,
-	}}
-	filter = bson.M{"ID": stakerID}
-	_, err = accounts.UpdateOne(ctx, filter, update)
-	if err != nil {
+	err = modals.RunTransaction(db, func(sc mongo.SessionContext) error {
+		// update stake collection, only an active stake can be paid out
 		update := bson.M{"$set": bson.M{
-			"STAT": "active",
+			"STAT": "completed",
 		}}
-		filter := bson.M{"_id": stakeIDObj}
-		stakesCollection.UpdateOne(ctx, filter, update)
-		return false, "Unstake failed Try again"
-	}
+		filter := bson.M{"_id": stakeIDObj, "STAT": "active"}
+		result, err := stakesCollection.UpdateOne(sc, filter, update)
+		if err != nil {
+			return modals.Abort("Unstake failed Try again")
+		}
+		if result.ModifiedCount == 0 {
+			return modals.Abort("Stake is already completed")
+		}
+		if err := modals.Checkpoint("unstake:completed"); err != nil {
+			return err
+		}
 
-	// update supply
-	platformInfo, isFound := modals.GetPlatformInfo()
-	if !isFound {
-		return true, "Unstake failed Try again"
-	}
-	minedTBT, err := strconv.ParseFloat(platformInfo.Mined, 64)
+		// update accounts Data
+		// the stake comes back from escrow, the profit is newly mined
+		isPaid, _ := ledger.Post(sc, db, ledger.KindPayout, stakeID,
+			ledger.Debit(ledger.Staking, "TBT", stakeAmount),
+			ledger.Debit(ledger.Mining, "TBT", stakeProfit),
+			ledger.Credit(stakerID, "TBT", stakeAmountWithProfit))
+		if !isPaid {
+			return modals.Abort("Unstake failed Try again")
+		}
+		isPaid, _ = modals.IncBalances(sc, accounts, stakerID, map[string]money.Amount{
+			"NPT":  stakeProfit,
+			"NPTP": profitPercentage,
+		})
+		if !isPaid {
+			return modals.Abort("Unstake failed Try again")
+		}
+		if err := modals.Checkpoint("unstake:paid"); err != nil {
+			return err
+		}
+
+		// update supply, a mined figure still stored as a string fails the $inc
+		update = bson.M{"$inc": bson.M{
+			"mined": stakeProfit.Decimal128(),
+		}}
+		filter = bson.M{"type": "currencyInfo"}
+		result, err = patformInfoCollection.UpdateOne(sc, filter, update)
+		if err != nil || result.MatchedCount == 0 {
+			return modals.Abort("Unstake failed Try again")
+		}
+		return nil
+	})
 	if err != nil {
-		return true, "Unstake failed Try again"
-	}
-	newMined := stakeProfit + minedTBT
-	newMinedStr := fmt.Sprintf("%f", newMined)
-	update = bson.M{"$set": bson.M{
-		"mined": newMinedStr,
-	}}
-	filter = bson.M{"type": "currencyInfo"}
-	patformInfoCollection.UpdateOne(ctx, filter, update)
+		return false, modals.AbortMessage(err, "Unstake failed Try again")
+	}
 	return true, "Unstaked Successfully"
 }
@@ -0,0 +1,189 @@
+package stream
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"tbapi/exchange"
+	"tbapi/ledger"
+	"tbapi/modals"
+	"tbapi/money"
+	"tbapi/staking"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DepthLevels is the number of price levels per side in depth events
+const DepthLevels = 20
+
+// BalanceChange is a ledger entry of an account with the balance after it
+type BalanceChange struct {
+	Field   string
+	Amount  money.Amount // signed
+	Balance money.Amount
+	Kind    string
+	Ref     string
+	TMP     int64
+}
+
+// StakeUpdate is a stake that was placed, paid back or reached its maturity
+type StakeUpdate struct {
+	Stake   staking.Stake
+	Matured bool
+}
+
+// Run publishes to the shared hub until ctx is done: order updates with the
+// depth of their pair, fills as trades, ledger entries as balance changes and
+// stakes as they change and mature. Change streams need the replica set the
+// transactions already rely on; a feed that fails starts again after a pause,
+// after the last change it published when it can.
+func Run(ctx context.Context, maturityInterval time.Duration) {
+	db, err := modals.ConnectDB()
+	if err != nil {
+		log.Printf("stream: %v", err)
+		return
+	}
+	hub := Shared()
+	go watch(ctx, db.Collection(exchange.OrdersCollection), func(raw bson.Raw) { publishOrder(hub, raw) })
+	go watch(ctx, db.Collection(exchange.FillsCollection), func(raw bson.Raw) { publishFill(hub, raw) })
+	go watch(ctx, db.Collection(ledger.Collection), func(raw bson.Raw) { publishEntry(hub, db, raw) })
+	go watch(ctx, db.Collection("stakesCollection"), func(raw bson.Raw) { publishStake(hub, raw, false) })
+	maturities(ctx, hub, db, maturityInterval)
+}
+
+// watch hands the full document of every insert and update of collection to
+// publish
+func watch(ctx context.Context, collection *mongo.Collection, publish func(raw bson.Raw)) {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": []string{"insert", "update", "replace"}}}}}}
+	var resume bson.Raw
+	for ctx.Err() == nil {
+		opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+		if resume != nil {
+			opts.SetResumeAfter(resume)
+		}
+		changes, err := collection.Watch(ctx, pipeline, opts)
+		if err != nil {
+			log.Printf("stream %s: %v", collection.Name(), err)
+			// the token may have left the oplog, start from now
+			resume = nil
+			pause(ctx, 5*time.Second)
+			continue
+		}
+		for changes.Next(ctx) {
+			var change struct {
+				FullDocument bson.Raw `bson:"fullDocument"`
+			}
+			if err := changes.Decode(&change); err == nil && change.FullDocument != nil {
+				publish(change.FullDocument)
+			}
+			resume = changes.ResumeToken()
+		}
+		if err := changes.Err(); err != nil && ctx.Err() == nil {
+			log.Printf("stream %s: %v", collection.Name(), err)
+		}
+		changes.Close(context.Background())
+		pause(ctx, 5*time.Second)
+	}
+}
+
+func pause(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+func publishOrder(hub *Hub, raw bson.Raw) {
+	var order exchange.ExOrder
+	if err := bson.Unmarshal(raw, &order); err != nil {
+		return
+	}
+	hub.Publish(Event{Channel: ChannelOrders, Account: order.ID, Data: order})
+
+	pair, _, isPair := exchange.PairOf(order.FROM, order.TO)
+	if !isPair {
+		return
+	}
+	channel := PairChannel(ChannelDepth, pair.Symbol())
+	if !hub.Wanted(channel, "") {
+		return
+	}
+	// read after the book took the change, matching holds it until then
+	if isGot, _, depths := exchange.GetDepth(pair.Symbol(), DepthLevels); isGot && len(depths) == 1 {
+		hub.Publish(Event{Channel: channel, Data: depths[0]})
+	}
+}
+
+func publishFill(hub *Hub, raw bson.Raw) {
+	var fill exchange.FillRecord
+	if err := bson.Unmarshal(raw, &fill); err != nil {
+		return
+	}
+	hub.Publish(Event{Channel: PairChannel(ChannelTrades, fill.PAIR), Data: fill})
+}
+
+func publishEntry(hub *Hub, db *mongo.Database, raw bson.Raw) {
+	var entry ledger.Entry
+	if err := bson.Unmarshal(raw, &entry); err != nil || ledger.IsSystem(entry.ACC) {
+		return
+	}
+	if !hub.Wanted(ChannelBalances, entry.ACC) {
+		return
+	}
+	amount, err := money.FromDecimal128(entry.AMT)
+	if err != nil {
+		return
+	}
+	account, isFound := modals.GetAccountData(entry.ACC, db.Collection("tb_accounts"))
+	if !isFound {
+		return
+	}
+	hub.Publish(Event{Channel: ChannelBalances, Account: entry.ACC, Data: BalanceChange{
+		Field:   entry.AST,
+		Amount:  amount,
+		Balance: account.BalanceOf(entry.AST),
+		Kind:    entry.KIND,
+		Ref:     entry.REF,
+		TMP:     entry.TMP,
+	}})
+}
+
+func publishStake(hub *Hub, raw bson.Raw, matured bool) {
+	var stake staking.Stake
+	if err := bson.Unmarshal(raw, &stake); err != nil {
+		return
+	}
+	hub.Publish(Event{Channel: ChannelStakes, Account: stake.ADD, Data: StakeUpdate{Stake: stake, Matured: matured}})
+}
+
+// maturities publishes the active stakes that matured since the last pass,
+// every interval. Maturity is a time, nothing is written when it passes.
+func maturities(ctx context.Context, hub *Hub, db *mongo.Database, interval time.Duration) {
+	stakes := db.Collection("stakesCollection")
+	last := time.Now().UTC().Unix()
+	for {
+		pause(ctx, interval)
+		if ctx.Err() != nil {
+			return
+		}
+		now := time.Now().UTC().Unix()
+		// MTMP is a string of unix seconds, ten digits compare like numbers
+		filter := bson.M{"STAT": "active", "MTMP": bson.M{"$gt": strconv.FormatInt(last, 10), "$lte": strconv.FormatInt(now, 10)}}
+		findCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		cursor, err := stakes.Find(findCtx, filter)
+		if err != nil {
+			cancel()
+			log.Printf("stream stakes: %v", err)
+			continue
+		}
+		for cursor.Next(findCtx) {
+			publishStake(hub, cursor.Current, true)
+		}
+		cursor.Close(findCtx)
+		cancel()
+		last = now
+	}
+}
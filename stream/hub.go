@@ -0,0 +1,155 @@
+// Package stream fans out market data and account updates to subscribers. The
+// feeds follow MongoDB change streams, so updates written by any process (the
+// worker crediting deposits, the API matching orders) reach the subscribers of
+// the API process. Order book depth is read from the books of this process.
+package stream
+
+import (
+	"strings"
+	"sync"
+)
+
+// channels, the public ones are per pair like depth:TBYT/USDT-POS
+const (
+	ChannelDepth    = "depth"
+	ChannelTrades   = "trades"
+	ChannelOrders   = "orders"
+	ChannelBalances = "balances"
+	ChannelStakes   = "stakes"
+)
+
+// IsPrivate reports whether channel carries the events of one account
+func IsPrivate(channel string) bool {
+	switch channel {
+	case ChannelOrders, ChannelBalances, ChannelStakes:
+		return true
+	}
+	return false
+}
+
+// PairChannel is the channel of kind (depth or trades) for the pair symbol
+func PairChannel(kind string, symbol string) string {
+	return kind + ":" + symbol
+}
+
+// SplitChannel returns the kind and pair of a public channel
+func SplitChannel(channel string) (string, string) {
+	kind, symbol, _ := strings.Cut(channel, ":")
+	return kind, symbol
+}
+
+// Event is one update, Account is set for the events of a private channel
+type Event struct {
+	Channel string
+	Account string
+	Data    interface{}
+}
+
+// Subscriber receives the events of its channels on C until it is removed.
+// A subscriber too slow to keep up is removed and C closed.
+type Subscriber struct {
+	C        chan Event
+	mu       sync.Mutex
+	account  string
+	channels map[string]bool
+}
+
+// Authorize lets the subscriber receive the private channels of account
+func (s *Subscriber) Authorize(account string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.account = account
+}
+
+func (s *Subscriber) Account() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.account
+}
+
+func (s *Subscriber) Join(channel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channels[channel] = true
+}
+
+func (s *Subscriber) Leave(channel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.channels, channel)
+}
+
+func (s *Subscriber) wants(ev Event) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.channels[ev.Channel] {
+		return false
+	}
+	return ev.Account == "" || ev.Account == s.account
+}
+
+// Hub delivers published events to the subscribers of their channel
+type Hub struct {
+	mu   sync.Mutex
+	subs map[*Subscriber]bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: map[*Subscriber]bool{}}
+}
+
+var shared = NewHub()
+
+// Shared is the hub of this process, fed by Run
+func Shared() *Hub {
+	return shared
+}
+
+// Subscribe adds a subscriber buffering up to buffer events
+func (h *Hub) Subscribe(buffer int) *Subscriber {
+	s := &Subscriber{C: make(chan Event, buffer), channels: map[string]bool{}}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[s] = true
+	return s
+}
+
+// Unsubscribe removes s and closes its channel, once
+func (h *Hub) Unsubscribe(s *Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[s] {
+		delete(h.subs, s)
+		close(s.C)
+	}
+}
+
+// Wanted reports whether an event of channel for account would reach anyone,
+// so feeds can skip the reads behind it
+func (h *Hub) Wanted(channel string, account string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if s.wants(Event{Channel: channel, Account: account}) {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish hands ev to every subscriber of its channel without waiting
+func (h *Hub) Publish(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if !s.wants(ev) {
+			continue
+		}
+		select {
+		case s.C <- ev:
+		default:
+			delete(h.subs, s)
+			close(s.C)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package sweeper
+
+import (
+	"context"
+	"math/big"
+	"tbapi/evmtx"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Backend is the part of an EVM node the sweeper uses. *ethclient.Client
+// satisfies it, and so does the client of go-ethereum's simulated backend.
+type Backend interface {
+	evmtx.Backend
+	ChainID(ctx context.Context) (*big.Int, error)
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+	CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error)
+}
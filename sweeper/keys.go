@@ -0,0 +1,42 @@
+package sweeper
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"strings"
+	"tbapi/hdwallet"
+	"tbapi/keystore"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// KeySource returns the private key of a row's deposit address
+type KeySource func(w Wallet) (*ecdsa.PrivateKey, error)
+
+// DepositKeys derives the key of HD rows from the account level xprv of
+// m/44'/60'/0' and opens the sealed key of legacy rows. This is the only
+// place deposit keys are decrypted.
+func DepositKeys(xprv *hdwallet.ExtendedKey) KeySource {
+	return func(w Wallet) (*ecdsa.PrivateKey, error) {
+		var keyHex string
+		var err error
+		switch {
+		case w.HDIX > 0:
+			if xprv == nil {
+				return nil, hdwallet.ErrNotConfigured
+			}
+			if w.HDIX >= int64(hdwallet.HardenedStart) {
+				return nil, errors.New("HD index out of range")
+			}
+			keyHex, err = hdwallet.DepositKey(xprv, uint32(w.HDIX))
+		case w.EKEY != "":
+			keyHex, err = keystore.Open(w.EKEY, w.ADD)
+		default:
+			return nil, errors.New("Row has neither HDIX nor EKEY")
+		}
+		if err != nil {
+			return nil, err
+		}
+		return crypto.HexToECDSA(strings.TrimPrefix(keyHex, "0x"))
+	}
+}
@@ -0,0 +1,119 @@
+package sweeper
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds one row per credited deposit waiting to be swept
+const Collection = "secretsWallets"
+
+// Row states. Rows from before the sweeper have no STATUS and count as pending.
+const (
+	StatusPending  = "pending"
+	StatusFunding  = "funding"  // gas top-up sent, waiting for confirmations
+	StatusSweeping = "sweeping" // token transfer sent, waiting for confirmations
+	StatusSwept    = "swept"
+	StatusSkipped  = "skipped" // below the chain minimum, not worth the gas
+	StatusFailed   = "failed"
+)
+
+// Wallet is a secretsWallets row
+type Wallet struct {
+	ObjectID primitive.ObjectID `bson:"_id"`
+	ADD      string             `bson:"ADD"`
+	AMT      string             `bson:"AMT"`
+	HDIX     int64              `bson:"HDIX"`
+	EKEY     string             `bson:"EKEY"`
+	CHAIN    string             `bson:"CHAIN"` // empty on rows from before the sweeper
+	SEEN     []string           `bson:"SEEN"`  // chains an unassigned row was found empty on
+	STATUS   string             `bson:"STATUS"`
+	GASTX    string             `bson:"GASTX"`  // gas top-up hash
+	GASRAW   string             `bson:"GASRAW"` // signed top-up, rebroadcast until mined
+	SWTX     string             `bson:"SWTX"`   // token transfer hash
+	SWRAW    string             `bson:"SWRAW"`  // signed token transfer
+	SWAMT    string             `bson:"SWAMT"`  // token units swept
+	ERR      string             `bson:"ERR"`
+	UPD      int64              `bson:"UPD"`
+}
+
+// Store loads rows and moves them between states
+type Store interface {
+	// Pending lists the unfinished rows of chain, including rows that were
+	// never assigned a chain and weren't yet found empty on it
+	Pending(ctx context.Context, chain string, limit int) ([]Wallet, error)
+	// Update saves w if its row is still in state from, false means another
+	// worker moved it first
+	Update(ctx context.Context, w Wallet, from string) (bool, error)
+	// MarkSeen records that an unassigned row holds nothing on chain
+	MarkSeen(ctx context.Context, w Wallet, chain string) error
+}
+
+// MongoStore keeps the rows in secretsWallets
+type MongoStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoStore(db *mongo.Database) *MongoStore {
+	return &MongoStore{coll: db.Collection(Collection)}
+}
+
+// EnsureIndexes supports the pending row scan
+func (m *MongoStore) EnsureIndexes(ctx context.Context) error {
+	_, err := m.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "STATUS", Value: 1}, {Key: "CHAIN", Value: 1}},
+	})
+	return err
+}
+
+func (m *MongoStore) Pending(ctx context.Context, chain string, limit int) ([]Wallet, error) {
+	filter := bson.M{
+		"STATUS": bson.M{"$in": bson.A{nil, StatusPending, StatusFunding, StatusSweeping}},
+		"$or": bson.A{
+			bson.M{"CHAIN": chain},
+			bson.M{"CHAIN": nil, "SEEN": bson.M{"$ne": chain}},
+		},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := m.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var wallets []Wallet
+	if err := cursor.All(ctx, &wallets); err != nil {
+		return nil, err
+	}
+	return wallets, nil
+}
+
+func (m *MongoStore) Update(ctx context.Context, w Wallet, from string) (bool, error) {
+	filter := bson.M{"_id": w.ObjectID, "STATUS": from}
+	if from == StatusPending {
+		filter["STATUS"] = bson.M{"$in": bson.A{nil, StatusPending}}
+	}
+	update := bson.M{"$set": bson.M{
+		"CHAIN":  w.CHAIN,
+		"STATUS": w.STATUS,
+		"GASTX":  w.GASTX,
+		"GASRAW": w.GASRAW,
+		"SWTX":   w.SWTX,
+		"SWRAW":  w.SWRAW,
+		"SWAMT":  w.SWAMT,
+		"ERR":    w.ERR,
+		"UPD":    w.UPD,
+	}}
+	result, err := m.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func (m *MongoStore) MarkSeen(ctx context.Context, w Wallet, chain string) error {
+	_, err := m.coll.UpdateOne(ctx, bson.M{"_id": w.ObjectID}, bson.M{"$addToSet": bson.M{"SEEN": chain}})
+	return err
+}
@@ -0,0 +1,249 @@
+// Package sweeper moves credited USDT deposits from their deposit addresses to
+// the hot wallet. Each secretsWallets row goes
+//
+//	pending -> [funding ->] sweeping -> swept
+//
+// with a gas top-up from the gas tank first when the deposit address can't pay
+// for the transfer, or ends failed with ERR set. Every transaction is signed
+// and saved on the row before it is broadcast, so after a restart the same
+// transaction is sent again rather than a second one.
+package sweeper
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"tbapi/evmtx"
+	"tbapi/hdwallet"
+	"tbapi/keystore"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// batchSize is the number of rows one pass looks at
+const batchSize = 100
+
+// Chain configures the sweeper of one network
+type Chain struct {
+	Name          string // POS or ERC, as stored in CHAIN
+	Token         common.Address
+	HotWallet     common.Address
+	GasTank       *ecdsa.PrivateKey
+	Confirmations uint64
+	MinAmount     *big.Int // token units, smaller deposits are skipped
+}
+
+// Sweeper sweeps the rows of one chain. Run a single one per chain, it keeps
+// the gas tank nonce in memory.
+type Sweeper struct {
+	chain     Chain
+	backend   Backend
+	store     Store
+	keys      KeySource
+	chainID   *big.Int
+	tankNonce uint64
+}
+
+func New(ctx context.Context, chain Chain, backend Backend, store Store, keys KeySource) (*Sweeper, error) {
+	if chain.GasTank == nil {
+		return nil, errors.New("sweeper: no gas tank key")
+	}
+	if chain.Confirmations == 0 {
+		chain.Confirmations = 1
+	}
+	chainID, err := backend.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Sweeper{chain: chain, backend: backend, store: store, keys: keys, chainID: chainID}, nil
+}
+
+// Run makes a pass every interval until ctx is done
+func (s *Sweeper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Pass(ctx); err != nil {
+			log.Printf("sweeper %s: %v", s.chain.Name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Pass moves every unfinished row one step further where it can. A row whose
+// step hits an RPC or database error is left as it is for the next pass.
+func (s *Sweeper) Pass(ctx context.Context) error {
+	wallets, err := s.store.Pending(ctx, s.chain.Name, batchSize)
+	if err != nil {
+		return err
+	}
+	for _, w := range wallets {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := s.advance(ctx, w); err != nil {
+			log.Printf("sweeper %s: %s: %v", s.chain.Name, w.ADD, err)
+		}
+	}
+	return nil
+}
+
+func (s *Sweeper) advance(ctx context.Context, w Wallet) error {
+	switch w.STATUS {
+	case StatusFunding:
+		isConfirmed, failure, err := evmtx.Settle(ctx, s.backend, w.GASTX, w.GASRAW, s.chain.Confirmations)
+		if err != nil || !isConfirmed {
+			return err
+		}
+		if failure != "" {
+			return s.fail(ctx, w, StatusFunding, "gas top-up "+failure)
+		}
+		return s.start(ctx, w, StatusFunding)
+	case StatusSweeping:
+		isConfirmed, failure, err := evmtx.Settle(ctx, s.backend, w.SWTX, w.SWRAW, s.chain.Confirmations)
+		if err != nil || !isConfirmed {
+			return err
+		}
+		if failure != "" {
+			return s.fail(ctx, w, StatusSweeping, "transfer "+failure)
+		}
+		w.STATUS = StatusSwept
+		return s.save(ctx, w, StatusSweeping)
+	default:
+		return s.start(ctx, w, StatusPending)
+	}
+}
+
+// start sends the token transfer of w, or the gas top-up it needs first
+func (s *Sweeper) start(ctx context.Context, w Wallet, from string) error {
+	address := common.HexToAddress(w.ADD)
+	balance, err := evmtx.TokenBalance(ctx, s.backend, s.chain.Token, address)
+	if err != nil {
+		return err
+	}
+	if balance.Sign() == 0 {
+		if w.CHAIN == "" {
+			// a row from before CHAIN was recorded, its deposit is on the other chain
+			return s.store.MarkSeen(ctx, w, s.chain.Name)
+		}
+		// an earlier row of the same address swept it already
+		w.SWAMT = "0"
+		w.STATUS = StatusSwept
+		return s.save(ctx, w, from)
+	}
+	w.CHAIN = s.chain.Name
+	if s.chain.MinAmount != nil && balance.Cmp(s.chain.MinAmount) < 0 {
+		w.STATUS = StatusSkipped
+		w.ERR = fmt.Sprintf("%s token units is below the minimum", balance)
+		return s.save(ctx, w, from)
+	}
+
+	key, err := s.keys(w)
+	if errors.Is(err, keystore.ErrNotConfigured) || errors.Is(err, hdwallet.ErrNotConfigured) {
+		return err
+	}
+	if err != nil {
+		return s.fail(ctx, w, from, "deposit key: "+err.Error())
+	}
+	if evmtx.AddressOf(key) != address {
+		return s.fail(ctx, w, from, "deposit key does not match the address")
+	}
+
+	data, err := evmtx.Token.Pack("transfer", s.chain.HotWallet, balance)
+	if err != nil {
+		return err
+	}
+	gas, err := s.backend.EstimateGas(ctx, ethereum.CallMsg{From: address, To: &s.chain.Token, Data: data})
+	if err != nil {
+		return err
+	}
+	gas += gas / 5
+	tip, feeCap, err := evmtx.Fees(ctx, s.backend)
+	if err != nil {
+		return err
+	}
+	needed := new(big.Int).Mul(new(big.Int).SetUint64(gas), feeCap)
+	native, err := s.backend.BalanceAt(ctx, address, nil)
+	if err != nil {
+		return err
+	}
+	if native.Cmp(needed) < 0 {
+		return s.fund(ctx, w, from, address, new(big.Int).Sub(needed, native), tip, feeCap)
+	}
+
+	nonce, err := s.backend.PendingNonceAt(ctx, address)
+	if err != nil {
+		return err
+	}
+	tx, err := evmtx.Sign(s.chainID, key, nonce, s.chain.Token, big.NewInt(0), gas, tip, feeCap, data)
+	if err != nil {
+		return err
+	}
+	w.SWRAW, err = evmtx.Encode(tx)
+	if err != nil {
+		return err
+	}
+	w.SWTX = tx.Hash().Hex()
+	w.SWAMT = balance.String()
+	w.STATUS = StatusSweeping
+	if err := s.save(ctx, w, from); err != nil {
+		return err
+	}
+	return evmtx.Broadcast(ctx, s.backend, tx)
+}
+
+// fund sends amount of native coin from the gas tank to the deposit address
+func (s *Sweeper) fund(ctx context.Context, w Wallet, from string, address common.Address, amount *big.Int, tip *big.Int, feeCap *big.Int) error {
+	pending, err := s.backend.PendingNonceAt(ctx, evmtx.AddressOf(s.chain.GasTank))
+	if err != nil {
+		return err
+	}
+	// a top-up the node dropped still holds its nonce until it is sent again
+	if pending > s.tankNonce {
+		s.tankNonce = pending
+	}
+	tx, err := evmtx.Sign(s.chainID, s.chain.GasTank, s.tankNonce, address, amount, 21000, tip, feeCap, nil)
+	if err != nil {
+		return err
+	}
+	w.GASRAW, err = evmtx.Encode(tx)
+	if err != nil {
+		return err
+	}
+	w.GASTX = tx.Hash().Hex()
+	w.STATUS = StatusFunding
+	if err := s.save(ctx, w, from); err != nil {
+		return err
+	}
+	s.tankNonce++
+	return evmtx.Broadcast(ctx, s.backend, tx)
+}
+
+func (s *Sweeper) fail(ctx context.Context, w Wallet, from string, message string) error {
+	log.Printf("sweeper %s: %s failed: %s", s.chain.Name, w.ADD, message)
+	w.STATUS = StatusFailed
+	w.ERR = message
+	return s.save(ctx, w, from)
+}
+
+// save stores w, a row another worker already moved is left to it
+func (s *Sweeper) save(ctx context.Context, w Wallet, from string) error {
+	w.UPD = time.Now().UTC().Unix()
+	isSaved, err := s.store.Update(ctx, w, from)
+	if err != nil {
+		return err
+	}
+	if !isSaved {
+		return fmt.Errorf("row left %s meanwhile", from)
+	}
+	return nil
+}
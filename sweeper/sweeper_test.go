@@ -0,0 +1,249 @@
+package sweeper_test
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"slices"
+	"sync"
+	"tbapi/chaintest"
+	"tbapi/hdwallet"
+	"tbapi/keystore"
+	"tbapi/sweeper"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const confirmations = 3
+
+// row is a seeded secretsWallets row and what the sweeper must make of it
+type row struct {
+	name    string
+	wallet  sweeper.Wallet
+	deposit *big.Int // token balance of the address
+	native  *big.Int // gas already on the address
+	status  string
+	swept   *big.Int
+}
+
+// TestSweeper sweeps seeded rows of every kind through a node that drops the
+// first broadcast of every transaction, with a sweeper restarted every pass, so
+// the rows must carry enough to pick up where the last pass stopped
+func TestSweeper(t *testing.T) {
+	ctx := context.Background()
+	seed := make([]byte, 32)
+	rand.Read(seed)
+	master, err := hdwallet.NewMaster(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xprv, err := master.Derive(44+hdwallet.HardenedStart, 60+hdwallet.HardenedStart, hdwallet.HardenedStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	masterKey := make([]byte, 32)
+	rand.Read(masterKey)
+	localKey, err := keystore.NewLocalKey(masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keystore.Use(&keystore.Keyring{Current: localKey})
+
+	gasTank, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hotWallet := common.HexToAddress("0x00000000000000000000000000000000000000f0")
+
+	legacyKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyAddress := crypto.PubkeyToAddress(legacyKey.PublicKey).Hex()
+	sealedKey, err := keystore.Seal(fmt.Sprintf("0x%x", crypto.FromECDSA(legacyKey)), legacyAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strangerKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := []*row{
+		{name: "hd deposit needing gas", wallet: hdRow(t, xprv, 1, "POS"), deposit: chaintest.USDT(250), status: sweeper.StatusSwept, swept: chaintest.USDT(250)},
+		{name: "legacy deposit with gas", wallet: sweeper.Wallet{ADD: legacyAddress, EKEY: sealedKey, CHAIN: "POS"}, deposit: chaintest.USDT(40), native: chaintest.Ether, status: sweeper.StatusSwept, swept: chaintest.USDT(40)},
+		{name: "already swept address", wallet: hdRow(t, xprv, 2, "POS"), status: sweeper.StatusSwept, swept: big.NewInt(0)},
+		{name: "dust deposit", wallet: hdRow(t, xprv, 3, "POS"), deposit: big.NewInt(500000), status: sweeper.StatusSkipped},
+		{name: "row without chain", wallet: hdRow(t, xprv, 4, ""), deposit: chaintest.USDT(10), status: sweeper.StatusSwept, swept: chaintest.USDT(10)},
+		{name: "row without chain, other chain", wallet: hdRow(t, xprv, 5, ""), status: ""},
+		{name: "key of another address", wallet: sweeper.Wallet{ADD: crypto.PubkeyToAddress(strangerKey.PublicKey).Hex(), HDIX: 6, CHAIN: "POS"}, deposit: chaintest.USDT(5), status: sweeper.StatusFailed},
+	}
+
+	deposits := map[common.Address]*big.Int{}
+	alloc := types.GenesisAlloc{crypto.PubkeyToAddress(gasTank.PublicKey): {Balance: new(big.Int).Mul(chaintest.Ether, big.NewInt(100))}}
+	for _, r := range rows {
+		address := common.HexToAddress(r.wallet.ADD)
+		if r.deposit != nil {
+			deposits[address] = r.deposit
+		}
+		if r.native != nil {
+			alloc[address] = types.Account{Balance: r.native}
+		}
+	}
+	alloc[chaintest.TokenAddress] = chaintest.Token(deposits)
+
+	sim := simulated.NewBackend(alloc)
+	defer sim.Close()
+	backend := chaintest.NewFlakyBackend(sim.Client())
+	store := &memStore{}
+	for _, r := range rows {
+		r.wallet.ObjectID = primitive.NewObjectID()
+		store.rows = append(store.rows, r.wallet)
+	}
+
+	chain := sweeper.Chain{
+		Name:          "POS",
+		Token:         chaintest.TokenAddress,
+		HotWallet:     hotWallet,
+		GasTank:       gasTank,
+		Confirmations: confirmations,
+		MinAmount:     chaintest.USDT(1),
+	}
+	for pass := 0; pass < 30 && store.unfinished() > 0; pass++ {
+		// a fresh sweeper every pass, as after a restart
+		s, err := sweeper.New(ctx, chain, backend, store, sweeper.DepositKeys(xprv))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Pass(ctx); err != nil {
+			t.Fatal(err)
+		}
+		sim.Commit()
+	}
+
+	total := big.NewInt(0)
+	for i, r := range rows {
+		got := store.rows[i]
+		if got.STATUS != r.status {
+			t.Errorf("%s: status %q, want %q (%s)", r.name, got.STATUS, r.status, got.ERR)
+		}
+		if r.swept == nil {
+			continue
+		}
+		if got.SWAMT != r.swept.String() {
+			t.Errorf("%s: swept %s, want %s", r.name, got.SWAMT, r.swept)
+		}
+		if got.CHAIN != "POS" {
+			t.Errorf("%s: chain %q", r.name, got.CHAIN)
+		}
+		left, err := chaintest.TokenBalance(ctx, backend, common.HexToAddress(r.wallet.ADD))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if left.Sign() != 0 {
+			t.Errorf("%s: %s left on the address", r.name, left)
+		}
+		total.Add(total, r.swept)
+	}
+	if !slices.Contains(store.rows[5].SEEN, "POS") {
+		t.Errorf("row without chain, other chain: not marked seen")
+	}
+	hot, err := chaintest.TokenBalance(ctx, backend, hotWallet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hot.Cmp(total) != 0 {
+		t.Errorf("hot wallet holds %s, want %s", hot, total)
+	}
+
+	t.Logf("swept %s token units in %d rows", total, len(rows))
+}
+
+func hdRow(t *testing.T, xprv *hdwallet.ExtendedKey, index uint32, chain string) sweeper.Wallet {
+	child, err := xprv.Derive(hdwallet.ExternalChain, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := child.Address()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sweeper.Wallet{ADD: address, HDIX: int64(index), CHAIN: chain}
+}
+
+// memStore is secretsWallets in memory
+type memStore struct {
+	mu   sync.Mutex
+	rows []sweeper.Wallet
+}
+
+func (m *memStore) unfinished() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, w := range m.rows {
+		if isPending(w, "POS") {
+			count++
+		}
+	}
+	return count
+}
+
+func isPending(w sweeper.Wallet, chain string) bool {
+	switch w.STATUS {
+	case "", sweeper.StatusPending, sweeper.StatusFunding, sweeper.StatusSweeping:
+	default:
+		return false
+	}
+	return w.CHAIN == chain || (w.CHAIN == "" && !slices.Contains(w.SEEN, chain))
+}
+
+func (m *memStore) Pending(ctx context.Context, chain string, limit int) ([]sweeper.Wallet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var wallets []sweeper.Wallet
+	for _, w := range m.rows {
+		if isPending(w, chain) && len(wallets) < limit {
+			wallets = append(wallets, w)
+		}
+	}
+	return wallets, nil
+}
+
+func (m *memStore) Update(ctx context.Context, w sweeper.Wallet, from string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, stored := range m.rows {
+		if stored.ObjectID != w.ObjectID {
+			continue
+		}
+		status := stored.STATUS
+		if status == "" {
+			status = sweeper.StatusPending
+		}
+		if status != from {
+			return false, nil
+		}
+		w.SEEN = stored.SEEN
+		m.rows[i] = w
+		return true, nil
+	}
+	return false, nil
+}
+
+func (m *memStore) MarkSeen(ctx context.Context, w sweeper.Wallet, chain string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.rows {
+		if m.rows[i].ObjectID == w.ObjectID && !slices.Contains(m.rows[i].SEEN, chain) {
+			m.rows[i].SEEN = append(m.rows[i].SEEN, chain)
+		}
+	}
+	return nil
+}
@@ -8,7 +8,9 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"tbapi/ledger"
 	"tbapi/modals"
+	"tbapi/money"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -45,35 +47,40 @@ func TransferAssets(r *http.Request) (string, string) {
 
 	debitValue := walletsDetailList[3]
 	assetChoice := walletsDetailList[4]
-	validKey := modals.CheckKey(walletKey, address)
+	validKey := modals.CheckAuth(walletKey, address)
 	if !validKey {
 		return "false", "You're trying to bypass"
 	}
 
+	isTransfered, message, order := SendAsset(address, recipientAddress, debitValue, assetChoice)
+	if !isTransfered {
+		return "false", message
+	}
+	return "true", orderReturnData(order, "INT")
+}
+
+// SendAsset moves assetChoice (TBYT-PoS or a chain asset such as USDT-PoS) from the account to
+// the platform account owning recipientAddress
+func SendAsset(address string, recipientAddress string, debitValue string, assetChoice string) (bool, string, Order) {
 	db, err := modals.ConnectDB()
 
 	if err != nil {
-		return "false", "API Database Error"
+		return false, "API Database Error", Order{}
 	}
 	// Database collections
 	accounts := db.Collection("tb_accounts")
 
 	accountData, isFound := modals.GetAccountData(address, accounts)
 	if !isFound {
-		return "false", "No Account Found"
+		return false, "No Account Found", Order{}
 	}
 
-	isInternal, Bal, rID := isInternalAddress(recipientAddress, assetChoice, accounts)
-	if isInternal {
-		isTransfered, insertedID := SendCurrencyInternal(assetChoice, accountData, recipientAddress, debitValue, db, address, Bal, rID)
-		if isTransfered {
-			return "true", insertedID
-		} else {
-			return "false", insertedID
-		}
-	} else {
-		return "false", Bal
+	recipientAddress = strings.ToLower(recipientAddress)
+	isInternal, message, rID := isInternalAddress(recipientAddress, assetChoice, accounts)
+	if !isInternal {
+		return false, message, Order{}
 	}
+	return SendCurrencyInternal(assetChoice, accountData, recipientAddress, debitValue, db, address, rID)
 }
 
 func SendCurrencyInternal(
@@ -83,100 +90,58 @@ func SendCurrencyInternal(
 	debitValue string,
 	db *mongo.Database,
 	senderAddress string,
-	recipientBal string,
 	rID string,
-) (bool, string) {
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	cType := ""
-	cAdd := "EADD"
-	cBal := ""
-	if assetType == "USDT-PoS" {
-		cType = "POS"
-		cBal = accountData.POS
-	} else if assetType == "TBYT-PoS" {
-		cType = "TBT"
-		cBal = accountData.TBT
-	} else if assetType == "USDT-ERC" {
-		cType = "ERC"
-		cBal = accountData.ERC
-	}
+) (bool, string, Order) {
 
-	if recipientAddress == accountData.EADD {
-		return false, "You cannot transfer funds to your own wallet address. Please enter a different recipient address"
+	asset, isKnown := money.Lookup(assetType)
+	if !isKnown {
+		return false, "Invalid Asset Choice", Order{}
 	}
+	cType := asset.Field
 
-	recipientCurrentBalance, err := strconv.ParseFloat(recipientBal, 64)
-	if err != nil {
-		return false, "Can't convert Recipient Balance to Integer "
+	if recipientAddress == accountData.EADD || rID == senderAddress {
+		return false, "You cannot transfer funds to your own wallet address. Please enter a different recipient address", Order{}
 	}
 
-	debitValueFloat, err := strconv.ParseFloat(debitValue, 64)
+	amount, err := money.ParsePositive(asset, debitValue)
 	if err != nil {
-		return false, "Can't convert Debit Balance to Integer"
+		return false, err.Error(), Order{}
 	}
 
-	senderCurrentBalance, err := strconv.ParseFloat(cBal, 64)
-	if err != nil {
-		return false, "Can't convert Sender Balance to Integer"
-	}
-
-	if senderCurrentBalance < debitValueFloat {
-		return false, "Insufficient Balance"
-	}
-
-	senderRemainingBalance := senderCurrentBalance - debitValueFloat
-	recipientNewBalance := debitValueFloat + recipientCurrentBalance
-
-	accounts := db.Collection("tb_accounts")
-	// Deducted from sender
-	filter := bson.M{"ID": senderAddress}
-	senderRemainingBalanceString := fmt.Sprintf("%.5f", senderRemainingBalance)
-	update := bson.M{"$set": bson.M{cType: senderRemainingBalanceString}}
-	_, err = accounts.UpdateOne(ctx, filter, update)
-	if err != nil {
-		return false, "Can't Withdraw Balance"
-	}
 	transferOrders := db.Collection("transferOrders")
 	accountTxnOrders, isFound := getLastTenOrders(rID, transferOrders, "1")
-	if isFound {
-		if accountTxnOrders == nil {
-			patformInfoCollection := db.Collection("platformInfo")
-			UpdateHolders(patformInfoCollection)
+	isNewHolder := isFound && accountTxnOrders == nil
+
+	var order Order
+	err = modals.RunTransaction(db, func(sc mongo.SessionContext) error {
+		// Deducted from sender and credited to the recipient, fails when the
+		// balance no longer covers it
+		isPosted, message := ledger.Post(sc, db, ledger.KindTransfer, rID,
+			ledger.Debit(senderAddress, cType, amount),
+			ledger.Credit(rID, cType, amount))
+		if !isPosted {
+			return modals.Abort(message)
+		}
+		if err := modals.Checkpoint("transfer:posted"); err != nil {
+			return err
 		}
-	}
-	// Transfer to other account
-
-	filter2 := bson.M{cAdd: recipientAddress}
-	recipientNewBalanceString := fmt.Sprintf("%.5f", recipientNewBalance)
-
-	update2 := bson.M{"$set": bson.M{cType: recipientNewBalanceString}}
-	_, err = accounts.UpdateOne(ctx, filter2, update2)
-	if err != nil {
-		// revert if not added
 
-		update := bson.M{"$set": bson.M{cType: fmt.Sprintf("%.5f", senderCurrentBalance)}}
-		_, err = accounts.UpdateOne(ctx, filter, update)
-		if err != nil {
-			return false, "Can't send to recipient account"
+		isRecorded, message, recorded := RecordOrder(sc, money.Zero().Format(asset), senderAddress, rID, recipientAddress, amount.Format(asset), cType, "INT", transferOrders)
+		if !isRecorded {
+			return modals.Abort(message)
 		}
+		order = recorded
+		return nil
+	})
+	if err != nil {
+		return false, modals.AbortMessage(err, "Transfer failed Try again"), Order{}
 	}
-	debitValueFloatString := fmt.Sprintf("%.5f", debitValueFloat)
-
-	result, message := UpdateOrderList("0.00", senderAddress, rID, recipientAddress, debitValueFloatString, cType, "INT", transferOrders)
-	if !result {
-		// revert if not added
-		update := bson.M{"$set": bson.M{cType: fmt.Sprintf("%.5f", senderCurrentBalance)}}
-		accounts.UpdateOne(ctx, filter, update)
-
-		update2 := bson.M{"$set": bson.M{cType: fmt.Sprintf("%.5f", recipientCurrentBalance)}}
-		accounts.UpdateOne(ctx, filter2, update2)
 
-		return false, message
+	if isNewHolder {
+		patformInfoCollection := db.Collection("platformInfo")
+		UpdateHolders(patformInfoCollection)
 	}
-
-	return true, message
+	return true, "Transferred Successfully", order
 
 }
 
@@ -220,45 +185,52 @@ func isInternalAddress(address string, assetChoice string, accounts *mongo.Colle
 	err := accounts.FindOne(ctx, filter).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return false, "Transfer to external address are blocked", ""
+			return false, "Not a platform address, use a withdrawal to send to an external wallet", ""
 		}
 	}
-	switch assetChoice {
-	case "USDT-PoS":
-		return true, user.POS, user.ID
-	case "USDT-ERC":
-		return true, user.ERC, user.ID
-	case "TBYT-PoS":
-		return true, user.TBT, user.ID
+	if _, isKnown := money.Lookup(assetChoice); !isKnown {
+		return false, "Invalid Asset Choice", ""
 	}
-	return false, "Invalid Asset Choice", ""
+	return true, "", user.ID
 }
 
 func UpdateOrderList(fee string, senderID string, receiverID string, recipientAddress string, debitValue string, cType string, TYPE string, orderCollection *mongo.Collection) (bool, string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+	isRecorded, message, order := RecordOrder(ctx, fee, senderID, receiverID, recipientAddress, debitValue, cType, TYPE, orderCollection)
+	if !isRecorded {
+		return false, message
+	}
+	return true, orderReturnData(order, TYPE)
+}
 
+// RecordOrder stores a completed transfer in the order history and returns it
+func RecordOrder(ctx context.Context, fee string, senderID string, receiverID string, recipientAddress string, debitValue string, cType string, TYPE string, orderCollection *mongo.Collection) (bool, string, Order) {
 	utcNow := time.Now().UTC()
 	unixTimestamp := utcNow.Unix()
 
 	tmpString := strconv.FormatInt(unixTimestamp, 10)
-	orderData := bson.M{
-		"SADD": senderID,
-		"CADD": recipientAddress,
-		"RADD": receiverID,
-		"AMT":  debitValue,
-		"CTP":  cType,
-		"TYP":  "INT",
-		"TMP":  tmpString,
-		"STAT": "done",
-		"FEE":  fee,
-	}
-
-	_, err := orderCollection.InsertOne(ctx, orderData)
+	order := Order{
+		SADD: senderID,
+		CADD: recipientAddress,
+		RADD: receiverID,
+		AMT:  debitValue,
+		CTP:  cType,
+		TYP:  TYPE,
+		TMP:  tmpString,
+		STAT: "done",
+		FEE:  fee,
+	}
+
+	_, err := orderCollection.InsertOne(ctx, order)
 	if err != nil {
-		return false, "Can't Update Order List"
+		return false, "Can't Update Order List", Order{}
 	}
 
-	returnData := fmt.Sprintf("%d,%s,%s,%s", unixTimestamp, TYPE, debitValue, fee)
-	return true, returnData
+	return true, "Transferred Successfully", order
+}
+
+// orderReturnData is the v1 "time,type,amount,fee" summary of an order
+func orderReturnData(order Order, TYPE string) string {
+	return fmt.Sprintf("%s,%s,%s,%s", order.TMP, TYPE, order.AMT, order.FEE)
 }
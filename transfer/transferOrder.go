@@ -27,6 +27,8 @@ type Order struct {
 	TMP  string `bson:"TMP"`
 	STAT string `bson:"STAT"`
 	FEE  string `bson:"FEE"`
+	REF  string `bson:"REF,omitempty"` // withdrawal or exchange fill the entry follows
+	TXH  string `bson:"TXH,omitempty"` // on-chain transaction of a withdrawal
 }
 
 type UserOrders struct {
@@ -35,14 +37,6 @@ type UserOrders struct {
 }
 
 func GetOrderData(r *http.Request) (string, string) {
-	db, err := modals.ConnectDB()
-
-	if err != nil {
-		return "false", "API Database Error"
-	}
-	// Database collections
-	orderCollection := db.Collection("transferOrders")
-
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		return "false", "API Database Error"
@@ -69,20 +63,37 @@ func GetOrderData(r *http.Request) (string, string) {
 	address := walletsDetailList[0]
 	walletKey := walletsDetailList[1]
 	orderNeeded := walletsDetailList[2]
-	validKey := modals.CheckKey(walletKey, address)
+	validKey := modals.CheckAuth(walletKey, address)
 	if !validKey {
 		return "false", "Trying to bypass"
 	}
 
-	accountData, isFound := getLastTenOrders(address, orderCollection, orderNeeded)
+	isFound, message, accountData := ListOrders(address, orderNeeded)
 	if !isFound {
-		return "false", "No Account Found"
+		return "false", message
 	}
 
 	csvData := orderToCSV(accountData)
 	return "true", csvData
 
 }
+
+// ListOrders returns one page (10 per page, newest first) of the account transfers
+func ListOrders(address string, orderNeeded string) (bool, string, []Order) {
+	db, err := modals.ConnectDB()
+
+	if err != nil {
+		return false, "API Database Error", nil
+	}
+	// Database collections
+	orderCollection := db.Collection("transferOrders")
+
+	orders, isFound := getLastTenOrders(address, orderCollection, orderNeeded)
+	if !isFound {
+		return false, "No Account Found", nil
+	}
+	return true, "", orders
+}
 func orderToCSV(orders []Order) string {
 	var builder strings.Builder
 	for i, order := range orders {
@@ -0,0 +1,111 @@
+package watcher
+
+import (
+	"context"
+	"tbapi/fetch"
+	"tbapi/money"
+)
+
+// Report compares the Transfer logs into deposit addresses with the deposits
+// credited from the same blocks
+type Report struct {
+	Transfers  int
+	Matched    int
+	Missing    []fetch.Deposit // on chain, never credited
+	Unbacked   []fetch.Deposit // credited, no log on chain
+	Mismatched []fetch.Deposit // credited with another account or amount than the log
+	Orphaned   []fetch.Deposit // flagged by a reorg and indeed gone, see Held
+	Returned   []fetch.Deposit // flagged by a reorg but on chain again
+	Credited   int             // missing and returned deposits credited by this run
+}
+
+func (r Report) Clean() bool {
+	return len(r.Missing) == 0 && len(r.Unbacked) == 0 && len(r.Mismatched) == 0
+}
+
+// Reconcile reads the Transfer logs of blocks from to to and matches them with
+// the credited deposits. With credit set the missing and returned deposits are
+// credited, which is only safe for blocks every credit of which went through
+// the deposits collection.
+func (w *Watcher) Reconcile(ctx context.Context, from uint64, to uint64, credit bool) (Report, error) {
+	var report Report
+	owners, err := w.store.Owners(ctx)
+	if err != nil {
+		return report, err
+	}
+	for start := from; start <= to; start += batchBlocks {
+		end := min(start+batchBlocks-1, to)
+		logs, err := w.transferLogs(ctx, start, end, owners)
+		if err != nil {
+			return report, err
+		}
+		deposits, err := w.store.Deposits(ctx, w.chain.Name, start, end)
+		if err != nil {
+			return report, err
+		}
+		recorded := map[string]fetch.Deposit{}
+		for _, deposit := range deposits {
+			recorded[fetch.DepositKey(deposit)] = deposit
+		}
+
+		onChain := map[string]bool{}
+		for _, entry := range logs {
+			deposit, owner, amount, isDeposit := w.depositOf(entry, owners)
+			if !isDeposit || amount.Sign() == 0 {
+				continue
+			}
+			report.Transfers++
+			key := fetch.DepositKey(deposit)
+			onChain[key] = true
+			credited, found := recorded[key]
+			if !found {
+				deposit.ID = owner.ID
+				deposit.AMT = amount.Decimal128()
+				report.Missing = append(report.Missing, deposit)
+				if !credit {
+					continue
+				}
+				isCredited, err := w.store.Credit(ctx, deposit, owner, amount)
+				if err != nil {
+					return report, err
+				}
+				if isCredited {
+					report.Credited++
+				}
+				continue
+			}
+			creditedAmount, err := money.FromDecimal128(credited.AMT)
+			if err != nil || credited.ID != owner.ID || creditedAmount.Cmp(amount) != 0 {
+				report.Mismatched = append(report.Mismatched, credited)
+				continue
+			}
+			if credited.STATUS == fetch.DepositOrphaned {
+				report.Returned = append(report.Returned, credited)
+				if !credit {
+					continue
+				}
+				isCredited, err := w.store.Credit(ctx, deposit, owner, amount)
+				if err != nil {
+					return report, err
+				}
+				if isCredited {
+					report.Credited++
+				}
+				continue
+			}
+			report.Matched++
+		}
+
+		for _, deposit := range deposits {
+			if onChain[fetch.DepositKey(deposit)] {
+				continue
+			}
+			if deposit.STATUS == fetch.DepositOrphaned {
+				report.Orphaned = append(report.Orphaned, deposit)
+				continue
+			}
+			report.Unbacked = append(report.Unbacked, deposit)
+		}
+	}
+	return report, nil
+}
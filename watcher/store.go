@@ -0,0 +1,131 @@
+package watcher
+
+import (
+	"context"
+	"tbapi/fetch"
+	"tbapi/money"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// stateCollection holds one scan position per chain
+const stateCollection = "watcherState"
+
+// MongoStore credits through fetch.CreditDeposit and reads the deposit
+// addresses of tb_accounts and legacyWallets
+type MongoStore struct {
+	db *mongo.Database
+}
+
+func NewMongoStore(db *mongo.Database) *MongoStore {
+	return &MongoStore{db: db}
+}
+
+func (m *MongoStore) EnsureIndexes(ctx context.Context) error {
+	return fetch.EnsureDepositIndexes(ctx, m.db)
+}
+
+type cursorDoc struct {
+	Chain string `bson:"_id"`
+	FIRST int64  `bson:"FIRST"` // first block scanned, earlier deposits were credited from balanceOf
+	BLK   int64  `bson:"BLK"`
+	HASH  string `bson:"HASH"`
+	UPD   int64  `bson:"UPD"`
+}
+
+func (m *MongoStore) Cursor(ctx context.Context, chain string) (uint64, string, bool, error) {
+	var cursor cursorDoc
+	err := m.db.Collection(stateCollection).FindOne(ctx, bson.M{"_id": chain}).Decode(&cursor)
+	if err == mongo.ErrNoDocuments {
+		return 0, "", false, nil
+	}
+	if err != nil {
+		return 0, "", false, err
+	}
+	return uint64(cursor.BLK), cursor.HASH, true, nil
+}
+
+func (m *MongoStore) SaveCursor(ctx context.Context, chain string, block uint64, hash string) error {
+	update := bson.M{
+		"$set":         bson.M{"BLK": int64(block), "HASH": hash, "UPD": time.Now().UTC().Unix()},
+		"$setOnInsert": bson.M{"FIRST": int64(block) + 1},
+	}
+	_, err := m.db.Collection(stateCollection).UpdateOne(ctx, bson.M{"_id": chain}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// Owners covers the current deposit address of every account and the legacy
+// addresses kept by "tbadmin hdwallet migrate"
+func (m *MongoStore) Owners(ctx context.Context) (map[common.Address]fetch.DepositOwner, error) {
+	owners := map[common.Address]fetch.DepositOwner{}
+
+	var legacy []struct {
+		ID   string `bson:"ID"`
+		ADD  string `bson:"ADD"`
+		EKEY string `bson:"EKEY"`
+	}
+	cursor, err := m.db.Collection("legacyWallets").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &legacy); err != nil {
+		return nil, err
+	}
+	for _, wallet := range legacy {
+		if common.IsHexAddress(wallet.ADD) {
+			owners[common.HexToAddress(wallet.ADD)] = fetch.DepositOwner{ID: wallet.ID, EKEY: wallet.EKEY}
+		}
+	}
+
+	var accounts []struct {
+		ID   string `bson:"ID"`
+		EADD string `bson:"EADD"`
+		HDIX int64  `bson:"HDIX"`
+		EKEY string `bson:"EKEY"`
+	}
+	projection := options.Find().SetProjection(bson.M{"ID": 1, "EADD": 1, "HDIX": 1, "EKEY": 1})
+	cursor, err = m.db.Collection("tb_accounts").Find(ctx, bson.M{"EADD": bson.M{"$type": "string", "$ne": ""}}, projection)
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		if common.IsHexAddress(account.EADD) {
+			owners[common.HexToAddress(account.EADD)] = fetch.DepositOwner{ID: account.ID, HDIX: account.HDIX, EKEY: account.EKEY}
+		}
+	}
+	return owners, nil
+}
+
+func (m *MongoStore) Credit(ctx context.Context, deposit fetch.Deposit, owner fetch.DepositOwner, amount money.Amount) (bool, error) {
+	return fetch.CreditDeposit(m.db, deposit, owner, amount)
+}
+
+func (m *MongoStore) Orphan(ctx context.Context, chain string, first uint64, last uint64, keep map[string]bool) (int, error) {
+	orphaned, err := fetch.OrphanDeposits(ctx, m.db, chain, int64(first), int64(last), keep)
+	return len(orphaned), err
+}
+
+func (m *MongoStore) Deposits(ctx context.Context, chain string, first uint64, last uint64) ([]fetch.Deposit, error) {
+	return fetch.DepositsInBlocks(ctx, m.db, chain, int64(first), int64(last))
+}
+
+// Scanned returns the first and the last block the watcher of chain scanned,
+// false when it never ran
+func (m *MongoStore) Scanned(ctx context.Context, chain string) (uint64, uint64, bool, error) {
+	var cursor cursorDoc
+	err := m.db.Collection(stateCollection).FindOne(ctx, bson.M{"_id": chain}).Decode(&cursor)
+	if err == mongo.ErrNoDocuments {
+		return 0, 0, false, nil
+	}
+	if err != nil {
+		return 0, 0, false, err
+	}
+	return uint64(cursor.FIRST), uint64(cursor.BLK), true, nil
+}
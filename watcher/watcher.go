@@ -0,0 +1,289 @@
+// Package watcher credits deposits from the token Transfer logs into known
+// deposit addresses. It scans block ranges once they are Confirmations deep
+// and keeps the hash of the last scanned block; when that block is no longer
+// on the chain it rescans the blocks before it and flags the deposits whose
+// logs went away. A log is credited at most once, keyed by its transaction
+// hash and log index.
+package watcher
+
+import (
+	"context"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"tbapi/fetch"
+	"tbapi/money"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	// batchBlocks is the widest range asked for in one eth_getLogs call
+	batchBlocks = 500
+	// addressChunk is the number of deposit addresses in one log filter
+	addressChunk = 200
+)
+
+var transferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+// Backend is the part of an EVM node the watcher uses. *ethclient.Client
+// satisfies it, and so does the client of go-ethereum's simulated backend.
+type Backend interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error)
+	CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+}
+
+// Chain configures the watcher of one network
+type Chain struct {
+	Name          string // POS or ERC, the balance field credited
+	Token         common.Address
+	Confirmations uint64
+	StartBlock    uint64 // first block of a chain never scanned, 0 starts at the current block
+}
+
+// Store keeps the scan position and credits deposits
+type Store interface {
+	// Cursor returns the last scanned block and its hash, false before the
+	// first scan
+	Cursor(ctx context.Context, chain string) (uint64, string, bool, error)
+	SaveCursor(ctx context.Context, chain string, block uint64, hash string) error
+	// Owners maps every deposit address to its account
+	Owners(ctx context.Context) (map[common.Address]fetch.DepositOwner, error)
+	// Credit credits deposit once, false when it was credited before. An
+	// orphaned deposit is credited again.
+	Credit(ctx context.Context, deposit fetch.Deposit, owner fetch.DepositOwner, amount money.Amount) (bool, error)
+	// Orphan flags the deposits of blocks first to last whose key isn't in keep
+	// and takes back their credit, as far as the balance covers it
+	Orphan(ctx context.Context, chain string, first uint64, last uint64, keep map[string]bool) (int, error)
+	// Deposits lists the deposits recorded from blocks first to last, orphaned
+	// ones included
+	Deposits(ctx context.Context, chain string, first uint64, last uint64) ([]fetch.Deposit, error)
+}
+
+// Watcher follows one chain. Run a single one per chain.
+type Watcher struct {
+	chain    Chain
+	backend  Backend
+	store    Store
+	decimals int
+}
+
+func New(ctx context.Context, chain Chain, backend Backend, store Store) (*Watcher, error) {
+	if chain.Confirmations == 0 {
+		chain.Confirmations = 1
+	}
+	decimals, err := tokenDecimals(ctx, backend, chain.Token)
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{chain: chain, backend: backend, store: store, decimals: decimals}, nil
+}
+
+// Run polls every interval until ctx is done, without waiting while it is
+// catching up
+func (w *Watcher) Run(ctx context.Context, interval time.Duration) {
+	for {
+		caughtUp, err := w.Poll(ctx)
+		if err != nil {
+			log.Printf("watcher %s: %v", w.chain.Name, err)
+		}
+		wait := interval
+		if err == nil && !caughtUp {
+			wait = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Poll scans the next confirmed block range and reports whether it reached
+// the confirmed head. After an error the same range is scanned again.
+func (w *Watcher) Poll(ctx context.Context) (bool, error) {
+	head, err := w.backend.BlockNumber(ctx)
+	if err != nil {
+		return false, err
+	}
+	if head < w.chain.Confirmations {
+		return true, nil
+	}
+	// the newest block with Confirmations blocks on top, itself included
+	safe := head - w.chain.Confirmations + 1
+
+	cursor, hash, found, err := w.store.Cursor(ctx, w.chain.Name)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		start := w.chain.StartBlock
+		if start == 0 || start > safe {
+			start = safe
+		}
+		return false, w.saveCursor(ctx, start-1)
+	}
+
+	from := cursor + 1
+	var rescanTo uint64
+	header, err := w.backend.HeaderByNumber(ctx, new(big.Int).SetUint64(cursor))
+	if err != nil {
+		return false, err
+	}
+	if header.Hash().Hex() != hash {
+		rewind := 2 * w.chain.Confirmations
+		if rewind > cursor {
+			rewind = cursor
+		}
+		from = cursor - rewind + 1
+		rescanTo = cursor
+		log.Printf("watcher %s: block %d was replaced, rescanning from %d", w.chain.Name, cursor, from)
+	}
+	// wait until the whole replaced range is confirmed again
+	if from > safe || rescanTo > safe {
+		return true, nil
+	}
+	// a rescan reads the whole replaced range before anything is orphaned
+	to := max(from+batchBlocks-1, rescanTo)
+	if to > safe {
+		to = safe
+	}
+
+	owners, err := w.store.Owners(ctx)
+	if err != nil {
+		return false, err
+	}
+	keep := map[string]bool{}
+	for start := from; start <= to; start += batchBlocks {
+		logs, err := w.transferLogs(ctx, start, min(start+batchBlocks-1, to), owners)
+		if err != nil {
+			return false, err
+		}
+		for _, entry := range logs {
+			if err := w.credit(ctx, entry, owners, keep); err != nil {
+				return false, err
+			}
+		}
+	}
+	if rescanTo > 0 {
+		orphaned, err := w.store.Orphan(ctx, w.chain.Name, from, rescanTo, keep)
+		if err != nil {
+			return false, err
+		}
+		if orphaned > 0 {
+			log.Printf("watcher %s: %d credited deposits left the chain and were taken back", w.chain.Name, orphaned)
+		}
+	}
+	return to == safe, w.saveCursor(ctx, to)
+}
+
+func (w *Watcher) credit(ctx context.Context, entry types.Log, owners map[common.Address]fetch.DepositOwner, keep map[string]bool) error {
+	deposit, owner, amount, isDeposit := w.depositOf(entry, owners)
+	if !isDeposit {
+		return nil
+	}
+	keep[fetch.DepositKey(deposit)] = true
+	if amount.Sign() == 0 {
+		return nil
+	}
+	isCredited, err := w.store.Credit(ctx, deposit, owner, amount)
+	if err != nil {
+		return err
+	}
+	if isCredited {
+		log.Printf("watcher %s: credited %s to %s from %s:%d", w.chain.Name, amount, owner.ID, deposit.TXH, deposit.LIX)
+	}
+	return nil
+}
+
+// depositOf reads a Transfer log, false when it doesn't pay a deposit address
+func (w *Watcher) depositOf(entry types.Log, owners map[common.Address]fetch.DepositOwner) (fetch.Deposit, fetch.DepositOwner, money.Amount, bool) {
+	if entry.Removed || len(entry.Topics) != 3 {
+		return fetch.Deposit{}, fetch.DepositOwner{}, money.Zero(), false
+	}
+	to := common.BytesToAddress(entry.Topics[2].Bytes())
+	owner, found := owners[to]
+	if !found {
+		return fetch.Deposit{}, fetch.DepositOwner{}, money.Zero(), false
+	}
+	deposit := fetch.Deposit{
+		CHAIN: w.chain.Name,
+		TXH:   entry.TxHash.Hex(),
+		LIX:   int64(entry.Index),
+		BLK:   int64(entry.BlockNumber),
+		BHASH: entry.BlockHash.Hex(),
+		FROM:  strings.ToLower(common.BytesToAddress(entry.Topics[1].Bytes()).Hex()),
+		ADD:   strings.ToLower(to.Hex()),
+	}
+	return deposit, owner, money.FromTokenUnits(new(big.Int).SetBytes(entry.Data), w.decimals), true
+}
+
+// transferLogs returns the Transfer logs of the token into any of the owners'
+// addresses in blocks from to to, in chain order
+func (w *Watcher) transferLogs(ctx context.Context, from uint64, to uint64, owners map[common.Address]fetch.DepositOwner) ([]types.Log, error) {
+	addresses := make([]common.Hash, 0, len(owners))
+	for address := range owners {
+		addresses = append(addresses, common.BytesToHash(address.Bytes()))
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].Cmp(addresses[j]) < 0 })
+
+	var logs []types.Log
+	for start := 0; start < len(addresses); start += addressChunk {
+		end := min(start+addressChunk, len(addresses))
+		chunk, err := w.backend.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(from),
+			ToBlock:   new(big.Int).SetUint64(to),
+			Addresses: []common.Address{w.chain.Token},
+			Topics:    [][]common.Hash{{transferTopic}, nil, addresses[start:end]},
+		})
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, chunk...)
+	}
+	sort.Slice(logs, func(i, j int) bool {
+		if logs[i].BlockNumber != logs[j].BlockNumber {
+			return logs[i].BlockNumber < logs[j].BlockNumber
+		}
+		return logs[i].Index < logs[j].Index
+	})
+	return logs, nil
+}
+
+func (w *Watcher) saveCursor(ctx context.Context, block uint64) error {
+	header, err := w.backend.HeaderByNumber(ctx, new(big.Int).SetUint64(block))
+	if err != nil {
+		return err
+	}
+	return w.store.SaveCursor(ctx, w.chain.Name, block, header.Hash().Hex())
+}
+
+const decimalsABI = `[{"constant":true,"inputs":[],"name":"decimals","outputs":[{"name":"","type":"uint8"}],"type":"function"}]`
+
+func tokenDecimals(ctx context.Context, backend Backend, tokenAddress common.Address) (int, error) {
+	parsed, err := abi.JSON(strings.NewReader(decimalsABI))
+	if err != nil {
+		return 0, err
+	}
+	data, err := parsed.Pack("decimals")
+	if err != nil {
+		return 0, err
+	}
+	result, err := backend.CallContract(ctx, ethereum.CallMsg{To: &tokenAddress, Data: data}, nil)
+	if err != nil {
+		return 0, err
+	}
+	var decimals uint8
+	if err := parsed.UnpackIntoInterface(&decimals, "decimals", result); err != nil {
+		return 0, err
+	}
+	return int(decimals), nil
+}
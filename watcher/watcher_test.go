@@ -0,0 +1,431 @@
+package watcher_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"tbapi/chaintest"
+	"tbapi/fetch"
+	"tbapi/modals"
+	"tbapi/money"
+	"tbapi/watcher"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const confirmations = 3
+
+// TestWatcher sends transfers into deposit addresses, scans them twice,
+// reorganises one away after it was credited and reconciles the blocks
+func TestWatcher(t *testing.T) {
+	ctx := context.Background()
+	c := newSimChain(t)
+	defer c.sim.Close()
+	client := c.sim.Client()
+	alice := common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+	bob := common.HexToAddress("0x0000000000000000000000000000000000000b0b")
+	stranger := common.HexToAddress("0x000000000000000000000000000000000000beef")
+	send, commit := c.send, c.commit
+
+	store := &memWatchStore{
+		owners: map[common.Address]fetch.DepositOwner{
+			alice: {ID: "alice", HDIX: 1},
+			bob:   {ID: "bob", EKEY: "sealed"},
+		},
+		deposits: map[string]*fetch.Deposit{},
+		balances: map[string]money.Amount{},
+	}
+	w, err := watcher.New(ctx, watcher.Chain{Name: "POS", Token: chaintest.TokenAddress, Confirmations: confirmations, StartBlock: 1}, client, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catchUp := func() {
+		for i := 0; i < 20; i++ {
+			caughtUp, err := w.Poll(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if caughtUp {
+				return
+			}
+		}
+		t.Fatal("watcher never caught up")
+	}
+
+	gwei := int64(1000000000)
+	send(alice, chaintest.USDT(100), gwei)
+	send(bob, chaintest.USDT(50), gwei)
+	send(stranger, chaintest.USDT(7), gwei)
+	commit(1)
+	catchUp()
+	if len(store.deposits) != 0 {
+		t.Errorf("credited %d deposits before they were confirmed", len(store.deposits))
+	}
+
+	commit(confirmations)
+	catchUp()
+	if store.balance("alice") != "100" {
+		t.Errorf("alice credited %s, want 100", store.balance("alice"))
+	}
+	if store.balance("bob") != "50" {
+		t.Errorf("bob credited %s, want 50", store.balance("bob"))
+	}
+	if len(store.deposits) != 2 {
+		t.Errorf("%d deposits, want 2", len(store.deposits))
+	}
+
+	// scanning the same blocks again credits nothing
+	store.rewind()
+	catchUp()
+	if store.balance("alice") != "100" {
+		t.Errorf("alice credited %s after the rescan, want 100", store.balance("alice"))
+	}
+	if len(store.deposits) != 2 {
+		t.Errorf("%d deposits after the rescan, want 2", len(store.deposits))
+	}
+
+	// a credited deposit reorganised away by a longer chain
+	head, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(alice, chaintest.USDT(30), gwei)
+	commit(confirmations + 1)
+	catchUp()
+	if store.balance("alice") != "130" {
+		t.Errorf("alice credited %s, want 130", store.balance("alice"))
+	}
+	// alice spends all but 20 of it before the reorg
+	store.spend("alice", money.MustParse("110"))
+	if err := c.sim.Fork(head.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	c.nonce--
+	send(stranger, chaintest.USDT(30), 2*gwei)
+	commit(confirmations + 4)
+	catchUp()
+	orphaned := 0
+	for _, deposit := range store.deposits {
+		if deposit.STATUS == fetch.DepositOrphaned {
+			orphaned++
+			amount, _ := money.FromDecimal128(deposit.AMT)
+			if deposit.ID != "alice" || amount.String() != "30" {
+				t.Errorf("orphaned the wrong deposit %s:%d", deposit.TXH, deposit.LIX)
+			}
+			if deposit.Held().String() != "10" {
+				t.Errorf("orphaned deposit holds %s, want the 10 alice spent", deposit.Held())
+			}
+		}
+	}
+	if orphaned != 1 {
+		t.Errorf("%d deposits orphaned, want 1", orphaned)
+	}
+	if store.balance("alice") != "0" {
+		t.Errorf("alice has %s after the orphan was taken back, want 0", store.balance("alice"))
+	}
+	t.Logf("credited %d deposits, %d orphaned", len(store.deposits), orphaned)
+
+	// reconciling the scanned blocks finds the orphan gone and nothing missing
+	last, _, _, err := store.Cursor(ctx, "POS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := w.Reconcile(ctx, 1, last, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Clean() {
+		t.Errorf("reconcile found %d missing, %d unbacked, %d mismatched", len(report.Missing), len(report.Unbacked), len(report.Mismatched))
+	}
+	if report.Matched != 2 || len(report.Orphaned) != 1 || len(report.Returned) != 0 {
+		t.Errorf("reconcile matched %d, %d orphaned and %d returned, want 2, 1 and 0", report.Matched, len(report.Orphaned), len(report.Returned))
+	}
+	if len(report.Orphaned) == 1 && report.Orphaned[0].Held().String() != "10" {
+		t.Errorf("reconcile reports %s held, want 10", report.Orphaned[0].Held())
+	}
+
+	// a lost credit is reported, then credited once
+	store.forget("bob")
+	report, err = w.Reconcile(ctx, 1, last, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Missing) != 1 || report.Credited != 0 {
+		t.Errorf("reconcile found %d missing and credited %d, want 1 and 0", len(report.Missing), report.Credited)
+	}
+	for i := 0; i < 2; i++ {
+		report, err = w.Reconcile(ctx, 1, last, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !report.Clean() || report.Credited != 0 {
+		t.Errorf("reconcile left %d missing after crediting", len(report.Missing))
+	}
+	if store.balance("bob") != "50" {
+		t.Errorf("bob credited %s after the reconcile, want 50", store.balance("bob"))
+	}
+}
+
+// TestWatcherLongRescan reorganises the last scanned block of a chain whose
+// rescan range is wider than one batch: deposits past the first batch of the
+// rescan are still on the chain and must keep their credit
+func TestWatcherLongRescan(t *testing.T) {
+	ctx := context.Background()
+	c := newSimChain(t)
+	defer c.sim.Close()
+	client := c.sim.Client()
+	alice := common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+	bob := common.HexToAddress("0x0000000000000000000000000000000000000b0b")
+	const deep = 260
+
+	store := &memWatchStore{
+		owners: map[common.Address]fetch.DepositOwner{
+			alice: {ID: "alice", HDIX: 1},
+			bob:   {ID: "bob", HDIX: 2},
+		},
+		deposits: map[string]*fetch.Deposit{},
+		balances: map[string]money.Amount{},
+	}
+	w, err := watcher.New(ctx, watcher.Chain{Name: "POS", Token: chaintest.TokenAddress, Confirmations: deep, StartBlock: 1}, client, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catchUp := func() {
+		for i := 0; i < 20; i++ {
+			caughtUp, err := w.Poll(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if caughtUp {
+				return
+			}
+		}
+		t.Fatal("watcher never caught up")
+	}
+
+	gwei := int64(1000000000)
+	c.send(alice, chaintest.USDT(10), gwei)
+	c.commit(600)
+	// bob's deposit lies past the first batch of the rescan below
+	c.send(bob, chaintest.USDT(20), gwei)
+	c.commit(deep + 1)
+	catchUp()
+	if store.balance("alice") != "10" || store.balance("bob") != "20" {
+		t.Fatalf("credited alice %s and bob %s, want 10 and 20", store.balance("alice"), store.balance("bob"))
+	}
+
+	// replace the last scanned block only, with one that moves tokens between
+	// strangers so it hashes differently
+	last, _, _, err := store.Cursor(ctx, "POS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := client.HeaderByNumber(ctx, new(big.Int).SetUint64(last-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.sim.Fork(parent.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	c.send(common.HexToAddress("0x00000000000000000000000000000000057a9e12"), chaintest.USDT(1), gwei)
+	c.commit(2*deep + 2)
+	catchUp()
+	if store.orphans != 0 {
+		t.Errorf("the rescan orphaned %d deposits still on the chain", store.orphans)
+	}
+	for _, deposit := range store.deposits {
+		if deposit.STATUS != fetch.DepositCredited {
+			t.Errorf("deposit of %s in block %d is %s after the rescan", deposit.ID, deposit.BLK, deposit.STATUS)
+		}
+	}
+	if store.balance("alice") != "10" || store.balance("bob") != "20" {
+		t.Errorf("after the rescan alice has %s and bob %s, want 10 and 20", store.balance("alice"), store.balance("bob"))
+	}
+}
+
+// simChain is a simulated chain with the token and a sender holding 1000 USDT
+type simChain struct {
+	t       *testing.T
+	sim     *simulated.Backend
+	chainID *big.Int
+	sender  *ecdsa.PrivateKey
+	nonce   uint64
+}
+
+func newSimChain(t *testing.T) *simChain {
+	sender, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	senderAddress := crypto.PubkeyToAddress(sender.PublicKey)
+	sim := simulated.NewBackend(types.GenesisAlloc{
+		senderAddress:          {Balance: new(big.Int).Mul(chaintest.Ether, big.NewInt(100))},
+		chaintest.TokenAddress: chaintest.Token(map[common.Address]*big.Int{senderAddress: chaintest.USDT(1000)}),
+	})
+	chainID, err := sim.Client().ChainID(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &simChain{t: t, sim: sim, chainID: chainID, sender: sender}
+}
+
+// send transfers amount token units to to with a tip in wei
+func (c *simChain) send(to common.Address, amount *big.Int, tip int64) {
+	data := append(common.FromHex("0xa9059cbb"), common.LeftPadBytes(to.Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(amount.Bytes(), 32)...)
+	tx, err := types.SignTx(types.NewTx(&types.DynamicFeeTx{
+		ChainID:   c.chainID,
+		Nonce:     c.nonce,
+		GasTipCap: big.NewInt(tip),
+		GasFeeCap: new(big.Int).Mul(big.NewInt(tip), big.NewInt(100)),
+		Gas:       100000,
+		To:        &chaintest.TokenAddress,
+		Data:      data,
+	}), types.LatestSignerForChainID(c.chainID), c.sender)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	// after a fork the pool catches up with the new head in the background
+	for try := 0; ; try++ {
+		err = c.sim.Client().SendTransaction(context.Background(), tx)
+		if err == nil || try == 50 || !strings.Contains(err.Error(), "nonce too low") {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.nonce++
+}
+
+func (c *simChain) commit(blocks int) {
+	for i := 0; i < blocks; i++ {
+		c.sim.Commit()
+	}
+}
+
+// memWatchStore is watcherState and deposits in memory
+type memWatchStore struct {
+	mu       sync.Mutex
+	block    uint64
+	hash     string
+	found    bool
+	owners   map[common.Address]fetch.DepositOwner
+	deposits map[string]*fetch.Deposit
+	balances map[string]money.Amount
+	orphans  int // deposits ever orphaned
+}
+
+// rewind moves the cursor back to the first block
+func (m *memWatchStore) rewind() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.found = false
+}
+
+func (m *memWatchStore) spend(ID string, amount money.Amount) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.balances[ID] = m.balances[ID].Sub(amount)
+}
+
+func (m *memWatchStore) balance(ID string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.balances[ID].String()
+}
+
+func (m *memWatchStore) Cursor(ctx context.Context, chain string) (uint64, string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.block, m.hash, m.found, nil
+}
+
+func (m *memWatchStore) SaveCursor(ctx context.Context, chain string, block uint64, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.block, m.hash, m.found = block, hash, true
+	return nil
+}
+
+func (m *memWatchStore) Owners(ctx context.Context) (map[common.Address]fetch.DepositOwner, error) {
+	return m.owners, nil
+}
+
+func (m *memWatchStore) Credit(ctx context.Context, deposit fetch.Deposit, owner fetch.DepositOwner, amount money.Amount) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := fmt.Sprintf("%s:%s:%d", deposit.CHAIN, deposit.TXH, deposit.LIX)
+	if recorded, found := m.deposits[key]; found {
+		if recorded.STATUS != fetch.DepositOrphaned {
+			return false, nil
+		}
+		// back on chain, the part taken back is credited again
+		m.balances[recorded.ID] = m.balances[recorded.ID].Add(modals.Balance(recorded.REV))
+		recorded.STATUS, recorded.REV, recorded.BLK = fetch.DepositCredited, primitive.Decimal128{}, deposit.BLK
+		return true, nil
+	}
+	deposit.ID = owner.ID
+	deposit.AMT = amount.Decimal128()
+	deposit.STATUS = fetch.DepositCredited
+	m.deposits[key] = &deposit
+	m.balances[owner.ID] = m.balances[owner.ID].Add(amount)
+	return true, nil
+}
+
+func (m *memWatchStore) Orphan(ctx context.Context, chain string, first uint64, last uint64, keep map[string]bool) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	orphaned := 0
+	for _, deposit := range m.deposits {
+		inRange := deposit.BLK >= int64(first) && deposit.BLK <= int64(last)
+		if deposit.CHAIN != chain || deposit.STATUS != fetch.DepositCredited || !inRange {
+			continue
+		}
+		if !keep[fmt.Sprintf("%s:%d", deposit.TXH, deposit.LIX)] {
+			reversed := money.Min(modals.Balance(deposit.AMT), m.balances[deposit.ID])
+			m.balances[deposit.ID] = m.balances[deposit.ID].Sub(reversed)
+			deposit.STATUS, deposit.REV = fetch.DepositOrphaned, reversed.Decimal128()
+			orphaned++
+			m.orphans++
+		}
+	}
+	return orphaned, nil
+}
+
+func (m *memWatchStore) Deposits(ctx context.Context, chain string, first uint64, last uint64) ([]fetch.Deposit, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deposits []fetch.Deposit
+	for _, deposit := range m.deposits {
+		if deposit.CHAIN == chain && deposit.BLK >= int64(first) && deposit.BLK <= int64(last) {
+			deposits = append(deposits, *deposit)
+		}
+	}
+	return deposits, nil
+}
+
+// forget loses a credited deposit of ID, as if it was never credited
+func (m *memWatchStore) forget(ID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, deposit := range m.deposits {
+		if deposit.ID == ID && deposit.STATUS == fetch.DepositCredited {
+			amount, _ := money.FromDecimal128(deposit.AMT)
+			m.balances[ID] = m.balances[ID].Sub(amount)
+			delete(m.deposits, key)
+			return
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package withdraw
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"log"
+	"math/big"
+	"tbapi/evmtx"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// batchSize is the number of withdrawals one pass looks at
+const batchSize = 100
+
+// Backend is the part of an EVM node the signer uses
+type Backend interface {
+	evmtx.Backend
+	ChainID(ctx context.Context) (*big.Int, error)
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+	CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error)
+}
+
+// Chain configures the signer of one network
+type Chain struct {
+	Name          string // as stored in CHAIN
+	Token         common.Address
+	Decimals      int // token decimals
+	HotWallet     *ecdsa.PrivateKey
+	Confirmations uint64
+}
+
+// Signer pays the withdrawals of one chain. Run a single one per chain, it
+// keeps the hot wallet nonce in memory.
+type Signer struct {
+	chain   Chain
+	backend Backend
+	store   Store
+	chainID *big.Int
+	nonce   uint64
+}
+
+func NewSigner(ctx context.Context, chain Chain, backend Backend, store Store) (*Signer, error) {
+	if chain.HotWallet == nil {
+		return nil, errors.New("withdraw: no hot wallet key")
+	}
+	if chain.Confirmations == 0 {
+		chain.Confirmations = 1
+	}
+	chainID, err := backend.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{chain: chain, backend: backend, store: store, chainID: chainID}, nil
+}
+
+// Run makes a pass every interval until ctx is done
+func (s *Signer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Pass(ctx); err != nil {
+			log.Printf("withdraw %s: %v", s.chain.Name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Pass settles the broadcast withdrawals and sends the pending ones in the
+// order they were requested. Sending stops at the first one the hot wallet
+// can't pay yet, or that hits an RPC or database error, so later requests
+// never overtake it.
+func (s *Signer) Pass(ctx context.Context) error {
+	withdrawals, err := s.store.Queue(ctx, s.chain.Name, batchSize)
+	if err != nil {
+		return err
+	}
+	// funds held by transfers that may not be mined yet
+	committed, gas := new(big.Int), new(big.Int)
+	for _, w := range withdrawals {
+		if w.STATUS != StatusBroadcast {
+			continue
+		}
+		committed.Add(committed, w.Amount().TokenUnits(s.chain.Decimals))
+		if tx, err := evmtx.Decode(w.RAW); err == nil {
+			gas.Add(gas, tx.Cost())
+		}
+	}
+
+	isBlocked := false
+	for _, w := range withdrawals {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var err error
+		if w.STATUS == StatusBroadcast {
+			err = s.settle(ctx, w)
+		} else if !isBlocked {
+			var isSent bool
+			isSent, err = s.send(ctx, w, committed, gas)
+			isBlocked = !isSent
+		}
+		if err != nil {
+			log.Printf("withdraw %s: %s: %v", s.chain.Name, w.EID.Hex(), err)
+		}
+	}
+	return nil
+}
+
+// send signs and broadcasts the transfer of w when the hot wallet holds the
+// tokens and the gas on top of what is committed, which grows by it
+func (s *Signer) send(ctx context.Context, w Withdrawal, committed *big.Int, gas *big.Int) (bool, error) {
+	hotWallet := evmtx.AddressOf(s.chain.HotWallet)
+	amount := w.Amount().TokenUnits(s.chain.Decimals)
+	balance, err := evmtx.TokenBalance(ctx, s.backend, s.chain.Token, hotWallet)
+	if err != nil {
+		return false, err
+	}
+	if balance.Cmp(new(big.Int).Add(committed, amount)) < 0 {
+		log.Printf("withdraw %s: hot wallet holds %s token units, %s needs %s more", s.chain.Name, balance, w.EID.Hex(), new(big.Int).Sub(new(big.Int).Add(committed, amount), balance))
+		return false, nil
+	}
+
+	data, err := evmtx.Token.Pack("transfer", common.HexToAddress(w.TO), amount)
+	if err != nil {
+		return false, err
+	}
+	gasLimit, err := s.backend.EstimateGas(ctx, ethereum.CallMsg{From: hotWallet, To: &s.chain.Token, Data: data})
+	if err != nil {
+		return false, err
+	}
+	gasLimit += gasLimit / 5
+	tip, feeCap, err := evmtx.Fees(ctx, s.backend)
+	if err != nil {
+		return false, err
+	}
+	cost := new(big.Int).Mul(new(big.Int).SetUint64(gasLimit), feeCap)
+	native, err := s.backend.BalanceAt(ctx, hotWallet, nil)
+	if err != nil {
+		return false, err
+	}
+	if native.Cmp(new(big.Int).Add(gas, cost)) < 0 {
+		log.Printf("withdraw %s: hot wallet can't pay the gas of %s", s.chain.Name, w.EID.Hex())
+		return false, nil
+	}
+
+	pending, err := s.backend.PendingNonceAt(ctx, hotWallet)
+	if err != nil {
+		return false, err
+	}
+	// a transfer the node dropped still holds its nonce until it is sent again
+	if pending > s.nonce {
+		s.nonce = pending
+	}
+	tx, err := evmtx.Sign(s.chainID, s.chain.HotWallet, s.nonce, s.chain.Token, big.NewInt(0), gasLimit, tip, feeCap, data)
+	if err != nil {
+		return false, err
+	}
+	w.RAW, err = evmtx.Encode(tx)
+	if err != nil {
+		return false, err
+	}
+	w.TXH = tx.Hash().Hex()
+	w.STATUS = StatusBroadcast
+	if err := s.save(ctx, w, StatusPending); err != nil {
+		return false, err
+	}
+	s.nonce++
+	committed.Add(committed, amount)
+	gas.Add(gas, tx.Cost())
+	// a failed broadcast is repeated by settle
+	return true, evmtx.Broadcast(ctx, s.backend, tx)
+}
+
+// settle confirms w once its transfer is deep enough, or fails and refunds it
+// when the transfer can never be mined
+func (s *Signer) settle(ctx context.Context, w Withdrawal) error {
+	isDone, failure, err := evmtx.Settle(ctx, s.backend, w.TXH, w.RAW, s.chain.Confirmations)
+	if err != nil || !isDone {
+		return err
+	}
+	w.STATUS = StatusConfirmed
+	if failure != "" {
+		log.Printf("withdraw %s: %s failed: transfer %s", s.chain.Name, w.EID.Hex(), failure)
+		w.STATUS = StatusFailed
+		w.ERR = "transfer " + failure
+	}
+	w.UPD = time.Now().UTC().Unix()
+	isSaved, err := s.store.Finish(ctx, w, StatusBroadcast)
+	if err == nil && !isSaved {
+		return errors.New("withdrawal left broadcast meanwhile")
+	}
+	return err
+}
+
+// save stores w, a withdrawal another worker already moved is left to it
+func (s *Signer) save(ctx context.Context, w Withdrawal, from string) error {
+	w.UPD = time.Now().UTC().Unix()
+	isSaved, err := s.store.Update(ctx, w, from)
+	if err != nil {
+		return err
+	}
+	if !isSaved {
+		return errors.New("withdrawal left " + from + " meanwhile")
+	}
+	return nil
+}
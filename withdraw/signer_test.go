@@ -0,0 +1,255 @@
+package withdraw_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"strings"
+	"sync"
+	"tbapi/chaintest"
+	"tbapi/evmtx"
+	"tbapi/money"
+	"tbapi/withdraw"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const confirmations = 3
+
+// TestSigner pays queued withdrawals from a hot wallet that runs short, through
+// a node that drops every first broadcast and a signer restarted every pass. It
+// must pay in request order, wait while the hot wallet is short and refund a
+// transfer whose nonce was spent.
+func TestSigner(t *testing.T) {
+	ctx := context.Background()
+	hotKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hotWallet := crypto.PubkeyToAddress(hotKey.PublicKey)
+	reserveKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserve := crypto.PubkeyToAddress(reserveKey.PublicKey)
+
+	token := chaintest.Token(map[common.Address]*big.Int{
+		hotWallet: chaintest.USDT(300),
+		reserve:   chaintest.USDT(1000),
+	})
+	sim := simulated.NewBackend(types.GenesisAlloc{
+		hotWallet:              {Balance: new(big.Int).Mul(chaintest.Ether, big.NewInt(10))},
+		reserve:                {Balance: new(big.Int).Mul(chaintest.Ether, big.NewInt(10))},
+		chaintest.TokenAddress: token,
+	})
+	defer sim.Close()
+	backend := chaintest.NewFlakyBackend(sim.Client())
+	chainID, err := backend.ChainID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sendFrom moves amount token units around the signer, straight to the node
+	sendFrom := func(key *ecdsa.PrivateKey, to common.Address, amount *big.Int) *types.Transaction {
+		nonce, err := backend.PendingNonceAt(ctx, crypto.PubkeyToAddress(key.PublicKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tip, feeCap, err := evmtx.Fees(ctx, backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := evmtx.Token.Pack("transfer", to, amount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx, err := evmtx.Sign(chainID, key, nonce, chaintest.TokenAddress, big.NewInt(0), 100000, tip, feeCap, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := backend.Client.SendTransaction(ctx, tx); err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+
+	// a transfer saved as broadcast whose nonce the operator then spent on
+	// something else, it can never be mined
+	tip, feeCap, err := evmtx.Fees(ctx, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := evmtx.Token.Pack("transfer", common.HexToAddress("0x00000000000000000000000000000000000000e5"), chaintest.USDT(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lost, err := evmtx.Sign(chainID, hotKey, 0, chaintest.TokenAddress, big.NewInt(0), 100000, tip, feeCap, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lostRaw, err := evmtx.Encode(lost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendFrom(hotKey, reserve, chaintest.USDT(0))
+	sim.Commit()
+
+	recipients := []common.Address{
+		common.HexToAddress("0x00000000000000000000000000000000000000a1"),
+		common.HexToAddress("0x00000000000000000000000000000000000000a2"),
+		common.HexToAddress("0x00000000000000000000000000000000000000a3"),
+		common.HexToAddress("0x00000000000000000000000000000000000000a4"),
+	}
+	store := &memWithdrawStore{finished: map[primitive.ObjectID]string{}}
+	store.add(withdraw.Withdrawal{TO: lost.To().Hex(), AMT: money.MustParse("1").Decimal128(), STATUS: withdraw.StatusBroadcast, TXH: lost.Hash().Hex(), RAW: lostRaw})
+	store.add(withdraw.Withdrawal{TO: recipients[0].Hex(), AMT: money.MustParse("100").Decimal128(), STATUS: withdraw.StatusPending})
+	store.add(withdraw.Withdrawal{TO: recipients[1].Hex(), AMT: money.MustParse("150").Decimal128(), STATUS: withdraw.StatusPending})
+	// more than is left, and a small one behind it that must not overtake
+	store.add(withdraw.Withdrawal{TO: recipients[2].Hex(), AMT: money.MustParse("100").Decimal128(), STATUS: withdraw.StatusPending})
+	store.add(withdraw.Withdrawal{TO: recipients[3].Hex(), AMT: money.MustParse("10").Decimal128(), STATUS: withdraw.StatusPending})
+
+	chain := withdraw.Chain{
+		Name:          "POS",
+		Token:         chaintest.TokenAddress,
+		Decimals:      6,
+		HotWallet:     hotKey,
+		Confirmations: confirmations,
+	}
+	run := func(passes int) {
+		for pass := 0; pass < passes; pass++ {
+			// a fresh signer every pass, as after a restart
+			s, err := withdraw.NewSigner(ctx, chain, backend, store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Pass(ctx); err != nil {
+				t.Fatal(err)
+			}
+			sim.Commit()
+		}
+	}
+
+	run(15)
+	statuses := store.statuses()
+	want := []string{withdraw.StatusFailed, withdraw.StatusConfirmed, withdraw.StatusConfirmed, withdraw.StatusPending, withdraw.StatusPending}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Errorf("withdrawal %d: status %q before the top-up, want %q", i, statuses[i], want[i])
+		}
+	}
+	if !strings.Contains(store.rows[0].ERR, "replaced") {
+		t.Errorf("lost transfer: error %q", store.rows[0].ERR)
+	}
+	if store.finished[store.rows[0].EID] != withdraw.StatusFailed {
+		t.Errorf("lost transfer: not refunded")
+	}
+
+	sendFrom(reserveKey, hotWallet, chaintest.USDT(200))
+	sim.Commit()
+	run(15)
+	statuses = store.statuses()
+	for i := 1; i < len(statuses); i++ {
+		if statuses[i] != withdraw.StatusConfirmed {
+			t.Errorf("withdrawal %d: status %q after the top-up (%s)", i, statuses[i], store.rows[i].ERR)
+		}
+		if store.finished[store.rows[i].EID] != withdraw.StatusConfirmed {
+			t.Errorf("withdrawal %d: not paid out", i)
+		}
+	}
+	third, err := evmtx.Decode(store.rows[3].RAW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fourth, err := evmtx.Decode(store.rows[4].RAW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Nonce() >= fourth.Nonce() {
+		t.Errorf("the small withdrawal overtook the one before it")
+	}
+
+	for i, amount := range []*big.Int{chaintest.USDT(100), chaintest.USDT(150), chaintest.USDT(100), chaintest.USDT(10)} {
+		got, err := chaintest.TokenBalance(ctx, backend, recipients[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Cmp(amount) != 0 {
+			t.Errorf("recipient %d holds %s, want %s", i, got, amount)
+		}
+	}
+	left, err := chaintest.TokenBalance(ctx, backend, hotWallet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left.Cmp(chaintest.USDT(140)) != 0 {
+		t.Errorf("hot wallet holds %s, want %s", left, chaintest.USDT(140))
+	}
+
+	t.Logf("paid %d withdrawals", len(recipients))
+}
+
+// memWithdrawStore is the withdrawals collection in memory, finished records
+// whether each one was paid out or refunded
+type memWithdrawStore struct {
+	mu       sync.Mutex
+	rows     []withdraw.Withdrawal
+	finished map[primitive.ObjectID]string
+}
+
+func (m *memWithdrawStore) add(w withdraw.Withdrawal) {
+	w.EID = primitive.NewObjectID()
+	w.CHAIN = "POS"
+	m.rows = append(m.rows, w)
+}
+
+func (m *memWithdrawStore) statuses() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var statuses []string
+	for _, w := range m.rows {
+		statuses = append(statuses, w.STATUS)
+	}
+	return statuses
+}
+
+func (m *memWithdrawStore) Queue(ctx context.Context, chain string, limit int) ([]withdraw.Withdrawal, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var queued []withdraw.Withdrawal
+	for _, w := range m.rows {
+		if w.CHAIN == chain && (w.STATUS == withdraw.StatusPending || w.STATUS == withdraw.StatusBroadcast) && len(queued) < limit {
+			queued = append(queued, w)
+		}
+	}
+	return queued, nil
+}
+
+func (m *memWithdrawStore) Update(ctx context.Context, w withdraw.Withdrawal, from string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, stored := range m.rows {
+		if stored.EID == w.EID {
+			if stored.STATUS != from {
+				return false, nil
+			}
+			m.rows[i] = w
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memWithdrawStore) Finish(ctx context.Context, w withdraw.Withdrawal, from string) (bool, error) {
+	isSaved, err := m.Update(ctx, w, from)
+	if isSaved {
+		m.mu.Lock()
+		m.finished[w.EID] = w.STATUS
+		m.mu.Unlock()
+	}
+	return isSaved, err
+}
@@ -0,0 +1,218 @@
+package withdraw
+
+import (
+	"context"
+	"errors"
+	"tbapi/ledger"
+	"tbapi/modals"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Store loads withdrawals and moves them between states
+type Store interface {
+	// Queue lists the pending and broadcast withdrawals of chain, oldest first
+	Queue(ctx context.Context, chain string, limit int) ([]Withdrawal, error)
+	// Update saves w if it is still in state from, false means another worker
+	// moved it first
+	Update(ctx context.Context, w Withdrawal, from string) (bool, error)
+	// Finish saves w as confirmed or failed if it is still in state from, and
+	// pays it out or refunds it
+	Finish(ctx context.Context, w Withdrawal, from string) (bool, error)
+}
+
+// MongoStore keeps the withdrawals in their collection and the order history
+type MongoStore struct {
+	db *mongo.Database
+}
+
+func NewMongoStore(db *mongo.Database) *MongoStore {
+	return &MongoStore{db: db}
+}
+
+// EnsureIndexes supports the queue scan and the order history updates
+func (m *MongoStore) EnsureIndexes(ctx context.Context) error {
+	_, err := m.db.Collection(Collection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "STATUS", Value: 1}, {Key: "CHAIN", Value: 1}}},
+		{Keys: bson.D{{Key: "ID", Value: 1}, {Key: "TMP", Value: -1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = m.db.Collection("transferOrders").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "REF", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	return err
+}
+
+func (m *MongoStore) Queue(ctx context.Context, chain string, limit int) ([]Withdrawal, error) {
+	filter := bson.M{"CHAIN": chain, "STATUS": bson.M{"$in": bson.A{StatusPending, StatusBroadcast}}}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	return m.find(ctx, filter, opts)
+}
+
+// InReview lists the withdrawals waiting for an admin, oldest first
+func (m *MongoStore) InReview(ctx context.Context) ([]Withdrawal, error) {
+	return m.find(ctx, bson.M{"STATUS": StatusReview}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+}
+
+// ByAccount lists one page (10 per page, newest first) of the withdrawals of
+// the account
+func (m *MongoStore) ByAccount(ctx context.Context, ID string, page int) ([]Withdrawal, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetSkip(int64(page-1) * 10).SetLimit(10)
+	return m.find(ctx, bson.M{"ID": ID}, opts)
+}
+
+func (m *MongoStore) Get(ctx context.Context, hexID string) (Withdrawal, error) {
+	EID, err := primitive.ObjectIDFromHex(hexID)
+	if err != nil {
+		return Withdrawal{}, errors.New("not a withdrawal id")
+	}
+	var w Withdrawal
+	err = m.db.Collection(Collection).FindOne(ctx, bson.M{"_id": EID}).Decode(&w)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Withdrawal{}, errors.New("withdrawal not found")
+	}
+	return w, err
+}
+
+func (m *MongoStore) find(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]Withdrawal, error) {
+	cursor, err := m.db.Collection(Collection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var withdrawals []Withdrawal
+	if err := cursor.All(ctx, &withdrawals); err != nil {
+		return nil, err
+	}
+	return withdrawals, nil
+}
+
+var errMoved = errors.New("withdrawal moved meanwhile")
+
+func (m *MongoStore) Update(ctx context.Context, w Withdrawal, from string) (bool, error) {
+	err := modals.RunTransaction(m.db, func(sc mongo.SessionContext) error {
+		return m.save(sc, w, from)
+	})
+	if errors.Is(err, errMoved) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m *MongoStore) Finish(ctx context.Context, w Withdrawal, from string) (bool, error) {
+	var legs []ledger.Leg
+	switch w.STATUS {
+	case StatusConfirmed:
+		legs = []ledger.Leg{
+			ledger.Debit(ledger.Withdrawing, w.CHAIN, w.Total()),
+			ledger.Credit(ledger.External, w.CHAIN, w.Amount()),
+			ledger.Credit(ledger.Fees, w.CHAIN, w.Fee()),
+		}
+	case StatusFailed:
+		legs = []ledger.Leg{
+			ledger.Debit(ledger.Withdrawing, w.CHAIN, w.Total()),
+			ledger.Credit(w.ID, w.CHAIN, w.Total()),
+		}
+	default:
+		return false, errors.New("withdrawal can only finish confirmed or failed")
+	}
+	err := modals.RunTransaction(m.db, func(sc mongo.SessionContext) error {
+		if err := m.save(sc, w, from); err != nil {
+			return err
+		}
+		if err := modals.Checkpoint("withdraw:finished"); err != nil {
+			return err
+		}
+		isPosted, message := ledger.Post(sc, m.db, ledger.KindWithdrawal, w.EID.Hex(), legs...)
+		if !isPosted {
+			return modals.Abort(message)
+		}
+		if w.STATUS != StatusFailed {
+			return nil
+		}
+		if err := modals.Checkpoint("withdraw:refunded"); err != nil {
+			return err
+		}
+		return release(sc, m.db.Collection(LimitsCollection), w)
+	})
+	if errors.Is(err, errMoved) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// save writes w and the status of its order history entry, ctx should be the
+// session context of a transaction
+func (m *MongoStore) save(ctx context.Context, w Withdrawal, from string) error {
+	result, err := m.db.Collection(Collection).UpdateOne(ctx, bson.M{"_id": w.EID, "STATUS": from}, bson.M{"$set": bson.M{
+		"STATUS": w.STATUS,
+		"TXH":    w.TXH,
+		"RAW":    w.RAW,
+		"ERR":    w.ERR,
+		"APPR":   w.APPR,
+		"UPD":    w.UPD,
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errMoved
+	}
+	if err := modals.Checkpoint("withdraw:saved"); err != nil {
+		return err
+	}
+	set := bson.M{"STAT": w.STATUS}
+	if w.TXH != "" {
+		set["TXH"] = w.TXH
+	}
+	_, err = m.db.Collection("transferOrders").UpdateOne(ctx, bson.M{"REF": w.EID.Hex(), "TYP": OrderType}, bson.M{"$set": set})
+	return err
+}
+
+// Approve releases a withdrawal in review to the signer
+func (m *MongoStore) Approve(ctx context.Context, hexID string) error {
+	w, err := m.Get(ctx, hexID)
+	if err != nil {
+		return err
+	}
+	if w.STATUS != StatusReview {
+		return errors.New("withdrawal is " + w.STATUS + ", not in review")
+	}
+	w.STATUS = StatusPending
+	w.APPR = "approved"
+	w.UPD = time.Now().UTC().Unix()
+	isSaved, err := m.Update(ctx, w, StatusReview)
+	if err == nil && !isSaved {
+		return errMoved
+	}
+	return err
+}
+
+// Reject fails a withdrawal in review and refunds the account
+func (m *MongoStore) Reject(ctx context.Context, hexID string, reason string) error {
+	w, err := m.Get(ctx, hexID)
+	if err != nil {
+		return err
+	}
+	if w.STATUS != StatusReview {
+		return errors.New("withdrawal is " + w.STATUS + ", not in review")
+	}
+	w.STATUS = StatusFailed
+	w.APPR = "rejected"
+	w.ERR = "rejected"
+	if reason != "" {
+		w.ERR += ": " + reason
+	}
+	w.UPD = time.Now().UTC().Unix()
+	isSaved, err := m.Finish(ctx, w, StatusReview)
+	if err == nil && !isSaved {
+		return errMoved
+	}
+	return err
+}
@@ -0,0 +1,346 @@
+// Package withdraw sends platform balances to external wallets. A request
+// debits the account at once, amount and network fee, and queues a withdrawal
+// that goes
+//
+//	[review ->] pending -> broadcast -> confirmed
+//
+// Withdrawals above WITHDRAW_REVIEW_ABOVE wait in review for an admin. The
+// signer pays pending withdrawals from the hot wallet, and one that can't be
+// paid (rejected, reverted, replaced) ends failed with the account refunded.
+// Its transferOrders entry, of type WDR, follows the status.
+package withdraw
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"tbapi/chains"
+	"tbapi/ledger"
+	"tbapi/modals"
+	"tbapi/money"
+	"tbapi/transfer"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	Collection = "withdrawals"
+	// LimitsCollection holds the amount withdrawn per chain, UTC day and
+	// account, and for the whole platform
+	LimitsCollection = "withdrawalLimits"
+)
+
+// Withdrawal states
+const (
+	StatusReview    = "review"    // above the review threshold, waits for an admin
+	StatusPending   = "pending"   // waits for the signer
+	StatusBroadcast = "broadcast" // sent, waiting for confirmations
+	StatusConfirmed = "confirmed"
+	StatusFailed    = "failed" // refunded, ERR tells why
+)
+
+// OrderType marks withdrawals in transferOrders
+const OrderType = "WDR"
+
+// Withdrawal is a withdrawals document
+type Withdrawal struct {
+	EID    primitive.ObjectID   `bson:"_id"`
+	ID     string               `bson:"ID"` // account
+	CHAIN  string               `bson:"CHAIN"`
+	TO     string               `bson:"TO"`  // external address
+	AMT    primitive.Decimal128 `bson:"AMT"` // sent to TO
+	FEE    primitive.Decimal128 `bson:"FEE"` // network fee, charged on top
+	DAY    string               `bson:"DAY"` // UTC day the limits were charged to
+	STATUS string               `bson:"STATUS"`
+	TXH    string               `bson:"TXH"`
+	RAW    string               `bson:"RAW"` // signed transfer, rebroadcast until mined
+	ERR    string               `bson:"ERR"`
+	APPR   string               `bson:"APPR"` // admin decision on a reviewed withdrawal
+	TMP    int64                `bson:"TMP"`
+	UPD    int64                `bson:"UPD"`
+}
+
+func (w Withdrawal) Amount() money.Amount {
+	return modals.Balance(w.AMT)
+}
+
+func (w Withdrawal) Fee() money.Amount {
+	return modals.Balance(w.FEE)
+}
+
+// Total is what the account was debited
+func (w Withdrawal) Total() money.Amount {
+	return w.Amount().Add(w.Fee())
+}
+
+// Limits are in the asset of a chain, a zero limit is not checked
+type Limits struct {
+	Minimum     money.Amount // minWithdraw of the registry
+	UserDaily   money.Amount // per account and UTC day, WITHDRAW_USER_DAILY
+	Daily       money.Amount // whole platform per UTC day, WITHDRAW_DAILY
+	ReviewAbove money.Amount // larger withdrawals wait for an admin, WITHDRAW_REVIEW_ABOVE
+}
+
+// LimitsOf reads the limits of chain, the environment ones apply to every
+// chain alike
+func LimitsOf(chain chains.Chain) (Limits, error) {
+	asset := money.ChainAsset(chain)
+	var limits Limits
+	var err error
+	if chain.MinWithdraw != "" {
+		if limits.Minimum, err = money.Parse(asset, chain.MinWithdraw); err != nil {
+			return Limits{}, errors.New(chain.Name + " minWithdraw: " + err.Error())
+		}
+	}
+	for _, limit := range []struct {
+		name     string
+		fallback string
+		value    *money.Amount
+	}{
+		{"WITHDRAW_USER_DAILY", "10000", &limits.UserDaily},
+		{"WITHDRAW_DAILY", "100000", &limits.Daily},
+		{"WITHDRAW_REVIEW_ABOVE", "1000", &limits.ReviewAbove},
+	} {
+		value := os.Getenv(limit.name)
+		if value == "" {
+			value = limit.fallback
+		}
+		if *limit.value, err = money.Parse(asset, value); err != nil {
+			return Limits{}, errors.New(limit.name + ": " + err.Error())
+		}
+	}
+	return limits, nil
+}
+
+// Quote is the price of a withdrawal
+type Quote struct {
+	Chain  chains.Chain
+	Asset  money.Asset
+	Amount money.Amount
+	Fee    money.Amount
+	Total  money.Amount
+	Review bool // will wait for an admin
+}
+
+// QuoteWithdrawal prices a withdrawal of amount in assetChoice (USDT-PoS or
+// the chain name)
+func QuoteWithdrawal(assetChoice string, amount string) (bool, string, Quote) {
+	asset, isKnown := money.Lookup(assetChoice)
+	if !isKnown {
+		return false, "Invalid Asset Choice", Quote{}
+	}
+	chain, found := chains.Get(asset.Field)
+	if !found || chain.FeePrice == "" {
+		return false, "Withdrawals are not available for " + asset.Code, Quote{}
+	}
+	limits, err := LimitsOf(chain)
+	if err != nil {
+		return false, "Backend Error", Quote{}
+	}
+	value, err := money.ParsePositive(asset, amount)
+	if err != nil {
+		return false, err.Error(), Quote{}
+	}
+	if value.LessThan(limits.Minimum) {
+		return false, "The minimum withdrawal is " + limits.Minimum.Format(asset) + " " + asset.Code, Quote{}
+	}
+	fee, isPriced := networkFee(chain, asset)
+	if !isPriced {
+		return false, "Cannot Get Fees Data", Quote{}
+	}
+	return true, "", Quote{
+		Chain:  chain,
+		Asset:  asset,
+		Amount: value,
+		Fee:    fee,
+		Total:  value.Add(fee),
+		Review: !limits.ReviewAbove.IsZero() && limits.ReviewAbove.LessThan(value),
+	}
+}
+
+// networkFee is the USD cost of a token transfer on chain, the same figure
+// the platform summary shows, rounded up to the asset
+func networkFee(chain chains.Chain, asset money.Asset) (money.Amount, bool) {
+	fees, isGot := modals.GetFees()
+	if !isGot {
+		return money.Amount{}, false
+	}
+	usd, isPriced := fees.TransferCost(chain)
+	if !isPriced {
+		return money.Amount{}, false
+	}
+	fee := money.Ceil(usd, asset)
+	if fee.Sign() <= 0 {
+		return money.Amount{}, false
+	}
+	return fee, true
+}
+
+// Request debits the account and queues the withdrawal of amount to the
+// external address to
+func Request(address string, assetChoice string, to string, amount string) (bool, string, Withdrawal) {
+	db, err := modals.ConnectDB()
+	if err != nil {
+		return false, "API Database Error", Withdrawal{}
+	}
+	if !common.IsHexAddress(to) || common.HexToAddress(to) == (common.Address{}) {
+		return false, "Invalid withdrawal address", Withdrawal{}
+	}
+	isInternal, err := isPlatformAddress(db, to)
+	if err != nil {
+		return false, "API Database Error", Withdrawal{}
+	}
+	if isInternal {
+		return false, "This is a platform address, send a transfer instead", Withdrawal{}
+	}
+	isQuoted, message, quote := QuoteWithdrawal(assetChoice, amount)
+	if !isQuoted {
+		return false, message, Withdrawal{}
+	}
+	limits, err := LimitsOf(quote.Chain)
+	if err != nil {
+		return false, "Backend Error", Withdrawal{}
+	}
+
+	now := time.Now().UTC()
+	w := Withdrawal{
+		EID:    primitive.NewObjectID(),
+		ID:     address,
+		CHAIN:  quote.Chain.Name,
+		TO:     common.HexToAddress(to).Hex(),
+		AMT:    quote.Amount.Decimal128(),
+		FEE:    quote.Fee.Decimal128(),
+		DAY:    now.Format(time.DateOnly),
+		STATUS: StatusPending,
+		TMP:    now.Unix(),
+		UPD:    now.Unix(),
+	}
+	if quote.Review {
+		w.STATUS = StatusReview
+	}
+	totals := db.Collection(LimitsCollection)
+	err = modals.RunTransaction(db, func(sc mongo.SessionContext) error {
+		isReserved, err := reserve(sc, totals, limitKey(w, w.ID), quote.Amount, limits.UserDaily)
+		if err != nil {
+			return err
+		}
+		if !isReserved {
+			return modals.Abort("Daily withdrawal limit reached")
+		}
+		isReserved, err = reserve(sc, totals, limitKey(w, ""), quote.Amount, limits.Daily)
+		if err != nil {
+			return err
+		}
+		if !isReserved {
+			return modals.Abort("Platform withdrawal limit reached, try again tomorrow")
+		}
+		if err := modals.Checkpoint("withdraw:reserved"); err != nil {
+			return err
+		}
+		isPosted, message := ledger.Post(sc, db, ledger.KindWithdrawal, w.EID.Hex(),
+			ledger.Debit(w.ID, w.CHAIN, quote.Total),
+			ledger.Credit(ledger.Withdrawing, w.CHAIN, quote.Total))
+		if !isPosted {
+			return modals.Abort(message)
+		}
+		if err := modals.Checkpoint("withdraw:debited"); err != nil {
+			return err
+		}
+		if _, err := db.Collection(Collection).InsertOne(sc, w); err != nil {
+			return err
+		}
+		if err := modals.Checkpoint("withdraw:queued"); err != nil {
+			return err
+		}
+		order := transfer.Order{
+			SADD: w.ID,
+			CADD: w.TO,
+			RADD: "ON-CHAIN",
+			AMT:  quote.Amount.Format(quote.Asset),
+			CTP:  w.CHAIN,
+			TYP:  OrderType,
+			TMP:  strconv.FormatInt(w.TMP, 10),
+			STAT: w.STATUS,
+			FEE:  quote.Fee.Format(quote.Asset),
+			REF:  w.EID.Hex(),
+		}
+		if _, err := db.Collection("transferOrders").InsertOne(sc, order); err != nil {
+			return modals.Abort("Can't Update Order List")
+		}
+		return nil
+	})
+	if err != nil {
+		return false, modals.AbortMessage(err, "Withdrawal failed Try again"), Withdrawal{}
+	}
+	return true, "Withdrawal queued", w
+}
+
+func isPlatformAddress(db *mongo.Database, address string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := db.Collection("tb_accounts").FindOne(ctx, bson.M{"EADD": strings.ToLower(address)}).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// limitKey is the total of account on the chain and day of w, an empty account
+// is the platform total
+func limitKey(w Withdrawal, account string) string {
+	if account == "" {
+		account = "platform"
+	}
+	return w.CHAIN + ":" + account + ":" + w.DAY
+}
+
+// reserve adds amount to the total under key unless that passes limit. When the
+// total is already too high the upsert collides with the existing document.
+func reserve(ctx context.Context, totals *mongo.Collection, key string, amount money.Amount, limit money.Amount) (bool, error) {
+	filter := bson.M{"_id": key}
+	if !limit.IsZero() {
+		room := limit.Sub(amount)
+		if room.Sign() < 0 {
+			return false, nil
+		}
+		filter["TOT"] = bson.M{"$lte": room.Decimal128()}
+	}
+	_, err := totals.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"TOT": amount.Decimal128()}}, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// release gives the amount of a failed withdrawal back to the day's limits
+func release(ctx context.Context, totals *mongo.Collection, w Withdrawal) error {
+	for _, key := range []string{limitKey(w, w.ID), limitKey(w, "")} {
+		if _, err := totals.UpdateOne(ctx, bson.M{"_id": key}, bson.M{"$inc": bson.M{"TOT": w.Amount().Neg().Decimal128()}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListWithdrawals returns one page (10 per page, newest first) of the
+// withdrawals of the account
+func ListWithdrawals(address string, page int) (bool, string, []Withdrawal) {
+	db, err := modals.ConnectDB()
+	if err != nil {
+		return false, "API Database Error", nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	withdrawals, err := NewMongoStore(db).ByAccount(ctx, address, page)
+	if err != nil {
+		return false, "API Database Error", nil
+	}
+	return true, "", withdrawals
+}